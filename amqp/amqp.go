@@ -0,0 +1,221 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package amqp implements the SASL security layer of AMQP 1.0 as defined in
+// section 5.3 of the OASIS AMQP 1.0 specification.
+//
+// Authenticate drives a client Negotiator and HandleSASL drives a server
+// Negotiator.
+// Both exchange the SASL protocol header and the sasl-mechanisms, sasl-init,
+// sasl-challenge, sasl-response and sasl-outcome frames and never read past
+// the outcome, so the caller can continue with the AMQP protocol header on the
+// same connection.
+// Only the parts of the AMQP type system needed for the SASL performatives are
+// implemented.
+package amqp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Header is the protocol header sent by both peers to start the SASL layer.
+const Header = "AMQP\x03\x01\x00\x00"
+
+// Outcome codes sent in the sasl-outcome frame.
+const (
+	CodeOK      = 0
+	CodeAuth    = 1
+	CodeSys     = 2
+	CodeSysPerm = 3
+	CodeSysTemp = 4
+)
+
+// Errors that correspond to the outcome codes.
+// Err and Code convert between the two.
+var (
+	ErrAuth    = errors.New("Authentication failed")
+	ErrSys     = errors.New("Authentication failed due to a system error")
+	ErrSysPerm = errors.New("Authentication failed due to a permanent system error")
+	ErrSysTemp = errors.New("Authentication failed due to a transient system error")
+)
+
+// Errors returned by the client and server.
+var (
+	ErrHeader           = errors.New("Unexpected protocol header")
+	ErrUnknownMechanism = errors.New("Mechanism not supported")
+	ErrUnexpectedFrame  = errors.New("Unexpected SASL frame")
+	ErrMalformed        = errors.New("Malformed SASL frame")
+	ErrFrameTooLong     = errors.New("SASL frame too long")
+)
+
+const (
+	frameTypeSASL = 0x01
+
+	// The specification limits frames to 512 bytes before the maximum frame
+	// size is negotiated, but some mechanisms (eg. GSSAPI) need more than that
+	// and most implementations allow it.
+	maxFrameSize = 64 * 1024
+)
+
+// Performative descriptors.
+const (
+	saslMechanisms = 0x40
+	saslInit       = 0x41
+	saslChallenge  = 0x42
+	saslResponse   = 0x43
+	saslOutcome    = 0x44
+)
+
+// Err returns the error for an outcome code, or nil if the code is CodeOK.
+// Unknown codes are treated as system errors.
+func Err(code uint8) error {
+	switch code {
+	case CodeOK:
+		return nil
+	case CodeAuth:
+		return ErrAuth
+	case CodeSysPerm:
+		return ErrSysPerm
+	case CodeSysTemp:
+		return ErrSysTemp
+	}
+	return ErrSys
+}
+
+// Code returns the outcome code that should be sent to the client when a
+// server Negotiator fails with err.
+// Any error that does not correspond to an outcome code results in CodeAuth.
+func Code(err error) uint8 {
+	switch err {
+	case nil:
+		return CodeOK
+	case ErrSys:
+		return CodeSys
+	case ErrSysPerm:
+		return CodeSysPerm
+	case ErrSysTemp:
+		return CodeSysTemp
+	}
+	return CodeAuth
+}
+
+// ReadHeader reads an 8 byte protocol header from r.
+// If the header does not start with "AMQP" ErrHeader is returned.
+// Servers should read the clients header and compare it to Header before
+// calling HandleSASL.
+func ReadHeader(r io.Reader) (string, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(string(h[:]), "AMQP") {
+		return "", ErrHeader
+	}
+	return string(h[:]), nil
+}
+
+// writeFrame writes a SASL frame containing the performative with the
+// descriptor desc and the list of fields.
+// Trailing null fields may be omitted by the caller.
+func writeFrame(w io.Writer, desc uint64, fields ...interface{}) error {
+	b := []byte{0, 0, 0, 0, 2, frameTypeSASL, 0, 0}
+	b = appendValue(b, described{descriptor: desc, value: fields})
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	_, err := w.Write(b)
+	return err
+}
+
+// readFrame reads a SASL frame and returns the descriptor of the performative
+// and its fields.
+// Empty frames are skipped.
+func readFrame(r io.Reader) (uint64, []interface{}, error) {
+	for {
+		var h [8]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return 0, nil, err
+		}
+		size := binary.BigEndian.Uint32(h[:])
+		switch {
+		case size > maxFrameSize:
+			return 0, nil, ErrFrameTooLong
+		case size < 8, h[4] < 2, uint32(h[4])*4 > size, h[5] != frameTypeSASL:
+			return 0, nil, ErrMalformed
+		}
+		b := make([]byte, size-8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		// Skip the extended header.
+		b = b[int(h[4])*4-8:]
+		if len(b) == 0 {
+			continue
+		}
+
+		v, _, err := readValue(b)
+		if err != nil {
+			return 0, nil, err
+		}
+		d, ok := v.(described)
+		if !ok {
+			return 0, nil, ErrMalformed
+		}
+		fields, ok := d.value.([]interface{})
+		if !ok {
+			return 0, nil, ErrMalformed
+		}
+		return d.descriptor, fields, nil
+	}
+}
+
+// field returns field i or nil if the list is too short.
+func field(fields []interface{}, i int) interface{} {
+	if i >= len(fields) {
+		return nil
+	}
+	return fields[i]
+}
+
+// binaryField returns field i if it is binary data.
+// If it is null, nil is returned.
+func binaryField(fields []interface{}, i int) ([]byte, error) {
+	switch v := field(fields, i).(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	}
+	return nil, ErrMalformed
+}
+
+// mechanismsField decodes the sasl-server-mechanisms field which may be a
+// single symbol or an array of symbols.
+func mechanismsField(fields []interface{}) ([]string, error) {
+	switch v := field(fields, 0).(type) {
+	case symbol:
+		return []string{string(v)}, nil
+	case []interface{}:
+		mechs := make([]string, 0, len(v))
+		for _, m := range v {
+			s, ok := m.(symbol)
+			if !ok {
+				return nil, ErrMalformed
+			}
+			mechs = append(mechs, string(s))
+		}
+		return mechs, nil
+	}
+	return nil, ErrMalformed
+}
+
+// optional converts a nil slice into an untyped nil so that it is encoded as
+// null instead of as empty binary data.
+func optional(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return b
+}
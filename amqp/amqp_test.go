@@ -0,0 +1,256 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package amqp_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/amqp"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+)
+
+// finalData is a mechanism with no initial response where the server sends
+// additional data with its success.
+var finalData = sasl.Mechanism{
+	Name: "X-FINAL",
+	Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+		return true, nil, nil, nil
+	},
+	Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		if n.State()&sasl.Receiving == sasl.Receiving {
+			if len(challenge) != 0 {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, []byte("goodbye"), nil, nil
+		}
+		if n.State()&sasl.StepMask == sasl.AuthTextSent {
+			return true, []byte{}, nil, nil
+		}
+		if string(challenge) != "goodbye" {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, nil, nil, nil
+	},
+}
+
+func TestCodes(t *testing.T) {
+	for i, tc := range [...]struct {
+		err  error
+		code uint8
+		back error
+	}{
+		0: {code: amqp.CodeOK},
+		1: {err: amqp.ErrAuth, code: amqp.CodeAuth, back: amqp.ErrAuth},
+		2: {err: amqp.ErrSys, code: amqp.CodeSys, back: amqp.ErrSys},
+		3: {err: amqp.ErrSysPerm, code: amqp.CodeSysPerm, back: amqp.ErrSysPerm},
+		4: {err: amqp.ErrSysTemp, code: amqp.CodeSysTemp, back: amqp.ErrSysTemp},
+		5: {err: sasl.ErrAuthn, code: amqp.CodeAuth, back: amqp.ErrAuth},
+		6: {err: errors.New("some error"), code: amqp.CodeAuth, back: amqp.ErrAuth},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			code := amqp.Code(tc.err)
+			if code != tc.code {
+				t.Errorf("Wrong code: want=%d, got=%d", tc.code, code)
+			}
+			if err := amqp.Err(code); err != tc.back {
+				t.Errorf("Wrong error: want=%v, got=%v", tc.back, err)
+			}
+		})
+	}
+	if err := amqp.Err(42); err != amqp.ErrSys {
+		t.Errorf("Wrong error for unknown code: want=%v, got=%v", amqp.ErrSys, err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		serverMech string
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		hostname   string
+		clientErr  error
+		serverErr  error
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			hostname:   "example.net",
+		},
+		1: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			clientErr:  amqp.ErrAuth,
+			serverErr:  sasl.ErrAuthn,
+		},
+		2: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+		},
+		3: {
+			// There is no way to abort, so the client just hangs up.
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  io.EOF,
+		},
+		4: {
+			mech: finalData,
+		},
+		5: {
+			mech:       sasl.Plain,
+			serverMech: "SCRAM-SHA-1",
+			clientErr:  amqp.ErrUnknownMechanism,
+			serverErr:  io.EOF,
+		},
+		6: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			serverMech := tc.serverMech
+			if serverMech == "" {
+				serverMech = tc.mech.Name
+			}
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			errs := make(chan error, 1)
+			hosts := make(chan string, 1)
+			go func() {
+				defer serverConn.Close()
+				h, err := amqp.ReadHeader(serverConn)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if h != amqp.Header {
+					errs <- amqp.ErrHeader
+					return
+				}
+				host, err := amqp.HandleSASL(serverConn, []string{"ANONYMOUS", serverMech}, func(name string) *sasl.Negotiator {
+					if name != serverMech {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+				hosts <- host
+				errs <- err
+			}()
+
+			err := amqp.Authenticate(clientConn, tc.hostname, sasl.NewClient(tc.mech, tc.clientOpts...))
+			clientConn.Close()
+			if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+			if host := <-hosts; host != tc.hostname {
+				t.Errorf("Unexpected hostname: want=%q, got=%q", tc.hostname, host)
+			}
+		})
+	}
+}
+
+func TestClientFrames(t *testing.T) {
+	// The server uses the compact list8 and array8 encodings which we never
+	// send ourselves.
+	const (
+		mechanisms = "\x00\x00\x00\x18\x02\x01\x00\x00" +
+			"\x00\x53\x40\xc0\x0b\x01\xe0\x08\x01\xa3\x05PLAIN"
+		init = "\x00\x00\x00\x2a\x02\x01\x00\x00" +
+			"\x00\x53\x41\xd0\x00\x00\x00\x1a\x00\x00\x00\x03" +
+			"\xa3\x05PLAIN\xa0\x0c\x00user\x00pencil\x40"
+	)
+	for i, tc := range [...]struct {
+		in  string
+		out string
+		err error
+	}{
+		0: {
+			in:  amqp.Header + mechanisms + "\x00\x00\x00\x10\x02\x01\x00\x00\x00\x53\x44\xc0\x03\x01\x50\x00",
+			out: amqp.Header + init,
+		},
+		1: {
+			in:  amqp.Header + mechanisms + "\x00\x00\x00\x10\x02\x01\x00\x00\x00\x53\x44\xc0\x03\x01\x50\x04",
+			out: amqp.Header + init,
+			err: amqp.ErrSysTemp,
+		},
+		2: {
+			// An empty frame, then a single symbol instead of an array.
+			in: amqp.Header + "\x00\x00\x00\x08\x02\x01\x00\x00" +
+				"\x00\x00\x00\x15\x02\x01\x00\x00\x00\x53\x40\xc0\x08\x01\xa3\x05PLAIN" +
+				"\x00\x00\x00\x10\x02\x01\x00\x00\x00\x53\x44\xc0\x03\x01\x50\x00",
+			out: amqp.Header + init,
+		},
+		3: {
+			in:  "AMQP\x00\x01\x00\x00",
+			out: amqp.Header,
+			err: amqp.ErrHeader,
+		},
+		4: {
+			in:  amqp.Header + "\x01\x00\x00\x00\x02\x01\x00\x00",
+			out: amqp.Header,
+			err: amqp.ErrFrameTooLong,
+		},
+		5: {
+			in:  amqp.Header + "\x00\x00\x00\x10\x02\x00\x00\x00\x00\x53\x44\xc0\x03\x01\x50\x00",
+			out: amqp.Header,
+			err: amqp.ErrMalformed,
+		},
+		6: {
+			in:  amqp.Header + "\x00\x00\x00\x10\x02\x01\x00\x00\x00\x53\x44\xc0\x03\x01\x50\x00",
+			out: amqp.Header,
+			err: amqp.ErrUnexpectedFrame,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: bytes.NewReader([]byte(tc.in)),
+				Writer: &out,
+			}
+			err := amqp.Authenticate(rw, "", sasl.NewClient(sasl.Plain, sasltest.Creds("user", "pencil")))
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if out.String() != tc.out {
+				t.Errorf("Unexpected output:\nwant=%q\n got=%q", tc.out, out.String())
+			}
+		})
+	}
+}
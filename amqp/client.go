@@ -0,0 +1,122 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package amqp
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Authenticate sends the SASL protocol header and runs the exchange using
+// client until the server sends a sasl-outcome.
+//
+// If hostname is not empty it is sent in the sasl-init frame so that the
+// server can select a virtual host.
+// If the server does not offer the clients mechanism ErrUnknownMechanism is
+// returned before anything other than the header is sent.
+// If the outcome is not ok the corresponding error is returned (see Err).
+// The SASL layer has no way to abort an exchange, so if the negotiator returns
+// an error it is returned immediately and the caller should close the
+// connection.
+func Authenticate(rw io.ReadWriter, hostname string, client *sasl.Negotiator) error {
+	if _, err := io.WriteString(rw, Header); err != nil {
+		return err
+	}
+	h, err := ReadHeader(rw)
+	if err != nil {
+		return err
+	}
+	if h != Header {
+		return ErrHeader
+	}
+
+	desc, fields, err := readFrame(rw)
+	if err != nil {
+		return err
+	}
+	if desc != saslMechanisms {
+		return ErrUnexpectedFrame
+	}
+	mechanisms, err := mechanismsField(fields)
+	if err != nil {
+		return err
+	}
+	name := client.Mechanism().Name
+	var found bool
+	for _, m := range mechanisms {
+		if m == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrUnknownMechanism
+	}
+
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+	var host interface{}
+	if hostname != "" {
+		host = hostname
+	}
+	if err = writeFrame(rw, saslInit, symbol(name), optional(resp), host); err != nil {
+		return err
+	}
+
+	for {
+		desc, fields, err := readFrame(rw)
+		if err != nil {
+			return err
+		}
+		switch desc {
+		case saslChallenge:
+		case saslOutcome:
+			code, ok := field(fields, 0).(uint8)
+			if !ok {
+				return ErrMalformed
+			}
+			if err = Err(code); err != nil {
+				return err
+			}
+			data, err := binaryField(fields, 1)
+			if err != nil {
+				return err
+			}
+			if more && data != nil {
+				more, _, err = client.Step(data)
+				if err != nil {
+					return err
+				}
+			}
+			if more {
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		default:
+			return ErrUnexpectedFrame
+		}
+
+		challenge, err := binaryField(fields, 0)
+		if err != nil {
+			return err
+		}
+		if challenge == nil {
+			challenge = []byte{}
+		}
+		more, resp, err = client.Step(challenge)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			resp = []byte{}
+		}
+		if err = writeFrame(rw, saslResponse, resp); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package amqp
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// HandleSASL handles the SASL layer on behalf of a server.
+//
+// The caller should already have read the clients protocol header (see
+// ReadHeader).
+// HandleSASL sends the SASL protocol header and a sasl-mechanisms frame
+// listing mechanisms, then reads the sasl-init frame.
+// The mechanism chosen by the client is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported.
+// The hostname sent by the client, if any, is returned.
+//
+// Challenges are sent in sasl-challenge frames and any additional data
+// returned by the negotiator on success is sent in the sasl-outcome.
+// If the negotiator fails the outcome code is picked using Code.
+func HandleSASL(rw io.ReadWriter, mechanisms []string, negotiator func(mechanism string) *sasl.Negotiator) (hostname string, err error) {
+	if _, err = io.WriteString(rw, Header); err != nil {
+		return "", err
+	}
+	mechs := make([]symbol, 0, len(mechanisms))
+	for _, m := range mechanisms {
+		mechs = append(mechs, symbol(m))
+	}
+	if err = writeFrame(rw, saslMechanisms, mechs); err != nil {
+		return "", err
+	}
+
+	desc, fields, err := readFrame(rw)
+	if err != nil {
+		return "", err
+	}
+	if desc != saslInit {
+		return "", ErrUnexpectedFrame
+	}
+	name, ok := field(fields, 0).(symbol)
+	if !ok {
+		return "", ErrMalformed
+	}
+	switch h := field(fields, 2).(type) {
+	case nil:
+	case string:
+		hostname = h
+	default:
+		return "", ErrMalformed
+	}
+	resp, err := binaryField(fields, 1)
+	if err != nil {
+		return hostname, err
+	}
+
+	var server *sasl.Negotiator
+	for _, m := range mechanisms {
+		if m == string(name) {
+			server = negotiator(m)
+			break
+		}
+	}
+	if server == nil {
+		return hostname, writeOutcome(rw, CodeAuth, ErrUnknownMechanism)
+	}
+
+	if resp == nil {
+		// No initial response, send an empty challenge to ask for one.
+		if resp, err = challenge(rw, []byte{}); err != nil {
+			return hostname, err
+		}
+	}
+	for {
+		more, data, err := server.Step(resp)
+		if err != nil {
+			return hostname, writeOutcome(rw, Code(err), err)
+		}
+		if !more {
+			return hostname, writeFrame(rw, saslOutcome, uint8(CodeOK), optional(data))
+		}
+		if data == nil {
+			data = []byte{}
+		}
+		if resp, err = challenge(rw, data); err != nil {
+			return hostname, err
+		}
+	}
+}
+
+// challenge sends a sasl-challenge and reads the clients sasl-response.
+func challenge(rw io.ReadWriter, data []byte) ([]byte, error) {
+	if err := writeFrame(rw, saslChallenge, data); err != nil {
+		return nil, err
+	}
+	desc, fields, err := readFrame(rw)
+	if err != nil {
+		return nil, err
+	}
+	if desc != saslResponse {
+		return nil, ErrUnexpectedFrame
+	}
+	resp, err := binaryField(fields, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		resp = []byte{}
+	}
+	return resp, nil
+}
+
+// writeOutcome sends a sasl-outcome with code and returns err, or the write
+// error if there was one.
+func writeOutcome(w io.Writer, code uint8, err error) error {
+	if werr := writeFrame(w, saslOutcome, code); werr != nil {
+		return werr
+	}
+	return err
+}
@@ -0,0 +1,234 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package amqp
+
+import (
+	"encoding/binary"
+)
+
+// Type constructors used by the SASL performatives.
+const (
+	typeDescribed  = 0x00
+	typeNull       = 0x40
+	typeULong0     = 0x44
+	typeList0      = 0x45
+	typeUByte      = 0x50
+	typeSmallULong = 0x53
+	typeULong      = 0x80
+	typeVBin8      = 0xa0
+	typeStr8       = 0xa1
+	typeSym8       = 0xa3
+	typeVBin32     = 0xb0
+	typeStr32      = 0xb1
+	typeSym32      = 0xb3
+	typeList8      = 0xc0
+	typeList32     = 0xd0
+	typeArray8     = 0xe0
+	typeArray32    = 0xf0
+)
+
+// symbol is an AMQP symbol, which is distinct from a string on the wire.
+type symbol string
+
+// described is a described type with a numeric descriptor.
+type described struct {
+	descriptor uint64
+	value      interface{}
+}
+
+// appendValue appends the encoding of v, which must be nil, uint8, []byte,
+// string, symbol, []symbol or a described list ([]interface{}).
+func appendValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, typeNull)
+	case uint8:
+		return append(b, typeUByte, v)
+	case []byte:
+		if len(v) <= 0xff {
+			b = append(b, typeVBin8, byte(len(v)))
+		} else {
+			b = append(b, typeVBin32)
+			b = appendUint32(b, uint32(len(v)))
+		}
+		return append(b, v...)
+	case string:
+		if len(v) <= 0xff {
+			b = append(b, typeStr8, byte(len(v)))
+		} else {
+			b = append(b, typeStr32)
+			b = appendUint32(b, uint32(len(v)))
+		}
+		return append(b, v...)
+	case symbol:
+		if len(v) <= 0xff {
+			b = append(b, typeSym8, byte(len(v)))
+		} else {
+			b = append(b, typeSym32)
+			b = appendUint32(b, uint32(len(v)))
+		}
+		return append(b, v...)
+	case []symbol:
+		// Always use the 32-bit form so that we do not have to calculate the
+		// size up front.
+		var body []byte
+		body = appendUint32(body, uint32(len(v)))
+		body = append(body, typeSym32)
+		for _, s := range v {
+			body = appendUint32(body, uint32(len(s)))
+			body = append(body, s...)
+		}
+		b = append(b, typeArray32)
+		b = appendUint32(b, uint32(len(body)))
+		return append(b, body...)
+	case described:
+		b = append(b, typeDescribed, typeSmallULong, byte(v.descriptor))
+		return appendValue(b, v.value)
+	case []interface{}:
+		var body []byte
+		for _, f := range v {
+			body = appendValue(body, f)
+		}
+		if len(v) == 0 {
+			return append(b, typeList0)
+		}
+		b = append(b, typeList32)
+		b = appendUint32(b, uint32(len(body)+4))
+		b = appendUint32(b, uint32(len(v)))
+		return append(b, body...)
+	}
+	panic("amqp: cannot encode value")
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// readValue decodes a single value from the front of b and returns it and the
+// remaining bytes.
+// Lists and arrays are returned as []interface{}.
+func readValue(b []byte) (interface{}, []byte, error) {
+	if len(b) < 1 {
+		return nil, nil, ErrMalformed
+	}
+	code := b[0]
+	b = b[1:]
+	if code == typeDescribed {
+		desc, rest, err := readValue(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		d, ok := desc.(uint64)
+		if !ok {
+			return nil, nil, ErrMalformed
+		}
+		v, rest, err := readValue(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		return described{descriptor: d, value: v}, rest, nil
+	}
+	return readConstructed(code, b)
+}
+
+func readConstructed(code byte, b []byte) (interface{}, []byte, error) {
+	switch code {
+	case typeNull:
+		return nil, b, nil
+	case typeULong0:
+		return uint64(0), b, nil
+	case typeList0:
+		return []interface{}{}, b, nil
+	case typeUByte:
+		if len(b) < 1 {
+			return nil, nil, ErrMalformed
+		}
+		return b[0], b[1:], nil
+	case typeSmallULong:
+		if len(b) < 1 {
+			return nil, nil, ErrMalformed
+		}
+		return uint64(b[0]), b[1:], nil
+	case typeULong:
+		if len(b) < 8 {
+			return nil, nil, ErrMalformed
+		}
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	case typeVBin8, typeStr8, typeSym8, typeVBin32, typeStr32, typeSym32:
+		var n int
+		if code&0xf0 == 0xa0 {
+			if len(b) < 1 {
+				return nil, nil, ErrMalformed
+			}
+			n = int(b[0])
+			b = b[1:]
+		} else {
+			if len(b) < 4 {
+				return nil, nil, ErrMalformed
+			}
+			n = int(binary.BigEndian.Uint32(b))
+			b = b[4:]
+		}
+		if n < 0 || n > len(b) {
+			return nil, nil, ErrMalformed
+		}
+		switch code {
+		case typeVBin8, typeVBin32:
+			return append([]byte{}, b[:n]...), b[n:], nil
+		case typeStr8, typeStr32:
+			return string(b[:n]), b[n:], nil
+		}
+		return symbol(b[:n]), b[n:], nil
+	case typeList8, typeList32, typeArray8, typeArray32:
+		var size, count int
+		if code == typeList8 || code == typeArray8 {
+			if len(b) < 2 {
+				return nil, nil, ErrMalformed
+			}
+			size, count = int(b[0]), int(b[1])
+			if size < 1 || size > len(b)-1 {
+				return nil, nil, ErrMalformed
+			}
+			b, size = b[2:], size-1
+		} else {
+			if len(b) < 8 {
+				return nil, nil, ErrMalformed
+			}
+			size, count = int(binary.BigEndian.Uint32(b)), int(binary.BigEndian.Uint32(b[4:]))
+			if size < 4 || size > len(b)-4 {
+				return nil, nil, ErrMalformed
+			}
+			b, size = b[8:], size-4
+		}
+		body, rest := b[:size], b[size:]
+		isArray := code == typeArray8 || code == typeArray32
+		var elemCode byte
+		if isArray && count > 0 {
+			if len(body) < 1 {
+				return nil, nil, ErrMalformed
+			}
+			elemCode, body = body[0], body[1:]
+		}
+		if count > len(body)+1 {
+			return nil, nil, ErrMalformed
+		}
+		l := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			var v interface{}
+			var err error
+			if isArray {
+				v, body, err = readConstructed(elemCode, body)
+			} else {
+				v, body, err = readValue(body)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			l = append(l, v)
+		}
+		return l, rest, nil
+	}
+	return nil, nil, ErrMalformed
+}
@@ -0,0 +1,295 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package amqp091 implements SASL authentication for AMQP 0-9-1 (as used by
+// RabbitMQ) during connection negotiation.
+//
+// The server advertises its mechanisms in Connection.Start as a space
+// separated list, the client picks one and sends its initial response in
+// Connection.StartOk, and challenges and responses are exchanged with
+// Connection.Secure and Connection.SecureOk until the server sends
+// Connection.Tune (success) or Connection.Close (failure).
+//
+// Authenticate drives a client Negotiator and Server drives a server
+// Negotiator.
+// Both exchange the protocol header and the connection methods up to and
+// including Connection.Tune and never read past it, so the caller can continue
+// with Connection.TuneOk and Connection.Open on the same connection.
+// Only the parts of the framing needed for connection negotiation are
+// implemented.
+package amqp091
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Header is the protocol header sent by the client to start the connection.
+const Header = "AMQP\x00\x00\x09\x01"
+
+// Reply codes used in Connection.Close.
+const (
+	ReplyAccessRefused  = 403
+	ReplyFrameError     = 501
+	ReplyCommandInvalid = 503
+)
+
+// Errors returned by the client and server.
+var (
+	ErrVersion          = errors.New("Protocol version not supported")
+	ErrNoMechanism      = errors.New("No mechanism supported by both peers")
+	ErrUnknownMechanism = errors.New("Unknown authentication mechanism")
+	ErrUnexpectedMethod = errors.New("Unexpected method")
+	ErrMalformed        = errors.New("Malformed frame")
+	ErrFrameTooLong     = errors.New("Frame too long")
+)
+
+// Error is returned by Authenticate when the server closes the connection with
+// Connection.Close.
+type Error struct {
+	Code     uint16
+	Text     string
+	ClassID  uint16
+	MethodID uint16
+}
+
+func (e *Error) Error() string {
+	s := "amqp091: reply code " + strconv.Itoa(int(e.Code))
+	if e.Text != "" {
+		s += ": " + e.Text
+	}
+	return s
+}
+
+// Tune contains the arguments of Connection.Tune which the server sends once
+// authentication has succeeded.
+type Tune struct {
+	ChannelMax uint16
+	FrameMax   uint32
+	Heartbeat  uint16
+}
+
+// Mechanisms splits the mechanisms argument of Connection.Start so that the
+// mechanisms can be passed to the sasl.RemoteMechanisms option.
+func Mechanisms(s string) []string {
+	return strings.Fields(s)
+}
+
+// ReadHeader reads the 8 byte protocol header sent by a client.
+// If it is not Header, ErrVersion is returned and the server should send
+// Header and close the connection.
+func ReadHeader(r io.Reader) error {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return err
+	}
+	if string(h[:]) != Header {
+		return ErrVersion
+	}
+	return nil
+}
+
+const (
+	frameMethod    = 1
+	frameHeartbeat = 8
+	frameEnd       = 0xce
+
+	// The frame size is not negotiated until Connection.Tune, but we allow
+	// larger frames than the 4096 byte minimum in case mechanisms need them.
+	maxFrameSize = 128 * 1024
+
+	classConnection = 10
+
+	defaultLocale   = "en_US"
+	maxShortstrSize = 255
+)
+
+// Connection class methods.
+const (
+	methodStart    = 10
+	methodStartOk  = 11
+	methodSecure   = 20
+	methodSecureOk = 21
+	methodTune     = 30
+	methodClose    = 50
+	methodCloseOk  = 51
+)
+
+// writeMethod writes a Connection class method frame on channel 0.
+func writeMethod(w io.Writer, method uint16, e *encoder) error {
+	b := make([]byte, 11, 12+len(e.b))
+	b[0] = frameMethod
+	binary.BigEndian.PutUint32(b[3:], uint32(4+len(e.b)))
+	binary.BigEndian.PutUint16(b[7:], classConnection)
+	binary.BigEndian.PutUint16(b[9:], method)
+	b = append(b, e.b...)
+	b = append(b, frameEnd)
+	_, err := w.Write(b)
+	return err
+}
+
+// readMethod reads a method frame and returns the Connection class method ID
+// and a decoder for its arguments.
+// Heartbeat frames are skipped.
+func readMethod(r io.Reader) (uint16, *decoder, error) {
+	for {
+		var h [7]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return 0, nil, err
+		}
+		if string(h[:4]) == "AMQP" {
+			// The server does not support our version and responded with the
+			// header for the version it does support.
+			return 0, nil, ErrVersion
+		}
+		size := binary.BigEndian.Uint32(h[3:])
+		if size > maxFrameSize {
+			return 0, nil, ErrFrameTooLong
+		}
+		b := make([]byte, size+1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		if b[size] != frameEnd {
+			return 0, nil, ErrMalformed
+		}
+		b = b[:size]
+		switch h[0] {
+		case frameHeartbeat:
+			continue
+		case frameMethod:
+		default:
+			return 0, nil, ErrUnexpectedMethod
+		}
+		if binary.BigEndian.Uint16(h[1:]) != 0 {
+			return 0, nil, ErrUnexpectedMethod
+		}
+		d := &decoder{b: b}
+		class, method := d.short(), d.short()
+		if d.err != nil {
+			return 0, nil, d.err
+		}
+		if class != classConnection {
+			return 0, nil, ErrUnexpectedMethod
+		}
+		return method, d, nil
+	}
+}
+
+// writeClose sends Connection.Close with the reply code and text and returns
+// err, or the write error if there was one.
+func writeClose(w io.Writer, code uint16, text string, method uint16, err error) error {
+	e := &encoder{}
+	e.short(code)
+	e.shortstr(text)
+	e.short(classConnection)
+	e.short(method)
+	if werr := writeMethod(w, methodClose, e); werr != nil {
+		return werr
+	}
+	return err
+}
+
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) octet(v uint8) {
+	e.b = append(e.b, v)
+}
+
+func (e *encoder) short(v uint16) {
+	e.b = append(e.b, byte(v>>8), byte(v))
+}
+
+func (e *encoder) long(v uint32) {
+	e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) longlong(v uint64) {
+	e.long(uint32(v >> 32))
+	e.long(uint32(v))
+}
+
+// shortstr appends a short string, truncating it if it is too long.
+func (e *encoder) shortstr(s string) {
+	if len(s) > maxShortstrSize {
+		s = s[:maxShortstrSize]
+	}
+	e.octet(uint8(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) longstr(b []byte) {
+	e.long(uint32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// decoder reads primitive types from b, after the first error all reads
+// return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = ErrMalformed
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) octet() uint8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) short() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) long() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) longlong() uint64 {
+	return uint64(d.long())<<32 | uint64(d.long())
+}
+
+func (d *decoder) shortstr() string {
+	return string(d.next(int(d.octet())))
+}
+
+func (d *decoder) longstr() []byte {
+	n := d.long()
+	if n > uint32(len(d.b)) {
+		d.err = ErrMalformed
+		return nil
+	}
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,332 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package amqp091_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/amqp091"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+)
+
+func TestMechanisms(t *testing.T) {
+	mechs := amqp091.Mechanisms("AMQPLAIN  PLAIN SCRAM-SHA-256 ")
+	if want := []string{"AMQPLAIN", "PLAIN", "SCRAM-SHA-256"}; !reflect.DeepEqual(mechs, want) {
+		t.Errorf("Wrong mechanisms: want=%v, got=%v", want, mechs)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	props := amqp091.Table{
+		"product": "test",
+		"capabilities": amqp091.Table{
+			"authentication_failure_close": true,
+		},
+		"a": []interface{}{"x", int64(-2), nil},
+		"b": []byte("bytes"),
+		"d": amqp091.Decimal{Scale: 2, Value: 314},
+		"f": 1.5,
+		"i": int32(-1),
+		"u": uint8(1),
+	}
+	tune := amqp091.Tune{ChannelMax: 2047, FrameMax: 131072, Heartbeat: 60}
+	for i, tc := range [...]struct {
+		mechs      []sasl.Mechanism
+		serverMech sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		clientErr  error
+		serverErr  error
+		errCode    uint16
+	}{
+		0: {
+			mechs:      []sasl.Mechanism{sasl.Plain},
+			serverMech: sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		1: {
+			// The server signature has to be sent in Connection.Secure.
+			mechs:      []sasl.Mechanism{sasl.ScramSha256},
+			serverMech: sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+		},
+		2: {
+			mechs:      []sasl.Mechanism{sasl.Plain},
+			serverMech: sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			serverErr:  sasl.ErrAuthn,
+			errCode:    amqp091.ReplyAccessRefused,
+		},
+		3: {
+			mechs:      []sasl.Mechanism{sasl.ScramSha256},
+			serverMech: sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			serverErr:  sasl.ErrAuthn,
+			errCode:    amqp091.ReplyAccessRefused,
+		},
+		4: {
+			mechs:      []sasl.Mechanism{sasl.WithTOTP(sasl.Plain)},
+			serverMech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+		},
+		5: {
+			// There is no way to abort, so the client just hangs up.
+			mechs:      []sasl.Mechanism{sasl.WithTOTP(sasl.Plain)},
+			serverMech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  io.EOF,
+		},
+		6: {
+			mechs:      []sasl.Mechanism{sasl.ScramSha1},
+			serverMech: sasl.Plain,
+			clientErr:  amqp091.ErrNoMechanism,
+			serverErr:  io.EOF,
+		},
+		7: {
+			// The clients order of preference wins.
+			mechs:      []sasl.Mechanism{sasl.Plain, sasl.ScramSha256},
+			serverMech: sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		8: {
+			mechs:      []sasl.Mechanism{sasl.ScramSha256},
+			serverMech: sasltest.BadSignature(sasl.ScramSha256),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			clientErr:  sasl.ErrAuthn,
+			serverErr:  io.EOF,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			errs := make(chan error, 1)
+			clientProps := make(chan amqp091.Table, 1)
+			go func() {
+				defer serverConn.Close()
+				if err := amqp091.ReadHeader(serverConn); err != nil {
+					clientProps <- nil
+					errs <- err
+					return
+				}
+				s := amqp091.Server{
+					Properties: amqp091.Table{"product": "server"},
+					Mechanisms: []string{"SCRAM-SHA-256", "PLAIN"},
+					Negotiator: func(name string) *sasl.Negotiator {
+						if name != tc.serverMech.Name {
+							return nil
+						}
+						return sasl.NewServer(tc.serverMech, perm, tc.serverOpts...)
+					},
+					Tune: tune,
+				}
+				p, err := s.Handle(serverConn)
+				clientProps <- p
+				errs <- err
+				if err != nil {
+					// Wait for the client to finish with Connection.CloseOk.
+					io.Copy(ioutil.Discard, serverConn)
+				}
+			}()
+
+			gotTune, err := amqp091.Authenticate(clientConn, props, tc.mechs, tc.clientOpts...)
+			clientConn.Close()
+			if e, ok := err.(*amqp091.Error); ok {
+				if e.Code != tc.errCode {
+					t.Errorf("Unexpected reply code: want=%d, got=%d", tc.errCode, e.Code)
+				}
+			} else if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+			p := <-clientProps
+			if tc.clientErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(p, props) {
+				t.Errorf("Wrong client properties:\nwant=%#v\n got=%#v", props, p)
+			}
+			if tc.serverErr == nil && gotTune != tune {
+				t.Errorf("Wrong tune: want=%+v, got=%+v", tune, gotTune)
+			}
+		})
+	}
+}
+
+// The following functions are a minimal stand-in for the framing of a real
+// server.
+
+func shortstr(s string) string {
+	return string([]byte{byte(len(s))}) + s
+}
+
+func longstr(s string) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(len(s)))
+	return string(b[:]) + s
+}
+
+func method(id byte, args ...string) string {
+	var body string
+	for _, a := range args {
+		body += a
+	}
+	body = "\x00\x0a\x00" + string([]byte{id}) + body
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(body)))
+	return "\x01\x00\x00" + string(size[:]) + body + "\xce"
+}
+
+func TestClientFrames(t *testing.T) {
+	serverProps := longstr(shortstr("capabilities") + "F" + longstr(shortstr("publisher_confirms")+"t\x01") +
+		shortstr("version") + "S" + longstr("3.8.0") +
+		shortstr("cluster_name") + "s\x00\x01")
+	start := method(10, "\x00\x09", serverProps, longstr("AMQPLAIN PLAIN"), longstr("en_US"))
+	startOk := method(11, "\x00\x00\x00\x00", shortstr("PLAIN"), longstr("\x00user\x00pencil"), shortstr("en_US"))
+	tune := method(30, "\x07\xff", "\x00\x02\x00\x00", "\x00\x3c")
+	for i, tc := range [...]struct {
+		in   string
+		out  string
+		tune amqp091.Tune
+		err  error
+	}{
+		0: {
+			// A heartbeat is ignored.
+			in:   "\x08\x00\x00\x00\x00\x00\x00\xce" + start + tune,
+			out:  amqp091.Header + startOk,
+			tune: amqp091.Tune{ChannelMax: 2047, FrameMax: 131072, Heartbeat: 60},
+		},
+		1: {
+			in:  start + method(50, "\x01\x93", shortstr("ACCESS_REFUSED - Login was refused"), "\x00\x0a\x00\x0b"),
+			out: amqp091.Header + startOk + method(51),
+			err: &amqp091.Error{Code: 403, Text: "ACCESS_REFUSED - Login was refused", ClassID: 10, MethodID: 11},
+		},
+		2: {
+			in:  "AMQP\x00\x00\x09\x00",
+			out: amqp091.Header,
+			err: amqp091.ErrVersion,
+		},
+		3: {
+			in:  method(10, "\x00\x09", serverProps, longstr("AMQPLAIN"), longstr("en_US")),
+			out: amqp091.Header,
+			err: amqp091.ErrNoMechanism,
+		},
+		4: {
+			in:  start[:len(start)-1] + "\x00",
+			out: amqp091.Header,
+			err: amqp091.ErrMalformed,
+		},
+		5: {
+			in:  method(30, "\x07\xff", "\x00\x02\x00\x00", "\x00\x3c"),
+			out: amqp091.Header,
+			err: amqp091.ErrUnexpectedMethod,
+		},
+		6: {
+			in:  "\x01\x00\x00\x00\x02\x00\x01",
+			out: amqp091.Header,
+			err: amqp091.ErrFrameTooLong,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: bytes.NewReader([]byte(tc.in)),
+				Writer: &out,
+			}
+			tune, err := amqp091.Authenticate(rw, nil, []sasl.Mechanism{sasl.Plain}, sasltest.Creds("user", "pencil"))
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if tune != tc.tune {
+				t.Errorf("Wrong tune: want=%+v, got=%+v", tc.tune, tune)
+			}
+			if out.String() != tc.out {
+				t.Errorf("Unexpected output:\nwant=%q\n got=%q", tc.out, out.String())
+			}
+		})
+	}
+}
+
+func TestServerFinalResponse(t *testing.T) {
+	// final is a mechanism that succeeds straight away with additional data.
+	final := sasl.Mechanism{
+		Name: "X-FINAL",
+		Next: func(*sasl.Negotiator, []byte, interface{}) (bool, []byte, interface{}, error) {
+			return false, []byte("final"), nil, nil
+		},
+	}
+	startOk := method(11, "\x00\x00\x00\x00", shortstr("X-FINAL"), longstr(""), shortstr("en_US"))
+	secure := method(20, longstr("final"))
+	for i, tc := range [...]struct {
+		resp string
+		out  string
+		err  error
+	}{
+		0: {
+			out: secure + method(30, "\x00\x00", "\x00\x00\x00\x00", "\x00\x00"),
+		},
+		1: {
+			resp: "junk",
+			out:  secure + method(50, "\x01\x93", shortstr("ACCESS_REFUSED - Login was refused using authentication mechanism X-FINAL"), "\x00\x0a\x00\x15"),
+			err:  sasl.ErrInvalidChallenge,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: bytes.NewReader([]byte(startOk + method(21, longstr(tc.resp)))),
+				Writer: &out,
+			}
+			s := amqp091.Server{
+				Mechanisms: []string{"X-FINAL"},
+				Negotiator: func(string) *sasl.Negotiator {
+					return sasl.NewServer(final, nil)
+				},
+			}
+			_, err := s.Handle(rw)
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if !bytes.HasSuffix(out.Bytes(), []byte(tc.out)) {
+				t.Errorf("Unexpected output:\nwant suffix=%q\n        got=%q", tc.out, out.String())
+			}
+		})
+	}
+}
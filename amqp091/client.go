@@ -0,0 +1,130 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package amqp091
+
+import (
+	"io"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Authenticate sends the protocol header and negotiates the connection until
+// the server sends Connection.Tune, which is returned.
+//
+// The first of mechanisms that is also advertised in Connection.Start is used
+// to create a client Negotiator with opts and the servers mechanisms as its
+// remote mechanisms (see sasl.RemoteMechanisms).
+// If there is no such mechanism ErrNoMechanism is returned.
+// The properties in props are sent as the client properties in
+// Connection.StartOk along with the first locale offered by the server.
+//
+// If the server closes the connection the reply is returned as an *Error after
+// sending Connection.CloseOk.
+// The protocol has no way to abort authentication, so if the negotiator
+// returns an error it is returned immediately and the caller should close the
+// connection.
+func Authenticate(rw io.ReadWriter, props Table, mechanisms []sasl.Mechanism, opts ...sasl.Option) (Tune, error) {
+	if _, err := io.WriteString(rw, Header); err != nil {
+		return Tune{}, err
+	}
+	method, d, err := readMethod(rw)
+	if err != nil {
+		return Tune{}, err
+	}
+	if method != methodStart {
+		return Tune{}, ErrUnexpectedMethod
+	}
+	major, minor := d.octet(), d.octet()
+	d.table()
+	remote := Mechanisms(string(d.longstr()))
+	locales := strings.Fields(string(d.longstr()))
+	switch {
+	case d.err != nil:
+		return Tune{}, d.err
+	case major != 0 || minor != 9:
+		return Tune{}, ErrVersion
+	}
+
+	var mech *sasl.Mechanism
+find:
+	for i, m := range mechanisms {
+		for _, name := range remote {
+			if m.Name == name {
+				mech = &mechanisms[i]
+				break find
+			}
+		}
+	}
+	if mech == nil {
+		return Tune{}, ErrNoMechanism
+	}
+	locale := defaultLocale
+	if len(locales) > 0 {
+		locale = locales[0]
+	}
+
+	clientOpts := make([]sasl.Option, 0, len(opts)+1)
+	clientOpts = append(clientOpts, opts...)
+	clientOpts = append(clientOpts, sasl.RemoteMechanisms(remote...))
+	client := sasl.NewClient(*mech, clientOpts...)
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return Tune{}, err
+	}
+	e := &encoder{}
+	if err = e.table(props); err != nil {
+		return Tune{}, err
+	}
+	e.shortstr(mech.Name)
+	e.longstr(resp)
+	e.shortstr(locale)
+	if err = writeMethod(rw, methodStartOk, e); err != nil {
+		return Tune{}, err
+	}
+
+	for {
+		method, d, err := readMethod(rw)
+		if err != nil {
+			return Tune{}, err
+		}
+		switch method {
+		case methodSecure:
+			challenge := d.longstr()
+			if d.err != nil {
+				return Tune{}, d.err
+			}
+			more, resp, err = client.Step(challenge)
+			if err != nil {
+				return Tune{}, err
+			}
+			e := &encoder{}
+			e.longstr(resp)
+			if err = writeMethod(rw, methodSecureOk, e); err != nil {
+				return Tune{}, err
+			}
+		case methodTune:
+			tune := Tune{ChannelMax: d.short(), FrameMax: d.long(), Heartbeat: d.short()}
+			if d.err != nil {
+				return Tune{}, d.err
+			}
+			if more {
+				return Tune{}, sasl.ErrUnexpectedSuccess
+			}
+			return tune, nil
+		case methodClose:
+			closeErr := &Error{Code: d.short(), Text: d.shortstr(), ClassID: d.short(), MethodID: d.short()}
+			if d.err != nil {
+				return Tune{}, d.err
+			}
+			if err = writeMethod(rw, methodCloseOk, &encoder{}); err != nil {
+				return Tune{}, err
+			}
+			return Tune{}, closeErr
+		default:
+			return Tune{}, ErrUnexpectedMethod
+		}
+	}
+}
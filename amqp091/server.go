@@ -0,0 +1,135 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package amqp091
+
+import (
+	"io"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Server negotiates connections on behalf of a server.
+type Server struct {
+	// Properties are sent as the server properties in Connection.Start.
+	Properties Table
+
+	// Mechanisms are advertised in Connection.Start in order of preference.
+	Mechanisms []string
+
+	// Negotiator is called with the mechanism picked by the client and should
+	// return a server Negotiator (normally created with sasl.NewServer) or nil
+	// if the mechanism is not supported.
+	Negotiator func(mechanism string) *sasl.Negotiator
+
+	// Tune is sent in Connection.Tune once authentication succeeds.
+	Tune Tune
+}
+
+// Handle sends Connection.Start and authenticates the client.
+//
+// The caller should already have read the clients protocol header (see
+// ReadHeader).
+// The response in Connection.StartOk is always passed to the negotiator as the
+// initial response and further challenges are sent with Connection.Secure.
+// Because Connection.Tune cannot carry additional data, any data returned by
+// the negotiator on success is sent as a final challenge and the response to
+// it must be empty.
+// A non-empty response is refused with ACCESS_REFUSED and
+// sasl.ErrInvalidChallenge is returned.
+// Once authentication succeeds Connection.Tune is sent and the client
+// properties are returned.
+//
+// If authentication fails Connection.Close is sent and the error is returned.
+// The caller should then wait for Connection.CloseOk before closing the
+// connection.
+func (s Server) Handle(rw io.ReadWriter) (Table, error) {
+	e := &encoder{}
+	e.octet(0)
+	e.octet(9)
+	if err := e.table(s.Properties); err != nil {
+		return nil, err
+	}
+	e.longstr([]byte(strings.Join(s.Mechanisms, " ")))
+	e.longstr([]byte(defaultLocale))
+	if err := writeMethod(rw, methodStart, e); err != nil {
+		return nil, err
+	}
+
+	method, d, err := readMethod(rw)
+	if err != nil {
+		return nil, err
+	}
+	if method != methodStartOk {
+		return nil, writeClose(rw, ReplyCommandInvalid, "COMMAND_INVALID - expected connection.start-ok", method, ErrUnexpectedMethod)
+	}
+	props := d.table()
+	name := d.shortstr()
+	resp := d.longstr()
+	d.shortstr()
+	if d.err != nil {
+		return nil, writeClose(rw, ReplyFrameError, "FRAME_ERROR - malformed connection.start-ok", method, d.err)
+	}
+
+	var server *sasl.Negotiator
+	for _, m := range s.Mechanisms {
+		if m == name {
+			server = s.Negotiator(m)
+			break
+		}
+	}
+	if server == nil {
+		return props, writeClose(rw, ReplyCommandInvalid, "COMMAND_INVALID - unknown authentication mechanism "+name, method, ErrUnknownMechanism)
+	}
+
+	for {
+		more, data, err := server.Step(resp)
+		if err != nil {
+			return props, writeClose(rw, ReplyAccessRefused, "ACCESS_REFUSED - Login was refused using authentication mechanism "+name, method, err)
+		}
+		if !more && data == nil {
+			break
+		}
+		method = methodSecureOk
+		resp, err = challenge(rw, data)
+		if err != nil {
+			return props, err
+		}
+		if !more {
+			if len(resp) != 0 {
+				return props, writeClose(rw, ReplyAccessRefused, "ACCESS_REFUSED - Login was refused using authentication mechanism "+name, method, sasl.ErrInvalidChallenge)
+			}
+			break
+		}
+	}
+
+	e = &encoder{}
+	e.short(s.Tune.ChannelMax)
+	e.long(s.Tune.FrameMax)
+	e.short(s.Tune.Heartbeat)
+	return props, writeMethod(rw, methodTune, e)
+}
+
+// challenge sends Connection.Secure and reads the clients
+// Connection.SecureOk.
+func challenge(rw io.ReadWriter, data []byte) ([]byte, error) {
+	e := &encoder{}
+	e.longstr(data)
+	if err := writeMethod(rw, methodSecure, e); err != nil {
+		return nil, err
+	}
+	method, d, err := readMethod(rw)
+	if err != nil {
+		return nil, err
+	}
+	if method != methodSecureOk {
+		return nil, writeClose(rw, ReplyCommandInvalid, "COMMAND_INVALID - expected connection.secure-ok", method, ErrUnexpectedMethod)
+	}
+	resp := d.longstr()
+	if d.err != nil {
+		return nil, writeClose(rw, ReplyFrameError, "FRAME_ERROR - malformed connection.secure-ok", method, d.err)
+	}
+	return resp, nil
+}
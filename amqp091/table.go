@@ -0,0 +1,185 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// ErrFieldType is returned when encoding a Table containing a value of an
+// unsupported type.
+var ErrFieldType = errors.New("Unsupported field table value")
+
+// Table is a field table such as the server and client properties.
+//
+// Values may be nil, bool, int8, uint8, int16, uint16, int32, uint32, int64,
+// float32, float64, string, []byte, time.Time, Decimal, []interface{}
+// (containing any of these types) or Table.
+// Long strings are always decoded as strings.
+type Table map[string]interface{}
+
+// Decimal is a decimal value with the given number of decimal places.
+type Decimal struct {
+	Scale uint8
+	Value int32
+}
+
+func (e *encoder) table(t Table) error {
+	inner := &encoder{}
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		inner.shortstr(k)
+		if err := inner.field(t[k]); err != nil {
+			return err
+		}
+	}
+	e.longstr(inner.b)
+	return nil
+}
+
+func (e *encoder) field(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		e.octet('V')
+	case bool:
+		e.octet('t')
+		if v {
+			e.octet(1)
+		} else {
+			e.octet(0)
+		}
+	case int8:
+		e.octet('b')
+		e.octet(uint8(v))
+	case uint8:
+		e.octet('B')
+		e.octet(v)
+	case int16:
+		e.octet('s')
+		e.short(uint16(v))
+	case uint16:
+		e.octet('u')
+		e.short(v)
+	case int32:
+		e.octet('I')
+		e.long(uint32(v))
+	case uint32:
+		e.octet('i')
+		e.long(v)
+	case int64:
+		e.octet('l')
+		e.longlong(uint64(v))
+	case float32:
+		e.octet('f')
+		e.long(math.Float32bits(v))
+	case float64:
+		e.octet('d')
+		e.longlong(math.Float64bits(v))
+	case Decimal:
+		e.octet('D')
+		e.octet(v.Scale)
+		e.long(uint32(v.Value))
+	case string:
+		e.octet('S')
+		e.longstr([]byte(v))
+	case []byte:
+		e.octet('x')
+		e.longstr(v)
+	case time.Time:
+		e.octet('T')
+		e.longlong(uint64(v.Unix()))
+	case []interface{}:
+		e.octet('A')
+		inner := &encoder{}
+		for _, f := range v {
+			if err := inner.field(f); err != nil {
+				return err
+			}
+		}
+		e.longstr(inner.b)
+	case Table:
+		e.octet('F')
+		return e.table(v)
+	default:
+		return ErrFieldType
+	}
+	return nil
+}
+
+func (d *decoder) table() Table {
+	inner := &decoder{b: d.longstr()}
+	if d.err != nil {
+		return nil
+	}
+	t := make(Table)
+	for len(inner.b) > 0 && inner.err == nil {
+		k := inner.shortstr()
+		t[k] = inner.field()
+	}
+	if inner.err != nil {
+		d.err = inner.err
+		return nil
+	}
+	return t
+}
+
+func (d *decoder) field() interface{} {
+	switch d.octet() {
+	case 'V':
+		return nil
+	case 't':
+		return d.octet() != 0
+	case 'b':
+		return int8(d.octet())
+	case 'B':
+		return d.octet()
+	case 's':
+		return int16(d.short())
+	case 'u':
+		return d.short()
+	case 'I':
+		return int32(d.long())
+	case 'i':
+		return d.long()
+	case 'l':
+		return int64(d.longlong())
+	case 'f':
+		return math.Float32frombits(d.long())
+	case 'd':
+		return math.Float64frombits(d.longlong())
+	case 'D':
+		return Decimal{Scale: d.octet(), Value: int32(d.long())}
+	case 'S':
+		return string(d.longstr())
+	case 'x':
+		return d.longstr()
+	case 'T':
+		return time.Unix(int64(d.longlong()), 0)
+	case 'A':
+		inner := &decoder{b: d.longstr()}
+		a := []interface{}{}
+		for len(inner.b) > 0 && inner.err == nil {
+			a = append(a, inner.field())
+		}
+		if inner.err != nil {
+			d.err = inner.err
+			return nil
+		}
+		return a
+	case 'F':
+		return d.table()
+	}
+	if d.err == nil {
+		d.err = ErrMalformed
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package cassandra implements authentication for the Cassandra native (CQL)
+// protocol versions 3 and 4.
+//
+// After the client sends STARTUP, servers that require authentication respond
+// with AUTHENTICATE naming their authenticator class.
+// The client then sends tokens in AUTH_RESPONSE frames and the server answers
+// with AUTH_CHALLENGE until it sends AUTH_SUCCESS or an ERROR.
+// The exchange has no mechanism names, so both sides must agree on the
+// mechanism by other means; PasswordAuthenticator expects the PLAIN mechanism.
+//
+// Startup and Authenticate drive a client Negotiator and HandleStartup drives
+// a server Negotiator.
+// Neither reads past the end of the exchange.
+// Compression is not supported.
+package cassandra
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Version is the protocol version used by the client.
+const Version = 4
+
+// PasswordAuthenticator is the class name of the built in authenticator,
+// which is equivalent to PLAIN.
+const PasswordAuthenticator = "org.apache.cassandra.auth.PasswordAuthenticator"
+
+// Opcodes of the frames used during startup and authentication.
+const (
+	OpError         = 0x00
+	OpStartup       = 0x01
+	OpReady         = 0x02
+	OpAuthenticate  = 0x03
+	OpAuthChallenge = 0x0e
+	OpAuthResponse  = 0x0f
+	OpAuthSuccess   = 0x10
+)
+
+// Error codes used by the server.
+const (
+	ErrorProtocol       = 0x000a
+	ErrorBadCredentials = 0x0100
+)
+
+// Errors returned by the client and server.
+var (
+	ErrVersion         = errors.New("Unsupported protocol version")
+	ErrCompression     = errors.New("Compressed frames are not supported")
+	ErrUnexpectedFrame = errors.New("Unexpected frame")
+	ErrMalformed       = errors.New("Malformed frame")
+	ErrFrameTooLong    = errors.New("Frame too long")
+)
+
+// Error is returned by the client when the server responds with an ERROR
+// frame.
+type Error struct {
+	Code    int32
+	Message string
+}
+
+func (e *Error) Error() string {
+	s := "cassandra: error code 0x" + strconv.FormatInt(int64(e.Code), 16)
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+// Frame is a protocol frame.
+// For responses the high bit of Version is set.
+type Frame struct {
+	Version uint8
+	Flags   uint8
+	Stream  int16
+	Opcode  uint8
+	Body    []byte
+}
+
+const (
+	headerSize      = 9
+	flagCompression = 0x01
+	responseBit     = 0x80
+
+	// The server limits frames to 256MiB by default, but authentication frames
+	// are much smaller than that.
+	maxFrameSize = 1 << 20
+)
+
+// ReadFrame reads a frame from r.
+func ReadFrame(r io.Reader) (Frame, error) {
+	var h [headerSize]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return Frame{}, err
+	}
+	f := Frame{
+		Version: h[0],
+		Flags:   h[1],
+		Stream:  int16(binary.BigEndian.Uint16(h[2:])),
+		Opcode:  h[4],
+	}
+	size := binary.BigEndian.Uint32(h[5:])
+	switch v := f.Version &^ responseBit; {
+	case v < 3 || v > 4:
+		return Frame{}, ErrVersion
+	case size > maxFrameSize:
+		return Frame{}, ErrFrameTooLong
+	case f.Flags&flagCompression != 0:
+		return Frame{}, ErrCompression
+	}
+	f.Body = make([]byte, size)
+	if _, err := io.ReadFull(r, f.Body); err != nil {
+		return Frame{}, err
+	}
+	return f, nil
+}
+
+func writeFrame(w io.Writer, f Frame) error {
+	b := make([]byte, headerSize, headerSize+len(f.Body))
+	b[0] = f.Version
+	b[1] = f.Flags
+	binary.BigEndian.PutUint16(b[2:], uint16(f.Stream))
+	b[4] = f.Opcode
+	binary.BigEndian.PutUint32(b[5:], uint32(len(f.Body)))
+	_, err := w.Write(append(b, f.Body...))
+	return err
+}
+
+// appendString appends a [string], which has a 2 byte length.
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// appendBytes appends [bytes], which has a 4 byte length or -1 if nil.
+func appendBytes(b []byte, v []byte) []byte {
+	n := uint32(len(v))
+	if v == nil {
+		n = 0xffffffff
+	}
+	b = append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	return append(b, v...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, ErrMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if n > len(b)-2 {
+		return "", nil, ErrMalformed
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+// readBytes reads [bytes], returning nil for a null value.
+func readBytes(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, ErrMalformed
+	}
+	n := int32(binary.BigEndian.Uint32(b))
+	switch {
+	case n < 0:
+		return nil, nil
+	case int64(n) > int64(len(b)-4):
+		return nil, ErrMalformed
+	}
+	return append([]byte{}, b[4:4+n]...), nil
+}
+
+func parseError(body []byte) error {
+	if len(body) < 4 {
+		return ErrMalformed
+	}
+	msg, _, err := readString(body[4:])
+	if err != nil {
+		return err
+	}
+	return &Error{Code: int32(binary.BigEndian.Uint32(body)), Message: msg}
+}
@@ -0,0 +1,260 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package cassandra_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/cassandra"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+)
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech          sasl.Mechanism
+		authenticator string
+		noAuth        bool
+		clientOpts    []sasl.Option
+		serverOpts    []sasl.Option
+		perm          func(*sasl.Negotiator) bool
+		tamper        bool
+		clientErr     error
+		serverErr     error
+		errCode       int32
+	}{
+		0: {
+			mech:          sasl.Plain,
+			authenticator: cassandra.PasswordAuthenticator,
+			clientOpts:    []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		1: {
+			mech:          sasl.Plain,
+			authenticator: cassandra.PasswordAuthenticator,
+			clientOpts:    []sasl.Option{sasltest.Creds("user", "pen")},
+			serverErr:     sasl.ErrAuthn,
+			errCode:       cassandra.ErrorBadCredentials,
+		},
+		2: {
+			mech:          sasl.ScramSha256,
+			authenticator: "com.example.ScramAuthenticator",
+			clientOpts:    []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts:    []sasl.Option{sasltest.ScramSecrets},
+			perm:          sasltest.CheckUser,
+		},
+		3: {
+			mech:          sasl.ScramSha256,
+			authenticator: "com.example.ScramAuthenticator",
+			clientOpts:    []sasl.Option{sasltest.Creds("user", "pen")},
+			serverOpts:    []sasl.Option{sasltest.ScramSecrets},
+			perm:          sasltest.CheckUser,
+			serverErr:     sasl.ErrAuthn,
+			errCode:       cassandra.ErrorBadCredentials,
+		},
+		4: {
+			mech:          sasl.WithTOTP(sasl.Plain),
+			authenticator: "com.example.TOTPAuthenticator",
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+		},
+		5: {
+			// There is no way to abort, so the client just hangs up.
+			mech:          sasl.WithTOTP(sasl.Plain),
+			authenticator: "com.example.TOTPAuthenticator",
+			clientOpts:    []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:     sasl.ErrInvalidState,
+			serverErr:     io.EOF,
+		},
+		6: {
+			noAuth: true,
+		},
+		7: {
+			mech:          sasl.ScramSha256,
+			authenticator: "com.example.ScramAuthenticator",
+			clientOpts:    []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts:    []sasl.Option{sasltest.ScramSecrets},
+			perm:          sasltest.CheckUser,
+			tamper:        true,
+			clientErr:     sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			errs := make(chan error, 1)
+			go func() {
+				defer serverConn.Close()
+				f, err := cassandra.ReadFrame(serverConn)
+				if err != nil {
+					errs <- err
+					return
+				}
+				var server *sasl.Negotiator
+				mech := tc.mech
+				if tc.tamper {
+					mech = sasltest.BadSignature(mech)
+				}
+				if !tc.noAuth {
+					server = sasl.NewServer(mech, perm, tc.serverOpts...)
+				}
+				errs <- cassandra.HandleStartup(serverConn, f, tc.authenticator, server)
+			}()
+
+			authenticator, err := cassandra.Startup(clientConn, map[string]string{"DRIVER_NAME": "test"})
+			if err != nil {
+				t.Fatalf("Unexpected error during startup: %v", err)
+			}
+			if authenticator != tc.authenticator {
+				t.Errorf("Wrong authenticator: want=%q, got=%q", tc.authenticator, authenticator)
+			}
+			if authenticator != "" {
+				err = cassandra.Authenticate(clientConn, sasl.NewClient(tc.mech, tc.clientOpts...))
+			}
+			clientConn.Close()
+			if e, ok := err.(*cassandra.Error); ok {
+				if e.Code != tc.errCode {
+					t.Errorf("Unexpected error code: want=%#x, got=%#x", tc.errCode, e.Code)
+				}
+			} else if err != tc.clientErr || tc.errCode != 0 {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+func TestStartup(t *testing.T) {
+	const startup = "\x04\x00\x00\x00\x01\x00\x00\x00\x25" +
+		"\x00\x02\x00\x0bCOMPRESSION\x00\x00\x00\x0bCQL_VERSION\x00\x053.0.0"
+	for i, tc := range [...]struct {
+		in            string
+		authenticator string
+		err           error
+	}{
+		0: {
+			in: "\x84\x00\x00\x00\x02\x00\x00\x00\x00",
+		},
+		1: {
+			in:            "\x84\x00\x00\x00\x03\x00\x00\x00\x31\x00\x2f" + cassandra.PasswordAuthenticator,
+			authenticator: cassandra.PasswordAuthenticator,
+		},
+		2: {
+			in:  "\x84\x00\x00\x00\x00\x00\x00\x00\x0a\x00\x00\x00\x0a\x00\x04oops",
+			err: &cassandra.Error{Code: cassandra.ErrorProtocol, Message: "oops"},
+		},
+		3: {
+			in:  "\x85\x00\x00\x00\x02\x00\x00\x00\x00",
+			err: cassandra.ErrVersion,
+		},
+		4: {
+			in:  "\x84\x01\x00\x00\x02\x00\x00\x00\x00",
+			err: cassandra.ErrCompression,
+		},
+		5: {
+			in:  "\x84\x00\x00\x01\x02\x00\x00\x00\x00",
+			err: cassandra.ErrUnexpectedFrame,
+		},
+		6: {
+			in:  "\x84\x00\x00\x00\x02\x00\x20\x00\x00",
+			err: cassandra.ErrFrameTooLong,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: bytes.NewReader([]byte(tc.in)),
+				Writer: &out,
+			}
+			authenticator, err := cassandra.Startup(rw, map[string]string{"COMPRESSION": ""})
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if authenticator != tc.authenticator {
+				t.Errorf("Wrong authenticator: want=%q, got=%q", tc.authenticator, authenticator)
+			}
+			if out.String() != startup {
+				t.Errorf("Unexpected output:\nwant=%q\n got=%q", startup, out.String())
+			}
+		})
+	}
+}
+
+func TestServerOutput(t *testing.T) {
+	const (
+		startup = "\x03\x00\x00\x07\x01\x00\x00\x00\x02\x00\x00"
+		authn   = "\x83\x00\x00\x07\x03\x00\x00\x00\x31\x00\x2f" + cassandra.PasswordAuthenticator
+	)
+	for i, tc := range [...]struct {
+		in  string
+		out string
+		err error
+	}{
+		0: {
+			in:  startup + "\x03\x00\x00\x08\x0f\x00\x00\x00\x10\x00\x00\x00\x0c\x00user\x00pencil",
+			out: authn + "\x83\x00\x00\x08\x10\x00\x00\x00\x04\xff\xff\xff\xff",
+		},
+		1: {
+			in: startup + "\x03\x00\x00\x08\x07\x00\x00\x00\x00",
+			out: authn + "\x83\x00\x00\x08\x00\x00\x00\x00\x30\x00\x00\x00\x0a\x00\x2a" +
+				"Unexpected message, expected AUTH_RESPONSE",
+			err: cassandra.ErrUnexpectedFrame,
+		},
+		2: {
+			in: startup + "\x03\x00\x00\x08\x0f\x00\x00\x00\x0d\x00\x00\x00\x09\x00user\x00pen",
+			out: authn + "\x83\x00\x00\x08\x00\x00\x00\x00\x35\x00\x00\x01\x00\x00\x2f" +
+				"Provided username and/or password are incorrect",
+			err: sasl.ErrAuthn,
+		},
+		3: {
+			in: "\x03\x00\x00\x07\x05\x00\x00\x00\x00",
+			out: "\x83\x00\x00\x07\x00\x00\x00\x00\x2a\x00\x00\x00\x0a\x00\x24" +
+				"Unexpected message, expected STARTUP",
+			err: cassandra.ErrUnexpectedFrame,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: bytes.NewReader([]byte(tc.in)),
+				Writer: &out,
+			}
+			f, err := cassandra.ReadFrame(rw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cassandra.HandleStartup(rw, f, cassandra.PasswordAuthenticator, sasl.NewServer(sasl.Plain, sasltest.CheckPass))
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if out.String() != tc.out {
+				t.Errorf("Unexpected output:\nwant=%q\n got=%q", tc.out, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package cassandra
+
+import (
+	"io"
+	"sort"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Startup sends a STARTUP frame with options and returns the authenticator
+// class name if the server requires authentication or an empty string if it
+// responded with READY.
+//
+// If options does not contain CQL_VERSION it is set to "3.0.0".
+// If the server responds with an error it is returned as an *Error.
+func Startup(rw io.ReadWriter, options map[string]string) (authenticator string, err error) {
+	keys := make([]string, 0, len(options)+1)
+	for k := range options {
+		keys = append(keys, k)
+	}
+	if _, ok := options["CQL_VERSION"]; !ok {
+		keys = append(keys, "CQL_VERSION")
+	}
+	sort.Strings(keys)
+	body := []byte{byte(len(keys) >> 8), byte(len(keys))}
+	for _, k := range keys {
+		v, ok := options[k]
+		if !ok {
+			v = "3.0.0"
+		}
+		body = appendString(body, k)
+		body = appendString(body, v)
+	}
+	if err = writeFrame(rw, Frame{Version: Version, Opcode: OpStartup, Body: body}); err != nil {
+		return "", err
+	}
+
+	f, err := readResponse(rw)
+	if err != nil {
+		return "", err
+	}
+	switch f.Opcode {
+	case OpReady:
+		return "", nil
+	case OpAuthenticate:
+		authenticator, _, err = readString(f.Body)
+		return authenticator, err
+	case OpError:
+		return "", parseError(f.Body)
+	}
+	return "", ErrUnexpectedFrame
+}
+
+// Authenticate runs the exchange using client after Startup has returned an
+// authenticator, until the server sends AUTH_SUCCESS or an error.
+//
+// If the server responds with an error it is returned as an *Error.
+// The protocol has no way to abort authentication, so if the negotiator
+// returns an error it is returned immediately and the caller should close the
+// connection.
+func Authenticate(rw io.ReadWriter, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+	for {
+		err = writeFrame(rw, Frame{Version: Version, Opcode: OpAuthResponse, Body: appendBytes(nil, resp)})
+		if err != nil {
+			return err
+		}
+		f, err := readResponse(rw)
+		if err != nil {
+			return err
+		}
+		switch f.Opcode {
+		case OpAuthChallenge:
+		case OpAuthSuccess:
+			token, err := readBytes(f.Body)
+			if err != nil {
+				return err
+			}
+			if more && token != nil {
+				more, _, err = client.Step(token)
+				if err != nil {
+					return err
+				}
+			}
+			if more {
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		case OpError:
+			return parseError(f.Body)
+		default:
+			return ErrUnexpectedFrame
+		}
+
+		challenge, err := readBytes(f.Body)
+		if err != nil {
+			return err
+		}
+		if challenge == nil {
+			challenge = []byte{}
+		}
+		more, resp, err = client.Step(challenge)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			resp = []byte{}
+		}
+	}
+}
+
+// readResponse reads a frame and checks that it is a response to one of our
+// requests.
+func readResponse(r io.Reader) (Frame, error) {
+	f, err := ReadFrame(r)
+	if err != nil {
+		return Frame{}, err
+	}
+	if f.Version != Version|responseBit || f.Stream != 0 {
+		return Frame{}, ErrUnexpectedFrame
+	}
+	return f, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package cassandra
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// HandleStartup responds to a STARTUP frame on behalf of a server and
+// authenticates the client using server.
+//
+// The startup argument is the STARTUP frame which was already read by the
+// caller (see ReadFrame), and responses use the same protocol version and
+// stream.
+// If server is nil no authentication is required and READY is sent.
+// Otherwise AUTHENTICATE is sent with the authenticator class name, the token
+// in the first AUTH_RESPONSE is always used as the initial response, and
+// challenges are sent in AUTH_CHALLENGE frames.
+// Any additional data returned by the negotiator on success is sent in
+// AUTH_SUCCESS.
+func HandleStartup(rw io.ReadWriter, startup Frame, authenticator string, server *sasl.Negotiator) error {
+	if startup.Opcode != OpStartup {
+		return writeErr(rw, startup, ErrorProtocol, "Unexpected message, expected STARTUP", ErrUnexpectedFrame)
+	}
+	if server == nil {
+		return respond(rw, startup, OpReady, nil)
+	}
+	if err := respond(rw, startup, OpAuthenticate, appendString(nil, authenticator)); err != nil {
+		return err
+	}
+
+	req, resp, err := readAuthResponse(rw, startup)
+	if err != nil {
+		return err
+	}
+	for {
+		more, data, err := server.Step(resp)
+		switch {
+		case err == sasl.ErrAuthn:
+			return writeErr(rw, req, ErrorBadCredentials, "Provided username and/or password are incorrect", err)
+		case err != nil:
+			return writeErr(rw, req, ErrorBadCredentials, "Authentication failed", err)
+		case !more:
+			return respond(rw, req, OpAuthSuccess, appendBytes(nil, data))
+		}
+		if data == nil {
+			data = []byte{}
+		}
+		if err = respond(rw, req, OpAuthChallenge, appendBytes(nil, data)); err != nil {
+			return err
+		}
+		req, resp, err = readAuthResponse(rw, startup)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readAuthResponse reads an AUTH_RESPONSE frame and returns it and its token.
+func readAuthResponse(rw io.ReadWriter, startup Frame) (Frame, []byte, error) {
+	f, err := ReadFrame(rw)
+	if err != nil {
+		return Frame{}, nil, err
+	}
+	if f.Opcode != OpAuthResponse {
+		return Frame{}, nil, writeErr(rw, f, ErrorProtocol, "Unexpected message, expected AUTH_RESPONSE", ErrUnexpectedFrame)
+	}
+	if f.Version != startup.Version {
+		return Frame{}, nil, writeErr(rw, f, ErrorProtocol, "Protocol version changed during authentication", ErrVersion)
+	}
+	token, err := readBytes(f.Body)
+	if err != nil {
+		return Frame{}, nil, writeErr(rw, f, ErrorProtocol, "Malformed AUTH_RESPONSE", err)
+	}
+	if token == nil {
+		token = []byte{}
+	}
+	return f, token, nil
+}
+
+func respond(w io.Writer, req Frame, opcode uint8, body []byte) error {
+	return writeFrame(w, Frame{Version: req.Version | responseBit, Stream: req.Stream, Opcode: opcode, Body: body})
+}
+
+// writeErr sends an ERROR frame and returns err, or the write error if there
+// was one.
+func writeErr(w io.Writer, req Frame, code uint32, msg string, err error) error {
+	body := []byte{byte(code >> 24), byte(code >> 16), byte(code >> 8), byte(code)}
+	if werr := respond(w, req, OpError, appendString(body, msg)); werr != nil {
+		return werr
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package dovecot implements the server side of the Dovecot authentication
+// protocol used by auth clients such as Postfix and Exim to delegate SASL
+// authentication to another process, normally over a Unix socket.
+//
+// When a client connects the server sends a handshake listing its mechanisms:
+//
+//	VERSION	1	2
+//	MECH	PLAIN	plaintext
+//	MECH	SCRAM-SHA-256	mutual-auth
+//	SPID	1234
+//	CUID	1
+//	DONE
+//
+// The client sends its own VERSION and CPID lines and then any number of
+// requests, each with an ID that is unique on the connection:
+//
+//	AUTH	1	PLAIN	service=smtp	resp=AHVzZXIAcGVuY2ls
+//
+// The server responds with CONT lines carrying a challenge (which the client
+// answers with a CONT line of its own) until it finishes the request with OK
+// or FAIL.
+package dovecot
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// The protocol version implemented by Server.
+const (
+	MajorVersion = 1
+	MinorVersion = 2
+)
+
+// Security flags sent with each mechanism in the handshake.
+const (
+	FlagAnonymous      = "anonymous"
+	FlagPlaintext      = "plaintext"
+	FlagDictionary     = "dictionary"
+	FlagActive         = "active"
+	FlagForwardSecrecy = "forward-secrecy"
+	FlagMutualAuth     = "mutual-auth"
+)
+
+// Dovecot limits lines from auth clients to 16KiB.
+const maxLineLen = 16384
+
+// Limits used by Server if MaxRequests or Timeout are not set.
+const (
+	DefaultMaxRequests = 100
+	DefaultTimeout     = time.Minute
+)
+
+// Errors returned by the server.
+// Each of them ends the connection.
+var (
+	ErrVersion           = errors.New("Unsupported protocol version")
+	ErrUnexpectedCommand = errors.New("Unexpected command")
+	ErrMalformed         = errors.New("Malformed command")
+	ErrDuplicateID       = errors.New("Authentication request ID already in use")
+	ErrLineTooLong       = wire.ErrLineTooLong
+)
+
+// Flags returns the security flags of well known mechanisms.
+// It is used by Server if no Flags function is set.
+func Flags(mechanism string) []string {
+	switch {
+	case mechanism == "PLAIN" || mechanism == "LOGIN":
+		return []string{FlagPlaintext}
+	case mechanism == "ANONYMOUS":
+		return []string{FlagAnonymous}
+	case mechanism == "CRAM-MD5" || mechanism == "DIGEST-MD5":
+		return []string{FlagDictionary, FlagActive}
+	case strings.HasPrefix(mechanism, "SCRAM-"):
+		return []string{FlagMutualAuth}
+	}
+	return nil
+}
+
+var (
+	escaper   = strings.NewReplacer("\x01", "\x011", "\t", "\x01t", "\r", "\x01r", "\n", "\x01n")
+	unescaper = strings.NewReplacer("\x011", "\x01", "\x01t", "\t", "\x01r", "\r", "\x01n", "\n")
+)
+
+// escape escapes a parameter value so that it can be sent in a tab separated
+// line.
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// unescape reverses escape.
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
+
+// params parses the name=value parameters of a request.
+// Parameters without a value (eg. "secured") are set to the empty string.
+func params(fields []string) map[string]string {
+	p := make(map[string]string, len(fields))
+	for _, f := range fields {
+		i := strings.IndexByte(f, '=')
+		if i < 0 {
+			p[f] = ""
+			continue
+		}
+		p[f[:i]] = unescape(f[i+1:])
+	}
+	return p
+}
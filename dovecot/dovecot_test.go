@@ -0,0 +1,335 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package dovecot_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/dovecot"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+)
+
+// checkPass also accepts a missing password, which is what SCRAM servers pass
+// to the permissions function.
+func checkPass(n *sasl.Negotiator) bool {
+	_, pass, _ := n.Credentials()
+	return sasltest.CheckPass(n) || sasltest.CheckUser(n) && pass == nil
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// listen starts a server on a Unix socket in a temporary directory and returns
+// the path of the socket and a function that stops the server.
+func listen(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "dovecot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "auth-client")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	s := &dovecot.Server{
+		Mechanisms:  []sasl.Mechanism{sasl.Plain, sasl.ScramSha256},
+		Permissions: checkPass,
+		Options:     []sasl.Option{sasltest.ScramSecrets},
+	}
+	go s.Serve(l)
+	return path, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// client is a minimal auth client.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, path string) client {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c client) send(line string) {
+	if _, err := io.WriteString(c.conn, line+"\n"); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c client) recv() string {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+// handshake reads the servers handshake and sends the clients.
+func (c client) handshake() []string {
+	var lines []string
+	for {
+		line := c.recv()
+		lines = append(lines, line)
+		if line == "DONE" {
+			break
+		}
+	}
+	c.send("VERSION\t1\t2")
+	c.send("CPID\t" + strconv.Itoa(os.Getpid()))
+	return lines
+}
+
+func TestHandshake(t *testing.T) {
+	path, stop := listen(t)
+	defer stop()
+
+	for cuid := 1; cuid <= 2; cuid++ {
+		c := dial(t, path)
+		lines := c.handshake()
+		c.conn.Close()
+		want := []string{
+			"VERSION\t1\t2",
+			"MECH\tPLAIN\tplaintext",
+			"MECH\tSCRAM-SHA-256\tmutual-auth",
+			"SPID\t" + strconv.Itoa(os.Getpid()),
+			"CUID\t" + strconv.Itoa(cuid),
+			"DONE",
+		}
+		if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+			t.Errorf("Wrong handshake:\nwant=%q\n got=%q", want, lines)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	path, stop := listen(t)
+	defer stop()
+
+	for i, tc := range [...][]string{
+		0: {
+			"AUTH\t1\tPLAIN\tservice=smtp\tresp=" + b64("\x00user\x00pencil"),
+			"OK\t1\tuser=user",
+		},
+		1: {
+			"AUTH\t1\tPLAIN\tservice=smtp\tsecured",
+			"CONT\t1\t",
+			"CONT\t1\t" + b64("\x00user\x00pencil"),
+			"OK\t1\tuser=user",
+		},
+		2: {
+			"AUTH\t1\tPLAIN\tservice=smtp\tresp=" + b64("\x00user\x00pen"),
+			"FAIL\t1",
+		},
+		3: {
+			"AUTH\t1\tPLAIN\tservice=smtp\tresp=" + b64("ad\tmin\x00user\x00pencil"),
+			"OK\t1\tuser=ad\x01tmin",
+		},
+		4: {
+			"AUTH\t1\tCRAM-MD5\tservice=smtp",
+			"FAIL\t1\treason=Unsupported authentication mechanism",
+		},
+		5: {
+			"CONT\t2\t" + b64("\x00user\x00pencil"),
+			"FAIL\t2\treason=Authentication request timed out",
+		},
+		6: {
+			"AUTH\t1\tPLAIN\tservice=smtp\tresp=!",
+			"FAIL\t1\treason=Invalid base64 data in initial response",
+		},
+		7: {
+			// Requests may be interleaved and IDs reused once they are finished.
+			"AUTH\t1\tPLAIN\tservice=smtp",
+			"CONT\t1\t",
+			"AUTH\t2\tPLAIN\tservice=smtp\tresp=" + b64("\x00user\x00pencil"),
+			"OK\t2\tuser=user",
+			"CONT\t1\t" + b64("\x00user\x00pencil"),
+			"OK\t1\tuser=user",
+			"AUTH\t1\tPLAIN\tservice=smtp\tresp=" + b64("\x00user\x00pencil"),
+			"OK\t1\tuser=user",
+		},
+		8: {
+			// CANCEL has no reply.
+			"AUTH\t3\tPLAIN\tservice=smtp",
+			"CONT\t3\t",
+			"CANCEL\t3\nCONT\t3\t" + b64("\x00user\x00pencil"),
+			"FAIL\t3\treason=Authentication request timed out",
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			c := dial(t, path)
+			defer c.conn.Close()
+			c.handshake()
+			for j := 0; j < len(tc); j += 2 {
+				c.send(tc[j])
+				if line := c.recv(); line != tc[j+1] {
+					t.Fatalf("Wrong reply to %q:\nwant=%q\n got=%q", tc[j], tc[j+1], line)
+				}
+			}
+		})
+	}
+}
+
+func TestScram(t *testing.T) {
+	path, stop := listen(t)
+	defer stop()
+	c := dial(t, path)
+	defer c.conn.Close()
+	c.handshake()
+
+	client := sasl.NewClient(sasl.ScramSha256, sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte("user"), []byte("pencil"), nil
+	}))
+	_, resp, err := client.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.send("AUTH\t7\tSCRAM-SHA-256\tservice=imap\tresp=" + base64.StdEncoding.EncodeToString(resp))
+	for {
+		fields := strings.Split(c.recv(), "\t")
+		if len(fields) < 2 || fields[1] != "7" {
+			t.Fatalf("Unexpected reply: %q", fields)
+		}
+		switch fields[0] {
+		case "CONT":
+			challenge, err := base64.StdEncoding.DecodeString(fields[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, resp, err = client.Step(challenge); err != nil {
+				t.Fatal(err)
+			}
+			c.send("CONT\t7\t" + base64.StdEncoding.EncodeToString(resp))
+			continue
+		case "OK":
+			if len(fields) != 4 || fields[2] != "user=user" || !strings.HasPrefix(fields[3], "resp=") {
+				t.Fatalf("Unexpected reply: %q", fields)
+			}
+			data, err := base64.StdEncoding.DecodeString(fields[3][5:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if more, _, err := client.Step(data); more || err != nil {
+				t.Errorf("Server signature not accepted: more=%t, err=%v", more, err)
+			}
+		default:
+			t.Fatalf("Unexpected reply: %q", fields)
+		}
+		return
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	for i, tc := range [...]struct {
+		lines string
+		max   int
+		reply string
+		err   error
+	}{
+		0: {lines: "VERSION\t1\t5\nCPID\t1\n"},
+		1: {lines: "VERSION\t2\t0\n", err: dovecot.ErrVersion},
+		2: {lines: "CPID\t1\n", err: dovecot.ErrUnexpectedCommand},
+		3: {lines: "VERSION\t1\t2\nAUTH\t0\tPLAIN\n", err: dovecot.ErrMalformed},
+		4: {
+			lines: "VERSION\t1\t2\nAUTH\t1\tPLAIN\nAUTH\t1\tPLAIN\n",
+			reply: "CONT\t1\t\n",
+			err:   dovecot.ErrDuplicateID,
+		},
+		5: {lines: "VERSION\t1\t2\nREQUEST\t1\n", err: dovecot.ErrUnexpectedCommand},
+		6: {
+			// A cancelled request can not be continued.
+			lines: "VERSION\t1\t2\nAUTH\t1\tPLAIN\nCANCEL\t1\nCONT\t1\tAHVzZXIAcGVuY2ls\n",
+			reply: "CONT\t1\t\nFAIL\t1\treason=Authentication request timed out\n",
+		},
+		7: {lines: "VERSION\t1\t2\nCANCEL\n", err: dovecot.ErrMalformed},
+		8: {lines: "VERSION\t1\t2\nCONT\t1\n", err: dovecot.ErrMalformed},
+		9: {
+			lines: "VERSION\t1\t2\nAUTH\t1\tPLAIN\nCONT\t1\t!!\n",
+			reply: "CONT\t1\t\nFAIL\t1\treason=Invalid base64 data in continued response\n",
+		},
+		10: {
+			lines: "VERSION\t1\t2\nAUTH\t1\tPLAIN\tresp=" + strings.Repeat("A", 20000) + "\n",
+			err:   dovecot.ErrLineTooLong,
+		},
+		11: {
+			lines: "VERSION\t1\t2\nAUTH\t1\tPLAIN\nAUTH\t2\tPLAIN\nCONT\t1\tAHVzZXIAcGVuY2ls\nAUTH\t2\tPLAIN\n",
+			max:   1,
+			reply: "CONT\t1\t\nFAIL\t2\treason=Too many pending authentication requests\nOK\t1\tuser=user\nCONT\t2\t\n",
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			s := &dovecot.Server{
+				Mechanisms:  []sasl.Mechanism{sasl.Plain},
+				Permissions: sasltest.CheckPass,
+				MaxRequests: tc.max,
+			}
+			var out strings.Builder
+			err := s.ServeConn(struct {
+				io.Reader
+				io.Writer
+			}{strings.NewReader(tc.lines), &out})
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if !strings.HasPrefix(out.String(), "VERSION\t1\t2\nMECH\tPLAIN\tplaintext\n") {
+				t.Errorf("Unexpected handshake: %q", out.String())
+			}
+			if !strings.HasSuffix(out.String(), "DONE\n"+tc.reply) {
+				t.Errorf("Unexpected reply: want=%q, got=%q", tc.reply, out.String())
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	s := &dovecot.Server{
+		Mechanisms:  []sasl.Mechanism{sasl.Plain},
+		Permissions: sasltest.CheckPass,
+		Timeout:     time.Millisecond,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.ServeConn(serverConn)
+		serverConn.Close()
+	}()
+
+	c := client{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}
+	c.handshake()
+	c.send("AUTH\t1\tPLAIN\tservice=smtp")
+	if line := c.recv(); line != "CONT\t1\t" {
+		t.Fatalf("Unexpected reply: %q", line)
+	}
+	time.Sleep(10 * time.Millisecond)
+	c.send("CONT\t1\t" + b64("\x00user\x00pencil"))
+	if want, line := "FAIL\t1\treason=Authentication request timed out", c.recv(); line != want {
+		t.Errorf("Unexpected reply: want=%q, got=%q", want, line)
+	}
+	clientConn.Close()
+	if err := <-errs; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package dovecot
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// Server answers authentication requests from Dovecot auth clients.
+// A Server must not be copied after first use.
+type Server struct {
+	// Mechanisms are the mechanisms advertised to clients.
+	// -PLUS variants must not be used.
+	Mechanisms []sasl.Mechanism
+
+	// Permissions is passed to sasl.NewServer.
+	Permissions func(*sasl.Negotiator) bool
+
+	// Options are passed to sasl.NewServer (eg. sasl.ScramSecrets if a SCRAM
+	// mechanism is offered).
+	Options []sasl.Option
+
+	// Flags returns the security flags that are advertised for a mechanism.
+	// If it is nil the package level Flags function is used.
+	Flags func(mechanism string) []string
+
+	// MaxRequests is the number of requests that may be waiting for the client
+	// to respond to a challenge on a single connection.
+	// Further requests fail until one of them finishes.
+	// If it is zero DefaultMaxRequests is used.
+	MaxRequests int
+
+	// Timeout is how long a request waits for the client to respond to a
+	// challenge.
+	// Like Dovecot, requests continued after they expired fail with
+	// "Authentication request timed out".
+	// If it is zero DefaultTimeout is used.
+	Timeout time.Duration
+
+	cuid uint32
+}
+
+// request is an authentication request that is waiting for the client to
+// respond to a challenge.
+type request struct {
+	server  *sasl.Negotiator
+	user    string
+	expires time.Time
+}
+
+// Serve accepts connections from l and handles each of them in a new goroutine
+// with ServeConn.
+// It returns when l returns an error from Accept.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn sends the handshake to a client and answers its requests until it
+// disconnects.
+// If the client closes the connection ServeConn returns nil, otherwise the
+// error that ended the connection is returned and the caller should close it.
+func (s *Server) ServeConn(rw io.ReadWriter) error {
+	cuid := atomic.AddUint32(&s.cuid, 1)
+	flags := s.Flags
+	if flags == nil {
+		flags = Flags
+	}
+	var b strings.Builder
+	b.WriteString("VERSION\t" + strconv.Itoa(MajorVersion) + "\t" + strconv.Itoa(MinorVersion) + "\n")
+	for _, m := range s.Mechanisms {
+		b.WriteString("MECH\t" + m.Name)
+		for _, f := range flags(m.Name) {
+			b.WriteString("\t" + f)
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString("SPID\t" + strconv.Itoa(os.Getpid()) + "\n")
+	b.WriteString("CUID\t" + strconv.FormatUint(uint64(cuid), 10) + "\n")
+	b.WriteString("DONE\n")
+	if _, err := io.WriteString(rw, b.String()); err != nil {
+		return err
+	}
+
+	// The connection belongs to us so we can buffer reads.
+	r := bufio.NewReader(rw)
+	fields, err := readLine(r)
+	switch {
+	case err == io.EOF:
+		return nil
+	case err != nil:
+		return err
+	case fields[0] != "VERSION" || len(fields) < 3:
+		return ErrUnexpectedCommand
+	case fields[1] != strconv.Itoa(MajorVersion):
+		return ErrVersion
+	}
+
+	requests := make(map[string]*request)
+	for {
+		fields, err = readLine(r)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		now := time.Now()
+		for id, req := range requests {
+			if now.After(req.expires) {
+				delete(requests, id)
+			}
+		}
+		switch fields[0] {
+		case "CPID":
+		case "AUTH":
+			err = s.auth(rw, requests, fields[1:])
+		case "CONT":
+			err = s.cont(rw, requests, fields[1:])
+		case "CANCEL":
+			if len(fields) != 2 {
+				return ErrMalformed
+			}
+			delete(requests, fields[1])
+		default:
+			return ErrUnexpectedCommand
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// auth starts a new request.
+func (s *Server) auth(w io.Writer, requests map[string]*request, fields []string) error {
+	if len(fields) < 2 || !validID(fields[0]) {
+		return ErrMalformed
+	}
+	id, name := fields[0], fields[1]
+	if _, ok := requests[id]; ok {
+		return ErrDuplicateID
+	}
+	max := s.MaxRequests
+	if max == 0 {
+		max = DefaultMaxRequests
+	}
+	if len(requests) >= max {
+		return writeLine(w, "FAIL", id, "reason=Too many pending authentication requests")
+	}
+	var mech sasl.Mechanism
+	var ok bool
+	for _, m := range s.Mechanisms {
+		if m.Name == name {
+			mech, ok = m, true
+			break
+		}
+	}
+	if !ok {
+		return writeLine(w, "FAIL", id, "reason=Unsupported authentication mechanism")
+	}
+
+	req := &request{}
+	perm := func(n *sasl.Negotiator) bool {
+		if s.Permissions == nil || !s.Permissions(n) {
+			return false
+		}
+		username, _, identity := n.Credentials()
+		req.user = string(username)
+		if len(identity) > 0 {
+			req.user = string(identity)
+		}
+		return true
+	}
+	req.server = sasl.NewServer(mech, perm, s.Options...)
+
+	initial, ok := params(fields[2:])["resp"]
+	if !ok {
+		// No initial response, send an empty challenge to ask for one.
+		s.wait(requests, id, req)
+		return writeLine(w, "CONT", id, "")
+	}
+	resp, err := base64.StdEncoding.DecodeString(initial)
+	if err != nil {
+		return writeLine(w, "FAIL", id, "reason=Invalid base64 data in initial response")
+	}
+	return s.step(w, requests, id, req, resp)
+}
+
+// cont continues a request with the clients response to a challenge.
+func (s *Server) cont(w io.Writer, requests map[string]*request, fields []string) error {
+	if len(fields) != 2 || !validID(fields[0]) {
+		return ErrMalformed
+	}
+	id := fields[0]
+	req, ok := requests[id]
+	if !ok {
+		// This is what Dovecot sends, the request may have been cancelled or the
+		// ID reused by a confused client.
+		return writeLine(w, "FAIL", id, "reason=Authentication request timed out")
+	}
+	resp, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		delete(requests, id)
+		return writeLine(w, "FAIL", id, "reason=Invalid base64 data in continued response")
+	}
+	return s.step(w, requests, id, req, resp)
+}
+
+// step passes a response to the negotiator and replies with the next
+// challenge or the outcome of the request.
+func (s *Server) step(w io.Writer, requests map[string]*request, id string, req *request, resp []byte) error {
+	more, data, err := req.server.Step(resp)
+	switch {
+	case err != nil:
+		delete(requests, id)
+		return writeLine(w, "FAIL", id)
+	case more:
+		s.wait(requests, id, req)
+		return writeLine(w, "CONT", id, base64.StdEncoding.EncodeToString(data))
+	}
+	delete(requests, id)
+	if data != nil {
+		return writeLine(w, "OK", id, "user="+escape(req.user), "resp="+base64.StdEncoding.EncodeToString(data))
+	}
+	return writeLine(w, "OK", id, "user="+escape(req.user))
+}
+
+// wait stores a request until the client responds to its challenge or the
+// request expires.
+func (s *Server) wait(requests map[string]*request, id string, req *request) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	req.expires = time.Now().Add(timeout)
+	requests[id] = req
+}
+
+// validID reports whether id is a valid request ID.
+func validID(id string) bool {
+	n, err := strconv.ParseUint(id, 10, 32)
+	return err == nil && n > 0
+}
+
+func readLine(r io.Reader) ([]string, error) {
+	line, err := wire.ReadLine(r, maxLineLen)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(line), "\t"), nil
+}
+
+func writeLine(w io.Writer, fields ...string) error {
+	_, err := io.WriteString(w, strings.Join(fields, "\t")+"\n")
+	return err
+}
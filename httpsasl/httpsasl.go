@@ -0,0 +1,55 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package httpsasl implements the HTTP SASL authentication scheme from
+// draft-vanrein-httpauth-sasl, which can carry any SASL mechanism.
+//
+// The server advertises its mechanisms in a challenge such as
+//
+//	WWW-Authenticate: SASL realm="example.net", mech="SCRAM-SHA-256 PLAIN"
+//
+// and the client picks one and sends its initial response (if any) in the c2s
+// parameter.
+// While the mechanism needs more data the server responds with 401
+// Unauthorized, its challenge in the s2c parameter, and an opaque s2s
+// parameter that the client echoes along with its next response.
+// Once the exchange succeeds the request is served and any additional data from
+// the server is sent in the s2c parameter of the Authentication-Info header.
+//
+// Server is middleware that handles the server side and Transport is an
+// http.RoundTripper for clients.
+// Because an exchange spans several requests which may be handled by different
+// machines, Server keeps what it needs to rebuild the Negotiator in a Store.
+package httpsasl
+
+import (
+	"github.com/whenspeakteam/sasl/internal/httpauth"
+)
+
+// Scheme is the name of the authentication scheme.
+const Scheme = "SASL"
+
+// Errors returned by a Store.
+var (
+	ErrSessionExists = httpauth.ErrSessionExists
+	ErrStoreFull     = httpauth.ErrStoreFull
+)
+
+// DefaultMaxSessions is the number of sessions that a MemoryStore keeps if Max
+// is not set.
+const DefaultMaxSessions = httpauth.DefaultMaxSessions
+
+// Session is the state that is kept between the requests of an exchange.
+// It contains no secrets: the server negotiator is rebuilt by replaying the
+// clients earlier responses with the same nonce.
+type Session = httpauth.Session
+
+// Store keeps sessions between requests.
+// Implementations that are shared by multiple servers (eg. backed by a
+// database) let each request of an exchange be handled by any of them.
+type Store = httpauth.Store
+
+// MemoryStore is a Store that keeps sessions in memory.
+// The zero value is ready to use, and Max limits the number of sessions.
+type MemoryStore = httpauth.MemoryStore
@@ -0,0 +1,268 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package httpsasl_test
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/httpsasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+)
+
+// checkPass also accepts a missing password, which is what SCRAM servers pass
+// to the permissions function.
+func checkPass(n *sasl.Negotiator) bool {
+	_, pass, _ := n.Credentials()
+	return sasltest.CheckPass(n) || sasltest.CheckUser(n) && pass == nil
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	user, identity, ok := httpsasl.User(r.Context())
+	if !ok {
+		panic("handler called without a user")
+	}
+	io.WriteString(w, user+":"+identity)
+})
+
+func newServer() *httpsasl.Server {
+	return &httpsasl.Server{
+		Realm:       "example.com",
+		Mechanisms:  []sasl.Mechanism{sasl.ScramSha256, sasl.Plain},
+		Permissions: checkPass,
+		Options:     []sasl.Option{sasltest.ScramSecrets},
+	}
+}
+
+func serve(h http.Handler, auth string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/resource", nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// param returns the value of an unquoted parameter from a header.
+func param(header, name string) string {
+	for _, p := range strings.Split(header, ",") {
+		p = strings.TrimSpace(p)
+		if i := strings.LastIndex(p, " "); i >= 0 {
+			p = p[i+1:]
+		}
+		if strings.HasPrefix(p, name+"=") {
+			return strings.Trim(p[len(name)+1:], `"`)
+		}
+	}
+	return ""
+}
+
+func TestChallenge(t *testing.T) {
+	for i, auth := range [...]string{
+		0: "",
+		1: "Basic dXNlcjpwZW5jaWw=",
+		2: `SASL realm="example.com", mech="CRAM-MD5", c2s=dXNlcg==`,
+		3: `SASL realm="example.com", mech="PLAIN", c2s=!`,
+		4: `SASL realm="example.com", s2s=AAAABBBB, c2s=dXNlcg==`,
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			w := serve(newServer().Wrap(okHandler), auth)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Wrong status: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+			}
+			const want = `SASL realm=example.com, mech="SCRAM-SHA-256 PLAIN"`
+			if got := w.Header().Get("WWW-Authenticate"); got != want {
+				t.Errorf("Wrong challenge:\nwant=%s\n got=%s", want, got)
+			}
+		})
+	}
+}
+
+func TestNoInitialResponse(t *testing.T) {
+	h := newServer().Wrap(okHandler)
+	w := serve(h, `SASL realm="example.com", mech="PLAIN"`)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+	challenge := w.Header().Get("WWW-Authenticate")
+	sid := param(challenge, "s2s")
+	if sid == "" || param(challenge, "s2c") != "" || param(challenge, "mech") != "PLAIN" {
+		t.Fatalf("Bad challenge: %q", challenge)
+	}
+	auth := `SASL realm="example.com", s2s=` + sid + ", c2s=" + base64.StdEncoding.EncodeToString([]byte("\x00user\x00pencil"))
+	w = serve(h, auth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status: want=%d, got=%d", http.StatusOK, w.Code)
+	}
+	if body := w.Body.String(); body != "user:" {
+		t.Errorf("Wrong user: %q", body)
+	}
+
+	// Sessions can only be used once.
+	w = serve(h, auth)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status on replay: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	s := newServer()
+	s.Timeout = -time.Second
+	h := s.Wrap(okHandler)
+	w := serve(h, `SASL realm="example.com", mech="PLAIN"`)
+	sid := param(w.Header().Get("WWW-Authenticate"), "s2s")
+	w = serve(h, `SASL realm="example.com", s2s=`+sid+", c2s="+base64.StdEncoding.EncodeToString([]byte("\x00user\x00pencil")))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+// roundTripper counts requests and lets tests tamper with responses.
+type roundTripper struct {
+	requests int
+	tamper   func(*http.Response)
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.requests++
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil && rt.tamper != nil {
+		rt.tamper(resp)
+	}
+	return resp, err
+}
+
+func TestTransport(t *testing.T) {
+	for i, tc := range [...]struct {
+		mechs    []sasl.Mechanism
+		pass     string
+		body     bool
+		tamper   func(*http.Response)
+		status   int
+		requests int
+		err      error
+	}{
+		0: {
+			mechs:    []sasl.Mechanism{sasl.Plain},
+			pass:     "pencil",
+			status:   http.StatusOK,
+			requests: 2,
+		},
+		1: {
+			mechs:    []sasl.Mechanism{sasl.ScramSha256, sasl.Plain},
+			pass:     "pencil",
+			status:   http.StatusOK,
+			requests: 3,
+		},
+		2: {
+			mechs:    []sasl.Mechanism{sasl.ScramSha256},
+			pass:     "pencil",
+			body:     true,
+			status:   http.StatusOK,
+			requests: 3,
+		},
+		3: {
+			mechs:    []sasl.Mechanism{sasl.Plain},
+			pass:     "pen",
+			status:   http.StatusUnauthorized,
+			requests: 2,
+		},
+		4: {
+			mechs:    []sasl.Mechanism{sasl.ScramSha256},
+			pass:     "pen",
+			status:   http.StatusUnauthorized,
+			requests: 3,
+		},
+		5: {
+			mechs:    []sasl.Mechanism{sasl.ScramSha1},
+			pass:     "pencil",
+			status:   http.StatusUnauthorized,
+			requests: 1,
+		},
+		6: {
+			mechs: []sasl.Mechanism{sasl.ScramSha256},
+			pass:  "pencil",
+			tamper: func(resp *http.Response) {
+				if resp.Header.Get("Authentication-Info") != "" {
+					resp.Header.Set("Authentication-Info", "s2c="+base64.StdEncoding.EncodeToString([]byte("v=AAAA")))
+				}
+			},
+			err: sasl.ErrAuthn,
+		},
+		7: {
+			mechs: []sasl.Mechanism{sasl.ScramSha256},
+			pass:  "pencil",
+			tamper: func(resp *http.Response) {
+				resp.Header.Del("Authentication-Info")
+			},
+			err: sasl.ErrUnexpectedSuccess,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			srv := httptest.NewServer(newServer().Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				io.WriteString(w, string(b))
+				okHandler(w, r)
+			})))
+			defer srv.Close()
+
+			rt := &roundTripper{tamper: tc.tamper}
+			client := &http.Client{Transport: &httpsasl.Transport{
+				Base:       rt,
+				Mechanisms: tc.mechs,
+				Options:    []sasl.Option{sasltest.Creds("user", tc.pass)},
+			}}
+			var body io.Reader
+			if tc.body {
+				body = strings.NewReader("body ")
+			}
+			req, err := http.NewRequest("POST", srv.URL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if tc.err != nil {
+				if e, ok := err.(*url.Error); !ok || e.Err != tc.err {
+					t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Fatalf("Wrong status: want=%d, got=%d", tc.status, resp.StatusCode)
+			}
+			if rt.requests != tc.requests {
+				t.Errorf("Wrong number of requests: want=%d, got=%d", tc.requests, rt.requests)
+			}
+			if resp.StatusCode != http.StatusOK {
+				if resp.Header.Get("WWW-Authenticate") == "" {
+					t.Errorf("Expected a new challenge")
+				}
+				return
+			}
+			want := "user:"
+			if tc.body {
+				want = "body " + want
+			}
+			if string(got) != want {
+				t.Errorf("Wrong response: want=%q, got=%q", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package httpsasl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/httpauth"
+)
+
+// DefaultTimeout is the time that a client has to send each response if the
+// servers Timeout is not set.
+const DefaultTimeout = time.Minute
+
+// Server is HTTP middleware that requires SASL authentication.
+// A Server must not be copied after first use.
+type Server struct {
+	// Realm is sent in every challenge.
+	Realm string
+
+	// Mechanisms are the mechanisms advertised to clients in order of
+	// preference.
+	// -PLUS variants must not be used.
+	Mechanisms []sasl.Mechanism
+
+	// Permissions is passed to sasl.NewServer.
+	Permissions func(*sasl.Negotiator) bool
+
+	// Options are passed to sasl.NewServer (eg. sasl.ScramSecrets if a SCRAM
+	// mechanism is offered).
+	Options []sasl.Option
+
+	// Store keeps sessions between requests.
+	// If it is nil sessions are kept in memory, which only works if every
+	// request from a client is handled by the same Server.
+	Store Store
+
+	// Timeout is how long a client has to send each response.
+	// If it is zero DefaultTimeout is used.
+	Timeout time.Duration
+
+	memory MemoryStore
+}
+
+type ctxKey struct{}
+
+type user struct {
+	username, identity string
+}
+
+// User returns the username and authorization identity that were
+// authenticated for a request handled by a Server.
+func User(ctx context.Context) (username, identity string, ok bool) {
+	u, ok := ctx.Value(ctxKey{}).(user)
+	return u.username, u.identity, ok
+}
+
+// Wrap returns a handler that only calls next once the client has
+// authenticated, with the user available from the requests context (see User).
+// All other requests are responded to with 401 Unauthorized and either the
+// next challenge of the exchange or a new challenge listing the mechanisms.
+func (s *Server) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+		for _, c := range httpauth.Parse(r.Header.Get("Authorization")) {
+			if strings.EqualFold(c.Scheme, Scheme) {
+				params = c.Params
+				break
+			}
+		}
+		if params == nil {
+			s.challenge(w)
+			return
+		}
+		c2s, hasResp := params["c2s"]
+		resp, err := base64.StdEncoding.DecodeString(c2s)
+		if err != nil {
+			s.challenge(w)
+			return
+		}
+
+		var sess Session
+		if sid, ok := params["s2s"]; ok {
+			var found bool
+			sess, found, err = s.store().Take(sid)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if !found || !hasResp || time.Now().After(sess.Expires) {
+				s.challenge(w)
+				return
+			}
+		} else {
+			sess.Mechanism = params["mech"]
+		}
+		var mech sasl.Mechanism
+		var ok bool
+		for _, m := range s.Mechanisms {
+			if m.Name == sess.Mechanism {
+				mech, ok = m, true
+				break
+			}
+		}
+		if !ok {
+			s.challenge(w)
+			return
+		}
+
+		var u user
+		perm := func(n *sasl.Negotiator) bool {
+			if s.Permissions == nil || !s.Permissions(n) {
+				return false
+			}
+			username, _, identity := n.Credentials()
+			u = user{username: string(username), identity: string(identity)}
+			return true
+		}
+		opts := make([]sasl.Option, 0, len(s.Options)+1)
+		opts = append(opts, s.Options...)
+		if sess.Nonce != nil {
+			opts = append(opts, sasl.Nonce(sess.Nonce))
+		}
+		server := sasl.NewServer(mech, perm, opts...)
+		for _, prev := range sess.Responses {
+			if _, _, err = server.Step(prev); err != nil {
+				s.challenge(w)
+				return
+			}
+		}
+		sess.Nonce = server.Nonce()
+
+		if !hasResp {
+			// No initial response, send an empty challenge to ask for one.
+			s.cont(w, sess, nil)
+			return
+		}
+		more, data, err := server.Step(resp)
+		switch {
+		case err != nil:
+			s.challenge(w)
+			return
+		case more:
+			sess.Responses = append(sess.Responses, resp)
+			s.cont(w, sess, data)
+			return
+		}
+
+		if data != nil {
+			w.Header().Set("Authentication-Info", httpauth.FormatParams("s2c", base64.StdEncoding.EncodeToString(data)))
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, u)))
+	})
+}
+
+// cont stores the session under a new ID and responds with 401 Unauthorized
+// and the next challenge.
+func (s *Server) cont(w http.ResponseWriter, sess Session, data []byte) {
+	var b [18]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	sid := base64.RawURLEncoding.EncodeToString(b[:])
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	sess.Expires = time.Now().Add(timeout)
+	switch err := s.store().Put(sid, sess); {
+	case err == ErrStoreFull:
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", httpauth.Format(Scheme,
+		"realm", s.Realm,
+		"mech", sess.Mechanism,
+		"s2s", sid,
+		"s2c", base64.StdEncoding.EncodeToString(data),
+	))
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// challenge responds with 401 Unauthorized and a challenge listing the
+// mechanisms.
+func (s *Server) challenge(w http.ResponseWriter) {
+	names := make([]string, 0, len(s.Mechanisms))
+	for _, m := range s.Mechanisms {
+		names = append(names, m.Name)
+	}
+	w.Header().Set("WWW-Authenticate", httpauth.Format(Scheme, "realm", s.Realm, "mech", strings.Join(names, " ")))
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (s *Server) store() Store {
+	if s.Store != nil {
+		return s.Store
+	}
+	return &s.memory
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package httpsasl
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/httpauth"
+)
+
+// Transport is an http.RoundTripper that authenticates using the SASL scheme
+// when the server responds to a request with a challenge.
+// The request is sent again with each message of the exchange, so requests
+// with a body must set GetBody (as http.NewRequest does for common body types)
+// or they are not authenticated.
+type Transport struct {
+	// Base is used to make the requests.
+	// If it is nil http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Mechanisms are the mechanisms that may be used in order of preference.
+	// -PLUS variants must not be used.
+	Mechanisms []sasl.Mechanism
+
+	// Options are passed to sasl.NewClient along with the mechanisms offered by
+	// the server.
+	Options []sasl.Option
+}
+
+// RoundTrip implements http.RoundTripper.
+// If the server does not accept the credentials the last 401 Unauthorized
+// response is returned.
+// If the exchange ends before the client mechanism has verified the server the
+// response is closed and sasl.ErrUnexpectedSuccess is returned.
+// Requests that already have an Authorization header are sent as is.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	canRetry := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	resp, err := httpauth.Base(t.Base).RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !canRetry || req.Header.Get("Authorization") != "" {
+		return resp, err
+	}
+
+	c, ok := challenge(resp)
+	if !ok {
+		return resp, nil
+	}
+	offered := strings.Fields(c.Params["mech"])
+	mech, ok := t.choose(offered)
+	if !ok {
+		return resp, nil
+	}
+	realm := c.Params["realm"]
+	opts := make([]sasl.Option, 0, len(t.Options)+1)
+	opts = append(opts, t.Options...)
+	opts = append(opts, sasl.RemoteMechanisms(offered...))
+	client := sasl.NewClient(mech, opts...)
+	more, data, err := client.Step(nil)
+	httpauth.Discard(resp)
+	if err != nil {
+		return nil, err
+	}
+	params := []string{"realm", realm, "mech", mech.Name}
+	if data != nil {
+		params = append(params, "c2s", base64.StdEncoding.EncodeToString(data))
+	}
+
+	for {
+		resp, err = httpauth.Retry(t.Base, req, httpauth.Format(Scheme, params...))
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			break
+		}
+		c, ok = challenge(resp)
+		s2s := c.Params["s2s"]
+		var s2c []byte
+		s2c, err = base64.StdEncoding.DecodeString(c.Params["s2c"])
+		if !ok || s2s == "" || err != nil {
+			// The server rejected the credentials.
+			return resp, nil
+		}
+		more, data, err = client.Step(s2c)
+		httpauth.Discard(resp)
+		if err != nil {
+			return nil, err
+		}
+		params = []string{"realm", realm, "s2s", s2s, "c2s", base64.StdEncoding.EncodeToString(data)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s2c, ok := httpauth.ParseParams(resp.Header.Get("Authentication-Info"))["s2c"]; ok && more {
+		data, err = base64.StdEncoding.DecodeString(s2c)
+		if err == nil {
+			more, _, err = client.Step(data)
+		}
+		if err != nil {
+			httpauth.Discard(resp)
+			return nil, err
+		}
+	}
+	if more {
+		httpauth.Discard(resp)
+		return nil, sasl.ErrUnexpectedSuccess
+	}
+	return resp, nil
+}
+
+// choose returns the first of the transports mechanisms that was offered.
+func (t *Transport) choose(offered []string) (sasl.Mechanism, bool) {
+	for _, m := range t.Mechanisms {
+		for _, name := range offered {
+			if m.Name == name {
+				return m, true
+			}
+		}
+	}
+	return sasl.Mechanism{}, false
+}
+
+// challenge returns the SASL challenge from a response.
+func challenge(resp *http.Response) (httpauth.Challenge, bool) {
+	for _, c := range httpauth.Parse(strings.Join(resp.Header["Www-Authenticate"], ", ")) {
+		if strings.EqualFold(c.Scheme, Scheme) {
+			return c, true
+		}
+	}
+	return httpauth.Challenge{}, false
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package httpscram implements the HTTP SCRAM authentication schemes defined in
+// RFC 7804.
+//
+// The server sends a challenge such as
+//
+//	WWW-Authenticate: SCRAM-SHA-256 realm="example.net"
+//
+// and the client responds with the client-first-message in the data parameter.
+// The server then sends the server-first-message along with a session ID (sid)
+// in another 401 response, and the client repeats the request with the sid and
+// its client-final-message.
+// If the proof is valid the request is served and the server signature is sent
+// in the Authentication-Info header so that the client can verify it.
+//
+// Because the exchange spans two requests which may be handled by different
+// machines, Server keeps what it needs to rebuild the Negotiator in a Store.
+// Transport is the client side, it authenticates when a request is challenged
+// and verifies the server signature before returning the response.
+// Channel binding and the reauthentication extension are not supported.
+package httpscram
+
+import (
+	"github.com/whenspeakteam/sasl/internal/httpauth"
+)
+
+// Errors returned by a Store.
+var (
+	ErrSessionExists = httpauth.ErrSessionExists
+	ErrStoreFull     = httpauth.ErrStoreFull
+)
+
+// DefaultMaxSessions is the number of sessions that a MemoryStore keeps if Max
+// is not set.
+const DefaultMaxSessions = httpauth.DefaultMaxSessions
+
+// Session is the state that is kept between the requests of an exchange.
+// It contains no secrets: the server negotiator is rebuilt by replaying the
+// clients earlier responses with the same nonce.
+type Session = httpauth.Session
+
+// Store keeps sessions between requests.
+// Implementations that are shared by multiple servers (eg. backed by a
+// database) let each request of an exchange be handled by any of them.
+type Store = httpauth.Store
+
+// MemoryStore is a Store that keeps sessions in memory.
+// The zero value is ready to use, and Max limits the number of sessions.
+type MemoryStore = httpauth.MemoryStore
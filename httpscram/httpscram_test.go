@@ -0,0 +1,394 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package httpscram_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/httpscram"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+)
+
+// The example from RFC 7804 section 5, which uses the messages from RFC 7677
+// (the server nonce in the RFCs encoded server-first-message has a typo).
+const (
+	rfcClientNonce = "rOprNGfwEbeRWgbNEkqO"
+	rfcServerNonce = "%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"
+	rfcServerFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	rfcServerFinal = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+)
+
+var rfcSalt, _ = base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+
+var scramSecrets = sasl.ScramSecrets(func(username []byte) (sasl.ScramCredentials, bool) {
+	return sasl.NewScramCredentials(sha256.New, []byte("pencil"), rfcSalt, 4096), string(username) == "user"
+})
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	user, identity, ok := httpscram.User(r.Context())
+	if !ok {
+		panic("handler called without a user")
+	}
+	io.WriteString(w, user+":"+identity)
+})
+
+func newServer(store httpscram.Store, opts ...sasl.Option) *httpscram.Server {
+	return &httpscram.Server{
+		Realm:       "testrealm@example.com",
+		Mechanisms:  []sasl.Mechanism{sasl.ScramSha256, sasl.ScramSha1},
+		Permissions: sasltest.CheckUser,
+		Options:     append([]sasl.Option{scramSecrets}, opts...),
+		Store:       store,
+	}
+}
+
+func serve(h http.Handler, auth string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/resource", nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// param returns the value of an unquoted parameter from a header.
+func param(header, name string) string {
+	for _, p := range strings.Split(header, ",") {
+		p = strings.TrimSpace(p)
+		if i := strings.LastIndex(p, " "); i >= 0 {
+			p = p[i+1:]
+		}
+		if strings.HasPrefix(p, name+"=") {
+			return p[len(name)+1:]
+		}
+	}
+	return ""
+}
+
+func TestChallenge(t *testing.T) {
+	w := serve(newServer(nil).Wrap(okHandler), "")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+	want := []string{`SCRAM-SHA-256 realm="testrealm@example.com"`, `SCRAM-SHA-1 realm="testrealm@example.com"`}
+	if got := w.Header()["Www-Authenticate"]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Wrong challenges:\nwant=%q\n got=%q", want, got)
+	}
+}
+
+func TestRFC7804(t *testing.T) {
+	h := newServer(nil, sasl.Nonce([]byte(rfcServerNonce))).Wrap(okHandler)
+	client := sasl.NewClient(sasl.ScramSha256, sasltest.Creds("user", "pencil"), sasl.Nonce([]byte(rfcClientNonce)))
+	_, first, err := client.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := serve(h, `SCRAM-SHA-256 realm="testrealm@example.com", data=`+base64.StdEncoding.EncodeToString(first))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+	challenge := w.Header().Get("WWW-Authenticate")
+	sid := param(challenge, "sid")
+	serverFirst, _ := base64.StdEncoding.DecodeString(param(challenge, "data"))
+	if string(serverFirst) != rfcServerFirst {
+		t.Fatalf("Wrong server-first-message:\nwant=%s\n got=%s", rfcServerFirst, serverFirst)
+	}
+	_, final, err := client.Step(serverFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = serve(h, "SCRAM-SHA-256 sid="+sid+", data="+base64.StdEncoding.EncodeToString(final))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status: want=%d, got=%d", http.StatusOK, w.Code)
+	}
+	info := w.Header().Get("Authentication-Info")
+	if got := param(info, "sid"); got != sid {
+		t.Errorf("Wrong sid: want=%s, got=%s", sid, got)
+	}
+	if got, _ := base64.StdEncoding.DecodeString(param(info, "data")); string(got) != rfcServerFinal {
+		t.Errorf("Wrong server-final-message:\nwant=%s\n got=%s", rfcServerFinal, got)
+	}
+	if body := w.Body.String(); body != "user:" {
+		t.Errorf("Wrong user: %q", body)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	shared := &httpscram.MemoryStore{}
+	for i, tc := range [...]struct {
+		mech    sasl.Mechanism
+		pass    string
+		first   *httpscram.Server
+		final   *httpscram.Server
+		replay  bool
+		badSID  bool
+		status  int
+		timeout time.Duration
+	}{
+		0: {
+			mech:   sasl.ScramSha256,
+			pass:   "pencil",
+			status: http.StatusOK,
+		},
+		1: {
+			mech:   sasl.ScramSha1,
+			pass:   "pencil",
+			status: http.StatusUnauthorized,
+		},
+		2: {
+			mech:   sasl.ScramSha256,
+			pass:   "pen",
+			status: http.StatusUnauthorized,
+		},
+		3: {
+			// The second request lands on a different server.
+			mech:   sasl.ScramSha256,
+			pass:   "pencil",
+			first:  newServer(shared),
+			final:  newServer(shared),
+			status: http.StatusOK,
+		},
+		4: {
+			// Without a shared store the other server does not know the session.
+			mech:   sasl.ScramSha256,
+			pass:   "pencil",
+			first:  newServer(nil),
+			final:  newServer(nil),
+			status: http.StatusUnauthorized,
+		},
+		5: {
+			mech:   sasl.ScramSha256,
+			pass:   "pencil",
+			replay: true,
+			status: http.StatusUnauthorized,
+		},
+		6: {
+			mech:   sasl.ScramSha256,
+			pass:   "pencil",
+			badSID: true,
+			status: http.StatusUnauthorized,
+		},
+		7: {
+			mech:    sasl.ScramSha256,
+			pass:    "pencil",
+			timeout: -time.Second,
+			status:  http.StatusUnauthorized,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			first, final := tc.first, tc.final
+			if first == nil {
+				first = newServer(nil)
+				final = first
+			}
+			first.Timeout = tc.timeout
+			// Case 1 uses SCRAM-SHA-1 with credentials derived using SHA-256, so it
+			// fails.
+			client := sasl.NewClient(tc.mech, sasltest.Creds("user", tc.pass))
+			_, msg, err := client.Step(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := serve(first.Wrap(okHandler), tc.mech.Name+" data="+base64.StdEncoding.EncodeToString(msg))
+			challenge := w.Header().Get("WWW-Authenticate")
+			sid := param(challenge, "sid")
+			data, err := base64.StdEncoding.DecodeString(param(challenge, "data"))
+			if sid == "" || err != nil {
+				t.Fatalf("Bad challenge: %q", challenge)
+			}
+			if tc.badSID {
+				sid = "AAAABBBBCCCCDDDD"
+			}
+			_, msg, err = client.Step(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth := tc.mech.Name + " sid=" + sid + ", data=" + base64.StdEncoding.EncodeToString(msg)
+			if tc.replay {
+				serve(final.Wrap(okHandler), auth)
+			}
+			w = serve(final.Wrap(okHandler), auth)
+			if w.Code != tc.status {
+				t.Fatalf("Wrong status: want=%d, got=%d", tc.status, w.Code)
+			}
+			if w.Code != http.StatusOK {
+				if w.Header().Get("WWW-Authenticate") == "" {
+					t.Errorf("Expected a new challenge")
+				}
+				return
+			}
+			data, err = base64.StdEncoding.DecodeString(param(w.Header().Get("Authentication-Info"), "data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if more, _, err := client.Step(data); more || err != nil {
+				t.Errorf("Server signature not accepted: more=%t, err=%v", more, err)
+			}
+		})
+	}
+}
+
+// roundTripper counts requests and lets tests tamper with responses.
+type roundTripper struct {
+	requests int
+	tamper   func(*http.Response)
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.requests++
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil && rt.tamper != nil {
+		rt.tamper(resp)
+	}
+	return resp, err
+}
+
+func TestTransport(t *testing.T) {
+	for i, tc := range [...]struct {
+		mechs  []sasl.Mechanism
+		pass   string
+		body   bool
+		tamper func(*http.Response)
+		status int
+		err    error
+	}{
+		0: {
+			mechs:  []sasl.Mechanism{sasl.ScramSha256},
+			pass:   "pencil",
+			status: http.StatusOK,
+		},
+		1: {
+			mechs:  []sasl.Mechanism{sasl.ScramSha256},
+			pass:   "pencil",
+			body:   true,
+			status: http.StatusOK,
+		},
+		2: {
+			mechs:  []sasl.Mechanism{sasl.ScramSha256},
+			pass:   "pen",
+			status: http.StatusUnauthorized,
+		},
+		3: {
+			// The server prefers SHA-256 but the client picks SHA-1, for which the
+			// stored credentials are wrong.
+			mechs:  []sasl.Mechanism{sasl.ScramSha1, sasl.ScramSha256},
+			pass:   "pencil",
+			status: http.StatusUnauthorized,
+		},
+		4: {
+			mechs:  []sasl.Mechanism{sasl.Plain},
+			pass:   "pencil",
+			status: http.StatusUnauthorized,
+		},
+		5: {
+			mechs: []sasl.Mechanism{sasl.ScramSha256},
+			pass:  "pencil",
+			tamper: func(resp *http.Response) {
+				if resp.Header.Get("Authentication-Info") != "" {
+					resp.Header.Set("Authentication-Info", "data="+base64.StdEncoding.EncodeToString([]byte("v=AAAA")))
+				}
+			},
+			err: sasl.ErrAuthn,
+		},
+		6: {
+			mechs: []sasl.Mechanism{sasl.ScramSha256},
+			pass:  "pencil",
+			tamper: func(resp *http.Response) {
+				resp.Header.Del("Authentication-Info")
+			},
+			err: sasl.ErrUnexpectedSuccess,
+		},
+		7: {
+			// A server that skips the rest of the exchange has not proven that it
+			// knows the credentials.
+			mechs: []sasl.Mechanism{sasl.ScramSha256},
+			pass:  "pencil",
+			tamper: func(resp *http.Response) {
+				if strings.Contains(resp.Header.Get("WWW-Authenticate"), "sid=") {
+					resp.StatusCode = http.StatusOK
+					resp.Header.Del("WWW-Authenticate")
+				}
+			},
+			err: sasl.ErrUnexpectedSuccess,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			srv := httptest.NewServer(newServer(nil).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				io.WriteString(w, string(b))
+				okHandler(w, r)
+			})))
+			defer srv.Close()
+
+			passwords := 0
+			rt := &roundTripper{tamper: tc.tamper}
+			client := &http.Client{Transport: &httpscram.Transport{
+				Base:       rt,
+				Mechanisms: tc.mechs,
+				Options: []sasl.Option{
+					sasltest.Creds("user", tc.pass),
+					sasl.ScramPassword(func(_, password []byte) []byte {
+						passwords++
+						return password
+					}),
+				},
+			}}
+
+			for j := 0; j < 2; j++ {
+				var body io.Reader
+				if tc.body {
+					body = strings.NewReader("body ")
+				}
+				req, err := http.NewRequest("POST", srv.URL, body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := client.Do(req)
+				if tc.err != nil {
+					if e, ok := err.(*url.Error); !ok || e.Err != tc.err {
+						t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != tc.status {
+					t.Fatalf("Wrong status: want=%d, got=%d", tc.status, resp.StatusCode)
+				}
+				if resp.StatusCode != http.StatusOK {
+					return
+				}
+				want := "user:"
+				if tc.body {
+					want = "body " + want
+				}
+				if string(got) != want {
+					t.Errorf("Wrong response: want=%q, got=%q", want, got)
+				}
+			}
+			if rt.requests != 6 {
+				t.Errorf("Wrong number of requests: want=6, got=%d", rt.requests)
+			}
+			if passwords != 1 {
+				t.Errorf("Salted password was not cached: password used %d times", passwords)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package httpscram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/httpauth"
+)
+
+// DefaultTimeout is the time that a client has to finish an exchange if the
+// servers Timeout is not set.
+const DefaultTimeout = time.Minute
+
+// Server is HTTP middleware that requires SCRAM authentication.
+// A Server must not be copied after first use.
+type Server struct {
+	// Realm is sent in every challenge.
+	Realm string
+
+	// Mechanisms are the SCRAM mechanisms offered to clients in order of
+	// preference (eg. sasl.ScramSha256).
+	// -PLUS variants must not be used.
+	Mechanisms []sasl.Mechanism
+
+	// Permissions is passed to sasl.NewServer.
+	// It is called with the username and authorization identity (but no
+	// password) once the clients proof has been verified.
+	Permissions func(*sasl.Negotiator) bool
+
+	// Options are passed to sasl.NewServer and must include sasl.ScramSecrets.
+	Options []sasl.Option
+
+	// Store keeps sessions between requests.
+	// If it is nil sessions are kept in memory, which only works if every
+	// request from a client is handled by the same Server.
+	Store Store
+
+	// Timeout is how long a client has to send its client-final-message.
+	// If it is zero DefaultTimeout is used.
+	Timeout time.Duration
+
+	memory MemoryStore
+}
+
+type ctxKey struct{}
+
+type user struct {
+	username, identity string
+}
+
+// User returns the username and authorization identity that were
+// authenticated for a request handled by a Server.
+func User(ctx context.Context) (username, identity string, ok bool) {
+	u, ok := ctx.Value(ctxKey{}).(user)
+	return u.username, u.identity, ok
+}
+
+// Wrap returns a handler that only calls next once the client has
+// authenticated, with the user available from the requests context (see User).
+// All other requests are responded to with 401 Unauthorized and a challenge.
+func (s *Server) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var mech sasl.Mechanism
+		var params map[string]string
+		if creds := httpauth.Parse(r.Header.Get("Authorization")); len(creds) > 0 {
+			for _, m := range s.Mechanisms {
+				if strings.EqualFold(creds[0].Scheme, m.Name) {
+					mech, params = m, creds[0].Params
+					break
+				}
+			}
+		}
+		data, err := base64.StdEncoding.DecodeString(params["data"])
+		if params == nil || err != nil || len(data) == 0 {
+			s.challenge(w)
+			return
+		}
+
+		if sid, ok := params["sid"]; ok {
+			s.final(w, r, next, mech, sid, data)
+			return
+		}
+		s.first(w, mech, data)
+	})
+}
+
+// first handles the client-first-message.
+func (s *Server) first(w http.ResponseWriter, mech sasl.Mechanism, data []byte) {
+	server := sasl.NewServer(mech, nil, s.Options...)
+	_, resp, err := server.Step(data)
+	if err != nil {
+		s.challenge(w)
+		return
+	}
+	var b [18]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	sid := base64.RawURLEncoding.EncodeToString(b[:])
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	err = s.store().Put(sid, Session{
+		Mechanism: mech.Name,
+		Nonce:     server.Nonce(),
+		Responses: [][]byte{data},
+		Expires:   time.Now().Add(timeout),
+	})
+	switch {
+	case err == ErrStoreFull:
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", httpauth.Format(mech.Name, "sid", sid, "data", base64.StdEncoding.EncodeToString(resp)))
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// final handles the client-final-message by rebuilding the negotiator from the
+// session.
+func (s *Server) final(w http.ResponseWriter, r *http.Request, next http.Handler, mech sasl.Mechanism, sid string, data []byte) {
+	sess, ok, err := s.store().Take(sid)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !ok || sess.Mechanism != mech.Name || len(sess.Responses) != 1 || time.Now().After(sess.Expires) {
+		s.challenge(w)
+		return
+	}
+
+	var u user
+	perm := func(n *sasl.Negotiator) bool {
+		if s.Permissions == nil || !s.Permissions(n) {
+			return false
+		}
+		username, _, identity := n.Credentials()
+		u = user{username: string(username), identity: string(identity)}
+		return true
+	}
+	opts := make([]sasl.Option, 0, len(s.Options)+1)
+	opts = append(opts, s.Options...)
+	opts = append(opts, sasl.Nonce(sess.Nonce))
+	server := sasl.NewServer(mech, perm, opts...)
+	if _, _, err = server.Step(sess.Responses[0]); err != nil {
+		s.challenge(w)
+		return
+	}
+	more, resp, err := server.Step(data)
+	if err != nil || more {
+		s.challenge(w)
+		return
+	}
+
+	w.Header().Set("Authentication-Info", httpauth.FormatParams("sid", sid, "data", base64.StdEncoding.EncodeToString(resp)))
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, u)))
+}
+
+// challenge responds with 401 Unauthorized and a challenge for each mechanism.
+func (s *Server) challenge(w http.ResponseWriter) {
+	for _, m := range s.Mechanisms {
+		w.Header().Add("WWW-Authenticate", httpauth.Format(m.Name, "realm", s.Realm))
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (s *Server) store() Store {
+	if s.Store != nil {
+		return s.Store
+	}
+	return &s.memory
+}
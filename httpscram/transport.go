@@ -0,0 +1,172 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package httpscram
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/httpauth"
+)
+
+// Transport is an http.RoundTripper that authenticates using SCRAM when the
+// server responds to a request with a challenge.
+// The request is sent again with each message of the exchange, so requests
+// with a body must set GetBody (as http.NewRequest does for common body types)
+// or they are not authenticated.
+//
+// The salted password is cached for each realm so that the expensive key
+// derivation only happens once unless the server changes the salt or
+// iteration count.
+// A Transport must not be copied after first use.
+type Transport struct {
+	// Base is used to make the requests.
+	// If it is nil http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Mechanisms are the SCRAM mechanisms that may be used in order of
+	// preference (eg. sasl.ScramSha256).
+	// -PLUS variants must not be used.
+	Mechanisms []sasl.Mechanism
+
+	// Options are passed to sasl.NewClient and must include sasl.Credentials.
+	Options []sasl.Option
+
+	mu     sync.Mutex
+	realms map[string]*keyCache
+}
+
+// RoundTrip implements http.RoundTripper.
+// If the server does not accept the credentials the last 401 Unauthorized
+// response is returned.
+// If the server signature is missing or invalid, or the server responds to the
+// client-first-message with anything but a challenge, the response is closed
+// and an error is returned (sasl.ErrUnexpectedSuccess if there was no
+// signature to verify).
+// Requests that already have an Authorization header are sent as is.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	canRetry := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	resp, err := httpauth.Base(t.Base).RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !canRetry || req.Header.Get("Authorization") != "" {
+		return resp, err
+	}
+
+	mech, realm, ok := t.choose(resp.Header["Www-Authenticate"])
+	if !ok {
+		return resp, nil
+	}
+	opts := make([]sasl.Option, 0, len(t.Options)+1)
+	opts = append(opts, t.Options...)
+	opts = append(opts, sasl.ScramCache(t.cache(realm)))
+	client := sasl.NewClient(mech, opts...)
+	_, msg, err := client.Step(nil)
+	httpauth.Discard(resp)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = httpauth.Retry(t.Base, req, httpauth.Format(mech.Name, "realm", realm, "data", base64.StdEncoding.EncodeToString(msg)))
+	switch {
+	case err != nil:
+		return nil, err
+	case resp.StatusCode != http.StatusUnauthorized:
+		// The exchange has only just begun so the server cannot have verified
+		// our proof, and we have not verified its signature.
+		httpauth.Discard(resp)
+		return nil, sasl.ErrUnexpectedSuccess
+	}
+
+	var sid string
+	var data []byte
+	for _, c := range httpauth.Parse(strings.Join(resp.Header["Www-Authenticate"], ", ")) {
+		if strings.EqualFold(c.Scheme, mech.Name) && c.Params["sid"] != "" {
+			sid = c.Params["sid"]
+			data, err = base64.StdEncoding.DecodeString(c.Params["data"])
+			break
+		}
+	}
+	if sid == "" || err != nil || len(data) == 0 {
+		return resp, nil
+	}
+	_, msg, err = client.Step(data)
+	httpauth.Discard(resp)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = httpauth.Retry(t.Base, req, httpauth.Format(mech.Name, "sid", sid, "data", base64.StdEncoding.EncodeToString(msg)))
+	if err != nil || resp.StatusCode == http.StatusUnauthorized {
+		return resp, err
+	}
+
+	info := httpauth.ParseParams(resp.Header.Get("Authentication-Info"))
+	data, err = base64.StdEncoding.DecodeString(info["data"])
+	if err != nil || len(data) == 0 {
+		httpauth.Discard(resp)
+		return nil, sasl.ErrUnexpectedSuccess
+	}
+	more, _, err := client.Step(data)
+	switch {
+	case err != nil:
+		httpauth.Discard(resp)
+		return nil, err
+	case more:
+		httpauth.Discard(resp)
+		return nil, sasl.ErrUnexpectedSuccess
+	}
+	return resp, nil
+}
+
+// choose returns the first of the transports mechanisms that was offered in
+// the challenges and the realm that it was offered for.
+func (t *Transport) choose(challenges []string) (sasl.Mechanism, string, bool) {
+	offered := httpauth.Parse(strings.Join(challenges, ", "))
+	for _, m := range t.Mechanisms {
+		for _, c := range offered {
+			if strings.EqualFold(c.Scheme, m.Name) {
+				return m, c.Params["realm"], true
+			}
+		}
+	}
+	return sasl.Mechanism{}, "", false
+}
+
+func (t *Transport) cache(realm string) *keyCache {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.realms == nil {
+		t.realms = make(map[string]*keyCache)
+	}
+	c, ok := t.realms[realm]
+	if !ok {
+		c = &keyCache{}
+		t.realms[realm] = c
+	}
+	return c
+}
+
+// keyCache is a sasl.ScramKeyCache for a single realm.
+type keyCache struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (c *keyCache) Get(mechanism string, salt []byte, iter int) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k, ok := c.keys[mechanism+","+strconv.Itoa(iter)+","+string(salt)]
+	return k, ok
+}
+
+func (c *keyCache) Put(mechanism string, salt []byte, iter int, saltedPassword []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[string][]byte)
+	}
+	c.keys[mechanism+","+strconv.Itoa(iter)+","+string(salt)] = saltedPassword
+}
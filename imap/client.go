@@ -0,0 +1,103 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package imap
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// Authenticate sends an AUTHENTICATE command with the given tag and runs the
+// exchange using client until the server sends a tagged response.
+//
+// If capabilities contains SASL-IR the initial response is sent with the
+// command (as "=" if it is empty), otherwise it is sent after the servers
+// first continuation request.
+// If the mechanism has no initial response it is never sent.
+// Untagged responses received during the exchange are ignored.
+// If the negotiator returns an error the exchange is cancelled by sending "*"
+// and the negotiators error is returned once the server has responded.
+func Authenticate(rw io.ReadWriter, tag string, capabilities []string, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+
+	name := client.Mechanism().Name
+	irSent := hasCapability(capabilities, "SASL-IR")
+	switch {
+	case !irSent || resp == nil:
+		err = wire.WriteLine(rw, "%s AUTHENTICATE %s", tag, name)
+	case len(resp) == 0:
+		err = wire.WriteLine(rw, "%s AUTHENTICATE %s =", tag, name)
+	default:
+		err = wire.WriteLine(rw, "%s AUTHENTICATE %s %s", tag, name, wire.Encode(resp))
+	}
+	if err != nil {
+		return err
+	}
+
+	tagPrefix := []byte(tag + " ")
+	var abortErr error
+	for {
+		line, err := wire.ReadLine(rw, maxLineLen)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case bytes.HasPrefix(line, []byte("+")):
+			if abortErr != nil {
+				// The server should not continue after we cancelled, but if it does
+				// keep cancelling.
+				if err = wire.WriteLine(rw, "*"); err != nil {
+					return err
+				}
+				continue
+			}
+			if !irSent {
+				irSent = true
+				if err = wire.WriteLine(rw, "%s", wire.Encode(resp)); err != nil {
+					return err
+				}
+				continue
+			}
+			challenge, err := wire.Decode(bytes.TrimPrefix(line[1:], []byte{' '}))
+			if err == nil {
+				more, resp, err = client.Step(challenge)
+			}
+			if err != nil {
+				abortErr = err
+				if err = wire.WriteLine(rw, "*"); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = wire.WriteLine(rw, "%s", wire.Encode(resp)); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(line, tagPrefix):
+			status := line[len(tagPrefix):]
+			var text []byte
+			if idx := bytes.IndexByte(status, ' '); idx != -1 {
+				status, text = status[:idx], status[idx+1:]
+			}
+			switch {
+			case abortErr != nil:
+				return abortErr
+			case bytes.EqualFold(status, []byte("OK")):
+				if more {
+					return sasl.ErrUnexpectedSuccess
+				}
+				return nil
+			}
+			return &Error{Status: string(bytes.ToUpper(status)), Text: string(text)}
+		}
+		// Anything else is an untagged response that we do not care about.
+	}
+}
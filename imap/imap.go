@@ -0,0 +1,65 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package imap implements the IMAP AUTHENTICATE command as defined in RFC 3501
+// and the SASL-IR extension from RFC 4959.
+//
+// Authenticate drives a client Negotiator and HandleAuthenticate drives a
+// server Negotiator.
+// Both operate on the raw connection after the caller has handled any other
+// IMAP commands and never read past the end of the exchange.
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// The maximum length of a line (excluding CRLF) that will be read during an
+// exchange.
+const maxLineLen = 65536
+
+// Errors returned by the client and server.
+var (
+	ErrAborted          = errors.New("Authentication aborted by client")
+	ErrUnknownMechanism = errors.New("Unsupported authentication mechanism")
+	ErrSyntax           = errors.New("Syntax error in AUTHENTICATE command")
+	ErrLineTooLong      = wire.ErrLineTooLong
+)
+
+// Error is returned by Authenticate when the server ends the exchange with a
+// tagged NO or BAD response.
+type Error struct {
+	Status string
+	Text   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("imap: %s %s", e.Status, e.Text)
+}
+
+// Mechanisms returns the SASL mechanisms advertised in a CAPABILITY list (as
+// AUTH=<mechanism>) so that they can be passed to the sasl.RemoteMechanisms
+// option.
+func Mechanisms(capabilities []string) []string {
+	var mechs []string
+	for _, c := range capabilities {
+		if len(c) > 5 && strings.EqualFold(c[:5], "AUTH=") {
+			mechs = append(mechs, strings.ToUpper(c[5:]))
+		}
+	}
+	return mechs
+}
+
+func hasCapability(capabilities []string, name string) bool {
+	for _, c := range capabilities {
+		if strings.EqualFold(c, name) {
+			return true
+		}
+	}
+	return false
+}
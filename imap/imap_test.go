@@ -0,0 +1,286 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package imap_test
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/imap"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+)
+
+// finalData is a mechanism where the server sends additional data with its
+// success.
+var finalData = sasl.Mechanism{
+	Name: "X-FINAL",
+	Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+		return true, []byte("hello"), nil, nil
+	},
+	Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		if n.State()&sasl.Receiving == sasl.Receiving {
+			if string(challenge) != "hello" {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, []byte("goodbye"), nil, nil
+		}
+		if string(challenge) != "goodbye" {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, nil, nil, nil
+	},
+}
+
+var totpServerOpts = []sasl.Option{
+	sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+	sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+	sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+}
+
+func TestMechanisms(t *testing.T) {
+	mechs := imap.Mechanisms([]string{"IMAP4rev1", "SASL-IR", "AUTH=PLAIN", "auth=scram-sha-1", "AUTH="})
+	if want := []string{"PLAIN", "SCRAM-SHA-1"}; !reflect.DeepEqual(mechs, want) {
+		t.Errorf("Wrong mechanisms: want=%v, got=%v", want, mechs)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		caps       []string
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		clientErr  error
+		serverErr  error
+		status     string
+	}{
+		0: {
+			mech:       sasl.Plain,
+			caps:       []string{"SASL-IR", "AUTH=PLAIN"},
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		1: {
+			mech:       sasl.Plain,
+			caps:       []string{"AUTH=PLAIN"},
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		2: {
+			mech:       sasl.Plain,
+			caps:       []string{"SASL-IR"},
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			serverErr:  sasl.ErrAuthn,
+			status:     "NO",
+		},
+		3: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			caps: []string{"SASL-IR"},
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: totpServerOpts,
+		},
+		4: {
+			// The client has no code to send so it cancels the exchange.
+			mech:       sasl.WithTOTP(sasl.Plain),
+			caps:       []string{"SASL-IR"},
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: totpServerOpts,
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  imap.ErrAborted,
+		},
+		5: {
+			mech: finalData,
+			caps: []string{"SASL-IR"},
+		},
+		6: {
+			mech: finalData,
+		},
+		7: {
+			// The client cancels the exchange when the server signature is wrong.
+			mech:       sasl.ScramSha256,
+			caps:       []string{"SASL-IR"},
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+			serverErr:  imap.ErrAborted,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			errs := make(chan error, 1)
+			go func() {
+				errs <- imap.HandleAuthenticate(serverConn, readCommand(serverConn), func(name string) *sasl.Negotiator {
+					if name != tc.mech.Name {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+				serverConn.Close()
+			}()
+
+			err := imap.Authenticate(clientConn, "a001", tc.caps, sasl.NewClient(tc.mech, tc.clientOpts...))
+			switch e := err.(type) {
+			case *imap.Error:
+				if e.Status != tc.status {
+					t.Errorf("Unexpected status: want=%q, got=%q", tc.status, e.Status)
+				}
+			default:
+				if err != tc.clientErr {
+					t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+				}
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+func TestUnknownMechanism(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- imap.HandleAuthenticate(serverConn, readCommand(serverConn), func(string) *sasl.Negotiator {
+			return nil
+		})
+		serverConn.Close()
+	}()
+	err := imap.Authenticate(clientConn, "a001", nil, sasl.NewClient(sasl.Plain))
+	if e, ok := err.(*imap.Error); !ok || e.Status != "NO" {
+		t.Errorf("Expected NO response, got %v", err)
+	}
+	if err = <-errs; err != imap.ErrUnknownMechanism {
+		t.Errorf("Unexpected server error: want=%v, got=%v", imap.ErrUnknownMechanism, err)
+	}
+}
+
+func TestInitialResponse(t *testing.T) {
+	for i, tc := range [...]struct {
+		resp []byte
+		cmd  string
+	}{
+		0: {cmd: "a001 AUTHENTICATE X-IR\r\n"},
+		1: {resp: []byte{}, cmd: "a001 AUTHENTICATE X-IR =\r\n"},
+		2: {resp: []byte("hello"), cmd: "a001 AUTHENTICATE X-IR aGVsbG8=\r\n"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			mech := sasl.Mechanism{
+				Name: "X-IR",
+				Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+					return false, tc.resp, nil, nil
+				},
+			}
+			var out strings.Builder
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{strings.NewReader("a001 OK\r\n"), &out}
+			if err := imap.Authenticate(rw, "a001", []string{"SASL-IR"}, sasl.NewClient(mech)); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if out.String() != tc.cmd {
+				t.Errorf("Unexpected command: want=%q, got=%q", tc.cmd, out.String())
+			}
+		})
+	}
+}
+
+func TestServerFraming(t *testing.T) {
+	for i, tc := range [...]struct {
+		line string
+		in   string
+		out  string
+		err  error
+	}{
+		0: {
+			line: "a001 AUTHENTICATE PLAIN",
+			in:   strings.Repeat("A", 65537) + "\r\nAHVzZXIAcGVuY2ls\r\n",
+			out:  "+ \r\na001 BAD Line too long\r\n",
+			err:  imap.ErrLineTooLong,
+		},
+		1: {
+			line: "a001 AUTHENTICATE PLAIN",
+			in:   "*\r\n",
+			out:  "+ \r\na001 BAD AUTHENTICATE cancelled\r\n",
+			err:  imap.ErrAborted,
+		},
+		2: {
+			line: "a001 AUTHENTICATE PLAIN",
+			in:   "!!!!\r\n",
+			out:  "+ \r\na001 BAD Invalid base64\r\n",
+			err:  base64.CorruptInputError(0),
+		},
+		3: {
+			line: "a001 LOGIN user pencil",
+			out:  "a001 BAD Invalid AUTHENTICATE command\r\n",
+			err:  imap.ErrSyntax,
+		},
+		4: {
+			line: "a001",
+			out:  "* BAD Missing command\r\n",
+			err:  imap.ErrSyntax,
+		},
+		5: {
+			line: "a001 AUTHENTICATE PLAIN =",
+			out:  "a001 NO [AUTHENTICATIONFAILED] Authentication failed\r\n",
+			err:  sasl.ErrInvalidChallenge,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out strings.Builder
+			err := imap.HandleAuthenticate(struct {
+				io.Reader
+				io.Writer
+			}{strings.NewReader(tc.in), &out}, tc.line, func(string) *sasl.Negotiator {
+				return sasl.NewServer(sasl.Plain, sasltest.CheckPass)
+			})
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if out.String() != tc.out {
+				t.Errorf("Unexpected output:\nwant=%q\n got=%q", tc.out, out.String())
+			}
+		})
+	}
+}
+
+// readCommand reads the command line sent by the client, a real server would
+// have read this as part of its normal command processing.
+func readCommand(conn net.Conn) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return ""
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	return string(line[:len(line)-1])
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package imap
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// HandleAuthenticate handles an AUTHENTICATE command on behalf of a server.
+//
+// The line argument is the command line that was already read by the caller,
+// including the tag and without the trailing CRLF.
+// The mechanism named in the command is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported.
+// An initial response (RFC 4959) is accepted if one was sent.
+//
+// Additional data returned by the negotiator on success is sent as a final
+// continuation request and the client must respond with an empty line before
+// the tagged OK is sent.
+// If the client cancels the exchange ErrAborted is returned.
+func HandleAuthenticate(rw io.ReadWriter, line string, negotiator func(mechanism string) *sasl.Negotiator) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return wire.Reply(rw, ErrSyntax, "* BAD Missing command")
+	}
+	tag := fields[0]
+	if len(fields) < 3 || len(fields) > 4 || !strings.EqualFold(fields[1], "AUTHENTICATE") {
+		return wire.Reply(rw, ErrSyntax, tag+" BAD Invalid AUTHENTICATE command")
+	}
+
+	server := negotiator(strings.ToUpper(fields[2]))
+	if server == nil {
+		return wire.Reply(rw, ErrUnknownMechanism, tag+" NO Unsupported authentication mechanism")
+	}
+
+	var resp []byte
+	var err error
+	switch {
+	case len(fields) == 3:
+		resp, err = continuation(rw, tag, nil)
+	case fields[3] == "=":
+		resp = []byte{}
+	default:
+		resp, err = wire.Decode([]byte(fields[3]))
+		if err != nil {
+			return wire.Reply(rw, err, tag+" BAD Invalid base64 in initial response")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		more, data, err := server.Step(resp)
+		if err != nil {
+			return wire.Reply(rw, err, tag+" NO [AUTHENTICATIONFAILED] Authentication failed")
+		}
+		if !more {
+			if len(data) > 0 {
+				resp, err = continuation(rw, tag, data)
+				if err != nil {
+					return err
+				}
+				if len(resp) != 0 {
+					return wire.Reply(rw, sasl.ErrInvalidChallenge, tag+" NO [AUTHENTICATIONFAILED] Authentication failed")
+				}
+			}
+			return wire.Reply(rw, nil, tag+" OK AUTHENTICATE completed")
+		}
+		resp, err = continuation(rw, tag, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// continuation sends a continuation request and reads the clients response.
+func continuation(rw io.ReadWriter, tag string, data []byte) ([]byte, error) {
+	if err := wire.WriteLine(rw, "+ %s", wire.Encode(data)); err != nil {
+		return nil, err
+	}
+	line, err := wire.ReadLine(rw, maxLineLen)
+	switch err {
+	case nil:
+	case ErrLineTooLong:
+		return nil, wire.Reply(rw, err, tag+" BAD Line too long")
+	default:
+		return nil, err
+	}
+	if bytes.Equal(line, []byte("*")) {
+		return nil, wire.Reply(rw, ErrAborted, tag+" BAD AUTHENTICATE cancelled")
+	}
+	resp, err := wire.Decode(line)
+	if err != nil {
+		return nil, wire.Reply(rw, err, tag+" BAD Invalid base64")
+	}
+	return resp, nil
+}
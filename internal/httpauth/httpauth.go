@@ -0,0 +1,174 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package httpauth parses and formats the challenges and credentials used by
+// HTTP authentication schemes (RFC 7235) that are made up of auth-params.
+// It also contains the session store and client helpers that are shared by
+// the schemes that take more than one request to authenticate.
+package httpauth
+
+import (
+	"strings"
+)
+
+// Challenge is a challenge from a WWW-Authenticate header or the credentials
+// from an Authorization header.
+// Parameter names are lower case.
+type Challenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+// Parse parses a header value containing one or more challenges.
+// Challenges that are not followed by auth-params (eg. token68 credentials)
+// have no parameters.
+func Parse(header string) []Challenge {
+	var challenges []Challenge
+	s := header
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return challenges
+		}
+		var scheme string
+		scheme, s = token(s)
+		if scheme == "" {
+			return challenges
+		}
+		c := Challenge{Scheme: scheme}
+		c.Params, s = params(s)
+		challenges = append(challenges, c)
+	}
+}
+
+// ParseParams parses a comma separated list of params from headers such as
+// Authentication-Info.
+func ParseParams(header string) map[string]string {
+	p, _ := params(header)
+	return p
+}
+
+// params parses the params at the start of s and returns them along with the
+// rest of s, which starts with the next challenge (if any).
+func params(s string) (map[string]string, string) {
+	p := make(map[string]string)
+	for {
+		rest := strings.TrimLeft(s, " \t")
+		name, after := token(rest)
+		after = strings.TrimLeft(after, " \t")
+		if name == "" {
+			// Skip anything that we do not understand up to the next parameter.
+			i := strings.IndexByte(rest, ',')
+			if i < 0 {
+				return p, ""
+			}
+			s = rest[i+1:]
+			continue
+		}
+		if !strings.HasPrefix(after, "=") {
+			// This is the scheme of the next challenge.
+			return p, rest
+		}
+		var value string
+		value, s = paramValue(strings.TrimLeft(after[1:], " \t"))
+		p[strings.ToLower(name)] = value
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ",") {
+			return p, s
+		}
+		s = s[1:]
+	}
+}
+
+// Format returns a challenge with params, which are pairs of names and values,
+// in the given order.
+func Format(scheme string, params ...string) string {
+	return scheme + " " + FormatParams(params...)
+}
+
+// FormatParams returns a comma separated list of params, which are pairs of
+// names and values, for use in headers such as Authentication-Info.
+// Values are quoted unless they are tokens or base64 (token68) strings, which
+// is how the data parameters of SASL based schemes are normally sent.
+func FormatParams(params ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(params); i += 2 {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(params[i])
+		b.WriteByte('=')
+		if isToken68(params[i+1]) {
+			b.WriteString(params[i+1])
+		} else {
+			b.WriteString(Quote(params[i+1]))
+		}
+	}
+	return b.String()
+}
+
+// Quote returns s as a quoted-string.
+func Quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// token returns the token at the start of s and the rest of s.
+func token(s string) (string, string) {
+	i := 0
+	for i < len(s) && isTokenChar(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// paramValue returns the quoted-string or token (which for compatibility may
+// also contain characters such as "/" and "=" used by base64) at the start of
+// s and the rest of s.
+func paramValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		i := strings.IndexAny(s, " \t,")
+		if i < 0 {
+			return s, ""
+		}
+		return s[:i], s[i:]
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i < len(s) {
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// isToken68 reports whether s is a token68, which is also true of all tokens
+// that do not contain "=" other than at the end.
+func isToken68(s string) bool {
+	t := strings.TrimRight(s, "=")
+	if t == "" {
+		return false
+	}
+	for i := 0; i < len(t); i++ {
+		if !isTokenChar(t[i]) && t[i] != '/' {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
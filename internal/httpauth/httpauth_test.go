@@ -0,0 +1,93 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package httpauth_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl/internal/httpauth"
+)
+
+func TestParse(t *testing.T) {
+	for i, tc := range [...]struct {
+		header string
+		out    []httpauth.Challenge
+	}{
+		0: {},
+		1: {
+			header: `SCRAM-SHA-256 realm="test\"realm", data=biws=`,
+			out: []httpauth.Challenge{
+				{Scheme: "SCRAM-SHA-256", Params: map[string]string{"realm": `test"realm`, "data": "biws="}},
+			},
+		},
+		2: {
+			header: `SCRAM-SHA-256 Realm=a, SCRAM-SHA-1 realm="b" ,Basic`,
+			out: []httpauth.Challenge{
+				{Scheme: "SCRAM-SHA-256", Params: map[string]string{"realm": "a"}},
+				{Scheme: "SCRAM-SHA-1", Params: map[string]string{"realm": "b"}},
+				{Scheme: "Basic", Params: map[string]string{}},
+			},
+		},
+		3: {
+			header: `SASL "oops", mech="PLAIN"`,
+			out: []httpauth.Challenge{
+				{Scheme: "SASL", Params: map[string]string{"mech": "PLAIN"}},
+			},
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if out := httpauth.Parse(tc.header); !reflect.DeepEqual(out, tc.out) {
+				t.Errorf("Wrong challenges:\nwant=%+v\n got=%+v", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	const want = `SCRAM-SHA-256 realm="a b", sid=AAAA_-, data=ab+/cd==`
+	if s := httpauth.Format("SCRAM-SHA-256", "realm", "a b", "sid", "AAAA_-", "data", "ab+/cd=="); s != want {
+		t.Errorf("Wrong challenge:\nwant=%s\n got=%s", want, s)
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	want := map[string]string{"sid": "AAAA_-", "data": "ab+/cd==", "realm": "a b"}
+	if p := httpauth.ParseParams(`sid=AAAA_-, Data=ab+/cd==,realm="a b"`); !reflect.DeepEqual(p, want) {
+		t.Errorf("Wrong params:\nwant=%v\n got=%v", want, p)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	m := &httpauth.MemoryStore{Max: 2}
+	now := time.Now()
+	put := func(sid string, expires time.Time, want error) {
+		t.Helper()
+		if err := m.Put(sid, httpauth.Session{Expires: expires}); err != want {
+			t.Fatalf("Unexpected error storing %s: want=%v, got=%v", sid, want, err)
+		}
+	}
+	put("a", now.Add(time.Minute), nil)
+	put("a", now.Add(time.Minute), httpauth.ErrSessionExists)
+	put("b", now.Add(-time.Minute), nil)
+	// b has expired so it makes room for c.
+	put("c", now.Add(2*time.Minute), nil)
+	put("d", now.Add(time.Minute), httpauth.ErrStoreFull)
+	if _, ok, _ := m.Take("b"); ok {
+		t.Errorf("Expired session was not removed")
+	}
+	if s, ok, _ := m.Take("a"); !ok || !s.Expires.Equal(now.Add(time.Minute)) {
+		t.Errorf("Wrong session: ok=%t, expires=%v", ok, s.Expires)
+	}
+	if _, ok, _ := m.Take("a"); ok {
+		t.Errorf("Session was taken twice")
+	}
+	put("d", now.Add(time.Minute), nil)
+	if _, ok, _ := m.Take("c"); !ok {
+		t.Errorf("Session c was lost")
+	}
+}
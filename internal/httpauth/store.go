@@ -0,0 +1,133 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package httpauth
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSessions is the number of sessions that a MemoryStore keeps if Max
+// is not set.
+const DefaultMaxSessions = 10000
+
+// Errors returned by a Store.
+var (
+	ErrSessionExists = errors.New("Session already exists")
+	ErrStoreFull     = errors.New("Too many sessions")
+)
+
+// Session is the state that is kept between the requests of an exchange.
+// It contains no secrets: the server negotiator is rebuilt by replaying the
+// clients earlier responses with the same nonce, so mechanisms must not
+// depend on anything else that changes between requests.
+type Session struct {
+	Mechanism string
+	Nonce     []byte
+	Responses [][]byte
+	Expires   time.Time
+}
+
+// Store keeps sessions between requests.
+// Implementations that are shared by multiple servers (eg. backed by a
+// database) let each request of an exchange be handled by any of them.
+type Store interface {
+	// Put stores a new session.
+	Put(sid string, s Session) error
+
+	// Take removes a session and returns it.
+	// A session may only be taken once so that a client cannot make more than
+	// one attempt at any step of the exchange.
+	Take(sid string) (s Session, ok bool, err error)
+}
+
+// MemoryStore is a Store that keeps sessions in memory.
+// The zero value is ready to use.
+type MemoryStore struct {
+	// Max is the maximum number of sessions that are kept at once.
+	// If it is zero DefaultMaxSessions is used.
+	Max int
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	expiry   expiryHeap
+}
+
+// Put implements Store.
+// Expired sessions are removed whenever a new one is stored, and if there are
+// still Max sessions left ErrStoreFull is returned.
+func (m *MemoryStore) Put(sid string, s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions == nil {
+		m.sessions = make(map[string]*session)
+	}
+	if _, ok := m.sessions[sid]; ok {
+		return ErrSessionExists
+	}
+	now := time.Now()
+	for len(m.expiry) > 0 && now.After(m.expiry[0].Expires) {
+		delete(m.sessions, heap.Pop(&m.expiry).(*session).sid)
+	}
+	max := m.Max
+	if max == 0 {
+		max = DefaultMaxSessions
+	}
+	if len(m.sessions) >= max {
+		return ErrStoreFull
+	}
+	sess := &session{Session: s, sid: sid}
+	m.sessions[sid] = sess
+	heap.Push(&m.expiry, sess)
+	return nil
+}
+
+// Take implements Store.
+func (m *MemoryStore) Take(sid string) (Session, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[sid]
+	if !ok {
+		return Session{}, false, nil
+	}
+	delete(m.sessions, sid)
+	heap.Remove(&m.expiry, sess.index)
+	return sess.Session, true, nil
+}
+
+// session is a Session in a MemoryStore.
+type session struct {
+	Session
+	sid   string
+	index int
+}
+
+// expiryHeap is a heap.Interface that orders sessions by expiry time.
+type expiryHeap []*session
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].Expires.Before(h[j].Expires) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	s := x.(*session)
+	s.index = len(*h)
+	*h = append(*h, s)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return s
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package httpauth
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Base returns rt, or http.DefaultTransport if rt is nil.
+func Base(rt http.RoundTripper) http.RoundTripper {
+	if rt != nil {
+		return rt
+	}
+	return http.DefaultTransport
+}
+
+// Retry sends a copy of req with the Authorization header set to auth using
+// rt.
+// If the request has a body it must set GetBody.
+func Retry(rt http.RoundTripper, req *http.Request, auth string) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", auth)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return Base(rt).RoundTrip(r)
+}
+
+// Discard reads a small amount of the body so that the connection can be
+// reused and closes it.
+func Discard(resp *http.Response) {
+	io.CopyN(ioutil.Discard, resp.Body, 4096)
+	resp.Body.Close()
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package sasltest contains the credentials and callbacks that are shared by
+// the tests of the protocol packages.
+package sasltest
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// The credentials accepted by CheckPass and ScramSecrets.
+const (
+	Username = "user"
+	Password = "pencil"
+)
+
+// CheckPass is a permissions function that accepts Username with Password.
+func CheckPass(n *sasl.Negotiator) bool {
+	user, pass, _ := n.Credentials()
+	return string(user) == Username && string(pass) == Password
+}
+
+// CheckUser is a permissions function that accepts Username with any password.
+func CheckUser(n *sasl.Negotiator) bool {
+	user, _, _ := n.Credentials()
+	return string(user) == Username
+}
+
+// Creds returns an option that sets the username and password of a client.
+func Creds(user, pass string) sasl.Option {
+	return sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte(user), []byte(pass), nil
+	})
+}
+
+// Secrets returns the SCRAM-SHA-256 credentials of Username.
+func Secrets(username []byte) (sasl.ScramCredentials, bool) {
+	return sasl.NewScramCredentials(sha256.New, []byte(Password), []byte("salt"), 4096), string(username) == Username
+}
+
+// ScramSecrets is an option for SCRAM-SHA-256 servers that looks up
+// credentials with Secrets.
+var ScramSecrets = sasl.ScramSecrets(Secrets)
+
+// BadSignature returns a server mechanism that behaves like m except that it
+// corrupts the SCRAM server signature, which clients must reject.
+func BadSignature(m sasl.Mechanism) sasl.Mechanism {
+	next := m.Next
+	m.Next = func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+		more, resp, cache, err := next(n, challenge, data)
+		if err == nil && bytes.HasPrefix(resp, []byte("v=")) {
+			resp = append([]byte("v=AAAA"), resp[6:]...)
+		}
+		return more, resp, cache, err
+	}
+	return m
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package wire contains helpers shared by the line based protocol adapters.
+package wire
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrLineTooLong is returned by ReadLine if a line is longer than the maximum.
+var ErrLineTooLong = errors.New("Line too long")
+
+// ReadLine reads a CRLF or LF terminated line of at most max bytes (excluding
+// the line ending) and returns it without the line ending.
+//
+// The line is read a byte at a time so that nothing after the line is
+// consumed; the caller may be handing the connection back to a protocol
+// implementation with its own buffering after authentication.
+// If the line is too long the rest of it is discarded and ErrLineTooLong is
+// returned.
+func ReadLine(r io.Reader, max int) ([]byte, error) {
+	var line []byte
+	var b [1]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		// A CR may follow a full line as long as it is the start of the line
+		// ending, which is checked on the next iteration.
+		if len(line) > max || len(line) == max && b[0] != '\r' {
+			for b[0] != '\n' {
+				if _, err := io.ReadFull(r, b[:]); err != nil {
+					return nil, err
+				}
+			}
+			return nil, ErrLineTooLong
+		}
+		line = append(line, b[0])
+	}
+	return bytes.TrimSuffix(line, []byte{'\r'}), nil
+}
+
+// WriteLine formats a line and writes it to w followed by CRLF.
+func WriteLine(w io.Writer, format string, a ...interface{}) error {
+	_, err := fmt.Fprintf(w, format+"\r\n", a...)
+	return err
+}
+
+// Reply writes line to w followed by CRLF and returns err, or the write error
+// if there was one.
+// It is used by servers to send the response that ends an exchange.
+func Reply(w io.Writer, err error, line string) error {
+	if _, werr := io.WriteString(w, line+"\r\n"); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// Encode returns the standard base64 encoding of data.
+func Encode(data []byte) string {
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// Decode decodes standard base64 encoded data.
+func Decode(data []byte) ([]byte, error) {
+	out := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(out, data)
+	return out[:n], err
+}
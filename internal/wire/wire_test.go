@@ -0,0 +1,46 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package wire_test
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+func TestReadLine(t *testing.T) {
+	for i, tc := range [...]struct {
+		in   string
+		line string
+		rest string
+		err  error
+	}{
+		0: {in: "abcd\r\nnext", line: "abcd", rest: "next"},
+		1: {in: "abcd\nnext", line: "abcd", rest: "next"},
+		2: {in: "abcde\r\nnext", err: wire.ErrLineTooLong, rest: "next"},
+		3: {in: "abcde\nnext", err: wire.ErrLineTooLong, rest: "next"},
+		4: {in: "abc\rd\r\nnext", err: wire.ErrLineTooLong, rest: "next"},
+		5: {in: "abcd\r\r\n", err: wire.ErrLineTooLong},
+		6: {in: "abcd", err: io.EOF},
+		7: {in: "\r\n", line: ""},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			r := strings.NewReader(tc.in)
+			line, err := wire.ReadLine(r, 4)
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if string(line) != tc.line {
+				t.Errorf("Wrong line: want=%q, got=%q", tc.line, line)
+			}
+			if rest := tc.in[len(tc.in)-r.Len():]; rest != tc.rest {
+				t.Errorf("Wrong remainder: want=%q, got=%q", tc.rest, rest)
+			}
+		})
+	}
+}
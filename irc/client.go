@@ -0,0 +1,123 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package irc
+
+import (
+	"io"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Authenticate runs the AUTHENTICATE exchange using client until the server
+// sends a numeric reply that ends it.
+//
+// The caller must already have negotiated the sasl capability.
+// Messages other than AUTHENTICATE and the SASL numerics are ignored.
+// If the negotiator returns an error or the server sends a challenge that is
+// too long the exchange is aborted by sending "AUTHENTICATE *" and the error
+// is returned once the server has responded.
+func Authenticate(rw io.ReadWriter, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(rw, "AUTHENTICATE "+client.Mechanism().Name+"\r\n"); err != nil {
+		return err
+	}
+
+	var (
+		irSent   bool
+		abortErr error
+		p        payload
+	)
+	for {
+		msg, err := readMessage(rw)
+		if err != nil {
+			return err
+		}
+
+		switch string(msg.command) {
+		case "AUTHENTICATE":
+			if len(msg.params) != 1 {
+				return ErrSyntax
+			}
+			complete, err := p.add(msg.params[0])
+			if err != nil && abortErr == nil {
+				abortErr = err
+				if _, err = io.WriteString(rw, "AUTHENTICATE *\r\n"); err != nil {
+					return err
+				}
+				continue
+			}
+			if !complete || abortErr != nil {
+				continue
+			}
+			if !irSent {
+				// The first (empty) challenge asks for the initial response.
+				irSent = true
+				if err = writePayload(rw, resp); err != nil {
+					return err
+				}
+				continue
+			}
+			challenge, err := p.decode()
+			if err == nil {
+				more, resp, err = client.Step(challenge)
+			}
+			if err != nil {
+				abortErr = err
+				if _, err = io.WriteString(rw, "AUTHENTICATE *\r\n"); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = writePayload(rw, resp); err != nil {
+				return err
+			}
+		case rplSASLSuccess:
+			switch {
+			case abortErr != nil:
+				return abortErr
+			case more:
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		case errNickLocked:
+			return numericErr(abortErr, ErrNickLocked)
+		case errSASLFail:
+			return numericErr(abortErr, ErrFailed)
+		case errSASLTooLong:
+			return numericErr(abortErr, ErrTooLong)
+		case errSASLAborted:
+			return numericErr(abortErr, ErrAborted)
+		case errSASLAlready:
+			return numericErr(abortErr, ErrAlready)
+		}
+		// Anything else, including RPL_LOGGEDIN and RPL_SASLMECHS (which is always
+		// followed by ERR_SASLFAIL), is ignored.
+	}
+}
+
+func numericErr(abortErr, err error) error {
+	if abortErr != nil {
+		return abortErr
+	}
+	return err
+}
+
+// Mechanisms returns the mechanisms from the value of the sasl capability
+// (eg. "PLAIN,EXTERNAL") or the RPL_SASLMECHS numeric so that they can be passed
+// to the sasl.RemoteMechanisms option.
+func Mechanisms(value string) []string {
+	var mechs []string
+	for _, m := range strings.Split(value, ",") {
+		m = strings.TrimSpace(m)
+		if m != "" {
+			mechs = append(mechs, strings.ToUpper(m))
+		}
+	}
+	return mechs
+}
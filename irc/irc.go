@@ -0,0 +1,169 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package irc implements the IRCv3 SASL authentication (AUTHENTICATE command)
+// for clients and servers.
+//
+// Payloads are base64 encoded and split into 400 byte AUTHENTICATE lines.
+// If the last line is exactly 400 bytes long it is followed by "AUTHENTICATE
+// +" to mark the end of the payload, the same line is used to send an empty
+// payload.
+// The result of the exchange is signaled with the numerics 900 through 908
+// which the client maps to the errors in this package.
+package irc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+const (
+	chunkLen = 400
+
+	// The maximum length of a line including message tags.
+	maxLineLen = 8191 + 512
+
+	// The maximum length of a reassembled payload (before base64 decoding).
+	maxPayloadLen = 65536
+)
+
+// SASL numerics.
+const (
+	rplLoggedIn    = "900"
+	errNickLocked  = "902"
+	rplSASLSuccess = "903"
+	errSASLFail    = "904"
+	errSASLTooLong = "905"
+	errSASLAborted = "906"
+	errSASLAlready = "907"
+	rplSASLMechs   = "908"
+)
+
+// Errors returned by the client and server.
+// The client returns ErrNickLocked, ErrFailed, ErrTooLong, ErrAborted and
+// ErrAlready when it receives the corresponding numeric.
+var (
+	ErrNickLocked       = errors.New("Account is unavailable")
+	ErrFailed           = errors.New("SASL authentication failed")
+	ErrTooLong          = errors.New("SASL message too long")
+	ErrAborted          = errors.New("SASL authentication aborted")
+	ErrAlready          = errors.New("Already authenticated")
+	ErrUnknownMechanism = errors.New("Unsupported authentication mechanism")
+	ErrSyntax           = errors.New("Syntax error in AUTHENTICATE command")
+)
+
+// message is a parsed IRC message.
+type message struct {
+	source  []byte
+	command []byte
+	params  [][]byte
+}
+
+// parse parses an IRC message, message tags are discarded.
+func parse(line []byte) message {
+	var m message
+	if bytes.HasPrefix(line, []byte{'@'}) {
+		idx := bytes.IndexByte(line, ' ')
+		if idx == -1 {
+			return m
+		}
+		line = bytes.TrimLeft(line[idx:], " ")
+	}
+	if bytes.HasPrefix(line, []byte{':'}) {
+		idx := bytes.IndexByte(line, ' ')
+		if idx == -1 {
+			return m
+		}
+		m.source = line[1:idx]
+		line = bytes.TrimLeft(line[idx:], " ")
+	}
+	idx := bytes.IndexByte(line, ' ')
+	if idx == -1 {
+		m.command = line
+		return m
+	}
+	m.command = line[:idx]
+	line = bytes.TrimLeft(line[idx:], " ")
+	for len(line) > 0 {
+		if line[0] == ':' {
+			m.params = append(m.params, line[1:])
+			break
+		}
+		idx = bytes.IndexByte(line, ' ')
+		if idx == -1 {
+			m.params = append(m.params, line)
+			break
+		}
+		m.params = append(m.params, line[:idx])
+		line = bytes.TrimLeft(line[idx:], " ")
+	}
+	return m
+}
+
+// writePayload base64 encodes data and writes it as AUTHENTICATE lines.
+func writePayload(w io.Writer, data []byte) error {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) >= chunkLen {
+		if _, err := io.WriteString(w, "AUTHENTICATE "+enc[:chunkLen]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[chunkLen:]
+	}
+	if enc == "" {
+		enc = "+"
+	}
+	_, err := io.WriteString(w, "AUTHENTICATE "+enc+"\r\n")
+	return err
+}
+
+// payload reassembles a payload from the parameters of AUTHENTICATE messages.
+type payload struct {
+	buf  []byte
+	done bool
+}
+
+// add adds a chunk to the payload and reports whether it is complete.
+func (p *payload) add(chunk []byte) (bool, error) {
+	if p.done {
+		p.buf = p.buf[:0]
+		p.done = false
+	}
+	if !bytes.Equal(chunk, []byte{'+'}) {
+		if len(chunk) > chunkLen {
+			return false, ErrTooLong
+		}
+		if len(p.buf)+len(chunk) > maxPayloadLen {
+			return false, ErrTooLong
+		}
+		p.buf = append(p.buf, chunk...)
+		if len(chunk) == chunkLen {
+			return false, nil
+		}
+	}
+	p.done = true
+	return true, nil
+}
+
+// decode returns the decoded payload.
+func (p *payload) decode() ([]byte, error) {
+	out := make([]byte, base64.StdEncoding.DecodedLen(len(p.buf)))
+	n, err := base64.StdEncoding.Decode(out, p.buf)
+	return out[:n], err
+}
+
+func readMessage(r io.Reader) (message, error) {
+	for {
+		line, err := wire.ReadLine(r, maxLineLen)
+		if err != nil {
+			return message{}, err
+		}
+		if len(line) > 0 {
+			return parse(line), nil
+		}
+	}
+}
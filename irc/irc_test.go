@@ -0,0 +1,285 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package irc_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/irc"
+)
+
+// A password that makes the PLAIN payload exactly 400 bytes once encoded.
+var exactPass = strings.Repeat("p", 294)
+
+// And one that needs three lines.
+var longPass = strings.Repeat("p", 700)
+
+func checkPass(n *sasl.Negotiator) bool {
+	_, pass, _ := n.Credentials()
+	switch string(pass) {
+	case sasltest.Password, exactPass, longPass:
+		return sasltest.CheckUser(n)
+	}
+	return false
+}
+
+// recorder records everything written by the client.
+type recorder struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.buf.Write(p)
+	return r.Conn.Write(p)
+}
+
+func TestMechanisms(t *testing.T) {
+	mechs := irc.Mechanisms("plain,EXTERNAL, SCRAM-SHA-256")
+	if want := []string{"PLAIN", "EXTERNAL", "SCRAM-SHA-256"}; !reflect.DeepEqual(mechs, want) {
+		t.Errorf("Wrong mechanisms: want=%v, got=%v", want, mechs)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		clientErr  error
+		serverErr  error
+		lines      int
+		plus       bool
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			lines:      2,
+		},
+		1: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", exactPass)},
+			lines:      3,
+			plus:       true,
+		},
+		2: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", longPass)},
+			lines:      4,
+		},
+		3: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			clientErr:  irc.ErrFailed,
+			serverErr:  sasl.ErrAuthn,
+			lines:      2,
+		},
+		4: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+			lines: 3,
+		},
+		5: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  irc.ErrAborted,
+			lines:      3,
+		},
+		6: {
+			// The client aborts when the server signature is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+			serverErr:  irc.ErrAborted,
+			lines:      4,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = checkPass
+			}
+			errs := make(chan error, 1)
+			s := irc.Server{
+				Name:       "irc.example.net",
+				Mechanisms: []string{"PLAIN"},
+				Negotiator: func(name string) *sasl.Negotiator {
+					if name != tc.mech.Name {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				},
+			}
+			go func() {
+				errs <- s.HandleAuthenticate(serverConn, readLine(serverConn), "juliet")
+				serverConn.Close()
+			}()
+
+			rec := &recorder{Conn: clientConn}
+			err := irc.Authenticate(rec, sasl.NewClient(tc.mech, tc.clientOpts...))
+			if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(rec.buf.String(), "\r\n"), "\r\n")
+			if len(lines) != tc.lines {
+				t.Fatalf("Wrong number of lines sent: want=%d, got=%d (%q)", tc.lines, len(lines), lines)
+			}
+			for _, l := range lines[1:] {
+				if len(l) > len("AUTHENTICATE ")+400 {
+					t.Errorf("Line too long: %d", len(l))
+				}
+			}
+			if tc.plus && lines[len(lines)-1] != "AUTHENTICATE +" {
+				t.Errorf("Expected exactly 400 byte payload to be terminated by +, got %q", lines[len(lines)-1])
+			}
+		})
+	}
+}
+
+func TestServerReplies(t *testing.T) {
+	for i, tc := range [...]struct {
+		line    string
+		replies []string
+		err     error
+	}{
+		0: {
+			line: "AUTHENTICATE DIGEST-MD5",
+			replies: []string{
+				":irc.example.net 908 juliet PLAIN,EXTERNAL :are available SASL mechanisms",
+				":irc.example.net 904 juliet :SASL authentication failed",
+			},
+			err: irc.ErrUnknownMechanism,
+		},
+		1: {
+			line:    "AUTHENTICATE *",
+			replies: []string{":irc.example.net 906 juliet :SASL authentication aborted"},
+			err:     irc.ErrAborted,
+		},
+		2: {
+			line:    "NICK romeo",
+			replies: []string{":irc.example.net 904 juliet :SASL authentication failed"},
+			err:     irc.ErrSyntax,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			errs := make(chan error, 1)
+			s := irc.Server{
+				Name:       "irc.example.net",
+				Mechanisms: []string{"PLAIN", "EXTERNAL"},
+				Negotiator: func(string) *sasl.Negotiator { return nil },
+			}
+			go func() {
+				errs <- s.HandleAuthenticate(serverConn, tc.line, "juliet")
+				serverConn.Close()
+			}()
+			for _, want := range tc.replies {
+				if got := readLine(clientConn); got != want {
+					t.Errorf("Unexpected reply: want=%q, got=%q", want, got)
+				}
+			}
+			if err := <-errs; err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestTooLong(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	errs := make(chan error, 1)
+	s := irc.Server{
+		Name: "irc.example.net",
+		Negotiator: func(string) *sasl.Negotiator {
+			return sasl.NewServer(sasl.Plain, checkPass)
+		},
+	}
+	go func() {
+		errs <- s.HandleAuthenticate(serverConn, "AUTHENTICATE PLAIN", "juliet")
+		serverConn.Close()
+	}()
+	if got := readLine(clientConn); got != "AUTHENTICATE +" {
+		t.Fatalf("Unexpected reply: %q", got)
+	}
+	go clientConn.Write([]byte("AUTHENTICATE " + strings.Repeat("A", 401) + "\r\n"))
+	if got, want := readLine(clientConn), ":irc.example.net 905 juliet :SASL message too long"; got != want {
+		t.Errorf("Unexpected reply: want=%q, got=%q", want, got)
+	}
+	if err := <-errs; err != irc.ErrTooLong {
+		t.Errorf("Unexpected error: want=%v, got=%v", irc.ErrTooLong, err)
+	}
+}
+
+func TestClientTooLong(t *testing.T) {
+	var out bytes.Buffer
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{
+		Reader: strings.NewReader("AUTHENTICATE +\r\n" +
+			"AUTHENTICATE " + strings.Repeat("A", 401) + "\r\n" +
+			":irc.example.net 906 juliet :SASL authentication aborted\r\n"),
+		Writer: &out,
+	}
+	err := irc.Authenticate(rw, sasl.NewClient(sasl.Plain, sasltest.Creds("user", "pencil")))
+	if err != irc.ErrTooLong {
+		t.Errorf("Unexpected error: want=%v, got=%v", irc.ErrTooLong, err)
+	}
+	if !strings.HasSuffix(out.String(), "\r\nAUTHENTICATE *\r\n") {
+		t.Errorf("Expected the exchange to be aborted, got %q", out.String())
+	}
+}
+
+// readLine reads a single line from conn, a real server would have read this
+// as part of its normal command processing.
+func readLine(conn net.Conn) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return ""
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	return strings.TrimSuffix(string(line), "\r")
+}
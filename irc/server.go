@@ -0,0 +1,131 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package irc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Server handles AUTHENTICATE commands on behalf of an IRC server.
+type Server struct {
+	// Name is the server name used as the source of numeric replies.
+	Name string
+
+	// Mechanisms is the list of supported mechanisms sent in RPL_SASLMECHS if a
+	// client requests a mechanism that is not supported.
+	Mechanisms []string
+
+	// Negotiator returns a server Negotiator (normally created with
+	// sasl.NewServer) for the named mechanism or nil if the mechanism is not
+	// supported.
+	Negotiator func(mechanism string) *sasl.Negotiator
+}
+
+// HandleAuthenticate handles an AUTHENTICATE command from the client using
+// nick.
+//
+// The line argument is the command line that was already read by the caller,
+// without the trailing CRLF.
+// On success RPL_SASLSUCCESS is sent, sending RPL_LOGGEDIN is left to the
+// caller since only it knows which account the client logged in as.
+// Additional data returned by the negotiator on success is sent as a final
+// challenge and the client must respond with an empty payload.
+// If the client aborts the exchange ErrAborted is returned.
+func (s Server) HandleAuthenticate(rw io.ReadWriter, line, nick string) error {
+	msg := parse([]byte(line))
+	if string(msg.command) != "AUTHENTICATE" || len(msg.params) != 1 {
+		return s.numeric(rw, ErrSyntax, errSASLFail, nick, "SASL authentication failed")
+	}
+	mech := strings.ToUpper(string(msg.params[0]))
+	if mech == "*" {
+		return s.numeric(rw, ErrAborted, errSASLAborted, nick, "SASL authentication aborted")
+	}
+
+	var server *sasl.Negotiator
+	if s.Negotiator != nil {
+		server = s.Negotiator(mech)
+	}
+	if server == nil {
+		if len(s.Mechanisms) > 0 {
+			_, err := fmt.Fprintf(rw, ":%s %s %s %s :are available SASL mechanisms\r\n",
+				s.Name, rplSASLMechs, nick, strings.Join(s.Mechanisms, ","))
+			if err != nil {
+				return err
+			}
+		}
+		return s.numeric(rw, ErrUnknownMechanism, errSASLFail, nick, "SASL authentication failed")
+	}
+
+	resp, err := s.challenge(rw, nick, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		more, data, err := server.Step(resp)
+		if err != nil {
+			return s.numeric(rw, err, errSASLFail, nick, "SASL authentication failed")
+		}
+		if !more {
+			if len(data) > 0 {
+				resp, err = s.challenge(rw, nick, data)
+				if err != nil {
+					return err
+				}
+				if len(resp) != 0 {
+					return s.numeric(rw, sasl.ErrInvalidChallenge, errSASLFail, nick, "SASL authentication failed")
+				}
+			}
+			return s.numeric(rw, nil, rplSASLSuccess, nick, "SASL authentication successful")
+		}
+		resp, err = s.challenge(rw, nick, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// challenge sends a challenge and reassembles the clients response.
+func (s Server) challenge(rw io.ReadWriter, nick string, data []byte) ([]byte, error) {
+	if err := writePayload(rw, data); err != nil {
+		return nil, err
+	}
+	var p payload
+	for {
+		msg, err := readMessage(rw)
+		if err != nil {
+			return nil, err
+		}
+		if string(msg.command) != "AUTHENTICATE" || len(msg.params) != 1 {
+			return nil, s.numeric(rw, ErrSyntax, errSASLFail, nick, "SASL authentication failed")
+		}
+		if string(msg.params[0]) == "*" {
+			return nil, s.numeric(rw, ErrAborted, errSASLAborted, nick, "SASL authentication aborted")
+		}
+		complete, err := p.add(msg.params[0])
+		if err != nil {
+			return nil, s.numeric(rw, err, errSASLTooLong, nick, "SASL message too long")
+		}
+		if complete {
+			resp, err := p.decode()
+			if err != nil {
+				return nil, s.numeric(rw, err, errSASLFail, nick, "SASL authentication failed")
+			}
+			return resp, nil
+		}
+	}
+}
+
+// numeric sends a numeric reply and returns err, or the write error if there
+// was one.
+func (s Server) numeric(w io.Writer, err error, num, nick, text string) error {
+	if _, werr := fmt.Fprintf(w, ":%s %s %s :%s\r\n", s.Name, num, nick, text); werr != nil {
+		return werr
+	}
+	return err
+}
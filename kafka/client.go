@@ -0,0 +1,106 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package kafka
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Authenticate sends a SaslHandshake request for the mechanism used by client
+// followed by SaslAuthenticate requests until the exchange is complete.
+//
+// Requests are sent with clientID and with correlation IDs counting up from 0.
+// If the server responds with an error code it is returned as an *Error.
+// The protocol has no way to cancel an exchange, so if the negotiator returns
+// an error it is returned immediately and the caller should close the
+// connection.
+func Authenticate(rw io.ReadWriter, clientID string, client *sasl.Negotiator) error {
+	var correlationID int32
+	request := func(apiKey, version int16) *encoder {
+		e := &encoder{}
+		e.int16(apiKey)
+		e.int16(version)
+		e.int32(correlationID)
+		e.nullableString(&clientID)
+		return e
+	}
+	response := func() (*decoder, error) {
+		msg, err := readMessage(rw)
+		if err != nil {
+			return nil, err
+		}
+		d := &decoder{b: msg}
+		if id := d.int32(); d.err == nil && id != correlationID {
+			return nil, ErrMalformed
+		}
+		correlationID++
+		return d, d.err
+	}
+
+	e := request(APIKeySaslHandshake, 1)
+	e.string(client.Mechanism().Name)
+	if err := writeMessage(rw, e); err != nil {
+		return err
+	}
+	d, err := response()
+	if err != nil {
+		return err
+	}
+	code := d.int16()
+	mechs := d.strings()
+	if d.err != nil {
+		return d.err
+	}
+	if code != ErrorNone {
+		return &Error{Code: code, Mechanisms: mechs}
+	}
+
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+	for {
+		e = request(APIKeySaslAuthenticate, 1)
+		e.bytes(resp)
+		if err = writeMessage(rw, e); err != nil {
+			return err
+		}
+		d, err = response()
+		if err != nil {
+			return err
+		}
+		code := d.int16()
+		msg := d.nullableString()
+		challenge := d.bytes()
+		d.int64()
+		if d.err != nil {
+			return d.err
+		}
+		if code != ErrorNone {
+			e := &Error{Code: code}
+			if msg != nil {
+				e.Message = *msg
+			}
+			return e
+		}
+
+		if !more {
+			// The response to our final message must not contain anything else.
+			if len(challenge) > 0 {
+				return sasl.ErrInvalidChallenge
+			}
+			return nil
+		}
+		more, resp, err = client.Step(challenge)
+		if err != nil {
+			return err
+		}
+		if !more && resp == nil {
+			return nil
+		}
+	}
+}
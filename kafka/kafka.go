@@ -0,0 +1,265 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package kafka implements SASL authentication for the Apache Kafka protocol
+// using the SaslHandshake (version 1) and SaslAuthenticate (versions 0 and 1)
+// requests.
+//
+// Authenticate drives a client Negotiator and Handle drives a server
+// Negotiator.
+// Both operate on the raw connection and never read past the end of the
+// exchange.
+//
+// Clients authenticating with a delegation token should use SCRAM with the
+// token ID as the username, the HMAC as the password, and the TokenAuth
+// option.
+// Servers that accept delegation tokens should use the ScramSecrets option to
+// look up token credentials separately from those of users, and Token in the
+// permissions function to find out which of them was used.
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// API keys of the requests used during authentication.
+const (
+	APIKeySaslHandshake    = 17
+	APIKeySaslAuthenticate = 36
+)
+
+// Error codes used during authentication.
+const (
+	ErrorNone                     = 0
+	ErrorUnsupportedSaslMechanism = 33
+	ErrorIllegalSaslState         = 34
+	ErrorUnsupportedVersion       = 35
+	ErrorSaslAuthenticationFailed = 58
+)
+
+// Requests and responses are limited to 100MiB by the default Kafka
+// configuration, but authentication messages are tiny.
+const maxMessageLen = 65536
+
+// Errors returned by the client and server.
+var (
+	ErrUnknownMechanism   = errors.New("Unsupported SASL mechanism")
+	ErrUnexpectedRequest  = errors.New("Unexpected request during SASL authentication")
+	ErrUnsupportedVersion = errors.New("Unsupported request version")
+	ErrMalformed          = errors.New("Malformed request or response")
+	ErrMessageTooLong     = errors.New("Message too long")
+)
+
+// Error is returned by Authenticate when the server responds with an error
+// code.
+type Error struct {
+	Code    int16
+	Message string
+
+	// Mechanisms contains the mechanisms enabled on the server if Code is
+	// ErrorUnsupportedSaslMechanism.
+	Mechanisms []string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return "kafka: SASL error code " + strconv.Itoa(int(e.Code))
+	}
+	return "kafka: " + e.Message
+}
+
+// TokenAuth is an option for SCRAM client Negotiators that marks the
+// credentials as a delegation token.
+var TokenAuth = sasl.ScramExtensions(map[string]string{"tokenauth": "true"})
+
+// ScramSecrets is an option for SCRAM server Negotiators that looks up the
+// credentials of clients that used TokenAuth with tokens (which is passed the
+// token ID) and those of all other clients with users.
+// If tokens is nil delegation tokens are not supported and clients that use
+// them fail to authenticate, as do clients that send any other extension.
+func ScramSecrets(users, tokens func(username []byte) (sasl.ScramCredentials, bool)) sasl.Option {
+	return sasl.ScramExtensionSecrets(func(username []byte, ext map[string]string) (sasl.ScramCredentials, bool) {
+		switch {
+		case len(ext) == 0:
+			return users(username)
+		case len(ext) == 1 && ext["tokenauth"] == "true" && tokens != nil:
+			return tokens(username)
+		}
+		return sasl.ScramCredentials{}, false
+	})
+}
+
+// Token reports whether the client authenticated with a delegation token.
+// It is meant to be called from the permissions function of a server
+// Negotiator, in which case the username is the token ID and the server must
+// check that the tokens owner may act as the authorization identity.
+func Token(n *sasl.Negotiator) bool {
+	return n.ScramExtensions()["tokenauth"] == "true"
+}
+
+// Request is a request read by ReadRequest.
+type Request struct {
+	APIKey        int16
+	APIVersion    int16
+	CorrelationID int32
+	ClientID      *string
+	Body          []byte
+}
+
+// ReadRequest reads a size delimited request with a version 1 request header
+// from r.
+func ReadRequest(r io.Reader) (Request, error) {
+	msg, err := readMessage(r)
+	if err != nil {
+		return Request{}, err
+	}
+	d := decoder{b: msg}
+	req := Request{
+		APIKey:        d.int16(),
+		APIVersion:    d.int16(),
+		CorrelationID: d.int32(),
+		ClientID:      d.nullableString(),
+	}
+	if d.err != nil {
+		return Request{}, d.err
+	}
+	req.Body = d.b
+	return req, nil
+}
+
+func readMessage(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxMessageLen {
+		return nil, ErrMessageTooLong
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeMessage(w io.Writer, e *encoder) error {
+	msg := make([]byte, 4, 4+len(e.b))
+	binary.BigEndian.PutUint32(msg, uint32(len(e.b)))
+	_, err := w.Write(append(msg, e.b...))
+	return err
+}
+
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) int16(v int16) {
+	e.b = append(e.b, byte(v>>8), byte(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) nullableString(s *string) {
+	if s == nil {
+		e.int16(-1)
+		return
+	}
+	e.string(*s)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// decoder reads primitive types from b, after the first error all reads
+// return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = ErrMalformed
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) int16() int16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) int32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	return int64(d.int32())<<32 | int64(uint32(d.int32()))
+}
+
+func (d *decoder) string() string {
+	return string(d.next(int(d.int16())))
+}
+
+func (d *decoder) nullableString() *string {
+	n := d.int16()
+	if n == -1 {
+		return nil
+	}
+	s := string(d.next(int(n)))
+	return &s
+}
+
+func (d *decoder) bytes() []byte {
+	b := d.next(int(d.int32()))
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func (d *decoder) strings() []string {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	var s []string
+	for i := int32(0); i < n && d.err == nil; i++ {
+		s = append(s, d.string())
+	}
+	return s
+}
@@ -0,0 +1,239 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package kafka_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/kafka"
+)
+
+func tokens(username []byte) (sasl.ScramCredentials, bool) {
+	return sasl.NewScramCredentials(sha256.New, []byte("hmac"), []byte("tokensalt"), 4096), string(username) == "token"
+}
+
+// checkToken is the permissions function for delegation tokens.
+func checkToken(n *sasl.Negotiator) bool {
+	user, _, _ := n.Credentials()
+	return kafka.Token(n) && string(user) == "token"
+}
+
+func TestAuthenticate(t *testing.T) {
+	offered := []string{"PLAIN", "SCRAM-SHA-256"}
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		clientErr  error
+		serverErr  error
+		errCode    int16
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		1: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+		},
+		2: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("token", "hmac"), kafka.TokenAuth},
+			serverOpts: []sasl.Option{kafka.ScramSecrets(sasltest.Secrets, tokens)},
+			perm:       checkToken,
+		},
+		3: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			serverErr:  sasl.ErrAuthn,
+			errCode:    kafka.ErrorSaslAuthenticationFailed,
+		},
+		4: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+		},
+		5: {
+			mech:      sasl.ScramSha1,
+			serverErr: kafka.ErrUnknownMechanism,
+			errCode:   kafka.ErrorUnsupportedSaslMechanism,
+		},
+		6: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  io.EOF,
+		},
+		7: {
+			// Token logins must not be checked against user credentials.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil"), kafka.TokenAuth},
+			serverOpts: []sasl.Option{kafka.ScramSecrets(sasltest.Secrets, nil)},
+			perm:       sasltest.CheckUser,
+			serverErr:  sasl.ErrAuthn,
+			errCode:    kafka.ErrorSaslAuthenticationFailed,
+		},
+		8: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil"), kafka.TokenAuth},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			serverErr:  sasl.ErrAuthn,
+			errCode:    kafka.ErrorSaslAuthenticationFailed,
+		},
+		9: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{kafka.ScramSecrets(sasltest.Secrets, tokens)},
+			perm:       checkToken,
+			serverErr:  sasl.ErrAuthn,
+			errCode:    kafka.ErrorSaslAuthenticationFailed,
+		},
+		10: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			errs := make(chan error, 1)
+			go func() {
+				defer serverConn.Close()
+				req, err := kafka.ReadRequest(serverConn)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if req.ClientID == nil || *req.ClientID != "test" {
+					t.Errorf("Wrong client ID: %v", req.ClientID)
+				}
+				errs <- kafka.Handle(serverConn, req, offered, func(name string) *sasl.Negotiator {
+					if name != tc.mech.Name {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+			}()
+
+			err := kafka.Authenticate(clientConn, "test", sasl.NewClient(tc.mech, tc.clientOpts...))
+			clientConn.Close()
+			if e, ok := err.(*kafka.Error); ok {
+				if e.Code != tc.errCode {
+					t.Errorf("Unexpected error code: want=%d, got=%d", tc.errCode, e.Code)
+				}
+				if e.Code == kafka.ErrorUnsupportedSaslMechanism && !reflect.DeepEqual(e.Mechanisms, offered) {
+					t.Errorf("Wrong mechanisms: want=%v, got=%v", offered, e.Mechanisms)
+				}
+			} else if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+// request returns a size delimited request with an empty client ID.
+func request(apiKey, version int16, body string) string {
+	msg := string([]byte{byte(apiKey >> 8), byte(apiKey), byte(version >> 8), byte(version), 0, 0, 0, 1, 0xff, 0xff}) + body
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(msg)))
+	return string(size[:]) + msg
+}
+
+func TestServerFraming(t *testing.T) {
+	const plain = "\x00\x05PLAIN"
+	for i, tc := range [...]struct {
+		req kafka.Request
+		in  string
+		err error
+	}{
+		0: {
+			req: kafka.Request{APIKey: kafka.APIKeySaslAuthenticate, APIVersion: 1},
+			err: kafka.ErrUnexpectedRequest,
+		},
+		1: {
+			req: kafka.Request{APIKey: kafka.APIKeySaslHandshake, APIVersion: 0, Body: []byte(plain)},
+			err: kafka.ErrUnsupportedVersion,
+		},
+		2: {
+			req: kafka.Request{APIKey: kafka.APIKeySaslHandshake, APIVersion: 1, Body: []byte("\x00\x09PLAIN")},
+			err: kafka.ErrMalformed,
+		},
+		3: {
+			req: kafka.Request{APIKey: kafka.APIKeySaslHandshake, APIVersion: 1, Body: []byte(plain)},
+			in:  request(3, 0, ""),
+			err: kafka.ErrUnexpectedRequest,
+		},
+		4: {
+			req: kafka.Request{APIKey: kafka.APIKeySaslHandshake, APIVersion: 1, Body: []byte(plain)},
+			in:  "\x00\x01\x00\x01",
+			err: kafka.ErrMessageTooLong,
+		},
+		5: {
+			req: kafka.Request{APIKey: kafka.APIKeySaslHandshake, APIVersion: 1, Body: []byte(plain)},
+			in:  request(kafka.APIKeySaslAuthenticate, 1, "\x00\x00\x00\x10user"),
+			err: kafka.ErrMalformed,
+		},
+		6: {
+			// The client hangs up in the middle of the exchange.
+			req: kafka.Request{APIKey: kafka.APIKeySaslHandshake, APIVersion: 1, Body: []byte(plain)},
+			err: io.EOF,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			err := kafka.Handle(struct {
+				io.Reader
+				io.Writer
+			}{strings.NewReader(tc.in), &out}, tc.req, []string{"PLAIN"}, func(string) *sasl.Negotiator {
+				return sasl.NewServer(sasl.Plain, sasltest.CheckPass)
+			})
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if out.Len() == 0 && err != io.EOF {
+				t.Errorf("Expected an error response")
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package kafka
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Handle handles a SaslHandshake request on behalf of a server and then reads
+// and responds to SaslAuthenticate requests until the exchange is complete.
+//
+// The req argument is the SaslHandshake request that was already read by the
+// caller (see ReadRequest).
+// The mechanism named in the request is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported; mechanisms is the list of enabled
+// mechanisms sent in the response.
+//
+// Only version 1 of SaslHandshake is supported, the unframed tokens used after
+// a version 0 handshake are not.
+// Errors are sent to the client in the response to the request that caused
+// them and then returned.
+func Handle(rw io.ReadWriter, req Request, mechanisms []string, negotiator func(mechanism string) *sasl.Negotiator) error {
+	response := func(req Request) *encoder {
+		e := &encoder{}
+		e.int32(req.CorrelationID)
+		return e
+	}
+	handshakeErr := func(code int16, err error) error {
+		e := response(req)
+		e.int16(code)
+		e.int32(int32(len(mechanisms)))
+		for _, m := range mechanisms {
+			e.string(m)
+		}
+		if werr := writeMessage(rw, e); werr != nil {
+			return werr
+		}
+		return err
+	}
+
+	switch {
+	case req.APIKey != APIKeySaslHandshake:
+		return handshakeErr(ErrorIllegalSaslState, ErrUnexpectedRequest)
+	case req.APIVersion != 1:
+		return handshakeErr(ErrorUnsupportedVersion, ErrUnsupportedVersion)
+	}
+	d := decoder{b: req.Body}
+	name := d.string()
+	if d.err != nil {
+		return handshakeErr(ErrorIllegalSaslState, d.err)
+	}
+	var server *sasl.Negotiator
+	for _, m := range mechanisms {
+		if m == name {
+			server = negotiator(name)
+			break
+		}
+	}
+	if server == nil {
+		return handshakeErr(ErrorUnsupportedSaslMechanism, ErrUnknownMechanism)
+	}
+	if err := handshakeErr(ErrorNone, nil); err != nil {
+		return err
+	}
+
+	for {
+		req, err := ReadRequest(rw)
+		if err != nil {
+			return err
+		}
+		authErr := func(code int16, msg string, err error) error {
+			e := response(req)
+			e.int16(code)
+			e.nullableString(&msg)
+			e.bytes(nil)
+			if req.APIVersion > 0 {
+				e.int64(0)
+			}
+			if werr := writeMessage(rw, e); werr != nil {
+				return werr
+			}
+			return err
+		}
+		switch {
+		case req.APIKey != APIKeySaslAuthenticate:
+			return authErr(ErrorIllegalSaslState, "Unexpected request during SASL authentication", ErrUnexpectedRequest)
+		case req.APIVersion > 1:
+			return authErr(ErrorUnsupportedVersion, "Unsupported SaslAuthenticate version", ErrUnsupportedVersion)
+		}
+		d := decoder{b: req.Body}
+		resp := d.bytes()
+		if d.err != nil {
+			return authErr(ErrorIllegalSaslState, "Malformed SaslAuthenticate request", d.err)
+		}
+
+		more, data, err := server.Step(resp)
+		if err != nil {
+			return authErr(ErrorSaslAuthenticationFailed, "Authentication failed during authentication due to invalid credentials with SASL mechanism "+name, err)
+		}
+		e := response(req)
+		e.int16(ErrorNone)
+		e.nullableString(nil)
+		e.bytes(data)
+		if req.APIVersion > 0 {
+			// A session lifetime of zero means that the client does not have to
+			// re-authenticate.
+			e.int64(0)
+		}
+		if err = writeMessage(rw, e); err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+}
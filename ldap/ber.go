@@ -0,0 +1,144 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package ldap
+
+import (
+	"errors"
+	"io"
+)
+
+// BER tags used by the bind operation (RFC 4511 section 4.2).
+// Only the low tag number form is needed.
+const (
+	tagInteger      = 0x02
+	tagOctetString  = 0x04
+	tagEnumerated   = 0x0a
+	tagSequence     = 0x30
+	tagBindRequest  = 0x60 // [APPLICATION 0] constructed
+	tagBindResponse = 0x61 // [APPLICATION 1] constructed
+	tagSimple       = 0x80 // [0] primitive
+	tagSASL         = 0xa3 // [3] constructed
+	tagServerCreds  = 0x87 // [7] primitive
+)
+
+// The largest packet that ReadPacket will accept.
+const maxPacketLen = 1 << 20
+
+// ErrMalformed is returned when a packet cannot be decoded.
+var ErrMalformed = errors.New("Malformed BER packet")
+
+// ErrPacketTooLong is returned by ReadPacket if the packet is larger than
+// 1MiB.
+var ErrPacketTooLong = errors.New("Packet too long")
+
+// tlv appends a BER element with a definite length to b.
+func tlv(b []byte, tag byte, value []byte) []byte {
+	b = append(b, tag)
+	switch n := len(value); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, value...)
+}
+
+// integer encodes i as a minimal two's complement big endian integer.
+func integer(i int64) []byte {
+	n := 1
+	for v := i; v > 127 || v < -128; v >>= 8 {
+		n++
+	}
+	b := make([]byte, n)
+	for j := n - 1; j >= 0; j-- {
+		b[j] = byte(i)
+		i >>= 8
+	}
+	return b
+}
+
+// element splits the first BER element off b and returns its tag, value, and
+// the remaining bytes.
+func element(b []byte) (tag byte, value, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, ErrMalformed
+	}
+	tag = b[0]
+	if tag&0x1f == 0x1f {
+		// High tag numbers are never used by LDAP.
+		return 0, nil, nil, ErrMalformed
+	}
+	n := int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		l := n & 0x7f
+		if l == 0 || l > 4 || len(b) < l {
+			// The indefinite length form is not allowed in LDAP.
+			return 0, nil, nil, ErrMalformed
+		}
+		n = 0
+		for _, c := range b[:l] {
+			n = n<<8 | int(c)
+		}
+		b = b[l:]
+	}
+	if n < 0 || n > len(b) {
+		return 0, nil, nil, ErrMalformed
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// parseInteger decodes a two's complement integer of at most 8 bytes.
+func parseInteger(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, ErrMalformed
+	}
+	i := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		i = i<<8 | int64(c)
+	}
+	return i, nil
+}
+
+// ReadPacket reads a single BER encoded LDAPMessage from r.
+//
+// The packet is read without buffering so that nothing after it is consumed.
+func ReadPacket(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[0] != tagSequence {
+		return nil, ErrMalformed
+	}
+	n := int(hdr[1])
+	if n&0x80 != 0 {
+		l := n & 0x7f
+		if l == 0 || l > 4 {
+			return nil, ErrMalformed
+		}
+		hdr = hdr[:2+l]
+		if _, err := io.ReadFull(r, hdr[2:]); err != nil {
+			return nil, err
+		}
+		n = 0
+		for _, c := range hdr[2:] {
+			n = n<<8 | int(c)
+		}
+	}
+	if n < 0 || n > maxPacketLen {
+		return nil, ErrPacketTooLong
+	}
+	packet := make([]byte, len(hdr)+n)
+	copy(packet, hdr)
+	if _, err := io.ReadFull(r, packet[len(hdr):]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package ldap
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Bind sends SASL BindRequests for the DN name (normally empty) and runs the
+// exchange using client until the server responds with a result code other
+// than saslBindInProgress.
+//
+// Requests are sent with message IDs counting up from 1.
+// If the server returns an error result it is returned as an *Error.
+// If the negotiator returns an error the bind is aborted by sending a
+// BindRequest with an empty mechanism and the negotiators error is returned
+// once the server has responded.
+func Bind(rw io.ReadWriter, name string, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+
+	mechanism := client.Mechanism().Name
+	var id int64 = 1
+	if _, err = rw.Write(bindRequest(id, name, mechanism, resp)); err != nil {
+		return err
+	}
+
+	var abortErr error
+	for {
+		packet, err := ReadPacket(rw)
+		if err != nil {
+			return err
+		}
+		respID, result, creds, err := parseBindResponse(packet)
+		switch {
+		case err != nil:
+			return err
+		case respID != id:
+			return ErrMessageID
+		case abortErr != nil:
+			return abortErr
+		}
+
+		switch result.ResultCode {
+		case ResultSuccess:
+			if more && creds != nil {
+				more, _, err = client.Step(creds)
+				if err != nil {
+					return err
+				}
+			}
+			if more {
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		case ResultSaslBindInProgress:
+		default:
+			return result
+		}
+
+		if creds == nil {
+			creds = []byte{}
+		}
+		more, resp, err = client.Step(creds)
+		id++
+		if err != nil {
+			abortErr = err
+			if _, err = rw.Write(bindRequest(id, name, "", nil)); err != nil {
+				return err
+			}
+			continue
+		}
+		if resp == nil {
+			resp = []byte{}
+		}
+		if _, err = rw.Write(bindRequest(id, name, mechanism, resp)); err != nil {
+			return err
+		}
+	}
+}
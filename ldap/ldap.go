@@ -0,0 +1,207 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package ldap implements the LDAP SASL bind operation as defined in RFC 4511
+// and RFC 4513.
+//
+// Bind drives a client Negotiator and HandleBind drives a server Negotiator.
+// Both operate on the raw connection after any other LDAP operations have been
+// handled and never read past the end of the exchange.
+// Only the parts of BER needed for the bind operation are implemented.
+package ldap
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Result codes used by the bind operation.
+const (
+	ResultSuccess                     = 0
+	ResultProtocolError               = 2
+	ResultAuthMethodNotSupported      = 7
+	ResultSaslBindInProgress          = 14
+	ResultInappropriateAuthentication = 48
+	ResultInvalidCredentials          = 49
+	ResultUnwillingToPerform          = 53
+)
+
+// Errors returned by the client and server.
+var (
+	ErrAborted          = errors.New("SASL bind aborted by client")
+	ErrUnknownMechanism = errors.New("Authentication method not supported")
+	ErrNotBind          = errors.New("Message is not a bind request")
+	ErrMessageID        = errors.New("Response has the wrong message ID")
+	ErrTooManyRestarts  = errors.New("Too many SASL bind restarts")
+)
+
+// Error is returned by Bind when the server responds with a result code other
+// than success or saslBindInProgress.
+type Error struct {
+	ResultCode int
+	MatchedDN  string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	s := "ldap: result code " + strconv.Itoa(e.ResultCode)
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+// BindRequest is a decoded BindRequest.
+// If the request uses simple authentication instead of SASL, Simple is true
+// and Credentials contains the password.
+type BindRequest struct {
+	MessageID   int64
+	Version     int64
+	Name        string
+	Simple      bool
+	Mechanism   string
+	Credentials []byte
+}
+
+// ParseBindRequest decodes a packet read with ReadPacket.
+// If the packet is a valid LDAPMessage but contains some other operation
+// ErrNotBind is returned.
+// Any controls are ignored.
+func ParseBindRequest(packet []byte) (BindRequest, error) {
+	id, op, opValue, err := parseMessage(packet)
+	if err != nil {
+		return BindRequest{}, err
+	}
+	if op != tagBindRequest {
+		return BindRequest{}, ErrNotBind
+	}
+	req := BindRequest{MessageID: id}
+
+	tag, v, rest, err := element(opValue)
+	if err != nil || tag != tagInteger {
+		return BindRequest{}, ErrMalformed
+	}
+	if req.Version, err = parseInteger(v); err != nil {
+		return BindRequest{}, err
+	}
+	tag, v, rest, err = element(rest)
+	if err != nil || tag != tagOctetString {
+		return BindRequest{}, ErrMalformed
+	}
+	req.Name = string(v)
+	tag, v, _, err = element(rest)
+	if err != nil {
+		return BindRequest{}, err
+	}
+	switch tag {
+	case tagSimple:
+		req.Simple = true
+		req.Credentials = v
+	case tagSASL:
+		tag, mech, rest, err := element(v)
+		if err != nil || tag != tagOctetString {
+			return BindRequest{}, ErrMalformed
+		}
+		req.Mechanism = string(mech)
+		if len(rest) > 0 {
+			tag, creds, _, err := element(rest)
+			if err != nil || tag != tagOctetString {
+				return BindRequest{}, ErrMalformed
+			}
+			req.Credentials = creds
+		}
+	default:
+		return BindRequest{}, ErrMalformed
+	}
+	return req, nil
+}
+
+// parseMessage decodes the outer LDAPMessage and returns the message ID and
+// the tag and value of the protocol operation.
+func parseMessage(packet []byte) (id int64, op byte, value []byte, err error) {
+	tag, msg, _, err := element(packet)
+	if err != nil || tag != tagSequence {
+		return 0, 0, nil, ErrMalformed
+	}
+	tag, v, rest, err := element(msg)
+	if err != nil || tag != tagInteger {
+		return 0, 0, nil, ErrMalformed
+	}
+	if id, err = parseInteger(v); err != nil {
+		return 0, 0, nil, err
+	}
+	op, value, _, err = element(rest)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return id, op, value, nil
+}
+
+func message(id int64, op byte, value []byte) []byte {
+	msg := tlv(nil, tagInteger, integer(id))
+	msg = tlv(msg, op, value)
+	return tlv(nil, tagSequence, msg)
+}
+
+func bindRequest(id int64, name, mechanism string, creds []byte) []byte {
+	sasl := tlv(nil, tagOctetString, []byte(mechanism))
+	if creds != nil {
+		sasl = tlv(sasl, tagOctetString, creds)
+	}
+	req := tlv(nil, tagInteger, integer(3))
+	req = tlv(req, tagOctetString, []byte(name))
+	req = tlv(req, tagSASL, sasl)
+	return message(id, tagBindRequest, req)
+}
+
+func bindResponse(id int64, code int, msg string, creds []byte) []byte {
+	resp := tlv(nil, tagEnumerated, integer(int64(code)))
+	resp = tlv(resp, tagOctetString, nil)
+	resp = tlv(resp, tagOctetString, []byte(msg))
+	if creds != nil {
+		resp = tlv(resp, tagServerCreds, creds)
+	}
+	return message(id, tagBindResponse, resp)
+}
+
+// parseBindResponse decodes a BindResponse and returns the result and any
+// server SASL credentials.
+func parseBindResponse(packet []byte) (id int64, result *Error, creds []byte, err error) {
+	id, op, value, err := parseMessage(packet)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if op != tagBindResponse {
+		return 0, nil, nil, ErrMalformed
+	}
+	tag, v, rest, err := element(value)
+	if err != nil || tag != tagEnumerated {
+		return 0, nil, nil, ErrMalformed
+	}
+	code, err := parseInteger(v)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	result = &Error{ResultCode: int(code)}
+	tag, v, rest, err = element(rest)
+	if err != nil || tag != tagOctetString {
+		return 0, nil, nil, ErrMalformed
+	}
+	result.MatchedDN = string(v)
+	tag, v, rest, err = element(rest)
+	if err != nil || tag != tagOctetString {
+		return 0, nil, nil, ErrMalformed
+	}
+	result.Message = string(v)
+	for len(rest) > 0 {
+		tag, v, rest, err = element(rest)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if tag == tagServerCreds {
+			creds = v
+		}
+	}
+	return id, result, creds, nil
+}
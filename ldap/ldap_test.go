@@ -0,0 +1,332 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package ldap_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/ldap"
+)
+
+var longPass = strings.Repeat("p", 300)
+
+// external is a stand-in for the EXTERNAL mechanism where the client sends an
+// empty authorization identity and the server always succeeds.
+var external = sasl.Mechanism{
+	Name: "EXTERNAL",
+	Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+		return false, []byte{}, nil, nil
+	},
+	Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		if n.State()&sasl.Receiving != sasl.Receiving || len(challenge) != 0 {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, nil, nil, nil
+	},
+}
+
+// finalData is a mechanism with no initial response where the server sends
+// additional data with its success.
+var finalData = sasl.Mechanism{
+	Name: "X-FINAL",
+	Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+		return true, nil, nil, nil
+	},
+	Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		if n.State()&sasl.Receiving == sasl.Receiving {
+			if len(challenge) != 0 {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, []byte("goodbye"), nil, nil
+		}
+		if n.State()&sasl.StepMask == sasl.AuthTextSent {
+			return true, []byte{}, nil, nil
+		}
+		if string(challenge) != "goodbye" {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, nil, nil, nil
+	},
+}
+
+// checkPass also accepts longPass, which is used to test long messages.
+func checkPass(n *sasl.Negotiator) bool {
+	_, pass, _ := n.Credentials()
+	return sasltest.CheckPass(n) || sasltest.CheckUser(n) && string(pass) == longPass
+}
+
+func TestBind(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		serverMech string
+		packet     []byte
+		clientErr  error
+		serverErr  error
+		resultCode int
+	}{
+		0: {
+			mech:   external,
+			packet: []byte("\x30\x18\x02\x01\x01\x60\x13\x02\x01\x03\x04\x00\xa3\x0c\x04\x08EXTERNAL\x04\x00"),
+		},
+		1: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		2: {
+			// Long form lengths.
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", longPass)},
+		},
+		3: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			serverErr:  sasl.ErrAuthn,
+			resultCode: ldap.ResultInvalidCredentials,
+		},
+		4: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+		},
+		5: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+		},
+		6: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  ldap.ErrAborted,
+		},
+		7: {
+			mech: finalData,
+		},
+		8: {
+			mech:       sasl.Plain,
+			serverMech: "SCRAM-SHA-1",
+			serverErr:  ldap.ErrUnknownMechanism,
+			resultCode: ldap.ResultAuthMethodNotSupported,
+		},
+		9: {
+			// The signature is sent with the result so only the client notices that
+			// it is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			serverMech := tc.serverMech
+			if serverMech == "" {
+				serverMech = tc.mech.Name
+			}
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = checkPass
+			}
+			errs := make(chan error, 1)
+			go func() {
+				defer serverConn.Close()
+				packet, err := ldap.ReadPacket(serverConn)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if tc.packet != nil && !bytes.Equal(packet, tc.packet) {
+					t.Errorf("Wrong packet:\nwant=%x\n got=%x", tc.packet, packet)
+				}
+				req, err := ldap.ParseBindRequest(packet)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if req.Version != 3 || req.MessageID != 1 {
+					t.Errorf("Wrong version or message ID: %+v", req)
+				}
+				errs <- ldap.HandleBind(serverConn, req, func(name string) *sasl.Negotiator {
+					if name != serverMech {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+			}()
+
+			err := ldap.Bind(clientConn, "", sasl.NewClient(tc.mech, tc.clientOpts...))
+			if e, ok := err.(*ldap.Error); ok {
+				if e.ResultCode != tc.resultCode {
+					t.Errorf("Unexpected result code: want=%d, got=%d", tc.resultCode, e.ResultCode)
+				}
+			} else if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+// saslBind is a minimal stand-in for the encoding of a real client, if creds is
+// empty no credentials are sent.
+func saslBind(id byte, mech, creds string) string {
+	auth := "\x04" + string(byte(len(mech))) + mech
+	if creds != "" {
+		auth += "\x04" + string(byte(len(creds))) + creds
+	}
+	bind := "\x02\x01\x03\x04\x00\xa3" + string(byte(len(auth))) + auth
+	msg := "\x02\x01" + string(id) + "\x60" + string(byte(len(bind))) + bind
+	return "\x30" + string(byte(len(msg))) + msg
+}
+
+func TestRestart(t *testing.T) {
+	var switching string
+	for i := 0; i < 20; i++ {
+		mech := "PLAIN"
+		if i%2 == 0 {
+			mech = "EXTERNAL"
+		}
+		switching += saslBind(byte(i+2), mech, "")
+	}
+	for i, tc := range [...]struct {
+		in  string
+		err error
+	}{
+		0: {
+			in: saslBind(2, "EXTERNAL", "") + saslBind(3, "PLAIN", "\x00user\x00pencil"),
+		},
+		1: {
+			in:  saslBind(2, "EXTERNAL", "") + saslBind(3, "", ""),
+			err: ldap.ErrAborted,
+		},
+		2: {
+			in:  switching,
+			err: ldap.ErrTooManyRestarts,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: strings.NewReader(tc.in),
+				Writer: &out,
+			}
+			req := ldap.BindRequest{MessageID: 1, Version: 3, Mechanism: "PLAIN"}
+			err := ldap.HandleBind(rw, req, func(name string) *sasl.Negotiator {
+				if name == "EXTERNAL" {
+					return sasl.NewServer(external, checkPass)
+				}
+				return sasl.NewServer(sasl.Plain, checkPass)
+			})
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestParseBindRequest(t *testing.T) {
+	for i, tc := range [...]struct {
+		packet string
+		req    ldap.BindRequest
+		err    error
+	}{
+		0: {
+			packet: "\x30\x1b\x02\x01\x02\x60\x16\x02\x01\x03\x04\x07cn=test\x80\x08password",
+			req:    ldap.BindRequest{MessageID: 2, Version: 3, Name: "cn=test", Simple: true, Credentials: []byte("password")},
+		},
+		1: {
+			packet: "\x30\x16\x02\x01\x01\x60\x11\x02\x01\x03\x04\x00\xa3\x0a\x04\x08EXTERNAL",
+			req:    ldap.BindRequest{MessageID: 1, Version: 3, Mechanism: "EXTERNAL"},
+		},
+		2: {
+			// An UnbindRequest.
+			packet: "\x30\x05\x02\x01\x03\x42\x00",
+			err:    ldap.ErrNotBind,
+		},
+		3: {
+			packet: "\x30\x16\x02\x01\x01\x60\x11\x02\x01\x03\x04\x00\xa3\x0a\x04\x09EXTERNAL",
+			err:    ldap.ErrMalformed,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			req, err := ldap.ParseBindRequest([]byte(tc.packet))
+			if err != tc.err {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if req.MessageID != tc.req.MessageID || req.Version != tc.req.Version ||
+				req.Name != tc.req.Name || req.Simple != tc.req.Simple ||
+				req.Mechanism != tc.req.Mechanism || !bytes.Equal(req.Credentials, tc.req.Credentials) {
+				t.Errorf("Wrong request: want=%+v, got=%+v", tc.req, req)
+			}
+		})
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	for i, tc := range [...]struct {
+		in  string
+		out string
+		err error
+	}{
+		0: {
+			in:  "\x30\x05\x02\x01\x03\x42\x00trailing",
+			out: "\x30\x05\x02\x01\x03\x42\x00",
+		},
+		1: {
+			in:  "\x30\x84\x00\x10\x00\x01",
+			err: ldap.ErrPacketTooLong,
+		},
+		2: {
+			in:  "\x30\x85\x00\x00\x00\x00\x01",
+			err: ldap.ErrMalformed,
+		},
+		3: {
+			in:  "\x04\x00",
+			err: ldap.ErrMalformed,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			packet, err := ldap.ReadPacket(strings.NewReader(tc.in))
+			if err != tc.err {
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if string(packet) != tc.out {
+				t.Errorf("Wrong packet: want=%q, got=%q", tc.out, packet)
+			}
+		})
+	}
+}
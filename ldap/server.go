@@ -0,0 +1,129 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package ldap
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// maxRestarts is the number of times that a client may switch to a different
+// mechanism during a single bind.
+const maxRestarts = 8
+
+// HandleBind handles a SASL bind on behalf of a server.
+//
+// The req argument is the first BindRequest which was already read by the
+// caller (see ReadPacket and ParseBindRequest).
+// The mechanism named in the request is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported.
+// Simple binds are not supported and result in authMethodNotSupported.
+//
+// Challenges are sent in BindResponses with the saslBindInProgress result and
+// any additional data returned by the negotiator on success is sent with the
+// success result.
+// If the client aborts the bind by sending an empty mechanism ErrAborted is
+// returned, and if it switches to a different mechanism the bind starts over.
+// If the client starts over too many times ErrTooManyRestarts is returned.
+func HandleBind(rw io.ReadWriter, req BindRequest, negotiator func(mechanism string) *sasl.Negotiator) error {
+	for restarts := 0; ; restarts++ {
+		next, err := bind(rw, req, negotiator)
+		if err != nil || next == nil {
+			return err
+		}
+		req = *next
+		switch {
+		case !req.Simple && req.Mechanism == "":
+			return writeErr(rw, req, ResultAuthMethodNotSupported, "SASL bind aborted", ErrAborted)
+		case restarts == maxRestarts:
+			return writeErr(rw, req, ResultUnwillingToPerform, "Too many SASL bind restarts", ErrTooManyRestarts)
+		}
+	}
+}
+
+// bind runs a single exchange.
+// If the client sends a BindRequest that changes the mechanism in the middle of
+// the exchange it is returned so that the bind can start over.
+func bind(rw io.ReadWriter, req BindRequest, negotiator func(mechanism string) *sasl.Negotiator) (*BindRequest, error) {
+	if req.Simple || req.Mechanism == "" {
+		return nil, writeErr(rw, req, ResultAuthMethodNotSupported, "Authentication method not supported", ErrUnknownMechanism)
+	}
+	server := negotiator(req.Mechanism)
+	if server == nil {
+		return nil, writeErr(rw, req, ResultAuthMethodNotSupported, "SASL mechanism not supported", ErrUnknownMechanism)
+	}
+
+	resp := req.Credentials
+	if resp == nil {
+		// No initial response, send an empty challenge to ask for one.
+		next, err := challenge(rw, req, nil)
+		if err != nil {
+			return nil, err
+		}
+		if next.Simple || next.Mechanism != req.Mechanism {
+			return &next, nil
+		}
+		req = next
+		resp = req.Credentials
+	}
+
+	for {
+		more, data, err := server.Step(resp)
+		switch {
+		case err == sasl.ErrAuthn:
+			return nil, writeErr(rw, req, ResultInvalidCredentials, "Invalid credentials", err)
+		case err != nil:
+			return nil, writeErr(rw, req, ResultInappropriateAuthentication, "SASL authentication failed", err)
+		case !more:
+			_, err = rw.Write(bindResponse(req.MessageID, ResultSuccess, "", data))
+			return nil, err
+		}
+
+		next, err := challenge(rw, req, data)
+		if err != nil {
+			return nil, err
+		}
+		if next.Simple || next.Mechanism != req.Mechanism {
+			return &next, nil
+		}
+		req = next
+		resp = req.Credentials
+		if resp == nil {
+			resp = []byte{}
+		}
+	}
+}
+
+// challenge sends a saslBindInProgress response and reads the next
+// BindRequest.
+func challenge(rw io.ReadWriter, req BindRequest, data []byte) (BindRequest, error) {
+	if data == nil {
+		data = []byte{}
+	}
+	if _, err := rw.Write(bindResponse(req.MessageID, ResultSaslBindInProgress, "", data)); err != nil {
+		return BindRequest{}, err
+	}
+	packet, err := ReadPacket(rw)
+	if err != nil {
+		return BindRequest{}, err
+	}
+	next, err := ParseBindRequest(packet)
+	if err != nil {
+		// We have no way to respond to other operations, so just give up.
+		return BindRequest{}, err
+	}
+	return next, nil
+}
+
+// writeErr sends a BindResponse with an error result and returns err, or the
+// write error if there was one.
+func writeErr(w io.Writer, req BindRequest, code int, msg string, err error) error {
+	if _, werr := w.Write(bindResponse(req.MessageID, code, msg, nil)); werr != nil {
+		return werr
+	}
+	return err
+}
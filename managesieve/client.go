@@ -0,0 +1,127 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package managesieve
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// Authenticate sends an AUTHENTICATE command with an initial response and runs
+// the exchange using client until the server sends an OK, NO or BYE response.
+//
+// If the OK response contains additional data in a SASL response code it is
+// passed to the negotiator, which must accept it and finish.
+// If the negotiator returns an error the exchange is cancelled by sending "*"
+// and the negotiators error is returned once the server has responded.
+func Authenticate(rw io.ReadWriter, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+
+	name := quote(client.Mechanism().Name)
+	_, err = fmt.Fprintf(rw, "AUTHENTICATE %s %s\r\n", name, str(wire.Encode(resp), true))
+	if err != nil {
+		return err
+	}
+
+	var abortErr error
+	for {
+		line, err := wire.ReadLine(rw, maxLineLen)
+		if err != nil {
+			return err
+		}
+		toks, err := tokenize(rw, line)
+		if err != nil {
+			return err
+		}
+		if len(toks) == 0 {
+			return ErrSyntax
+		}
+
+		if toks[0].kind == tokString {
+			// A challenge.
+			if abortErr != nil {
+				if err = wire.WriteLine(rw, "%s", str("*", true)); err != nil {
+					return err
+				}
+				continue
+			}
+			challenge, err := wire.Decode(toks[0].val)
+			if err == nil {
+				more, resp, err = client.Step(challenge)
+			}
+			if err != nil {
+				abortErr = err
+				if err = wire.WriteLine(rw, "%s", str("*", true)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = wire.WriteLine(rw, "%s", str(wire.Encode(resp), true)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		code, data, text := parseResponse(toks[1:])
+		switch status := strings.ToUpper(string(toks[0].val)); {
+		case abortErr != nil:
+			return abortErr
+		case status == "OK":
+			if data != nil {
+				if !more {
+					return sasl.ErrInvalidChallenge
+				}
+				final, err := wire.Decode(data)
+				if err != nil {
+					return err
+				}
+				more, _, err = client.Step(final)
+				if err != nil {
+					return err
+				}
+			}
+			if more {
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		default:
+			return &Error{Status: status, Code: code, Text: text}
+		}
+	}
+}
+
+// parseResponse parses the optional response code and human readable text
+// that follow OK, NO and BYE.
+// If the response code is SASL its argument is returned as data.
+func parseResponse(toks []token) (code string, data []byte, text string) {
+	if len(toks) > 0 && toks[0].kind == tokLParen {
+		var args []token
+		i := 1
+		for ; i < len(toks) && toks[i].kind != tokRParen; i++ {
+			args = append(args, toks[i])
+		}
+		toks = toks[i:]
+		if len(toks) > 0 {
+			toks = toks[1:]
+		}
+		if len(args) > 0 {
+			code = strings.ToUpper(string(args[0].val))
+		}
+		if code == "SASL" && len(args) == 2 && args[1].kind == tokString {
+			data = args[1].val
+		}
+	}
+	if len(toks) > 0 && toks[0].kind == tokString {
+		text = string(toks[0].val)
+	}
+	return code, data, text
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package managesieve implements the ManageSieve AUTHENTICATE command as
+// defined in RFC 5804.
+//
+// Unlike most SASL profiles ManageSieve sends the base64 encoded challenges
+// and responses as strings, which may be either quoted or literal, and allows
+// the server to send additional data with its final OK response.
+// Authenticate drives a client Negotiator and HandleAuthenticate drives a
+// server Negotiator.
+package managesieve
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+const (
+	maxLineLen = 65536
+
+	// Strings longer than this are sent as literals.
+	maxQuotedLen = 1024
+)
+
+// Errors returned by the client and server.
+var (
+	ErrAborted          = errors.New("Authentication aborted by client")
+	ErrUnknownMechanism = errors.New("Unsupported authentication mechanism")
+	ErrSyntax           = errors.New("Syntax error")
+	ErrMissingMechanism = errors.New("PLAIN and SCRAM-SHA-1 must be advertised")
+	ErrLineTooLong      = wire.ErrLineTooLong
+)
+
+// Error is returned by Authenticate when the server ends the exchange with a
+// NO or BYE response.
+type Error struct {
+	Status string
+	Code   string
+	Text   string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("managesieve: %s (%s) %s", e.Status, e.Code, e.Text)
+	}
+	return fmt.Sprintf("managesieve: %s %s", e.Status, e.Text)
+}
+
+// SASLCapability returns the SASL capability line advertising mechs, eg.
+//
+//	"SASL" "PLAIN SCRAM-SHA-1"
+//
+// RFC 5804 requires that all servers implement PLAIN and SCRAM-SHA-1, if
+// either of them is missing from mechs ErrMissingMechanism is returned.
+func SASLCapability(mechs ...string) (string, error) {
+	var plain, scram bool
+	for _, m := range mechs {
+		switch strings.ToUpper(m) {
+		case "PLAIN":
+			plain = true
+		case "SCRAM-SHA-1":
+			scram = true
+		}
+	}
+	if !plain || !scram {
+		return "", ErrMissingMechanism
+	}
+	return `"SASL" ` + quote(strings.Join(mechs, " ")), nil
+}
+
+// Mechanisms returns the SASL mechanisms from a capability response line so
+// that they can be passed to the sasl.RemoteMechanisms option.
+// If line is not a SASL capability it returns nil.
+func Mechanisms(line string) []string {
+	toks, err := tokenize(nil, []byte(line))
+	if err != nil || len(toks) != 2 || toks[0].kind != tokString || toks[1].kind != tokString {
+		return nil
+	}
+	if !strings.EqualFold(string(toks[0].val), "SASL") {
+		return nil
+	}
+	return strings.Fields(strings.ToUpper(string(toks[1].val)))
+}
+
+type tokenKind uint8
+
+const (
+	tokAtom tokenKind = iota
+	tokString
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	val  []byte
+}
+
+// tokenize splits a line into atoms, strings and parentheses.
+// If the line contains a literal the literal data and the remainder of the
+// line are read from r.
+func tokenize(r io.Reader, line []byte) ([]token, error) {
+	var toks []token
+	for {
+		line = bytes.TrimLeft(line, " ")
+		if len(line) == 0 {
+			return toks, nil
+		}
+		switch line[0] {
+		case '(':
+			toks = append(toks, token{kind: tokLParen})
+			line = line[1:]
+		case ')':
+			toks = append(toks, token{kind: tokRParen})
+			line = line[1:]
+		case '"':
+			var val []byte
+			i := 1
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+					if i == len(line) {
+						break
+					}
+				}
+				val = append(val, line[i])
+			}
+			if i >= len(line) {
+				return nil, ErrSyntax
+			}
+			toks = append(toks, token{kind: tokString, val: val})
+			line = line[i+1:]
+		case '{':
+			end := bytes.IndexByte(line, '}')
+			if end != len(line)-1 || r == nil {
+				return nil, ErrSyntax
+			}
+			n, err := strconv.ParseUint(string(bytes.TrimSuffix(line[1:end], []byte{'+'})), 10, 32)
+			if err != nil || n > maxLineLen {
+				return nil, ErrSyntax
+			}
+			val := make([]byte, n)
+			if _, err = io.ReadFull(r, val); err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind: tokString, val: val})
+			line, err = wire.ReadLine(r, maxLineLen)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			end := bytes.IndexAny(line, " ()")
+			if end == -1 {
+				end = len(line)
+			}
+			toks = append(toks, token{kind: tokAtom, val: line[:end]})
+			line = line[end:]
+		}
+	}
+}
+
+// quote returns s as a quoted string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// str returns data formatted as a string.
+// Long strings are sent as literals, clients must use the non-synchronizing
+// form.
+func str(data string, client bool) string {
+	switch {
+	case len(data) <= maxQuotedLen:
+		return quote(data)
+	case client:
+		return fmt.Sprintf("{%d+}\r\n%s", len(data), data)
+	}
+	return fmt.Sprintf("{%d}\r\n%s", len(data), data)
+}
@@ -0,0 +1,276 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package managesieve_test
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/managesieve"
+)
+
+var longPass = strings.Repeat("p", 1000)
+
+// finalData returns a mechanism where the server sends additional data with
+// its success.
+func finalData(data []byte) sasl.Mechanism {
+	return sasl.Mechanism{
+		Name: "X-FINAL",
+		Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+			return true, []byte("hello"), nil, nil
+		},
+		Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+			if n.State()&sasl.Receiving == sasl.Receiving {
+				if string(challenge) != "hello" {
+					return false, nil, nil, sasl.ErrAuthn
+				}
+				return false, data, nil, nil
+			}
+			if !bytes.Equal(challenge, data) {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, nil, nil, nil
+		},
+	}
+}
+
+// checkPass also accepts longPass, which is used to test long messages.
+func checkPass(n *sasl.Negotiator) bool {
+	_, pass, _ := n.Credentials()
+	return sasltest.CheckPass(n) || sasltest.CheckUser(n) && string(pass) == longPass
+}
+
+func TestCapability(t *testing.T) {
+	line, err := managesieve.SASLCapability("PLAIN", "SCRAM-SHA-1", "SCRAM-SHA-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"SASL" "PLAIN SCRAM-SHA-1 SCRAM-SHA-256"`; line != want {
+		t.Errorf("Wrong capability: want=%q, got=%q", want, line)
+	}
+	mechs := managesieve.Mechanisms(line)
+	if want := []string{"PLAIN", "SCRAM-SHA-1", "SCRAM-SHA-256"}; !reflect.DeepEqual(mechs, want) {
+		t.Errorf("Wrong mechanisms: want=%v, got=%v", want, mechs)
+	}
+	if _, err = managesieve.SASLCapability("PLAIN"); err != managesieve.ErrMissingMechanism {
+		t.Errorf("Unexpected error: want=%v, got=%v", managesieve.ErrMissingMechanism, err)
+	}
+	if mechs = managesieve.Mechanisms(`"SIEVE" "fileinto vacation"`); mechs != nil {
+		t.Errorf("Expected no mechanisms, got %v", mechs)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		clientErr  error
+		serverErr  error
+		errText    string
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		1: {
+			// The initial response is too long for a quoted string.
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", longPass)},
+		},
+		2: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			serverErr:  sasl.ErrAuthn,
+			errText:    "Authentication failed",
+		},
+		3: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+		},
+		4: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  managesieve.ErrAborted,
+		},
+		5: {
+			mech: finalData([]byte("goodbye")),
+		},
+		6: {
+			mech: finalData(bytes.Repeat([]byte("goodbye"), 500)),
+		},
+		7: {
+			// The signature is sent with the OK response so only the client notices
+			// that it is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = checkPass
+			}
+			errs := make(chan error, 1)
+			go func() {
+				errs <- managesieve.HandleAuthenticate(serverConn, readLine(serverConn), func(name string) *sasl.Negotiator {
+					if name != tc.mech.Name {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+				serverConn.Close()
+			}()
+
+			err := managesieve.Authenticate(clientConn, sasl.NewClient(tc.mech, tc.clientOpts...))
+			if e, ok := err.(*managesieve.Error); ok {
+				if e.Status != "NO" || e.Text != tc.errText {
+					t.Errorf("Unexpected response: want=NO %q, got=%s %q", tc.errText, e.Status, e.Text)
+				}
+			} else if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+func TestServerResponses(t *testing.T) {
+	for i, tc := range [...]struct {
+		line  string
+		resp  string
+		reply string
+		err   error
+	}{
+		0: {
+			line:  `AUTHENTICATE "PLAIN" "AHVzZXIAcGVuY2ls"`,
+			reply: "OK",
+		},
+		1: {
+			line:  `authenticate "plain" "AHVzZXIAcGVuY2ls"`,
+			reply: "OK",
+		},
+		2: {
+			line:  `AUTHENTICATE "DIGEST-MD5"`,
+			reply: `NO "Unsupported authentication mechanism"`,
+			err:   managesieve.ErrUnknownMechanism,
+		},
+		3: {
+			line:  `AUTHENTICATE PLAIN`,
+			reply: `NO "Syntax error"`,
+			err:   managesieve.ErrSyntax,
+		},
+		4: {
+			line:  `AUTHENTICATE "PLAIN" "AHVzZXIAcGVuY2ls`,
+			reply: `NO "Syntax error"`,
+			err:   managesieve.ErrSyntax,
+		},
+		5: {
+			line:  `AUTHENTICATE "PLAIN"`,
+			resp:  `"*"`,
+			reply: `NO "Authentication cancelled"`,
+			err:   managesieve.ErrAborted,
+		},
+		6: {
+			line:  `AUTHENTICATE "PLAIN"`,
+			resp:  `"` + strings.Repeat("A", 65536) + `"`,
+			reply: `NO "Line too long"`,
+			err:   managesieve.ErrLineTooLong,
+		},
+		7: {
+			line:  `AUTHENTICATE "PLAIN"`,
+			resp:  `AHVzZXIAcGVuY2ls`,
+			reply: `NO "Syntax error"`,
+			err:   managesieve.ErrSyntax,
+		},
+		8: {
+			line:  `AUTHENTICATE "PLAIN"`,
+			resp:  `"AHVzZXIAcGVuY2ls" "AHVzZXIAcGVuY2ls"`,
+			reply: `NO "Syntax error"`,
+			err:   managesieve.ErrSyntax,
+		},
+		9: {
+			// The rest of the line after a literal is too long.
+			line:  `AUTHENTICATE "PLAIN"`,
+			resp:  "{16+}\r\nAHVzZXIAcGVuY2ls" + strings.Repeat(" ", 65537),
+			reply: `NO "Line too long"`,
+			err:   managesieve.ErrLineTooLong,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			errs := make(chan error, 1)
+			go func() {
+				errs <- managesieve.HandleAuthenticate(serverConn, tc.line, func(name string) *sasl.Negotiator {
+					if name != "PLAIN" {
+						return nil
+					}
+					return sasl.NewServer(sasl.Plain, checkPass)
+				})
+				serverConn.Close()
+			}()
+			if tc.resp != "" {
+				if c := readLine(clientConn); c != `""` {
+					t.Fatalf("Unexpected challenge: %q", c)
+				}
+				go clientConn.Write([]byte(tc.resp + "\r\n"))
+			}
+			if reply := readLine(clientConn); reply != tc.reply {
+				t.Errorf("Unexpected reply: want=%q, got=%q", tc.reply, reply)
+			}
+			if err := <-errs; err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+// readLine reads a single line from conn, a real server would have read this
+// as part of its normal command processing.
+func readLine(conn net.Conn) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return ""
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	return strings.TrimSuffix(string(line), "\r")
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package managesieve
+
+import (
+	"io"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// HandleAuthenticate handles an AUTHENTICATE command on behalf of a server.
+//
+// The line argument is the first line of the command that was already read by
+// the caller, without the trailing CRLF.
+// If the command ends with a literal the literal is read from rw.
+// The mechanism named in the command is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported.
+//
+// Additional data returned by the negotiator on success is sent in the SASL
+// response code of the final OK response.
+// If the client cancels the exchange ErrAborted is returned.
+func HandleAuthenticate(rw io.ReadWriter, line string, negotiator func(mechanism string) *sasl.Negotiator) error {
+	toks, err := tokenize(rw, []byte(line))
+	switch err {
+	case nil:
+	case ErrSyntax:
+		return wire.Reply(rw, err, `NO "Syntax error"`)
+	case ErrLineTooLong:
+		return wire.Reply(rw, err, `NO "Line too long"`)
+	default:
+		return err
+	}
+	if len(toks) < 2 || len(toks) > 3 ||
+		toks[0].kind != tokAtom || !strings.EqualFold(string(toks[0].val), "AUTHENTICATE") ||
+		toks[1].kind != tokString || (len(toks) == 3 && toks[2].kind != tokString) {
+		return wire.Reply(rw, ErrSyntax, `NO "Syntax error"`)
+	}
+
+	server := negotiator(strings.ToUpper(string(toks[1].val)))
+	if server == nil {
+		return wire.Reply(rw, ErrUnknownMechanism, `NO "Unsupported authentication mechanism"`)
+	}
+
+	var resp []byte
+	if len(toks) == 3 {
+		resp, err = wire.Decode(toks[2].val)
+		if err != nil {
+			return wire.Reply(rw, err, `NO "Invalid base64 in initial response"`)
+		}
+	} else {
+		resp, err = challenge(rw, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		more, data, err := server.Step(resp)
+		if err != nil {
+			return wire.Reply(rw, err, `NO "Authentication failed"`)
+		}
+		if !more {
+			if len(data) > 0 {
+				return wire.Reply(rw, nil, "OK (SASL "+str(wire.Encode(data), false)+")")
+			}
+			return wire.Reply(rw, nil, "OK")
+		}
+		resp, err = challenge(rw, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// challenge sends a challenge string and reads the clients response.
+func challenge(rw io.ReadWriter, data []byte) ([]byte, error) {
+	if err := wire.WriteLine(rw, "%s", str(wire.Encode(data), false)); err != nil {
+		return nil, err
+	}
+	line, err := wire.ReadLine(rw, maxLineLen)
+	switch err {
+	case nil:
+	case ErrLineTooLong:
+		return nil, wire.Reply(rw, err, `NO "Line too long"`)
+	default:
+		return nil, err
+	}
+	toks, err := tokenize(rw, line)
+	switch {
+	case err == ErrSyntax || (err == nil && (len(toks) != 1 || toks[0].kind != tokString)):
+		return nil, wire.Reply(rw, ErrSyntax, `NO "Syntax error"`)
+	case err == ErrLineTooLong:
+		return nil, wire.Reply(rw, err, `NO "Line too long"`)
+	case err != nil:
+		return nil, err
+	case string(toks[0].val) == "*":
+		return nil, wire.Reply(rw, ErrAborted, `NO "Authentication cancelled"`)
+	}
+	resp, err := wire.Decode(toks[0].val)
+	if err != nil {
+		return nil, wire.Reply(rw, err, `NO "Invalid base64"`)
+	}
+	return resp, nil
+}
@@ -17,6 +17,12 @@ var (
 	ErrInvalidChallenge = errors.New("Invalid or missing challenge")
 	ErrAuthn            = errors.New("Authentication error")
 	ErrTooManySteps     = errors.New("Step called too many times")
+
+	// ErrUnexpectedSuccess is returned by clients if the server reports that
+	// authentication succeeded before the client mechanism has finished, in
+	// which case the server has not proven its identity (eg. by sending the
+	// SCRAM server signature).
+	ErrUnexpectedSuccess = errors.New("Server reported success before authentication completed")
 )
 
 var (
@@ -25,8 +31,9 @@ var (
 	Plain Mechanism = plain
 
 	// ScramSha256Plus is a Mechanism that implements the SCRAM-SHA-256-PLUS
-	// authentication mechanism defined in RFC 7677. The only supported channel
-	// binding type is tls-unique as defined in RFC 5929.
+	// authentication mechanism defined in RFC 7677. The supported channel
+	// binding types are tls-unique and tls-server-end-point as defined in RFC
+	// 5929.
 	ScramSha256Plus Mechanism = scram("SCRAM-SHA-256-PLUS", sha256.New)
 
 	// ScramSha256 is a Mechanism that implements the SCRAM-SHA-256
@@ -34,8 +41,9 @@ var (
 	ScramSha256 Mechanism = scram("SCRAM-SHA-256", sha256.New)
 
 	// ScramSha1Plus is a Mechanism that implements the SCRAM-SHA-1-PLUS
-	// authentication mechanism defined in RFC 5802. The only supported channel
-	// binding type is tls-unique as defined in RFC 5929.
+	// authentication mechanism defined in RFC 5802. The supported channel
+	// binding types are tls-unique and tls-server-end-point as defined in RFC
+	// 5929.
 	ScramSha1Plus Mechanism = scram("SCRAM-SHA-1-PLUS", sha1.New)
 
 	// ScramSha1 is a Mechanism that implements the SCRAM-SHA-1 authentication
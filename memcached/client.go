@@ -0,0 +1,91 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package memcached
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// ListMechanisms sends the SASL List Mechs command and returns the mechanisms
+// supported by the server.
+func ListMechanisms(rw io.ReadWriter) ([]string, error) {
+	if err := writePacket(rw, magicRequest, packet{opcode: OpSASLListMechs}); err != nil {
+		return nil, err
+	}
+	p, err := readResponse(rw, OpSASLListMechs, 0)
+	if err != nil {
+		return nil, err
+	}
+	if p.status != StatusOK {
+		return nil, &Error{Status: p.status, Message: string(p.value)}
+	}
+	return Mechanisms(p.value), nil
+}
+
+// Authenticate sends the SASL Auth command and runs the exchange using client
+// until the server responds with a status other than authentication continue.
+//
+// Commands are sent with opaque values counting up from 1.
+// If the server returns an error status it is returned as an *Error.
+// The protocol has no way to abort authentication, so if the negotiator
+// returns an error it is returned immediately and the caller should close the
+// connection (or start over with a new SASL Auth command).
+func Authenticate(rw io.ReadWriter, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+	key := []byte(client.Mechanism().Name)
+	var opaque uint32 = 1
+	opcode := uint8(OpSASLAuth)
+	for {
+		err = writePacket(rw, magicRequest, packet{opcode: opcode, opaque: opaque, key: key, value: resp})
+		if err != nil {
+			return err
+		}
+		p, err := readResponse(rw, opcode, opaque)
+		if err != nil {
+			return err
+		}
+		switch p.status {
+		case StatusOK:
+			if more && len(p.value) > 0 {
+				more, _, err = client.Step(p.value)
+				if err != nil {
+					return err
+				}
+			}
+			if more {
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		case StatusAuthContinue:
+		default:
+			return &Error{Status: p.status, Message: string(p.value)}
+		}
+
+		more, resp, err = client.Step(p.value)
+		if err != nil {
+			return err
+		}
+		opcode = OpSASLStep
+		opaque++
+	}
+}
+
+// readResponse reads a response packet and checks that it is the response to
+// the command with opcode and opaque.
+func readResponse(r io.Reader, opcode uint8, opaque uint32) (packet, error) {
+	p, err := readPacket(r, magicResponse)
+	if err != nil {
+		return packet{}, err
+	}
+	if p.opcode != opcode || p.opaque != opaque {
+		return packet{}, ErrUnexpectedResponse
+	}
+	return p, nil
+}
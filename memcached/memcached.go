@@ -0,0 +1,151 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package memcached implements SASL authentication for the memcached binary
+// protocol.
+//
+// ListMechanisms and Authenticate send the SASL List Mechs (0x20), SASL Auth
+// (0x21) and SASL Step (0x22) commands and drive a client Negotiator while
+// the server responds with the "authentication continue" status (0x21).
+// HandleSASL handles the same commands on behalf of a server.
+// Neither reads past the end of the exchange.
+package memcached
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Opcodes of the SASL commands.
+const (
+	OpSASLListMechs = 0x20
+	OpSASLAuth      = 0x21
+	OpSASLStep      = 0x22
+)
+
+// Response statuses used by the SASL commands.
+const (
+	StatusOK           = 0x00
+	StatusAuthError    = 0x20
+	StatusAuthContinue = 0x21
+)
+
+// Errors returned by the client and server.
+var (
+	ErrAborted            = errors.New("Authentication aborted by client")
+	ErrUnknownMechanism   = errors.New("Unknown mechanism")
+	ErrUnexpectedCommand  = errors.New("Unexpected command")
+	ErrUnexpectedResponse = errors.New("Unexpected response")
+	ErrMalformed          = errors.New("Malformed packet")
+	ErrPacketTooLong      = errors.New("Packet too long")
+	ErrTooManyRestarts    = errors.New("Too many SASL Auth restarts")
+)
+
+// Error is returned by the client when the server responds with a status
+// other than success or authentication continue.
+type Error struct {
+	Status  uint16
+	Message string
+}
+
+func (e *Error) Error() string {
+	s := "memcached: status 0x" + strconv.FormatUint(uint64(e.Status), 16)
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+// Request is a request packet.
+// Only the fields used by the SASL commands are decoded.
+type Request struct {
+	Opcode uint8
+	Opaque uint32
+	Key    []byte
+	Value  []byte
+}
+
+// ReadRequest reads a request packet from r.
+func ReadRequest(r io.Reader) (Request, error) {
+	p, err := readPacket(r, magicRequest)
+	if err != nil {
+		return Request{}, err
+	}
+	return Request{Opcode: p.opcode, Opaque: p.opaque, Key: p.key, Value: p.value}, nil
+}
+
+// Mechanisms splits the value of the SASL List Mechs response so that the
+// mechanisms can be passed to the sasl.RemoteMechanisms option.
+func Mechanisms(value []byte) []string {
+	return strings.Fields(string(value))
+}
+
+const (
+	magicRequest  = 0x80
+	magicResponse = 0x81
+
+	headerSize = 24
+
+	// Memcached itself limits items to 1MiB by default, SASL payloads are much
+	// smaller than that.
+	maxBodySize = 1 << 20
+)
+
+// packet is a decoded request or response packet, status is the vbucket ID for
+// requests.
+type packet struct {
+	opcode uint8
+	status uint16
+	opaque uint32
+	key    []byte
+	value  []byte
+}
+
+func writePacket(w io.Writer, magic uint8, p packet) error {
+	b := make([]byte, headerSize, headerSize+len(p.key)+len(p.value))
+	b[0] = magic
+	b[1] = p.opcode
+	binary.BigEndian.PutUint16(b[2:], uint16(len(p.key)))
+	binary.BigEndian.PutUint16(b[6:], p.status)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(p.key)+len(p.value)))
+	binary.BigEndian.PutUint32(b[12:], p.opaque)
+	b = append(b, p.key...)
+	b = append(b, p.value...)
+	_, err := w.Write(b)
+	return err
+}
+
+// readPacket reads a packet, any extras are ignored.
+func readPacket(r io.Reader, magic uint8) (packet, error) {
+	var h [headerSize]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return packet{}, err
+	}
+	keyLen := int(binary.BigEndian.Uint16(h[2:]))
+	extrasLen := int(h[4])
+	bodyLen := binary.BigEndian.Uint32(h[8:])
+	switch {
+	case h[0] != magic:
+		return packet{}, ErrMalformed
+	case bodyLen > maxBodySize:
+		return packet{}, ErrPacketTooLong
+	case uint32(keyLen+extrasLen) > bodyLen:
+		return packet{}, ErrMalformed
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	body = body[extrasLen:]
+	return packet{
+		opcode: h[1],
+		status: binary.BigEndian.Uint16(h[6:]),
+		opaque: binary.BigEndian.Uint32(h[12:]),
+		key:    body[:keyLen],
+		value:  body[keyLen:],
+	}, nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package memcached_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/memcached"
+)
+
+var totpServerOpts = []sasl.Option{
+	sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+	sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+	sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+}
+
+var offered = []string{"SCRAM-SHA-256", "PLAIN"}
+
+func TestListMechanisms(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	errs := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		req, err := memcached.ReadRequest(serverConn)
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- memcached.HandleSASL(serverConn, req, offered, func(string) *sasl.Negotiator { return nil })
+	}()
+	mechs, err := memcached.ListMechanisms(clientConn)
+	if err != nil {
+		t.Errorf("Unexpected client error: %v", err)
+	}
+	if !reflect.DeepEqual(mechs, offered) {
+		t.Errorf("Wrong mechanisms: want=%v, got=%v", offered, mechs)
+	}
+	if err = <-errs; err != nil {
+		t.Errorf("Unexpected server error: %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		clientErr  error
+		serverErr  error
+		status     uint16
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		1: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			serverErr:  sasl.ErrAuthn,
+			status:     memcached.StatusAuthError,
+		},
+		2: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+		},
+		3: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			serverErr:  sasl.ErrAuthn,
+			status:     memcached.StatusAuthError,
+		},
+		4: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: totpServerOpts,
+		},
+		5: {
+			// There is no way to abort, so the client just hangs up.
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  io.EOF,
+		},
+		6: {
+			mech:      sasl.ScramSha1,
+			serverErr: memcached.ErrUnknownMechanism,
+			status:    memcached.StatusAuthError,
+		},
+		7: {
+			// The signature is sent with the success response so only the client
+			// notices that it is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			errs := make(chan error, 1)
+			go func() {
+				defer serverConn.Close()
+				req, err := memcached.ReadRequest(serverConn)
+				if err != nil {
+					errs <- err
+					return
+				}
+				errs <- memcached.HandleSASL(serverConn, req, offered, func(name string) *sasl.Negotiator {
+					if name != tc.mech.Name {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+			}()
+
+			err := memcached.Authenticate(clientConn, sasl.NewClient(tc.mech, tc.clientOpts...))
+			clientConn.Close()
+			if e, ok := err.(*memcached.Error); ok {
+				if e.Status != tc.status {
+					t.Errorf("Unexpected status: want=%#x, got=%#x", tc.status, e.Status)
+				}
+			} else if err != tc.clientErr || tc.status != 0 {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+// packet is a minimal stand-in for the framing of a real client or server.
+func packet(magic, opcode byte, status uint16, opaque uint32, key, value string) string {
+	b := make([]byte, 24)
+	b[0] = magic
+	b[1] = opcode
+	binary.BigEndian.PutUint16(b[2:], uint16(len(key)))
+	binary.BigEndian.PutUint16(b[6:], status)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(key)+len(value)))
+	binary.BigEndian.PutUint32(b[12:], opaque)
+	return string(b) + key + value
+}
+
+func TestServerOutput(t *testing.T) {
+	const auth = "\x00user\x00pencil"
+	for i, tc := range [...]struct {
+		in  string
+		out string
+		err error
+	}{
+		0: {
+			in:  packet(0x80, 0x21, 0, 7, "PLAIN", auth),
+			out: packet(0x81, 0x21, 0x21, 7, "", ""),
+			err: io.EOF,
+		},
+		1: {
+			// Another command before authentication has finished.
+			in: packet(0x80, 0x21, 0, 7, "PLAIN", auth) +
+				packet(0x80, 0x00, 0, 8, "key", ""),
+			out: packet(0x81, 0x21, 0x21, 7, "", "") +
+				packet(0x81, 0x00, 0x20, 8, "", "Auth failure"),
+			err: memcached.ErrAborted,
+		},
+		2: {
+			in: packet(0x80, 0x21, 0, 7, "PLAIN", auth) +
+				packet(0x80, 0x22, 0, 8, "PLAIN", "287082"),
+			out: packet(0x81, 0x21, 0x21, 7, "", "") +
+				packet(0x81, 0x22, 0, 8, "", ""),
+		},
+		3: {
+			// Starting over with a new SASL Auth command.
+			in: packet(0x80, 0x21, 0, 7, "PLAIN", auth) +
+				packet(0x80, 0x21, 0, 8, "PLAIN", "\x00user\x00pen"),
+			out: packet(0x81, 0x21, 0x21, 7, "", "") +
+				packet(0x81, 0x21, 0x20, 8, "", "Auth failure"),
+			err: sasl.ErrAuthn,
+		},
+		4: {
+			in:  packet(0x80, 0x22, 0, 7, "PLAIN", "287082"),
+			out: packet(0x81, 0x22, 0x20, 7, "", "Auth failure"),
+			err: memcached.ErrUnexpectedCommand,
+		},
+		5: {
+			in:  packet(0x80, 0x21, 0, 7, "X-UNKNOWN", ""),
+			out: packet(0x81, 0x21, 0x20, 7, "", "Auth failure"),
+			err: memcached.ErrUnknownMechanism,
+		},
+		6: {
+			in: packet(0x80, 0x21, 0, 7, "PLAIN", auth) +
+				"\x80\x22\x00\x05\x00\x00\x00\x00\x00\x10\x00\x01\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00",
+			out: packet(0x81, 0x21, 0x21, 7, "", ""),
+			err: memcached.ErrPacketTooLong,
+		},
+		7: {
+			// A response instead of a request.
+			in: packet(0x80, 0x21, 0, 7, "PLAIN", auth) +
+				packet(0x81, 0x22, 0, 8, "PLAIN", "287082"),
+			out: packet(0x81, 0x21, 0x21, 7, "", ""),
+			err: memcached.ErrMalformed,
+		},
+		8: {
+			// Starting over too many times.
+			in: strings.Repeat(packet(0x80, 0x21, 0, 7, "PLAIN", auth), 10),
+			out: strings.Repeat(packet(0x81, 0x21, 0x21, 7, "", ""), 9) +
+				packet(0x81, 0x21, 0x20, 7, "", "Auth failure"),
+			err: memcached.ErrTooManyRestarts,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: bytes.NewReader([]byte(tc.in)),
+				Writer: &out,
+			}
+			req, err := memcached.ReadRequest(rw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = memcached.HandleSASL(rw, req, offered, func(string) *sasl.Negotiator {
+				return sasl.NewServer(sasl.WithTOTP(sasl.Plain), sasltest.CheckPass, totpServerOpts...)
+			})
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if out.String() != tc.out {
+				t.Errorf("Unexpected output:\nwant=%q\n got=%q", tc.out, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package memcached
+
+import (
+	"io"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// maxRestarts is the number of times that a client may start over with a new
+// SASL Auth command during a single exchange.
+const maxRestarts = 8
+
+// HandleSASL handles a SASL command on behalf of a server.
+//
+// The req argument is the command which was already read by the caller (see
+// ReadRequest).
+// SASL List Mechs is answered with mechanisms.
+// For SASL Auth the mechanism is passed to negotiator which should return a
+// server Negotiator (normally created with sasl.NewServer) or nil if the
+// mechanism is not supported, and the value is always used as the initial
+// response.
+//
+// Challenges are sent with the authentication continue status and the value of
+// each SASL Step command is passed to the negotiator.
+// Any additional data returned by the negotiator on success is sent in the
+// value of the final response.
+// If the client sends a new SASL Auth command the exchange starts over, and if
+// it sends any other command it is answered with an authentication error and
+// ErrAborted is returned.
+// If the client starts over too many times ErrTooManyRestarts is returned.
+func HandleSASL(rw io.ReadWriter, req Request, mechanisms []string, negotiator func(mechanism string) *sasl.Negotiator) error {
+	switch req.Opcode {
+	case OpSASLListMechs:
+		return respond(rw, req, StatusOK, []byte(strings.Join(mechanisms, " ")))
+	case OpSASLAuth:
+	default:
+		return writeErr(rw, req, ErrUnexpectedCommand)
+	}
+
+	for restarts := 0; ; restarts++ {
+		next, err := auth(rw, req, mechanisms, negotiator)
+		if err != nil || next == nil {
+			return err
+		}
+		req = *next
+		if restarts == maxRestarts {
+			return writeErr(rw, req, ErrTooManyRestarts)
+		}
+	}
+}
+
+// auth runs a single exchange.
+// If the client sends a new SASL Auth command in the middle of the exchange it
+// is returned so that the exchange can start over.
+func auth(rw io.ReadWriter, req Request, mechanisms []string, negotiator func(mechanism string) *sasl.Negotiator) (*Request, error) {
+	var server *sasl.Negotiator
+	for _, m := range mechanisms {
+		if m == string(req.Key) {
+			server = negotiator(m)
+			break
+		}
+	}
+	if server == nil {
+		return nil, writeErr(rw, req, ErrUnknownMechanism)
+	}
+
+	for {
+		more, data, err := server.Step(req.Value)
+		if err != nil {
+			return nil, writeErr(rw, req, err)
+		}
+		if !more {
+			return nil, respond(rw, req, StatusOK, data)
+		}
+		if err = respond(rw, req, StatusAuthContinue, data); err != nil {
+			return nil, err
+		}
+
+		next, err := ReadRequest(rw)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case next.Opcode == OpSASLAuth:
+			return &next, nil
+		case next.Opcode != OpSASLStep || string(next.Key) != string(req.Key):
+			return nil, writeErr(rw, next, ErrAborted)
+		}
+		req = next
+	}
+}
+
+func respond(w io.Writer, req Request, status uint16, value []byte) error {
+	return writePacket(w, magicResponse, packet{opcode: req.Opcode, status: status, opaque: req.Opaque, value: value})
+}
+
+// writeErr sends an authentication error response and returns err, or the
+// write error if there was one.
+func writeErr(w io.Writer, req Request, err error) error {
+	if werr := respond(w, req, StatusAuthError, []byte("Auth failure")); werr != nil {
+		return werr
+	}
+	return err
+}
@@ -0,0 +1,239 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package mongo
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// BSON element types supported by Document.
+const (
+	bsonDouble   = 0x01
+	bsonString   = 0x02
+	bsonDocument = 0x03
+	bsonBinary   = 0x05
+	bsonBool     = 0x08
+	bsonInt32    = 0x10
+	bsonInt64    = 0x12
+)
+
+// ErrBSON is returned when a BSON document is malformed or contains a type
+// that is not supported by Document.
+var ErrBSON = errors.New("Malformed or unsupported BSON document")
+
+// Document is a minimal ordered BSON document containing just enough types to
+// express the authentication commands.
+//
+// Values must be one of float64, string, Document, []byte (binary with the
+// generic subtype), bool, int32 or int64.
+type Document []Element
+
+// Element is a single key and value in a Document.
+type Element struct {
+	Key   string
+	Value interface{}
+}
+
+// Lookup returns the value of the first element with the given key or nil.
+func (d Document) Lookup(key string) interface{} {
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+// MarshalBSON encodes the document.
+func (d Document) MarshalBSON() ([]byte, error) {
+	return d.appendBSON(nil)
+}
+
+func (d Document) appendBSON(b []byte) ([]byte, error) {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0)
+	for _, e := range d {
+		var typ byte
+		switch e.Value.(type) {
+		case float64:
+			typ = bsonDouble
+		case string:
+			typ = bsonString
+		case Document:
+			typ = bsonDocument
+		case []byte:
+			typ = bsonBinary
+		case bool:
+			typ = bsonBool
+		case int32:
+			typ = bsonInt32
+		case int64:
+			typ = bsonInt64
+		default:
+			return nil, ErrBSON
+		}
+		b = append(b, typ)
+		b = append(b, e.Key...)
+		b = append(b, 0)
+
+		switch v := e.Value.(type) {
+		case float64:
+			b = appendUint64(b, math.Float64bits(v))
+		case string:
+			b = appendUint32(b, uint32(len(v)+1))
+			b = append(b, v...)
+			b = append(b, 0)
+		case Document:
+			var err error
+			if b, err = v.appendBSON(b); err != nil {
+				return nil, err
+			}
+		case []byte:
+			b = appendUint32(b, uint32(len(v)))
+			b = append(b, 0)
+			b = append(b, v...)
+		case bool:
+			if v {
+				b = append(b, 1)
+			} else {
+				b = append(b, 0)
+			}
+		case int32:
+			b = appendUint32(b, uint32(v))
+		case int64:
+			b = appendUint64(b, uint64(v))
+		}
+	}
+	b = append(b, 0)
+	binary.LittleEndian.PutUint32(b[start:], uint32(len(b)-start))
+	return b, nil
+}
+
+// UnmarshalBSON decodes b into the document.
+func (d *Document) UnmarshalBSON(b []byte) error {
+	doc, rest, err := readDocument(b)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return ErrBSON
+	}
+	*d = doc
+	return nil
+}
+
+// readDocument reads a document from the front of b and returns the rest.
+func readDocument(b []byte) (Document, []byte, error) {
+	if len(b) < 5 {
+		return nil, nil, ErrBSON
+	}
+	n := binary.LittleEndian.Uint32(b)
+	if n < 5 || uint64(n) > uint64(len(b)) || b[n-1] != 0 {
+		return nil, nil, ErrBSON
+	}
+	rest := b[n:]
+	b = b[4 : n-1]
+
+	doc := Document{}
+	for len(b) > 0 {
+		typ := b[0]
+		key, r, ok := cstring(b[1:])
+		if !ok {
+			return nil, nil, ErrBSON
+		}
+		b = r
+
+		var v interface{}
+		switch typ {
+		case bsonDouble:
+			if len(b) < 8 {
+				return nil, nil, ErrBSON
+			}
+			v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case bsonString:
+			if len(b) < 4 {
+				return nil, nil, ErrBSON
+			}
+			l := binary.LittleEndian.Uint32(b)
+			if l < 1 || uint64(l) > uint64(len(b)-4) || b[3+l] != 0 {
+				return nil, nil, ErrBSON
+			}
+			v = string(b[4 : 3+l])
+			b = b[4+l:]
+		case bsonDocument:
+			var err error
+			v, b, err = readDocument(b)
+			if err != nil {
+				return nil, nil, err
+			}
+		case bsonBinary:
+			if len(b) < 5 {
+				return nil, nil, ErrBSON
+			}
+			l := binary.LittleEndian.Uint32(b)
+			if uint64(l) > uint64(len(b)-5) {
+				return nil, nil, ErrBSON
+			}
+			v = append([]byte{}, b[5:5+l]...)
+			b = b[5+l:]
+		case bsonBool:
+			if len(b) < 1 {
+				return nil, nil, ErrBSON
+			}
+			v = b[0] != 0
+			b = b[1:]
+		case bsonInt32:
+			if len(b) < 4 {
+				return nil, nil, ErrBSON
+			}
+			v = int32(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case bsonInt64:
+			if len(b) < 8 {
+				return nil, nil, ErrBSON
+			}
+			v = int64(binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		default:
+			return nil, nil, ErrBSON
+		}
+		doc = append(doc, Element{Key: key, Value: v})
+	}
+	return doc, rest, nil
+}
+
+// number returns the value of a numeric element of any type.
+func number(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
+
+// cstring splits a null terminated string off the front of b.
+func cstring(b []byte) (string, []byte, bool) {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i]), b[i+1:], true
+		}
+	}
+	return "", nil, false
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package mongo
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Authenticate sends a saslStart command for the database db (normally
+// "admin") followed by saslContinue commands until the server reports that the
+// conversation is done.
+//
+// Requests are sent with request IDs counting up from 1.
+// If the server responds with ok: 0 the error is returned as an *Error.
+// The protocol has no way to cancel a conversation, so if the negotiator
+// returns an error it is returned immediately.
+func Authenticate(rw io.ReadWriter, db string, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		resp = []byte{}
+	}
+
+	requestID := int32(1)
+	err = writeMessage(rw, requestID, 0, Document{
+		{Key: "saslStart", Value: int32(1)},
+		{Key: "mechanism", Value: client.Mechanism().Name},
+		{Key: "payload", Value: resp},
+		{Key: "autoAuthorize", Value: int32(1)},
+		{Key: "options", Value: Document{{Key: "skipEmptyExchange", Value: true}}},
+		{Key: "$db", Value: db},
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		_, responseTo, reply, err := readMessage(rw)
+		if err != nil {
+			return err
+		}
+		if responseTo != requestID {
+			return ErrMalformed
+		}
+		if ok, _ := number(reply.Lookup("ok")); ok != 1 {
+			e := &Error{}
+			code, _ := number(reply.Lookup("code"))
+			e.Code = int32(code)
+			e.CodeName, _ = reply.Lookup("codeName").(string)
+			e.Message, _ = reply.Lookup("errmsg").(string)
+			return e
+		}
+		done, _ := reply.Lookup("done").(bool)
+		challenge, err := payload(reply)
+		if err != nil {
+			return err
+		}
+		conversationID, ok := number(reply.Lookup("conversationId"))
+		if !ok {
+			return ErrMalformed
+		}
+
+		switch {
+		case done && more && len(challenge) == 0:
+			return sasl.ErrUnexpectedSuccess
+		case more:
+			more, resp, err = client.Step(challenge)
+			if err != nil {
+				return err
+			}
+		case len(challenge) > 0:
+			// We are finished but the server sent more data.
+			return sasl.ErrInvalidChallenge
+		default:
+			// The server has not agreed to skip the final empty exchange.
+			resp = nil
+		}
+
+		if done {
+			if more || len(resp) > 0 {
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		}
+
+		if resp == nil {
+			resp = []byte{}
+		}
+		requestID++
+		err = writeMessage(rw, requestID, 0, Document{
+			{Key: "saslContinue", Value: int32(1)},
+			{Key: "conversationId", Value: int32(conversationID)},
+			{Key: "payload", Value: resp},
+			{Key: "$db", Value: db},
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package mongo implements SASL authentication for MongoDB using the
+// saslStart and saslContinue commands sent as OP_MSG messages.
+//
+// Authenticate drives a client Negotiator and HandleSaslStart drives a server
+// Negotiator.
+// Both operate on the raw connection and never read past the end of the
+// exchange.
+//
+// MongoDB's SCRAM-SHA-1 salts and hashes a digest of the password instead of
+// the password itself, so clients must use the ScramSha1Password option and
+// servers must derive the stored credentials from PasswordDigest.
+// SCRAM-SHA-256 uses the password as is.
+package mongo
+
+import (
+	"crypto/md5" /* #nosec */
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+const opMsg = 2013
+
+// Flag bits of OP_MSG.
+const (
+	flagChecksumPresent = 1 << 0
+)
+
+// Error codes sent by the server.
+const (
+	codeProtocolError        = 17
+	codeAuthenticationFailed = 18
+	codeMechanismUnavailable = 334
+)
+
+// The server allows much larger messages, but authentication commands are
+// tiny.
+const maxMessageLen = 65536
+
+// Errors returned by the client and server.
+var (
+	ErrUnknownMechanism  = errors.New("Mechanism unavailable")
+	ErrUnexpectedCommand = errors.New("Unexpected command during SASL authentication")
+	ErrMalformed         = errors.New("Malformed message")
+	ErrMessageTooLong    = errors.New("Message too long")
+	ErrUnsupportedOpcode = errors.New("Unsupported opcode")
+	ErrConversationID    = errors.New("Mismatched conversation ID")
+	errMissingPayload    = errors.New("Missing payload")
+)
+
+// Error is returned by Authenticate when the server responds with ok: 0.
+type Error struct {
+	Code     int32
+	CodeName string
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return "mongo: " + e.Message + " (" + e.CodeName + " " + strconv.Itoa(int(e.Code)) + ")"
+}
+
+// PasswordDigest returns the hex encoded MD5 of "username:mongo:password",
+// which is used in place of the password with SCRAM-SHA-1.
+func PasswordDigest(username, password []byte) []byte {
+	/* #nosec */
+	h := md5.New()
+	h.Write(username)
+	h.Write([]byte(":mongo:"))
+	h.Write(password)
+	return []byte(hex.EncodeToString(h.Sum(nil)))
+}
+
+// ScramSha1Password is an option for SCRAM-SHA-1 client Negotiators that
+// replaces the password with its PasswordDigest.
+var ScramSha1Password = sasl.ScramPassword(PasswordDigest)
+
+// Command is a command sent in the body section of an OP_MSG.
+type Command struct {
+	RequestID int32
+	Body      Document
+}
+
+// Name returns the name of the command, which is always the first key.
+func (c Command) Name() string {
+	if len(c.Body) == 0 {
+		return ""
+	}
+	return c.Body[0].Key
+}
+
+// ReadCommand reads an OP_MSG message from r and returns its body.
+// Document sequence sections are skipped.
+func ReadCommand(r io.Reader) (Command, error) {
+	requestID, _, body, err := readMessage(r)
+	return Command{RequestID: requestID, Body: body}, err
+}
+
+func readMessage(r io.Reader) (requestID, responseTo int32, body Document, err error) {
+	var hdr [16]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[:])
+	if n < 16 {
+		return 0, 0, nil, ErrMalformed
+	}
+	if n-16 > maxMessageLen {
+		return 0, 0, nil, ErrMessageTooLong
+	}
+	msg := make([]byte, n-16)
+	if _, err = io.ReadFull(r, msg); err != nil {
+		return 0, 0, nil, err
+	}
+	if binary.LittleEndian.Uint32(hdr[12:]) != opMsg {
+		return 0, 0, nil, ErrUnsupportedOpcode
+	}
+	if len(msg) < 4 {
+		return 0, 0, nil, ErrMalformed
+	}
+	flags := binary.LittleEndian.Uint32(msg)
+	msg = msg[4:]
+	if flags&flagChecksumPresent != 0 {
+		if len(msg) < 4 {
+			return 0, 0, nil, ErrMalformed
+		}
+		msg = msg[:len(msg)-4]
+	}
+
+	for len(msg) > 0 {
+		kind := msg[0]
+		msg = msg[1:]
+		switch kind {
+		case 0:
+			body, msg, err = readDocument(msg)
+			if err != nil {
+				return 0, 0, nil, err
+			}
+		case 1:
+			if len(msg) < 4 {
+				return 0, 0, nil, ErrMalformed
+			}
+			l := binary.LittleEndian.Uint32(msg)
+			if l < 4 || uint64(l) > uint64(len(msg)) {
+				return 0, 0, nil, ErrMalformed
+			}
+			msg = msg[l:]
+		default:
+			return 0, 0, nil, ErrMalformed
+		}
+	}
+	if body == nil {
+		return 0, 0, nil, ErrMalformed
+	}
+	requestID = int32(binary.LittleEndian.Uint32(hdr[4:]))
+	responseTo = int32(binary.LittleEndian.Uint32(hdr[8:]))
+	return requestID, responseTo, body, nil
+}
+
+func writeMessage(w io.Writer, requestID, responseTo int32, doc Document) error {
+	msg := make([]byte, 16, 64)
+	binary.LittleEndian.PutUint32(msg[4:], uint32(requestID))
+	binary.LittleEndian.PutUint32(msg[8:], uint32(responseTo))
+	binary.LittleEndian.PutUint32(msg[12:], opMsg)
+	msg = appendUint32(msg, 0)
+	msg = append(msg, 0)
+	msg, err := doc.appendBSON(msg)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+	_, err = w.Write(msg)
+	return err
+}
+
+// payload returns the binary payload of a command or reply.
+func payload(doc Document) ([]byte, error) {
+	switch p := doc.Lookup("payload").(type) {
+	case []byte:
+		return p, nil
+	case string:
+		// Some old drivers send the payload as a string.
+		return []byte(p), nil
+	}
+	return nil, ErrMalformed
+}
@@ -0,0 +1,361 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package mongo_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/mongo"
+)
+
+var (
+	scramSha1Secrets = sasl.ScramSecrets(func(username []byte) (sasl.ScramCredentials, bool) {
+		pass := mongo.PasswordDigest(username, []byte("pencil"))
+		return sasl.NewScramCredentials(sha1.New, pass, []byte("salt"), 10000), string(username) == "user"
+	})
+	scramSha256Secrets = sasl.ScramSecrets(func(username []byte) (sasl.ScramCredentials, bool) {
+		return sasl.NewScramCredentials(sha256.New, []byte("pencil"), []byte("salt"), 15000), string(username) == "user"
+	})
+)
+
+// send writes doc as the body of an OP_MSG.
+func send(t *testing.T, w io.Writer, requestID int32, doc mongo.Document) {
+	b, err := doc.MarshalBSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hdr [21]byte
+	binary.LittleEndian.PutUint32(hdr[:], uint32(len(hdr)+len(b)))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(requestID))
+	binary.LittleEndian.PutUint32(hdr[12:], 2013)
+	if _, err = w.Write(append(hdr[:], b...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDocument(t *testing.T) {
+	doc := mongo.Document{{Key: "a", Value: int32(1)}}
+	b, err := doc.MarshalBSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("\x0c\x00\x00\x00\x10a\x00\x01\x00\x00\x00\x00"); !bytes.Equal(b, want) {
+		t.Errorf("Wrong encoding: want=%x, got=%x", want, b)
+	}
+
+	doc = mongo.Document{
+		{Key: "saslStart", Value: float64(1)},
+		{Key: "mechanism", Value: "PLAIN"},
+		{Key: "payload", Value: []byte("\x00user\x00pencil")},
+		{Key: "options", Value: mongo.Document{{Key: "skipEmptyExchange", Value: true}}},
+		{Key: "conversationId", Value: int64(-1)},
+	}
+	b, err = doc.MarshalBSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out mongo.Document
+	if err = out.UnmarshalBSON(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc, out) {
+		t.Errorf("Round trip failed: want=%v, got=%v", doc, out)
+	}
+	if err = out.UnmarshalBSON(b[:len(b)-1]); err != mongo.ErrBSON {
+		t.Errorf("Unexpected error for truncated document: %v", err)
+	}
+	if _, err = (mongo.Document{{Key: "a", Value: 1}}).MarshalBSON(); err != mongo.ErrBSON {
+		t.Errorf("Unexpected error for unsupported type: %v", err)
+	}
+}
+
+func TestPasswordDigest(t *testing.T) {
+	const want = "1c33006ec1ffd90f9cadcbcc0e118200"
+	if d := mongo.PasswordDigest([]byte("user"), []byte("pencil")); string(d) != want {
+		t.Errorf("Wrong digest: want=%s, got=%s", want, d)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		serverMech string
+		clientErr  error
+		serverErr  error
+		errCode    int32
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		1: {
+			mech:       sasl.ScramSha1,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil"), mongo.ScramSha1Password},
+			serverOpts: []sasl.Option{scramSha1Secrets},
+			perm:       sasltest.CheckUser,
+		},
+		2: {
+			// The password must be transformed for SCRAM-SHA-1.
+			mech:       sasl.ScramSha1,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{scramSha1Secrets},
+			perm:       sasltest.CheckUser,
+			serverErr:  sasl.ErrAuthn,
+			errCode:    18,
+		},
+		3: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{scramSha256Secrets},
+			perm:       sasltest.CheckUser,
+		},
+		4: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+		},
+		5: {
+			mech:       sasl.Plain,
+			serverMech: "SCRAM-SHA-1",
+			serverErr:  mongo.ErrUnknownMechanism,
+			errCode:    334,
+		},
+		6: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  io.EOF,
+		},
+		7: {
+			// The signature is sent with done set so only the client notices that it
+			// is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{scramSha256Secrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			serverMech := tc.serverMech
+			if serverMech == "" {
+				serverMech = tc.mech.Name
+			}
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			errs := make(chan error, 1)
+			go func() {
+				defer serverConn.Close()
+				cmd, err := mongo.ReadCommand(serverConn)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if db := cmd.Body.Lookup("$db"); db != "admin" {
+					t.Errorf("Wrong database: %v", db)
+				}
+				errs <- mongo.HandleSaslStart(serverConn, cmd, func(name string) *sasl.Negotiator {
+					if name != serverMech {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+			}()
+
+			err := mongo.Authenticate(clientConn, "admin", sasl.NewClient(tc.mech, tc.clientOpts...))
+			clientConn.Close()
+			if e, ok := err.(*mongo.Error); ok {
+				if e.Code != tc.errCode {
+					t.Errorf("Unexpected error code: want=%d, got=%d", tc.errCode, e.Code)
+				}
+			} else if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+func TestEmptyExchange(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	errs := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		cmd, err := mongo.ReadCommand(serverConn)
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- mongo.HandleSaslStart(serverConn, cmd, func(string) *sasl.Negotiator {
+			return sasl.NewServer(sasl.ScramSha256, sasltest.CheckUser, scramSha256Secrets)
+		})
+	}()
+
+	// An old client that does not ask to skip the empty exchange.
+	client := sasl.NewClient(sasl.ScramSha256, sasltest.Creds("user", "pencil"))
+	_, resp, err := client.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(t, clientConn, 1, mongo.Document{
+		{Key: "saslStart", Value: int32(1)},
+		{Key: "mechanism", Value: "SCRAM-SHA-256"},
+		{Key: "payload", Value: resp},
+		{Key: "$db", Value: "admin"},
+	})
+	for i, wantDone := range []bool{false, false, true} {
+		reply, err := mongo.ReadCommand(clientConn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done := reply.Body.Lookup("done"); done != wantDone {
+			t.Fatalf("%d: Wrong value for done: want=%v, got=%v", i, wantDone, done)
+		}
+		if wantDone {
+			break
+		}
+		more, resp, err := client.Step(reply.Body.Lookup("payload").([]byte))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !more && resp == nil {
+			resp = []byte{}
+		}
+		send(t, clientConn, int32(i+2), mongo.Document{
+			{Key: "saslContinue", Value: int32(1)},
+			{Key: "conversationId", Value: reply.Body.Lookup("conversationId")},
+			{Key: "payload", Value: resp},
+			{Key: "$db", Value: "admin"},
+		})
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("Unexpected server error: %v", err)
+	}
+}
+
+func TestServerFraming(t *testing.T) {
+	start := mongo.Document{
+		{Key: "saslStart", Value: int32(1)},
+		{Key: "mechanism", Value: "PLAIN"},
+		{Key: "payload", Value: []byte("\x00user\x00pencil")},
+		{Key: "$db", Value: "admin"},
+	}
+	for i, tc := range [...]struct {
+		next mongo.Document
+		raw  string
+		code int32
+		err  error
+	}{
+		0: {
+			next: mongo.Document{
+				{Key: "saslContinue", Value: int32(1)},
+				{Key: "conversationId", Value: int32(2)},
+				{Key: "payload", Value: []byte("287082")},
+			},
+			code: 17,
+			err:  mongo.ErrConversationID,
+		},
+		1: {
+			next: mongo.Document{{Key: "ping", Value: int32(1)}},
+			code: 17,
+			err:  mongo.ErrUnexpectedCommand,
+		},
+		2: {
+			next: mongo.Document{
+				{Key: "saslContinue", Value: int32(1)},
+				{Key: "conversationId", Value: int32(1)},
+			},
+			code: 17,
+			err:  mongo.ErrMalformed,
+		},
+		3: {
+			raw: "\x00\x00\x10\x00\x02\x00\x00\x00\x00\x00\x00\x00\xdd\x07\x00\x00",
+			err: mongo.ErrMessageTooLong,
+		},
+		4: {
+			raw: "\x15\x00\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00\xd4\x07\x00\x00\x00\x00\x00\x00\x00",
+			err: mongo.ErrUnsupportedOpcode,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var in, out bytes.Buffer
+			send(t, &in, 1, start)
+			if tc.next != nil {
+				send(t, &in, 2, tc.next)
+			}
+			in.WriteString(tc.raw)
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: &in,
+				Writer: &out,
+			}
+			cmd, err := mongo.ReadCommand(rw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = mongo.HandleSaslStart(rw, cmd, func(string) *sasl.Negotiator {
+				return sasl.NewServer(sasl.WithTOTP(sasl.Plain), sasltest.CheckPass,
+					sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+					sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+					sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+				)
+			})
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			// The reply to saslStart asks for the TOTP code.
+			if _, err = mongo.ReadCommand(&out); err != nil {
+				t.Fatal(err)
+			}
+			reply, err := mongo.ReadCommand(&out)
+			switch {
+			case tc.code == 0 && err != io.EOF:
+				t.Errorf("Unexpected reply: %v (%v)", reply.Body, err)
+			case tc.code == 0:
+			case err != nil:
+				t.Fatal(err)
+			default:
+				if code := reply.Body.Lookup("code"); code != tc.code {
+					t.Errorf("Wrong error code: want=%d, got=%v", tc.code, code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package mongo
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// The conversation ID sent to clients, there is only ever one conversation
+// on a connection at a time.
+const conversationID = 1
+
+// HandleSaslStart handles a saslStart command on behalf of a server and then
+// reads and replies to saslContinue commands until the conversation is done.
+//
+// The cmd argument is the saslStart command that was already read by the
+// caller (see ReadCommand).
+// The mechanism named in the command is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported.
+//
+// Additional data returned by the negotiator on success is sent with done set
+// to true if the client asked to skip the empty exchange, otherwise the client
+// must send an empty saslContinue before the conversation is done.
+// Errors are sent to the client as a reply with ok: 0 and then returned.
+func HandleSaslStart(rw io.ReadWriter, cmd Command, negotiator func(mechanism string) *sasl.Negotiator) error {
+	if cmd.Name() != "saslStart" {
+		return writeErr(rw, cmd, codeProtocolError, "ProtocolError", "Expected saslStart", ErrUnexpectedCommand)
+	}
+	name, _ := cmd.Body.Lookup("mechanism").(string)
+	var server *sasl.Negotiator
+	if name != "" {
+		server = negotiator(name)
+	}
+	if server == nil {
+		return writeErr(rw, cmd, codeMechanismUnavailable, "MechanismUnavailable", "Received authentication for mechanism "+name+" which is not enabled", ErrUnknownMechanism)
+	}
+	skipEmpty := false
+	if opts, ok := cmd.Body.Lookup("options").(Document); ok {
+		skipEmpty, _ = opts.Lookup("skipEmptyExchange").(bool)
+	}
+
+	finished := false
+	for {
+		resp, err := payload(cmd.Body)
+		if err != nil {
+			return writeErr(rw, cmd, codeProtocolError, "ProtocolError", "Missing payload", err)
+		}
+
+		var more bool
+		var data []byte
+		if finished {
+			if len(resp) != 0 {
+				return writeErr(rw, cmd, codeProtocolError, "ProtocolError", "Unexpected payload", sasl.ErrInvalidChallenge)
+			}
+		} else {
+			more, data, err = server.Step(resp)
+			if err != nil {
+				return writeErr(rw, cmd, codeAuthenticationFailed, "AuthenticationFailed", "Authentication failed.", err)
+			}
+			finished = !more
+		}
+		if data == nil {
+			data = []byte{}
+		}
+
+		done := finished && (skipEmpty || len(data) == 0)
+		err = writeMessage(rw, 0, cmd.RequestID, Document{
+			{Key: "conversationId", Value: int32(conversationID)},
+			{Key: "done", Value: done},
+			{Key: "payload", Value: data},
+			{Key: "ok", Value: float64(1)},
+		})
+		if err != nil || done {
+			return err
+		}
+
+		cmd, err = ReadCommand(rw)
+		if err != nil {
+			return err
+		}
+		if cmd.Name() != "saslContinue" {
+			return writeErr(rw, cmd, codeProtocolError, "ProtocolError", "Expected saslContinue", ErrUnexpectedCommand)
+		}
+		if id, _ := number(cmd.Body.Lookup("conversationId")); id != conversationID {
+			return writeErr(rw, cmd, codeProtocolError, "ProtocolError", "sasl: Mismatched conversation id", ErrConversationID)
+		}
+	}
+}
+
+// writeErr sends an error reply to cmd and returns err, or the write error if
+// there was one.
+func writeErr(w io.Writer, cmd Command, code int32, codeName, msg string, err error) error {
+	werr := writeMessage(w, 0, cmd.RequestID, Document{
+		{Key: "ok", Value: float64(0)},
+		{Key: "errmsg", Value: msg},
+		{Key: "code", Value: code},
+		{Key: "codeName", Value: codeName},
+	})
+	if werr != nil {
+		return werr
+	}
+	return err
+}
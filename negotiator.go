@@ -7,7 +7,9 @@ package sasl
 import (
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"strings"
+	"time"
 )
 
 // State represents the current state of a Negotiator.
@@ -86,13 +88,26 @@ func NewServer(m Mechanism, permissions func(*Negotiator) bool, opts ...Option)
 // goroutines, and must be reset between negotiation attempts.
 type Negotiator struct {
 	tlsState         *tls.ConnectionState
+	serverCert       *x509.Certificate
+	localMechanisms  []string
 	remoteMechanisms []string
 	credentials      func() (Username, Password, Identity []byte)
 	permissions      func(*Negotiator) bool
+	authnUser        []byte
+	authzIdentity    []byte
 	mechanism        Mechanism
 	state            State
 	nonce            []byte
 	cache            interface{}
+	totpCode         func() []byte
+	totpSecrets      func(username []byte) []byte
+	totpCounter      func(username []byte, counter uint64) bool
+	clock            func() time.Time
+	scramSecrets     func(username []byte, ext map[string]string) (ScramCredentials, bool)
+	scramExtensions  map[string]string
+	scramPassword    func(username, password []byte) []byte
+	scramCache       ScramKeyCache
+	scramFakeSecret  []byte
 }
 
 // Nonce returns a unique nonce that is reset for each negotiation attempt. It
@@ -135,6 +150,11 @@ func (c *Negotiator) Step(challenge []byte) (more bool, resp []byte, err error)
 	return more, resp, err
 }
 
+// Mechanism returns the mechanism that the negotiator was created with.
+func (c *Negotiator) Mechanism() Mechanism {
+	return c.mechanism
+}
+
 // State returns the internal state of the SASL state machine.
 func (c *Negotiator) State() State {
 	return c.state
@@ -152,6 +172,8 @@ func (c *Negotiator) Reset() {
 
 	c.nonce = nonce(noncerandlen, rand.Reader)
 	c.cache = nil
+	c.authnUser = nil
+	c.authzIdentity = nil
 }
 
 // Credentials returns a username, and password for authentication and optional
@@ -168,11 +190,22 @@ func (c *Negotiator) Permissions(opts ...Option) bool {
 	if c.permissions != nil {
 		nn := *c
 		getOpts(&nn, opts...)
-		return c.permissions(&nn)
+		if !c.permissions(&nn) {
+			return false
+		}
+		c.authnUser, _, c.authzIdentity = nn.Credentials()
+		return true
 	}
 	return false
 }
 
+// Authenticated returns the username and authorization identity that were last
+// accepted by the permissions function of a server, or nil if none have been
+// accepted since the negotiator was created or reset.
+func (c *Negotiator) Authenticated() (username, identity []byte) {
+	return c.authnUser, c.authzIdentity
+}
+
 // TLSState is the state of any TLS connections being used to negotiate SASL
 // (it can be used for channel binding).
 func (c *Negotiator) TLSState() *tls.ConnectionState {
@@ -182,6 +215,22 @@ func (c *Negotiator) TLSState() *tls.ConnectionState {
 	return nil
 }
 
+// now returns the current time according to the clock set with the Clock
+// option.
+func (c *Negotiator) now() time.Time {
+	if c.clock != nil {
+		return c.clock()
+	}
+	return time.Now()
+}
+
+// ScramExtensions returns the extension attributes that a SCRAM client sends.
+// In the permissions function of a SCRAM server it returns the extensions that
+// the client sent.
+func (c *Negotiator) ScramExtensions() map[string]string {
+	return c.scramExtensions
+}
+
 // RemoteMechanisms is a list of mechanisms as advertised by the other side of a
 // SASL negotiation.
 func (c *Negotiator) RemoteMechanisms() []string {
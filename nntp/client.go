@@ -0,0 +1,122 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package nntp
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// Authenticate sends an AUTHINFO SASL command and runs the exchange using
+// client until the server sends a final response.
+//
+// The initial response is sent with the command unless doing so would make
+// the command line longer than 512 octets, in which case it is sent after the
+// servers first 383 response.
+// Success data sent with a 283 response is passed to the negotiator, which must
+// accept it and finish.
+// If the negotiator returns an error the exchange is cancelled by sending "*"
+// and the negotiators error is returned once the server has responded.
+func Authenticate(rw io.ReadWriter, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+
+	name := client.Mechanism().Name
+	ir := encode(resp)
+	irSent := len("AUTHINFO SASL ")+len(name)+1+len(ir)+2 <= maxCommandLen
+	if irSent {
+		err = wire.WriteLine(rw, "AUTHINFO SASL %s %s", name, ir)
+	} else {
+		err = wire.WriteLine(rw, "AUTHINFO SASL %s", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	var abortErr error
+	for {
+		line, err := wire.ReadLine(rw, maxLineLen)
+		if err != nil {
+			return err
+		}
+		if len(line) < 3 {
+			return ErrSyntax
+		}
+		code, err := strconv.Atoi(string(line[:3]))
+		if err != nil {
+			return ErrSyntax
+		}
+		arg := bytes.TrimLeft(line[3:], " ")
+
+		if abortErr != nil {
+			if code == codeContinue {
+				if err = wire.WriteLine(rw, "*"); err != nil {
+					return err
+				}
+				continue
+			}
+			return abortErr
+		}
+
+		switch code {
+		case codeContinue:
+			if !irSent {
+				irSent = true
+				if err = wire.WriteLine(rw, "%s", ir); err != nil {
+					return err
+				}
+				continue
+			}
+			challenge, err := decode(arg)
+			if err == nil {
+				more, resp, err = client.Step(challenge)
+			}
+			if err != nil {
+				abortErr = err
+				if err = wire.WriteLine(rw, "*"); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = wire.WriteLine(rw, "%s", encode(resp)); err != nil {
+				return err
+			}
+		case codeAcceptedData:
+			if !more {
+				return sasl.ErrInvalidChallenge
+			}
+			data, err := decode(arg)
+			if err != nil {
+				return err
+			}
+			more, _, err = client.Step(data)
+			if err != nil {
+				return err
+			}
+			fallthrough
+		case codeAccepted:
+			if more {
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		case codeRejected:
+			return ErrRejected
+		case codeOutOfSequence:
+			return ErrOutOfSequence
+		case codeUnavailable:
+			return ErrUnavailable
+		case codeUnknownMech:
+			return ErrUnknownMechanism
+		default:
+			return &Error{Code: code, Text: string(arg)}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package nntp implements the NNTP AUTHINFO SASL command as defined in RFC
+// 4643.
+//
+// Authenticate drives a client Negotiator and HandleAuthinfo drives a server
+// Negotiator.
+// Both operate on the raw connection after the caller has handled any other
+// NNTP commands and never read past the end of the exchange.
+package nntp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+const (
+	// RFC 3977 limits command lines, including the initial response, to 512
+	// octets including the CRLF.
+	maxCommandLen = 512
+
+	// Responses to challenges are exempt from the command line limit, but we
+	// have to stop somewhere.
+	maxLineLen = 65536
+)
+
+// Response codes used during AUTHINFO SASL.
+const (
+	codeAccepted      = 281
+	codeAcceptedData  = 283
+	codeContinue      = 383
+	codeRejected      = 481
+	codeOutOfSequence = 482
+	codeUnavailable   = 502
+	codeSyntax        = 501
+	codeUnknownMech   = 503
+	codeBase64        = 504
+)
+
+// Errors returned by the client and server.
+// The client returns ErrRejected, ErrOutOfSequence, ErrUnavailable and
+// ErrUnknownMechanism when it receives the corresponding response code.
+var (
+	ErrRejected         = errors.New("Authentication failed or rejected")
+	ErrOutOfSequence    = errors.New("Authentication commands issued out of sequence")
+	ErrUnavailable      = errors.New("Command unavailable")
+	ErrUnknownMechanism = errors.New("Mechanism not recognized")
+	ErrAborted          = errors.New("Authentication aborted by client")
+	ErrSyntax           = errors.New("Syntax error in AUTHINFO SASL command")
+	ErrLineTooLong      = wire.ErrLineTooLong
+)
+
+// Error is returned by Authenticate when the server responds with a code that
+// does not have a more specific error.
+type Error struct {
+	Code int
+	Text string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("nntp: %d %s", e.Code, e.Text)
+}
+
+// encode base64 encodes data, an empty payload is encoded as "=".
+func encode(data []byte) string {
+	if len(data) == 0 {
+		return "="
+	}
+	return wire.Encode(data)
+}
+
+func decode(data []byte) ([]byte, error) {
+	if string(data) == "=" {
+		return []byte{}, nil
+	}
+	return wire.Decode(data)
+}
@@ -0,0 +1,261 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package nntp_test
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/nntp"
+)
+
+var longPass = strings.Repeat("p", 400)
+
+// finalData is a mechanism where the server sends additional data with its
+// success.
+var finalData = sasl.Mechanism{
+	Name: "X-FINAL",
+	Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+		return true, nil, nil, nil
+	},
+	Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		if n.State()&sasl.Receiving == sasl.Receiving {
+			if len(challenge) != 0 {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, []byte("goodbye"), nil, nil
+		}
+		if string(challenge) != "goodbye" {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, nil, nil, nil
+	},
+}
+
+// checkPass also accepts longPass, which is used to test long messages.
+func checkPass(n *sasl.Negotiator) bool {
+	_, pass, _ := n.Credentials()
+	return sasltest.CheckPass(n) || sasltest.CheckUser(n) && string(pass) == longPass
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		serverMech string
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		command    string
+		clientErr  error
+		serverErr  error
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			command:    "AUTHINFO SASL PLAIN AHVzZXIAcGVuY2ls",
+		},
+		1: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", longPass)},
+			command:    "AUTHINFO SASL PLAIN",
+		},
+		2: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			command:    "AUTHINFO SASL PLAIN AHVzZXIAcGVu",
+			clientErr:  nntp.ErrRejected,
+			serverErr:  sasl.ErrAuthn,
+		},
+		3: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+			command: "AUTHINFO SASL PLAIN AHVzZXIAcGVuY2ls",
+		},
+		4: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			command:    "AUTHINFO SASL PLAIN AHVzZXIAcGVuY2ls",
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  nntp.ErrAborted,
+		},
+		5: {
+			mech:    finalData,
+			command: "AUTHINFO SASL X-FINAL =",
+		},
+		6: {
+			mech:       sasl.Plain,
+			serverMech: "SCRAM-SHA-1",
+			command:    "AUTHINFO SASL PLAIN AAA=",
+			clientErr:  nntp.ErrUnknownMechanism,
+			serverErr:  nntp.ErrUnknownMechanism,
+		},
+		7: {
+			// The signature is sent with the 283 response so only the client notices
+			// that it is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			errs := make(chan error, 1)
+			commands := make(chan string, 1)
+			serverMech := tc.serverMech
+			if serverMech == "" {
+				serverMech = tc.mech.Name
+			}
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = checkPass
+			}
+			go func() {
+				line := readLine(serverConn)
+				commands <- line
+				errs <- nntp.HandleAuthinfo(serverConn, line, func(name string) *sasl.Negotiator {
+					if name != serverMech {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+				serverConn.Close()
+			}()
+
+			err := nntp.Authenticate(clientConn, sasl.NewClient(tc.mech, tc.clientOpts...))
+			if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+			if cmd := <-commands; tc.command != "" && cmd != tc.command {
+				t.Errorf("Unexpected command: want=%q, got=%q", tc.command, cmd)
+			}
+		})
+	}
+}
+
+func TestClientLineTooLong(t *testing.T) {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(strings.Repeat("A", 70000) + "\r\n"), ioutil.Discard}
+	err := nntp.Authenticate(rw, sasl.NewClient(sasl.Plain, sasltest.Creds("user", "pencil")))
+	if err != nntp.ErrLineTooLong {
+		t.Errorf("Unexpected error: want=%v, got=%v", nntp.ErrLineTooLong, err)
+	}
+}
+
+func TestServerResponses(t *testing.T) {
+	for i, tc := range [...]struct {
+		line  string
+		resp  string
+		reply string
+		err   error
+	}{
+		0: {
+			line:  "AUTHINFO SASL PLAIN " + strings.Repeat("A", 512),
+			reply: "501 Command line too long",
+			err:   nntp.ErrLineTooLong,
+		},
+		1: {
+			line:  "AUTHINFO USER romeo",
+			reply: "501 Syntax error",
+			err:   nntp.ErrSyntax,
+		},
+		2: {
+			line:  "AUTHINFO SASL DIGEST-MD5",
+			reply: "503 Mechanism not recognized",
+			err:   nntp.ErrUnknownMechanism,
+		},
+		3: {
+			line:  "AUTHINFO SASL PLAIN",
+			resp:  "*",
+			reply: "481 Authentication aborted",
+			err:   nntp.ErrAborted,
+		},
+		4: {
+			line:  "AUTHINFO SASL PLAIN",
+			resp:  strings.Repeat("A", 65540),
+			reply: "501 Line too long",
+			err:   nntp.ErrLineTooLong,
+		},
+		5: {
+			line:  "AUTHINFO SASL PLAIN",
+			resp:  "AHVzZXIAcGVuY2ls=",
+			reply: "504 Base64 encoding error",
+			err:   base64.CorruptInputError(16),
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			errs := make(chan error, 1)
+			go func() {
+				errs <- nntp.HandleAuthinfo(serverConn, tc.line, func(name string) *sasl.Negotiator {
+					if name != "PLAIN" {
+						return nil
+					}
+					return sasl.NewServer(sasl.Plain, checkPass)
+				})
+				serverConn.Close()
+			}()
+			if tc.resp != "" {
+				if c := readLine(clientConn); c != "383 =" {
+					t.Fatalf("Unexpected challenge: %q", c)
+				}
+				go clientConn.Write([]byte(tc.resp + "\r\n"))
+			}
+			if reply := readLine(clientConn); reply != tc.reply {
+				t.Errorf("Unexpected reply: want=%q, got=%q", tc.reply, reply)
+			}
+			if err := <-errs; err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+// readLine reads a single line from conn, a real server would have read this
+// as part of its normal command processing.
+func readLine(conn net.Conn) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return ""
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	return strings.TrimSuffix(string(line), "\r")
+}
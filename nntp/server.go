@@ -0,0 +1,102 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package nntp
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// HandleAuthinfo handles an AUTHINFO SASL command on behalf of a server.
+//
+// The line argument is the command line that was already read by the caller,
+// without the trailing CRLF.
+// The mechanism named in the command is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported.
+//
+// Additional data returned by the negotiator on success is sent with a 283
+// response.
+// If the client cancels the exchange ErrAborted is returned.
+func HandleAuthinfo(rw io.ReadWriter, line string, negotiator func(mechanism string) *sasl.Negotiator) error {
+	if len(line)+2 > maxCommandLen {
+		return respond(rw, ErrLineTooLong, codeSyntax, "Command line too long")
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 || len(fields) > 4 ||
+		!strings.EqualFold(fields[0], "AUTHINFO") || !strings.EqualFold(fields[1], "SASL") {
+		return respond(rw, ErrSyntax, codeSyntax, "Syntax error")
+	}
+
+	server := negotiator(strings.ToUpper(fields[2]))
+	if server == nil {
+		return respond(rw, ErrUnknownMechanism, codeUnknownMech, "Mechanism not recognized")
+	}
+
+	var resp []byte
+	var err error
+	if len(fields) == 4 {
+		resp, err = decode([]byte(fields[3]))
+		if err != nil {
+			return respond(rw, err, codeBase64, "Base64 encoding error")
+		}
+	} else {
+		resp, err = challenge(rw, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		more, data, err := server.Step(resp)
+		if err != nil {
+			return respond(rw, err, codeRejected, "Authentication failed")
+		}
+		if !more {
+			if len(data) > 0 {
+				return respond(rw, nil, codeAcceptedData, encode(data))
+			}
+			return respond(rw, nil, codeAccepted, "Authentication accepted")
+		}
+		resp, err = challenge(rw, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// challenge sends a 383 response and reads the clients response.
+func challenge(rw io.ReadWriter, data []byte) ([]byte, error) {
+	if err := wire.WriteLine(rw, "%d %s", codeContinue, encode(data)); err != nil {
+		return nil, err
+	}
+	line, err := wire.ReadLine(rw, maxLineLen)
+	switch err {
+	case nil:
+	case wire.ErrLineTooLong:
+		return nil, respond(rw, ErrLineTooLong, codeSyntax, "Line too long")
+	default:
+		return nil, err
+	}
+	if bytes.Equal(line, []byte("*")) {
+		return nil, respond(rw, ErrAborted, codeRejected, "Authentication aborted")
+	}
+	resp, err := decode(line)
+	if err != nil {
+		return nil, respond(rw, err, codeBase64, "Base64 encoding error")
+	}
+	return resp, nil
+}
+
+// respond writes a response line and returns err, or the write error if there
+// was one.
+func respond(w io.Writer, err error, code int, text string) error {
+	return wire.Reply(w, err, strconv.Itoa(code)+" "+text)
+}
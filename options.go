@@ -6,6 +6,8 @@ package sasl
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"time"
 )
 
 // An Option represents an input to a SASL state machine.
@@ -25,12 +27,40 @@ func getOpts(n *Negotiator, o ...Option) {
 
 // TLSState lets the state machine negotiate channel binding with a TLS session
 // if supported by the underlying mechanism.
+// The SCRAM -PLUS mechanisms use the tls-unique channel binding if the session
+// has one (it does not with TLS 1.3) and tls-server-end-point otherwise.
+// Servers must also set ServerCertificate to support tls-server-end-point.
+//
+// Setting it does not tell the server that the -PLUS mechanisms were offered,
+// see LocalMechanisms.
 func TLSState(cs tls.ConnectionState) Option {
 	return func(n *Negotiator) {
 		n.tlsState = &cs
 	}
 }
 
+// ServerCertificate sets the certificate that a server presented on the TLS
+// connection, which is used for the tls-server-end-point channel binding
+// (RFC 5929).
+// Clients use the first peer certificate of the connection set with TLSState
+// instead.
+func ServerCertificate(cert *x509.Certificate) Option {
+	return func(n *Negotiator) {
+		n.serverCert = cert
+	}
+}
+
+// LocalMechanisms sets the list of mechanisms that a server offered to the
+// client.
+// If it includes a SCRAM -PLUS mechanism, SCRAM servers reject clients that
+// support channel binding but chose a mechanism without it since an attacker
+// may have removed the -PLUS mechanisms from the list (RFC 5802 section 6).
+func LocalMechanisms(m ...string) Option {
+	return func(n *Negotiator) {
+		n.localMechanisms = m
+	}
+}
+
 // RemoteMechanisms sets a list of mechanisms supported by the remote client or
 // server with which the state machine will be negotiating.
 // It is used to determine if the server supports channel binding.
@@ -51,3 +81,142 @@ func Credentials(f func() (Username, Password, Identity []byte)) Option {
 		n.credentials = f
 	}
 }
+
+// TOTPCode sets the function that clients call to get the one-time password to
+// send when the mechanism was wrapped with WithTOTP.
+// The function will normally prompt the user for a code from their
+// authenticator app.
+func TOTPCode(f func() []byte) Option {
+	return func(n *Negotiator) {
+		n.totpCode = f
+	}
+}
+
+// TOTPSecrets sets the function that servers use to look up the shared TOTP
+// secret of a user that was authenticated by a mechanism wrapped with
+// WithTOTP.
+// If it returns an empty secret the user cannot authenticate.
+func TOTPSecrets(f func(username []byte) (secret []byte)) Option {
+	return func(n *Negotiator) {
+		n.totpSecrets = f
+	}
+}
+
+// TOTPCounter sets the function that servers use to record the time step
+// (counter) of each valid code so that codes cannot be used more than once
+// (RFC 6238 section 5.2).
+// It should store counter as the last accepted counter of the user and report
+// whether it was greater than the one that was already stored, and must do so
+// atomically (eg. with a compare and swap) if the same user can authenticate
+// in more than one exchange at once.
+// If it is not set or reports false the user cannot authenticate.
+func TOTPCounter(f func(username []byte, counter uint64) (ok bool)) Option {
+	return func(n *Negotiator) {
+		n.totpCounter = f
+	}
+}
+
+// Clock sets the function used by time dependent mechanisms to get the current
+// time.
+// If it is not set time.Now is used.
+func Clock(f func() time.Time) Option {
+	return func(n *Negotiator) {
+		n.clock = f
+	}
+}
+
+// ScramSecrets sets the function that servers use to look up the stored
+// credentials of a user that is authenticating with a SCRAM mechanism.
+// It should return false if the user does not exist.
+//
+// If the server negotiator also has a username set using the Credentials
+// option it is used in place of the username sent by the client.
+// Once the clients proof has been verified the negotiators permissions
+// function is called with the username and authorization identity, but no
+// password.
+//
+// Clients that send extension attributes (see ScramExtensions) fail to
+// authenticate, use ScramExtensionSecrets to support them.
+func ScramSecrets(f func(username []byte) (creds ScramCredentials, ok bool)) Option {
+	return func(n *Negotiator) {
+		n.scramSecrets = func(username []byte, ext map[string]string) (ScramCredentials, bool) {
+			if len(ext) > 0 {
+				return ScramCredentials{}, false
+			}
+			return f(username)
+		}
+	}
+}
+
+// ScramExtensionSecrets is like ScramSecrets except that the function is also
+// passed the extension attributes sent by the client, or nil if there were
+// none.
+// It should return false if the user does not exist or if it does not support
+// one of the extensions.
+// The extensions are also available from the ScramExtensions method of the
+// negotiator passed to the permissions function.
+func ScramExtensionSecrets(f func(username []byte, ext map[string]string) (creds ScramCredentials, ok bool)) Option {
+	return func(n *Negotiator) {
+		n.scramSecrets = f
+	}
+}
+
+// ScramExtensions adds extension attributes to the client-first-message sent
+// by SCRAM clients, sorted by name.
+// For example, Kafka clients authenticating with a delegation token set
+// "tokenauth" to "true".
+// Names may not be empty, "m", or contain "=" or ",", and values may not
+// contain ",".
+//
+// SCRAM servers pass the extensions to the function set with
+// ScramExtensionSecrets.
+// The reserved "m" attribute always causes authentication to fail.
+func ScramExtensions(ext map[string]string) Option {
+	return func(n *Negotiator) {
+		n.scramExtensions = ext
+	}
+}
+
+// ScramPassword sets a function that SCRAM clients use to transform the
+// password before it is salted and hashed.
+// For example, MongoDB uses the hex encoded MD5 of "username:mongo:password"
+// with SCRAM-SHA-1.
+// Servers must store credentials derived from the transformed password.
+func ScramPassword(f func(username, password []byte) []byte) Option {
+	return func(n *Negotiator) {
+		n.scramPassword = f
+	}
+}
+
+// ScramFakeSecret sets the secret that SCRAM servers use to derive the salt
+// and iteration count sent to users that do not exist.
+// If it is not set a random secret is generated for each process, so servers
+// that share a user database (eg. behind a load balancer) should set the same
+// secret or an attacker can compare their responses to find out which users
+// exist.
+func ScramFakeSecret(secret []byte) Option {
+	return func(n *Negotiator) {
+		n.scramFakeSecret = secret
+	}
+}
+
+// ScramCache sets the cache that SCRAM clients use to store the salted
+// password so that the expensive key derivation can be skipped in later
+// exchanges that use the same salt and iteration count.
+// A cache must only be shared by negotiators with the same credentials.
+func ScramCache(c ScramKeyCache) Option {
+	return func(n *Negotiator) {
+		n.scramCache = c
+	}
+}
+
+// Nonce sets the random part of the nonce instead of generating a new one.
+// It lets servers that handle an exchange across several requests (eg. over
+// HTTP) rebuild the negotiator on any machine by replaying the earlier steps.
+// A nonce must never be reused for a new exchange, and Reset always generates
+// a new one.
+func Nonce(nonce []byte) Option {
+	return func(n *Negotiator) {
+		n.nonce = nonce
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package passkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrNoMatchingCredential is returned by the software authenticator if it does
+// not hold a credential that the relying party will accept.
+var ErrNoMatchingCredential = errors.New("No matching credential")
+
+// The number of random bytes in credential IDs generated by the software
+// authenticator.
+const credentialIDLen = 16
+
+type softCredential struct {
+	rpID      string
+	id        []byte
+	alg       Algorithm
+	key       interface{}
+	signCount uint32
+}
+
+// SoftwareAuthenticator is an Authenticator that keeps its private keys in
+// memory.
+// It is meant for testing and for environments without hardware
+// authenticators, it provides none of the protections of a real
+// authenticator.
+//
+// A SoftwareAuthenticator is always considered to have verified the user.
+// It is safe for concurrent use.
+type SoftwareAuthenticator struct {
+	rand  io.Reader
+	mu    sync.Mutex
+	creds []*softCredential
+}
+
+// NewSoftwareAuthenticator returns an authenticator that uses r as its source
+// of randomness.
+// If r is nil, crypto/rand.Reader is used.
+func NewSoftwareAuthenticator(r io.Reader) *SoftwareAuthenticator {
+	if r == nil {
+		r = rand.Reader
+	}
+	return &SoftwareAuthenticator{rand: r}
+}
+
+// Register creates a new key pair scoped to rpID and returns the public
+// credential that should be stored by the relying party.
+func (a *SoftwareAuthenticator) Register(rpID string, alg Algorithm) (Credential, error) {
+	id := make([]byte, credentialIDLen)
+	if _, err := io.ReadFull(a.rand, id); err != nil {
+		return Credential{}, err
+	}
+
+	sc := &softCredential{rpID: rpID, id: id, alg: alg}
+	cred := Credential{ID: id, Algorithm: alg}
+	switch alg {
+	case ES256:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), a.rand)
+		if err != nil {
+			return Credential{}, err
+		}
+		sc.key = priv
+		cred.PublicKey = &priv.PublicKey
+	case EdDSA:
+		pub, priv, err := ed25519.GenerateKey(a.rand)
+		if err != nil {
+			return Credential{}, err
+		}
+		sc.key = priv
+		cred.PublicKey = pub
+	default:
+		return Credential{}, ErrAlgorithm
+	}
+
+	a.mu.Lock()
+	a.creds = append(a.creds, sc)
+	a.mu.Unlock()
+	return cred, nil
+}
+
+// GetAssertion satisfies the Authenticator interface.
+func (a *SoftwareAuthenticator) GetAssertion(rpID string, clientDataHash []byte, allowed [][]byte) (Assertion, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var sc *softCredential
+	for _, c := range a.creds {
+		if c.rpID != rpID {
+			continue
+		}
+		if len(allowed) == 0 {
+			sc = c
+			break
+		}
+		for _, id := range allowed {
+			if bytes.Equal(id, c.id) {
+				sc = c
+				break
+			}
+		}
+		if sc != nil {
+			break
+		}
+	}
+	if sc == nil {
+		return Assertion{}, ErrNoMatchingCredential
+	}
+
+	sc.signCount++
+	authData := authenticatorData(rpID, flagUserPresent|flagUserVerified, sc.signCount)
+	signed := make([]byte, 0, len(authData)+len(clientDataHash))
+	signed = append(signed, authData...)
+	signed = append(signed, clientDataHash...)
+
+	var sig []byte
+	switch key := sc.key.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(signed)
+		r, s, err := ecdsa.Sign(a.rand, key, digest[:])
+		if err != nil {
+			return Assertion{}, err
+		}
+		sig, err = asn1.Marshal(ecdsaSignature{R: r, S: s})
+		if err != nil {
+			return Assertion{}, err
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, signed)
+	default:
+		return Assertion{}, ErrAlgorithm
+	}
+
+	return Assertion{
+		CredentialID:      sc.id,
+		AuthenticatorData: authData,
+		Signature:         sig,
+	}, nil
+}
@@ -0,0 +1,491 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package passkey implements a SASL mechanism that authenticates users with
+// WebAuthn assertions (passkeys).
+//
+// The exchange follows the general shape of the SASL passkey drafts:
+//
+//	C: authzid NUL authcid
+//	S: {"challenge": …, "rpId": …, "allowCredentials": […]}
+//	C: {"id": …, "response": {"authenticatorData": …, "clientDataJSON": …, "signature": …}}
+//
+// All binary values in the JSON messages are base64url encoded without
+// padding, the same as in the WebAuthn JavaScript API.
+// The server verifies the signature against the public keys that were
+// registered for the user and then calls the negotiators permissions function
+// to check that the user may act as the requested authorization identity.
+package passkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Name is the name of the SASL mechanism.
+const Name = "PASSKEY"
+
+// Algorithm is a COSE algorithm identifier.
+type Algorithm int
+
+// Supported signature algorithms.
+const (
+	ES256 Algorithm = -7
+	EdDSA Algorithm = -8
+)
+
+// Flags set in the authenticator data.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+)
+
+// The number of random bytes in a challenge.
+const challengeLen = 32
+
+// The secret used to derive fake credential IDs if Config.FakeSecret is not
+// set.
+var (
+	fakeSecretOnce sync.Once
+	fakeSecret     []byte
+)
+
+// Errors returned by the mechanism.
+var (
+	ErrRPID       = errors.New("Relying party ID does not match")
+	ErrSignature  = errors.New("Invalid assertion signature")
+	ErrSignCount  = errors.New("Signature counter did not increase")
+	ErrClientData = errors.New("Invalid client data")
+	ErrAlgorithm  = errors.New("Unsupported algorithm")
+)
+
+// Credential is a public key credential that has been registered with a
+// relying party.
+type Credential struct {
+	ID        []byte
+	Algorithm Algorithm
+
+	// PublicKey is an *ecdsa.PublicKey on the P-256 curve for ES256 or an
+	// ed25519.PublicKey for EdDSA.
+	PublicKey interface{}
+
+	// SignCount is the signature counter of the authenticator that was stored
+	// after the last successful authentication (see Config.UpdateSignCount).
+	// If the authenticator supports a counter and it did not increase the
+	// authenticator may have been cloned and authentication fails with
+	// ErrSignCount.
+	SignCount uint32
+}
+
+// Assertion is the result of asking an authenticator to sign a challenge.
+type Assertion struct {
+	CredentialID      []byte
+	AuthenticatorData []byte
+	Signature         []byte
+}
+
+// Authenticator is an abstraction over a platform or roaming authenticator.
+// GetAssertion signs the authenticator data concatenated with clientDataHash
+// using one of the credentials in allowed (or any credential scoped to rpID if
+// allowed is empty).
+type Authenticator interface {
+	GetAssertion(rpID string, clientDataHash []byte, allowed [][]byte) (Assertion, error)
+}
+
+// Config configures the passkey mechanism.
+// Clients must set Authenticator and servers must set Credentials.
+type Config struct {
+	// RPID is the relying party ID (normally a domain name). It is required.
+	RPID string
+
+	// Origin is included in the client data by clients and, if non-empty,
+	// checked by servers.
+	Origin string
+
+	// Authenticator is used by clients to sign challenges.
+	Authenticator Authenticator
+
+	// Credentials is used by servers to look up the credentials registered to
+	// a user.
+	// Users without credentials are sent a challenge for a made up credential
+	// so that they cannot be told apart from users that exist, and the
+	// exchange fails with sasl.ErrAuthn once the client responds.
+	Credentials func(username []byte) []Credential
+
+	// UpdateSignCount is called by servers with the new signature counter of
+	// the credential that was used after a successful authentication.
+	// It should store the counter so that it is returned in the SignCount
+	// field of the credential next time.
+	// If it returns an error authentication fails.
+	UpdateSignCount func(username, id []byte, signCount uint32) error
+
+	// FakeSecret is the secret that servers use to derive the credential IDs
+	// sent to users that have no credentials.
+	// If it is not set a random secret is generated for each process, so
+	// servers that share a user database should set the same secret or an
+	// attacker can compare their responses to find out which users exist.
+	FakeSecret []byte
+
+	// UserVerification requires that the authenticator verified the user (eg.
+	// with a PIN or biometric) and not just their presence.
+	UserVerification bool
+
+	// Rand is the source of randomness for challenges.
+	// If nil, crypto/rand.Reader is used.
+	Rand io.Reader
+}
+
+type challengeMsg struct {
+	Challenge        string           `json:"challenge"`
+	RPID             string           `json:"rpId"`
+	AllowCredentials []credDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string           `json:"userVerification,omitempty"`
+}
+
+type credDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type responseMsg struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		AuthenticatorData string `json:"authenticatorData"`
+		ClientDataJSON    string `json:"clientDataJSON"`
+		Signature         string `json:"signature"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type serverState struct {
+	challenge []byte
+	username  []byte
+	identity  []byte
+	creds     []Credential
+}
+
+var b64 = base64.RawURLEncoding
+
+// New returns a Mechanism that authenticates using passkeys.
+func New(c Config) sasl.Mechanism {
+	return sasl.Mechanism{
+		Name: Name,
+		Start: func(m *sasl.Negotiator) (bool, []byte, interface{}, error) {
+			username, _, identity := m.Credentials()
+			payload := make([]byte, 0, len(identity)+len(username)+1)
+			payload = append(payload, identity...)
+			payload = append(payload, 0)
+			payload = append(payload, username...)
+			return true, payload, nil, nil
+		},
+		Next: func(m *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			if m.State()&sasl.Receiving == sasl.Receiving {
+				return c.serverNext(m, challenge, data)
+			}
+			return c.clientNext(m, challenge)
+		},
+	}
+}
+
+func (c Config) clientNext(m *sasl.Negotiator, challenge []byte) (bool, []byte, interface{}, error) {
+	if m.State()&sasl.StepMask != sasl.AuthTextSent {
+		return false, nil, nil, sasl.ErrTooManySteps
+	}
+	if c.Authenticator == nil {
+		return false, nil, nil, sasl.ErrInvalidState
+	}
+
+	var msg challengeMsg
+	if err := json.Unmarshal(challenge, &msg); err != nil {
+		return false, nil, nil, sasl.ErrInvalidChallenge
+	}
+	// The relying party ID is what binds the signature to a server. If we let the
+	// server pick it a phishing server could relay a challenge for another site.
+	if msg.RPID != c.RPID {
+		return false, nil, nil, ErrRPID
+	}
+	if _, err := b64.DecodeString(msg.Challenge); err != nil || msg.Challenge == "" {
+		return false, nil, nil, sasl.ErrInvalidChallenge
+	}
+	allowed := make([][]byte, 0, len(msg.AllowCredentials))
+	for _, cred := range msg.AllowCredentials {
+		id, err := b64.DecodeString(cred.ID)
+		if err != nil {
+			return false, nil, nil, sasl.ErrInvalidChallenge
+		}
+		allowed = append(allowed, id)
+	}
+
+	cdJSON, err := json.Marshal(clientData{
+		Type:      "webauthn.get",
+		Challenge: msg.Challenge,
+		Origin:    c.Origin,
+	})
+	if err != nil {
+		return false, nil, nil, err
+	}
+	cdHash := sha256.Sum256(cdJSON)
+	assertion, err := c.Authenticator.GetAssertion(c.RPID, cdHash[:], allowed)
+	if err != nil {
+		return false, nil, nil, err
+	}
+
+	var resp responseMsg
+	resp.ID = b64.EncodeToString(assertion.CredentialID)
+	resp.Type = "public-key"
+	resp.Response.AuthenticatorData = b64.EncodeToString(assertion.AuthenticatorData)
+	resp.Response.ClientDataJSON = b64.EncodeToString(cdJSON)
+	resp.Response.Signature = b64.EncodeToString(assertion.Signature)
+	payload, err := json.Marshal(resp)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	return false, payload, nil, nil
+}
+
+func (c Config) serverNext(m *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+	switch m.State() & sasl.StepMask {
+	case sasl.AuthTextSent:
+		parts := bytes.Split(challenge, []byte{0})
+		if len(parts) != 2 || len(parts[1]) == 0 {
+			return false, nil, nil, sasl.ErrInvalidChallenge
+		}
+		var creds []Credential
+		if c.Credentials != nil {
+			creds = c.Credentials(parts[1])
+		}
+
+		r := c.Rand
+		if r == nil {
+			r = rand.Reader
+		}
+		nonce := make([]byte, challengeLen)
+		if _, err := io.ReadFull(r, nonce); err != nil {
+			return false, nil, nil, err
+		}
+
+		msg := challengeMsg{
+			Challenge: b64.EncodeToString(nonce),
+			RPID:      c.RPID,
+		}
+		for _, cred := range creds {
+			msg.AllowCredentials = append(msg.AllowCredentials, credDescriptor{
+				Type: "public-key",
+				ID:   b64.EncodeToString(cred.ID),
+			})
+		}
+		if len(creds) == 0 {
+			msg.AllowCredentials = []credDescriptor{{
+				Type: "public-key",
+				ID:   b64.EncodeToString(c.fakeCredentialID(parts[1])),
+			}}
+		}
+		if c.UserVerification {
+			msg.UserVerification = "required"
+		}
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return false, nil, nil, err
+		}
+		return true, payload, serverState{
+			challenge: nonce,
+			username:  parts[1],
+			identity:  parts[0],
+			creds:     creds,
+		}, nil
+	case sasl.ResponseSent:
+		state, ok := data.(serverState)
+		if !ok {
+			return false, nil, nil, sasl.ErrInvalidState
+		}
+		cred, signCount, err := c.verify(state, challenge)
+		if err != nil {
+			return false, nil, nil, err
+		}
+		if !m.Permissions(sasl.Credentials(func() (Username, Password, Identity []byte) {
+			return state.username, nil, state.identity
+		})) {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		if c.UpdateSignCount != nil {
+			if err := c.UpdateSignCount(state.username, cred.ID, signCount); err != nil {
+				return false, nil, nil, err
+			}
+		}
+		return false, nil, nil, nil
+	}
+	return false, nil, nil, sasl.ErrTooManySteps
+}
+
+// fakeCredentialID returns the credential ID that is sent to a user that has
+// no credentials.
+// It is derived from a secret so that it is the same each time the user tries
+// to authenticate but cannot be predicted by an attacker.
+func (c Config) fakeCredentialID(username []byte) []byte {
+	secret := c.FakeSecret
+	if secret == nil {
+		fakeSecretOnce.Do(func() {
+			fakeSecret = make([]byte, 32)
+			if _, err := rand.Read(fakeSecret); err != nil {
+				panic("passkey: failed to generate secret: " + err.Error())
+			}
+		})
+		secret = fakeSecret
+	}
+	h := hmac.New(sha256.New, secret)
+	/* #nosec */
+	h.Write(username)
+	return h.Sum(nil)[:16]
+}
+
+// verify checks an assertion response against the challenge that was sent and
+// the users registered credentials.
+// It returns the credential that was used and the new signature counter.
+func (c Config) verify(state serverState, payload []byte) (*Credential, uint32, error) {
+	var resp responseMsg
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return nil, 0, sasl.ErrInvalidChallenge
+	}
+	id, err := b64.DecodeString(resp.ID)
+	if err != nil {
+		return nil, 0, sasl.ErrInvalidChallenge
+	}
+	authData, err := b64.DecodeString(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, 0, sasl.ErrInvalidChallenge
+	}
+	cdJSON, err := b64.DecodeString(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, 0, sasl.ErrInvalidChallenge
+	}
+	sig, err := b64.DecodeString(resp.Response.Signature)
+	if err != nil {
+		return nil, 0, sasl.ErrInvalidChallenge
+	}
+
+	var cred *Credential
+	for i, cc := range state.creds {
+		if bytes.Equal(cc.ID, id) {
+			cred = &state.creds[i]
+			break
+		}
+	}
+	if cred == nil {
+		return nil, 0, sasl.ErrAuthn
+	}
+
+	var cd clientData
+	if err := json.Unmarshal(cdJSON, &cd); err != nil {
+		return nil, 0, ErrClientData
+	}
+	gotChallenge, err := b64.DecodeString(cd.Challenge)
+	switch {
+	case err != nil:
+		return nil, 0, ErrClientData
+	case cd.Type != "webauthn.get":
+		return nil, 0, ErrClientData
+	case subtle.ConstantTimeCompare(gotChallenge, state.challenge) != 1:
+		return nil, 0, ErrClientData
+	case c.Origin != "" && cd.Origin != c.Origin:
+		return nil, 0, ErrClientData
+	}
+
+	// authenticatorData is rpIdHash (32) || flags (1) || signCount (4) || …
+	if len(authData) < 37 {
+		return nil, 0, sasl.ErrInvalidChallenge
+	}
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return nil, 0, ErrRPID
+	}
+	flags := authData[32]
+	if flags&flagUserPresent != flagUserPresent {
+		return nil, 0, sasl.ErrAuthn
+	}
+	if c.UserVerification && flags&flagUserVerified != flagUserVerified {
+		return nil, 0, sasl.ErrAuthn
+	}
+
+	cdHash := sha256.Sum256(cdJSON)
+	signed := make([]byte, 0, len(authData)+len(cdHash))
+	signed = append(signed, authData...)
+	signed = append(signed, cdHash[:]...)
+	if err := verifySignature(*cred, signed, sig); err != nil {
+		return nil, 0, err
+	}
+
+	// Authenticators that do not support a signature counter always send zero.
+	signCount := binary.BigEndian.Uint32(authData[33:37])
+	if (signCount != 0 || cred.SignCount != 0) && signCount <= cred.SignCount {
+		return nil, 0, ErrSignCount
+	}
+	return cred, signCount, nil
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func verifySignature(cred Credential, signed, sig []byte) error {
+	switch cred.Algorithm {
+	case ES256:
+		pub, ok := cred.PublicKey.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return ErrAlgorithm
+		}
+		var esig ecdsaSignature
+		rest, err := asn1.Unmarshal(sig, &esig)
+		if err != nil || len(rest) != 0 || esig.R == nil || esig.S == nil {
+			return ErrSignature
+		}
+		digest := sha256.Sum256(signed)
+		if !ecdsa.Verify(pub, digest[:], esig.R, esig.S) {
+			return ErrSignature
+		}
+		return nil
+	case EdDSA:
+		pub, ok := cred.PublicKey.(ed25519.PublicKey)
+		if !ok || len(pub) != ed25519.PublicKeySize {
+			return ErrAlgorithm
+		}
+		if !ed25519.Verify(pub, signed, sig) {
+			return ErrSignature
+		}
+		return nil
+	}
+	return ErrAlgorithm
+}
+
+// authenticatorData builds the authenticator data for an assertion.
+func authenticatorData(rpID string, flags byte, signCount uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := make([]byte, 37)
+	copy(data, rpIDHash[:])
+	data[32] = flags
+	binary.BigEndian.PutUint32(data[33:], signCount)
+	return data
+}
@@ -0,0 +1,281 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package passkey_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/passkey"
+)
+
+const (
+	rpID   = "example.net"
+	origin = "https://example.net"
+)
+
+func negotiate(client, server *sasl.Negotiator, tamper func([]byte) []byte) (clientErr, serverErr error) {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err, nil
+	}
+	for {
+		if tamper != nil {
+			resp = tamper(resp)
+		}
+		var challenge []byte
+		var smore bool
+		smore, challenge, err = server.Step(resp)
+		if err != nil {
+			return nil, err
+		}
+		if !more && !smore {
+			return nil, nil
+		}
+		more, resp, err = client.Step(challenge)
+		if err != nil {
+			return err, nil
+		}
+	}
+}
+
+func userCreds(user, identity string) sasl.Option {
+	return sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte(user), nil, []byte(identity)
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	for i, alg := range []passkey.Algorithm{passkey.ES256, passkey.EdDSA} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a := passkey.NewSoftwareAuthenticator(nil)
+			cred, err := a.Register(rpID, alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A key for some other site should never be used.
+			if _, err = a.Register("example.com", alg); err != nil {
+				t.Fatal(err)
+			}
+
+			var authorized string
+			server := sasl.NewServer(passkey.New(passkey.Config{
+				RPID:             rpID,
+				Origin:           origin,
+				UserVerification: true,
+				Credentials: func(username []byte) []passkey.Credential {
+					if string(username) == "juliet" {
+						return []passkey.Credential{cred}
+					}
+					return nil
+				},
+				UpdateSignCount: func(username, id []byte, signCount uint32) error {
+					if string(username) != "juliet" || !bytes.Equal(id, cred.ID) {
+						t.Errorf("Wrong credential updated: user=%q, id=%x", username, id)
+					}
+					cred.SignCount = signCount
+					return nil
+				},
+			}), func(n *sasl.Negotiator) bool {
+				user, _, _ := n.Credentials()
+				authorized = string(user)
+				return true
+			})
+			client := sasl.NewClient(passkey.New(passkey.Config{
+				RPID:          rpID,
+				Origin:        origin,
+				Authenticator: a,
+			}), userCreds("juliet", ""))
+
+			cErr, sErr := negotiate(client, server, nil)
+			if cErr != nil || sErr != nil {
+				t.Fatalf("Unexpected error: client=%v, server=%v", cErr, sErr)
+			}
+			if authorized != "juliet" {
+				t.Errorf("Wrong user passed to permissions: want=juliet, got=%q", authorized)
+			}
+			if cred.SignCount != 1 {
+				t.Errorf("Wrong signature counter stored: want=1, got=%d", cred.SignCount)
+			}
+
+			// The same credential must still work after a reset.
+			client.Reset()
+			server.Reset()
+			cErr, sErr = negotiate(client, server, nil)
+			if cErr != nil || sErr != nil {
+				t.Fatalf("Unexpected error after reset: client=%v, server=%v", cErr, sErr)
+			}
+			if cred.SignCount != 2 {
+				t.Errorf("Wrong signature counter stored: want=2, got=%d", cred.SignCount)
+			}
+		})
+	}
+}
+
+func TestFailures(t *testing.T) {
+	a := passkey.NewSoftwareAuthenticator(nil)
+	cred, err := a.Register(rpID, passkey.ES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := passkey.NewSoftwareAuthenticator(nil).Register(rpID, passkey.ES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.ID = cred.ID
+	cloned := cred
+	cloned.SignCount = 1000
+
+	lookup := func(c passkey.Credential) func([]byte) []passkey.Credential {
+		return func(username []byte) []passkey.Credential {
+			if string(username) == "juliet" {
+				return []passkey.Credential{c}
+			}
+			return nil
+		}
+	}
+	acceptAll := func(*sasl.Negotiator) bool { return true }
+
+	tamperSig := func(resp []byte) []byte {
+		if !bytes.HasPrefix(resp, []byte("{")) {
+			return resp
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(resp, &msg); err != nil {
+			panic(err)
+		}
+		msg["response"].(map[string]interface{})["clientDataJSON"] = "e30"
+		resp, err := json.Marshal(msg)
+		if err != nil {
+			panic(err)
+		}
+		return resp
+	}
+
+	for i, tc := range [...]struct {
+		clientCfg passkey.Config
+		serverCfg passkey.Config
+		user      string
+		perm      func(*sasl.Negotiator) bool
+		tamper    func([]byte) []byte
+		clientErr error
+		serverErr error
+	}{
+		0: {
+			clientCfg: passkey.Config{RPID: "evil.example", Authenticator: a},
+			serverCfg: passkey.Config{RPID: rpID, Credentials: lookup(cred)},
+			user:      "juliet",
+			perm:      acceptAll,
+			clientErr: passkey.ErrRPID,
+		},
+		1: {
+			clientCfg: passkey.Config{RPID: rpID, Authenticator: a},
+			serverCfg: passkey.Config{RPID: rpID, Credentials: lookup(cred)},
+			user:      "romeo",
+			perm:      acceptAll,
+			clientErr: passkey.ErrNoMatchingCredential,
+		},
+		2: {
+			clientCfg: passkey.Config{RPID: rpID, Authenticator: a},
+			serverCfg: passkey.Config{RPID: rpID, Credentials: lookup(other)},
+			user:      "juliet",
+			perm:      acceptAll,
+			serverErr: passkey.ErrSignature,
+		},
+		3: {
+			clientCfg: passkey.Config{RPID: rpID, Authenticator: a},
+			serverCfg: passkey.Config{RPID: rpID, Credentials: lookup(cred)},
+			user:      "juliet",
+			perm:      func(*sasl.Negotiator) bool { return false },
+			serverErr: sasl.ErrAuthn,
+		},
+		4: {
+			clientCfg: passkey.Config{RPID: rpID, Authenticator: a, Origin: "https://evil.example"},
+			serverCfg: passkey.Config{RPID: rpID, Origin: origin, Credentials: lookup(cred)},
+			user:      "juliet",
+			perm:      acceptAll,
+			serverErr: passkey.ErrClientData,
+		},
+		5: {
+			clientCfg: passkey.Config{RPID: rpID, Authenticator: a},
+			serverCfg: passkey.Config{RPID: rpID, Credentials: lookup(cred)},
+			user:      "juliet",
+			perm:      acceptAll,
+			tamper:    tamperSig,
+			serverErr: passkey.ErrClientData,
+		},
+		6: {
+			// A user without credentials signing with a credential that was not
+			// offered.
+			clientCfg: passkey.Config{RPID: rpID, Authenticator: anyCredential{a}},
+			serverCfg: passkey.Config{RPID: rpID, Credentials: lookup(cred)},
+			user:      "romeo",
+			perm:      acceptAll,
+			serverErr: sasl.ErrAuthn,
+		},
+		7: {
+			clientCfg: passkey.Config{RPID: rpID, Authenticator: a},
+			serverCfg: passkey.Config{RPID: rpID, Credentials: lookup(cloned)},
+			user:      "juliet",
+			perm:      acceptAll,
+			serverErr: passkey.ErrSignCount,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			client := sasl.NewClient(passkey.New(tc.clientCfg), userCreds(tc.user, ""))
+			server := sasl.NewServer(passkey.New(tc.serverCfg), tc.perm)
+			cErr, sErr := negotiate(client, server, tc.tamper)
+			if cErr != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, cErr)
+			}
+			if sErr != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, sErr)
+			}
+		})
+	}
+}
+
+// anyCredential is an authenticator that ignores the credentials allowed by
+// the server.
+type anyCredential struct {
+	passkey.Authenticator
+}
+
+func (a anyCredential) GetAssertion(rpID string, clientDataHash []byte, _ [][]byte) (passkey.Assertion, error) {
+	return a.Authenticator.GetAssertion(rpID, clientDataHash, nil)
+}
+
+func TestUnknownUser(t *testing.T) {
+	cfg := passkey.Config{
+		RPID:       rpID,
+		FakeSecret: []byte("secret"),
+		Credentials: func([]byte) []passkey.Credential {
+			return nil
+		},
+	}
+	allowed := func() interface{} {
+		server := sasl.NewServer(passkey.New(cfg), nil)
+		more, challenge, err := server.Step([]byte("\x00romeo"))
+		if err != nil || !more {
+			t.Fatalf("Unexpected result: more=%t, err=%v", more, err)
+		}
+		var msg map[string]interface{}
+		if err = json.Unmarshal(challenge, &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg["allowCredentials"]
+	}
+	first := allowed()
+	if creds, ok := first.([]interface{}); !ok || len(creds) != 1 {
+		t.Fatalf("Expected a made up credential, got %v", first)
+	}
+	if second := allowed(); !reflect.DeepEqual(first, second) {
+		t.Errorf("Made up credential changed: first=%v, second=%v", first, second)
+	}
+}
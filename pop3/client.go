@@ -0,0 +1,97 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package pop3
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// Authenticate sends an AUTH command and runs the exchange using client until
+// the server sends a +OK or -ERR response.
+//
+// The initial response is sent with the command unless doing so would make
+// the command line longer than 255 octets, in which case it is sent after the
+// servers first continuation.
+// If the negotiator returns an error the exchange is cancelled by sending "*"
+// and the negotiators error is returned once the server has responded.
+func Authenticate(rw io.ReadWriter, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+
+	name := client.Mechanism().Name
+	ir := wire.Encode(resp)
+	if len(ir) == 0 {
+		ir = "="
+	}
+	irSent := len("AUTH ")+len(name)+1+len(ir)+2 <= maxCommandLen
+	if irSent {
+		err = wire.WriteLine(rw, "AUTH %s %s", name, ir)
+	} else {
+		err = wire.WriteLine(rw, "AUTH %s", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	var abortErr error
+	for {
+		line, err := wire.ReadLine(rw, maxLineLen)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case bytes.HasPrefix(line, []byte("+OK")):
+			switch {
+			case abortErr != nil:
+				return abortErr
+			case more:
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		case bytes.HasPrefix(line, []byte("-ERR")):
+			if abortErr != nil {
+				return abortErr
+			}
+			return &Error{Text: string(bytes.TrimPrefix(line[4:], []byte{' '}))}
+		case bytes.HasPrefix(line, []byte("+")):
+			if abortErr != nil {
+				if err = wire.WriteLine(rw, "*"); err != nil {
+					return err
+				}
+				continue
+			}
+			if !irSent {
+				irSent = true
+				if err = wire.WriteLine(rw, "%s", wire.Encode(resp)); err != nil {
+					return err
+				}
+				continue
+			}
+			challenge, err := wire.Decode(bytes.TrimPrefix(line[1:], []byte{' '}))
+			if err == nil {
+				more, resp, err = client.Step(challenge)
+			}
+			if err != nil {
+				abortErr = err
+				if err = wire.WriteLine(rw, "*"); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = wire.WriteLine(rw, "%s", wire.Encode(resp)); err != nil {
+				return err
+			}
+		default:
+			return sasl.ErrInvalidChallenge
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package pop3 implements the POP3 AUTH command as defined in RFC 5034.
+//
+// Authenticate drives a client Negotiator and HandleAuth drives a server
+// Negotiator.
+// Both operate on the raw connection after the caller has handled any other
+// POP3 commands and never read past the end of the exchange.
+package pop3
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+const (
+	// RFC 2449 limits command lines, including the AUTH command and its initial
+	// response, to 255 octets including the CRLF.
+	maxCommandLen = 255
+
+	// Responses to challenges are not limited by RFC 5034, but we have to stop
+	// somewhere.
+	maxLineLen = 65536
+)
+
+// Errors returned by the client and server.
+var (
+	ErrAborted          = errors.New("Authentication aborted by client")
+	ErrUnknownMechanism = errors.New("Unrecognized authentication type")
+	ErrSyntax           = errors.New("Syntax error in AUTH command")
+	ErrLineTooLong      = wire.ErrLineTooLong
+)
+
+// Error is returned by Authenticate when the server ends the exchange with an
+// -ERR response.
+type Error struct {
+	Text string
+}
+
+func (e *Error) Error() string {
+	return "pop3: -ERR " + e.Text
+}
+
+// Mechanisms returns the SASL mechanisms advertised in the SASL line of a CAPA
+// response so that they can be passed to the sasl.RemoteMechanisms option.
+func Mechanisms(capa []string) []string {
+	for _, line := range capa {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.EqualFold(fields[0], "SASL") {
+			mechs := fields[1:]
+			for i, m := range mechs {
+				mechs[i] = strings.ToUpper(m)
+			}
+			return mechs
+		}
+	}
+	return nil
+}
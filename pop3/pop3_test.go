@@ -0,0 +1,252 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package pop3_test
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/pop3"
+)
+
+var longPass = strings.Repeat("p", 200)
+
+// checkPass also accepts longPass, which is used to test long messages.
+func checkPass(n *sasl.Negotiator) bool {
+	_, pass, _ := n.Credentials()
+	return sasltest.CheckPass(n) || sasltest.CheckUser(n) && string(pass) == longPass
+}
+
+func TestMechanisms(t *testing.T) {
+	mechs := pop3.Mechanisms([]string{"TOP", "USER", "SASL plain SCRAM-SHA-1", "UIDL"})
+	if want := []string{"PLAIN", "SCRAM-SHA-1"}; !reflect.DeepEqual(mechs, want) {
+		t.Errorf("Wrong mechanisms: want=%v, got=%v", want, mechs)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		command    string
+		clientErr  error
+		serverErr  error
+		errText    string
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			command:    "AUTH PLAIN AHVzZXIAcGVuY2ls",
+		},
+		1: {
+			// The initial response would make the command too long.
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", longPass)},
+			command:    "AUTH PLAIN",
+		},
+		2: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			command:    "AUTH PLAIN AHVzZXIAcGVu",
+			serverErr:  sasl.ErrAuthn,
+			errText:    "[AUTH] Authentication failed",
+		},
+		3: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+			command: "AUTH PLAIN AHVzZXIAcGVuY2ls",
+		},
+		4: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			command:    "AUTH PLAIN AHVzZXIAcGVuY2ls",
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  pop3.ErrAborted,
+		},
+		5: {
+			// The signature is sent in a final challenge so the client can cancel
+			// the exchange when it is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+			serverErr:  pop3.ErrAborted,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = checkPass
+			}
+			errs := make(chan error, 1)
+			commands := make(chan string, 1)
+			go func() {
+				line := readCommand(serverConn)
+				commands <- line
+				errs <- pop3.HandleAuth(serverConn, line, func(name string) *sasl.Negotiator {
+					if name != tc.mech.Name {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+				serverConn.Close()
+			}()
+
+			err := pop3.Authenticate(clientConn, sasl.NewClient(tc.mech, tc.clientOpts...))
+			if e, ok := err.(*pop3.Error); ok {
+				if e.Text != tc.errText {
+					t.Errorf("Unexpected error text: want=%q, got=%q", tc.errText, e.Text)
+				}
+			} else if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+			if cmd := <-commands; tc.command != "" && cmd != tc.command {
+				t.Errorf("Unexpected command: want=%q, got=%q", tc.command, cmd)
+			}
+		})
+	}
+}
+
+func TestCommandTooLong(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- pop3.HandleAuth(serverConn, "AUTH PLAIN "+strings.Repeat("A", 250), func(string) *sasl.Negotiator {
+			return sasl.NewServer(sasl.Plain, checkPass)
+		})
+		serverConn.Close()
+	}()
+	if line := readCommand(clientConn); line != "-ERR Command line too long" {
+		t.Errorf("Unexpected response: %q", line)
+	}
+	if err := <-errs; err != pop3.ErrLineTooLong {
+		t.Errorf("Unexpected server error: want=%v, got=%v", pop3.ErrLineTooLong, err)
+	}
+}
+
+func TestClientLineTooLong(t *testing.T) {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(strings.Repeat("A", 70000) + "\r\n"), ioutil.Discard}
+	err := pop3.Authenticate(rw, sasl.NewClient(sasl.Plain, sasltest.Creds("user", "pencil")))
+	if err != pop3.ErrLineTooLong {
+		t.Errorf("Unexpected error: want=%v, got=%v", pop3.ErrLineTooLong, err)
+	}
+}
+
+func TestServerResponses(t *testing.T) {
+	for i, tc := range [...]struct {
+		line  string
+		resp  string
+		reply string
+		err   error
+	}{
+		0: {
+			line:  "USER romeo",
+			reply: "-ERR Syntax error",
+			err:   pop3.ErrSyntax,
+		},
+		1: {
+			line:  "AUTH DIGEST-MD5",
+			reply: "-ERR Unrecognized authentication type",
+			err:   pop3.ErrUnknownMechanism,
+		},
+		2: {
+			line:  "AUTH PLAIN",
+			resp:  "*",
+			reply: "-ERR Authentication cancelled",
+			err:   pop3.ErrAborted,
+		},
+		3: {
+			line:  "AUTH PLAIN",
+			resp:  strings.Repeat("A", 65540),
+			reply: "-ERR Line too long",
+			err:   pop3.ErrLineTooLong,
+		},
+		4: {
+			line:  "AUTH PLAIN",
+			resp:  "AHVzZXIAcGVuY2ls=",
+			reply: "-ERR Invalid base64",
+			err:   base64.CorruptInputError(16),
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			errs := make(chan error, 1)
+			go func() {
+				errs <- pop3.HandleAuth(serverConn, tc.line, func(name string) *sasl.Negotiator {
+					if name != "PLAIN" {
+						return nil
+					}
+					return sasl.NewServer(sasl.Plain, checkPass)
+				})
+				serverConn.Close()
+			}()
+			if tc.resp != "" {
+				if c := readCommand(clientConn); c != "+ " {
+					t.Fatalf("Unexpected challenge: %q", c)
+				}
+				go clientConn.Write([]byte(tc.resp + "\r\n"))
+			}
+			if reply := readCommand(clientConn); reply != tc.reply {
+				t.Errorf("Unexpected reply: want=%q, got=%q", tc.reply, reply)
+			}
+			if err := <-errs; err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+// readCommand reads a single line from conn, a real server would have read
+// this as part of its normal command processing.
+func readCommand(conn net.Conn) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return ""
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	return strings.TrimSuffix(string(line), "\r")
+}
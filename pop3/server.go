@@ -0,0 +1,104 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package pop3
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// HandleAuth handles an AUTH command on behalf of a server.
+//
+// The line argument is the command line that was already read by the caller,
+// without the trailing CRLF.
+// The mechanism named in the command is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported.
+//
+// Additional data returned by the negotiator on success is sent as a final
+// challenge and the client must respond with an empty line before +OK is
+// sent.
+// If the client cancels the exchange ErrAborted is returned.
+func HandleAuth(rw io.ReadWriter, line string, negotiator func(mechanism string) *sasl.Negotiator) error {
+	if len(line)+2 > maxCommandLen {
+		return wire.Reply(rw, ErrLineTooLong, "-ERR Command line too long")
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 || !strings.EqualFold(fields[0], "AUTH") {
+		return wire.Reply(rw, ErrSyntax, "-ERR Syntax error")
+	}
+
+	server := negotiator(strings.ToUpper(fields[1]))
+	if server == nil {
+		return wire.Reply(rw, ErrUnknownMechanism, "-ERR Unrecognized authentication type")
+	}
+
+	var resp []byte
+	var err error
+	switch {
+	case len(fields) == 2:
+		resp, err = challenge(rw, nil)
+	case fields[2] == "=":
+		resp = []byte{}
+	default:
+		resp, err = wire.Decode([]byte(fields[2]))
+		if err != nil {
+			return wire.Reply(rw, err, "-ERR Invalid base64 in initial response")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		more, data, err := server.Step(resp)
+		if err != nil {
+			return wire.Reply(rw, err, "-ERR [AUTH] Authentication failed")
+		}
+		if !more {
+			if len(data) > 0 {
+				resp, err = challenge(rw, data)
+				if err != nil {
+					return err
+				}
+				if len(resp) != 0 {
+					return wire.Reply(rw, sasl.ErrInvalidChallenge, "-ERR [AUTH] Authentication failed")
+				}
+			}
+			return wire.Reply(rw, nil, "+OK Authentication successful")
+		}
+		resp, err = challenge(rw, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// challenge sends a continuation and reads the clients response.
+func challenge(rw io.ReadWriter, data []byte) ([]byte, error) {
+	if err := wire.WriteLine(rw, "+ %s", wire.Encode(data)); err != nil {
+		return nil, err
+	}
+	line, err := wire.ReadLine(rw, maxLineLen)
+	switch err {
+	case nil:
+	case wire.ErrLineTooLong:
+		return nil, wire.Reply(rw, ErrLineTooLong, "-ERR Line too long")
+	default:
+		return nil, err
+	}
+	if bytes.Equal(line, []byte("*")) {
+		return nil, wire.Reply(rw, ErrAborted, "-ERR Authentication cancelled")
+	}
+	resp, err := wire.Decode(line)
+	if err != nil {
+		return nil, wire.Reply(rw, err, "-ERR Invalid base64")
+	}
+	return resp, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package postgres
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Authenticate sends a SASLInitialResponse and runs the exchange using client
+// until the server sends AuthenticationOk or an ErrorResponse.
+//
+// The mechanisms argument is the list of mechanisms from the
+// AuthenticationSASL message that the caller has already read (see
+// Mechanisms).
+// If it does not contain the mechanism used by client ErrUnsupportedMechanism
+// is returned without sending anything.
+//
+// The protocol has no way to cancel an exchange, so if the negotiator returns
+// an error it is returned immediately and the caller should close the
+// connection.
+// Notices sent by the server during the exchange are ignored.
+func Authenticate(rw io.ReadWriter, mechanisms []string, client *sasl.Negotiator) error {
+	name := client.Mechanism().Name
+	supported := false
+	for _, m := range mechanisms {
+		if m == name {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return ErrUnsupportedMechanism
+	}
+
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+	var respLen [4]byte
+	if resp == nil {
+		binary.BigEndian.PutUint32(respLen[:], 0xffffffff)
+	} else {
+		binary.BigEndian.PutUint32(respLen[:], uint32(len(resp)))
+	}
+	err = writeMessage(rw, typeSASLResponse, []byte(name), []byte{0}, respLen[:], resp)
+	if err != nil {
+		return err
+	}
+
+	for {
+		typ, body, err := ReadMessage(rw)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case typeNoticeResponse:
+			continue
+		case typeErrorResponse:
+			return parseError(body)
+		case typeAuthentication:
+		default:
+			return ErrUnexpectedMessage
+		}
+		if len(body) < 4 {
+			return ErrUnexpectedMessage
+		}
+
+		switch binary.BigEndian.Uint32(body) {
+		case authSASLContinue:
+			more, resp, err = client.Step(body[4:])
+			if err != nil {
+				return err
+			}
+			if err = writeMessage(rw, typeSASLResponse, resp); err != nil {
+				return err
+			}
+		case authSASLFinal:
+			more, _, err = client.Step(body[4:])
+			if err != nil {
+				return err
+			}
+			if more {
+				return sasl.ErrInvalidChallenge
+			}
+		case authOK:
+			if more {
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		default:
+			return ErrUnexpectedMessage
+		}
+	}
+}
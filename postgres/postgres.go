@@ -0,0 +1,177 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package postgres implements SASL authentication for the PostgreSQL frontend
+// and backend protocol.
+//
+// Authenticate drives a client Negotiator and HandleSASL drives a server
+// Negotiator using the AuthenticationSASL, AuthenticationSASLContinue and
+// AuthenticationSASLFinal backend messages and the SASLInitialResponse and
+// SASLResponse frontend messages.
+// Both operate on the raw connection after the startup message has been
+// handled and never read past the end of the exchange.
+//
+// PostgreSQL identifies the user with the startup message and ignores the
+// username in the SCRAM client-first-message; libpq always sends an empty one.
+// Clients may do the same by leaving the username in their credentials empty,
+// and servers should create their negotiators with the User option so that the
+// SCRAM server authenticates the user from the startup message no matter what
+// the client sent.
+package postgres
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Message types used during authentication.
+const (
+	typeAuthentication = 'R'
+	typeSASLResponse   = 'p'
+	typeErrorResponse  = 'E'
+	typeNoticeResponse = 'N'
+)
+
+// Authentication request codes.
+const (
+	authOK           = 0
+	authSASL         = 10
+	authSASLContinue = 11
+	authSASLFinal    = 12
+)
+
+// SQLSTATE codes sent by the server.
+const (
+	codeInvalidPassword      = "28P01"
+	codeInvalidAuthorization = "28000"
+	codeProtocolViolation    = "08P01"
+)
+
+// PostgreSQL limits SASL messages to 1024 bytes, but other implementations
+// may be more lenient.
+const maxMessageLen = 65536
+
+// Errors returned by the client and server.
+var (
+	ErrUnsupportedMechanism = errors.New("Mechanism not supported by server")
+	ErrUnknownMechanism     = errors.New("Selected SASL authentication mechanism is not supported")
+	ErrUnexpectedMessage    = errors.New("Unexpected message")
+	ErrMessageTooLong       = errors.New("Message too long")
+)
+
+// Error is an ErrorResponse sent by the server.
+type Error struct {
+	Severity string
+	Code     string
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return "postgres: " + e.Severity + ": " + e.Message + " (SQLSTATE " + e.Code + ")"
+}
+
+// User returns an option that sets the username from the startup message on a
+// server Negotiator.
+// SCRAM servers use it in place of the username sent by the client.
+func User(user string) sasl.Option {
+	return sasl.Credentials(func() ([]byte, []byte, []byte) {
+		return []byte(user), nil, nil
+	})
+}
+
+// ReadMessage reads a single message from r and returns its type and body.
+func ReadMessage(r io.Reader) (typ byte, body []byte, err error) {
+	var hdr [5]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n < 4 {
+		return 0, nil, ErrUnexpectedMessage
+	}
+	if n-4 > maxMessageLen {
+		return 0, nil, ErrMessageTooLong
+	}
+	body = make([]byte, n-4)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], body, nil
+}
+
+// Mechanisms returns the mechanisms offered in the body of an
+// AuthenticationSASL message so that they can be passed to Authenticate.
+func Mechanisms(body []byte) ([]string, error) {
+	if len(body) < 4 || binary.BigEndian.Uint32(body) != authSASL {
+		return nil, ErrUnexpectedMessage
+	}
+	var mechs []string
+	body = body[4:]
+	for {
+		name, rest, ok := cstring(body)
+		if !ok {
+			return nil, ErrUnexpectedMessage
+		}
+		if name == "" {
+			return mechs, nil
+		}
+		mechs = append(mechs, name)
+		body = rest
+	}
+}
+
+func writeMessage(w io.Writer, typ byte, body ...[]byte) error {
+	n := 4
+	for _, b := range body {
+		n += len(b)
+	}
+	msg := make([]byte, 5, 1+n)
+	msg[0] = typ
+	binary.BigEndian.PutUint32(msg[1:], uint32(n))
+	for _, b := range body {
+		msg = append(msg, b...)
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+func writeAuth(w io.Writer, code uint32, data []byte) error {
+	var c [4]byte
+	binary.BigEndian.PutUint32(c[:], code)
+	return writeMessage(w, typeAuthentication, c[:], data)
+}
+
+// cstring splits a null terminated string off the front of b.
+func cstring(b []byte) (string, []byte, bool) {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i]), b[i+1:], true
+		}
+	}
+	return "", nil, false
+}
+
+func parseError(body []byte) *Error {
+	e := &Error{}
+	for len(body) > 0 && body[0] != 0 {
+		field := body[0]
+		val, rest, ok := cstring(body[1:])
+		if !ok {
+			break
+		}
+		switch field {
+		case 'S':
+			e.Severity = val
+		case 'C':
+			e.Code = val
+		case 'M':
+			e.Message = val
+		}
+		body = rest
+	}
+	return e
+}
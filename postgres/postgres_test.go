@@ -0,0 +1,286 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package postgres_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/postgres"
+)
+
+// verifier is a pg_authid entry created by PostgreSQL for the password
+// "password", it is taken from the regression tests of PostgreSQL
+// (regress_passwd_sha_len0 in src/test/regress/sql/password.sql).
+const verifier = "SCRAM-SHA-256$4096:A6xHKoH/494E941doaPOYg==$Ky+A30sewHIH3VHQLRN9vYsuzlgNyGNKCh37dy96Rqw=:COPdlNiIkrsacU5QoxydEuOH6e/KfiipeETb/bPw8ZI="
+
+var verifierSecrets = sasl.ScramSecrets(func(username []byte) (sasl.ScramCredentials, bool) {
+	creds, err := postgres.ParseVerifier(verifier)
+	return creds, err == nil && string(username) == "user"
+})
+
+func TestVerifier(t *testing.T) {
+	creds, err := postgres.ParseVerifier(verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt, err := base64.StdEncoding.DecodeString("A6xHKoH/494E941doaPOYg==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sasl.NewScramCredentials(sha256.New, []byte("password"), salt, 4096); !reflect.DeepEqual(creds, want) {
+		t.Errorf("Wrong credentials: want=%+v, got=%+v", want, creds)
+	}
+	if s := postgres.FormatVerifier(creds); s != verifier {
+		t.Errorf("Wrong verifier: want=%s, got=%s", verifier, s)
+	}
+
+	for i, bad := range [...]string{
+		0: "md5a3556571e93b0d20722ba62be61e8c2d",
+		1: "SCRAM-SHA-256$4096:MDEyMzQ1Njc4OWFiY2RlZg==",
+		2: "SCRAM-SHA-256$0:MDEyMzQ1Njc4OWFiY2RlZg==$nQpbZ77WudtqufPwikHXGRt6g2QJ4zns8bZLw273DRM=:jn2amWP1q1h+jgjy0YTO14S6/F02SV7taipOeB7ef20=",
+		3: "SCRAM-SHA-256$4096:!!$nQpbZ77WudtqufPwikHXGRt6g2QJ4zns8bZLw273DRM=:jn2amWP1q1h+jgjy0YTO14S6/F02SV7taipOeB7ef20=",
+		4: "SCRAM-SHA-256$4096:MDEyMzQ1Njc4OWFiY2RlZg==$MDEy:jn2amWP1q1h+jgjy0YTO14S6/F02SV7taipOeB7ef20=",
+		5: "SCRAM-SHA-256$4096:MDEyMzQ1Njc4OWFiY2RlZg==$nQpbZ77WudtqufPwikHXGRt6g2QJ4zns8bZLw273DRM=",
+	} {
+		if _, err := postgres.ParseVerifier(bad); err != postgres.ErrVerifier {
+			t.Errorf("%d: Unexpected error: want=%v, got=%v", i, postgres.ErrVerifier, err)
+		}
+	}
+}
+
+func TestMechanisms(t *testing.T) {
+	mechs, err := postgres.Mechanisms([]byte("\x00\x00\x00\x0aSCRAM-SHA-256-PLUS\x00SCRAM-SHA-256\x00\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256"}; !reflect.DeepEqual(mechs, want) {
+		t.Errorf("Wrong mechanisms: want=%v, got=%v", want, mechs)
+	}
+	if _, err = postgres.Mechanisms([]byte("\x00\x00\x00\x0aSCRAM-SHA-256")); err != postgres.ErrUnexpectedMessage {
+		t.Errorf("Unexpected error for unterminated list: %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		offered    []string
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		nilServer  bool
+		tamper     bool
+		clientErr  error
+		serverErr  error
+		errCode    string
+	}{
+		0: {
+			// libpq sends an empty username.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("", "pencil")},
+			serverOpts: []sasl.Option{postgres.User("user"), sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+		},
+		1: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("someone", "pencil")},
+			serverOpts: []sasl.Option{postgres.User("user"), sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+		},
+		2: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("", "pen")},
+			serverOpts: []sasl.Option{postgres.User("user"), sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			serverErr:  sasl.ErrAuthn,
+			errCode:    "28P01",
+		},
+		3: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("", "password")},
+			serverOpts: []sasl.Option{postgres.User("user"), verifierSecrets},
+			perm:       sasltest.CheckUser,
+		},
+		4: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+		},
+		5: {
+			mech:      sasl.Plain,
+			offered:   []string{"SCRAM-SHA-256"},
+			clientErr: postgres.ErrUnsupportedMechanism,
+			serverErr: io.EOF,
+		},
+		6: {
+			mech:      sasl.Plain,
+			nilServer: true,
+			serverErr: postgres.ErrUnknownMechanism,
+			errCode:   "08P01",
+		},
+		7: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  io.EOF,
+		},
+		8: {
+			// The client hangs up instead of reading AuthenticationOk when the
+			// signature in AuthenticationSASLFinal is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("", "pencil")},
+			serverOpts: []sasl.Option{postgres.User("user"), sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+			serverErr:  io.ErrClosedPipe,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			offered := tc.offered
+			if offered == nil {
+				offered = []string{tc.mech.Name}
+			}
+			errs := make(chan error, 1)
+			go func() {
+				errs <- postgres.HandleSASL(serverConn, offered, func(name string) *sasl.Negotiator {
+					if tc.nilServer || name != tc.mech.Name {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+				serverConn.Close()
+			}()
+
+			typ, body, err := postgres.ReadMessage(clientConn)
+			if err != nil || typ != 'R' {
+				t.Fatalf("Error reading AuthenticationSASL: %q, %v", typ, err)
+			}
+			mechs, err := postgres.Mechanisms(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = postgres.Authenticate(clientConn, mechs, sasl.NewClient(tc.mech, tc.clientOpts...))
+			clientConn.Close()
+			if e, ok := err.(*postgres.Error); ok {
+				if e.Code != tc.errCode || e.Severity != "FATAL" {
+					t.Errorf("Unexpected error: want code %s, got %v", tc.errCode, e)
+				}
+			} else if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+// message is a minimal stand-in for the framing of a real client.
+func message(typ byte, body string) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(len(body)+4))
+	return string(typ) + string(b[:]) + body
+}
+
+func TestServerFraming(t *testing.T) {
+	for i, tc := range [...]struct {
+		in   string
+		code string
+		err  error
+	}{
+		0: {
+			// A query instead of a SASLInitialResponse.
+			in:   message('Q', "SELECT 1\x00"),
+			code: "08P01",
+			err:  postgres.ErrUnexpectedMessage,
+		},
+		1: {
+			in:   "p\x00\x01\x00\x05",
+			code: "08P01",
+			err:  postgres.ErrMessageTooLong,
+		},
+		2: {
+			in:   "p\x00\x00\x00\x02",
+			code: "08P01",
+			err:  postgres.ErrUnexpectedMessage,
+		},
+		3: {
+			// The length of the initial response does not match.
+			in:   message('p', "PLAIN\x00\x00\x00\x00\x20\x00user\x00pencil"),
+			code: "08P01",
+			err:  postgres.ErrUnexpectedMessage,
+		},
+		4: {
+			in:   message('p', "PLAIN\x00\xff\xff\xff\xff") + message('Q', "SELECT 1\x00"),
+			code: "08P01",
+			err:  postgres.ErrUnexpectedMessage,
+		},
+		5: {
+			in:  message('p', "PLAIN\x00\xff\xff\xff\xff"),
+			err: io.EOF,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: strings.NewReader(tc.in),
+				Writer: &out,
+			}
+			err := postgres.HandleSASL(rw, []string{"PLAIN"}, func(string) *sasl.Negotiator {
+				return sasl.NewServer(sasl.Plain, sasltest.CheckPass)
+			})
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			var typ byte
+			var body []byte
+			for {
+				typ2, body2, err := postgres.ReadMessage(&out)
+				if err != nil {
+					break
+				}
+				typ, body = typ2, body2
+			}
+			switch {
+			case tc.code == "" && typ == 'E':
+				t.Errorf("Unexpected ErrorResponse: %q", body)
+			case tc.code != "" && (typ != 'E' || !bytes.Contains(body, []byte("C"+tc.code+"\x00"))):
+				t.Errorf("Expected ErrorResponse with code %s, got %q %q", tc.code, typ, body)
+			}
+		})
+	}
+}
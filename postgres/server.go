@@ -0,0 +1,131 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package postgres
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// HandleSASL sends an AuthenticationSASL message offering mechanisms and runs
+// the exchange on behalf of a server.
+//
+// The mechanism selected by the client is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer and the User
+// option) or nil if the mechanism is not supported.
+//
+// Challenges are sent with AuthenticationSASLContinue and any additional data
+// returned by the negotiator on success with AuthenticationSASLFinal.
+// AuthenticationOk is sent once authentication succeeds, after which the
+// caller should continue with the rest of the startup sequence.
+// On failure a FATAL ErrorResponse is sent and the error is returned; the
+// caller should close the connection.
+func HandleSASL(rw io.ReadWriter, mechanisms []string, negotiator func(mechanism string) *sasl.Negotiator) error {
+	var list []byte
+	for _, m := range mechanisms {
+		list = append(list, m...)
+		list = append(list, 0)
+	}
+	list = append(list, 0)
+	if err := writeAuth(rw, authSASL, list); err != nil {
+		return err
+	}
+
+	body, err := readResponse(rw)
+	if err != nil {
+		return err
+	}
+	name, rest, ok := cstring(body)
+	if !ok || len(rest) < 4 {
+		return writeErr(rw, ErrUnexpectedMessage, codeProtocolViolation, "malformed SASLInitialResponse message")
+	}
+	var resp []byte
+	switch n := binary.BigEndian.Uint32(rest); {
+	case n == 0xffffffff:
+	case int(n) == len(rest)-4:
+		resp = rest[4:]
+	default:
+		return writeErr(rw, ErrUnexpectedMessage, codeProtocolViolation, "malformed SASLInitialResponse message")
+	}
+
+	server := negotiator(name)
+	if server == nil {
+		return writeErr(rw, ErrUnknownMechanism, codeProtocolViolation, "selected SASL authentication mechanism is not supported")
+	}
+	if resp == nil {
+		// No initial response, send an empty challenge to ask for one.
+		if err = writeAuth(rw, authSASLContinue, nil); err != nil {
+			return err
+		}
+		if resp, err = readResponse(rw); err != nil {
+			return err
+		}
+	}
+
+	for {
+		more, data, err := server.Step(resp)
+		switch {
+		case err == sasl.ErrAuthn:
+			return writeErr(rw, err, codeInvalidPassword, "password authentication failed")
+		case err != nil:
+			return writeErr(rw, err, codeInvalidAuthorization, "SASL authentication failed")
+		case !more:
+			if data != nil {
+				if err = writeAuth(rw, authSASLFinal, data); err != nil {
+					return err
+				}
+			}
+			return writeAuth(rw, authOK, nil)
+		}
+
+		if err = writeAuth(rw, authSASLContinue, data); err != nil {
+			return err
+		}
+		if resp, err = readResponse(rw); err != nil {
+			return err
+		}
+	}
+}
+
+// readResponse reads a SASLInitialResponse or SASLResponse message and returns
+// its body.
+func readResponse(rw io.ReadWriter) ([]byte, error) {
+	typ, body, err := ReadMessage(rw)
+	switch {
+	case err == ErrMessageTooLong || err == ErrUnexpectedMessage:
+		return nil, writeErr(rw, err, codeProtocolViolation, "invalid message length")
+	case err != nil:
+		return nil, err
+	case typ != typeSASLResponse:
+		return nil, writeErr(rw, ErrUnexpectedMessage, codeProtocolViolation, "expected SASL response")
+	}
+	return body, nil
+}
+
+// writeErr sends a FATAL ErrorResponse and returns err, or the write error if
+// there was one.
+func writeErr(w io.Writer, err error, code, msg string) error {
+	var body []byte
+	for _, f := range [...]struct {
+		typ byte
+		val string
+	}{
+		{'S', "FATAL"},
+		{'V', "FATAL"},
+		{'C', code},
+		{'M', msg},
+	} {
+		body = append(body, f.typ)
+		body = append(body, f.val...)
+		body = append(body, 0)
+	}
+	body = append(body, 0)
+	if werr := writeMessage(w, typeErrorResponse, body); werr != nil {
+		return werr
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package postgres
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+const verifierPrefix = "SCRAM-SHA-256$"
+
+// ErrVerifier is returned by ParseVerifier if the verifier is malformed or is
+// not a SCRAM-SHA-256 verifier (eg. an older MD5 password hash).
+var ErrVerifier = errors.New("Invalid SCRAM-SHA-256 verifier")
+
+// ParseVerifier parses a SCRAM verifier as stored in the rolpassword column of
+// pg_authid:
+//
+//	SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+//
+// The resulting credentials can be returned from the function set with the
+// sasl.ScramSecrets option to authenticate the user with sasl.ScramSha256 or
+// sasl.ScramSha256Plus.
+func ParseVerifier(verifier string) (sasl.ScramCredentials, error) {
+	if !strings.HasPrefix(verifier, verifierPrefix) {
+		return sasl.ScramCredentials{}, ErrVerifier
+	}
+	parts := strings.Split(verifier[len(verifierPrefix):], "$")
+	if len(parts) != 2 {
+		return sasl.ScramCredentials{}, ErrVerifier
+	}
+	iterSalt := strings.Split(parts[0], ":")
+	keys := strings.Split(parts[1], ":")
+	if len(iterSalt) != 2 || len(keys) != 2 {
+		return sasl.ScramCredentials{}, ErrVerifier
+	}
+
+	iter, err := strconv.Atoi(iterSalt[0])
+	if err != nil || iter < 1 {
+		return sasl.ScramCredentials{}, ErrVerifier
+	}
+	salt, err := base64.StdEncoding.DecodeString(iterSalt[1])
+	if err != nil || len(salt) == 0 {
+		return sasl.ScramCredentials{}, ErrVerifier
+	}
+	storedKey, err := base64.StdEncoding.DecodeString(keys[0])
+	if err != nil || len(storedKey) != sha256.Size {
+		return sasl.ScramCredentials{}, ErrVerifier
+	}
+	serverKey, err := base64.StdEncoding.DecodeString(keys[1])
+	if err != nil || len(serverKey) != sha256.Size {
+		return sasl.ScramCredentials{}, ErrVerifier
+	}
+
+	return sasl.ScramCredentials{
+		Salt:      salt,
+		Iter:      iter,
+		StoredKey: storedKey,
+		ServerKey: serverKey,
+	}, nil
+}
+
+// FormatVerifier formats SCRAM-SHA-256 credentials in the form used by
+// pg_authid so that they can be copied into PostgreSQL (eg. with ALTER ROLE
+// ... PASSWORD).
+// To create a verifier from a password use sasl.NewScramCredentials with
+// sha256.New.
+func FormatVerifier(creds sasl.ScramCredentials) string {
+	return verifierPrefix + strconv.Itoa(creds.Iter) + ":" +
+		base64.StdEncoding.EncodeToString(creds.Salt) + "$" +
+		base64.StdEncoding.EncodeToString(creds.StoredKey) + ":" +
+		base64.StdEncoding.EncodeToString(creds.ServerKey)
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl2
+
+import (
+	"encoding/xml"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/xmpp"
+)
+
+// Authenticate sends an authenticate element and runs the exchange using
+// client, and any tasks requested by the server, until the server sends
+// success or failure.
+// On success the authorization identifier sent by the server is returned.
+//
+// If ua is not nil it is sent with the authenticate element.
+// When the server asks the client to continue, tasks is called with each of
+// the offered task names in order and the first non-nil Negotiator is used to
+// perform that task.
+// If tasks is nil or none of the offered tasks are supported the exchange is
+// aborted and ErrNoTask is returned.
+//
+// If a negotiator returns an error the exchange is aborted and the negotiators
+// error is returned once the server has sent its failure.
+// A failure from the server is converted to an error using xmpp.Err.
+func Authenticate(d *xml.Decoder, e *xml.Encoder, client *sasl.Negotiator, ua *UserAgent, tasks func(name string) *sasl.Negotiator) (string, error) {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return "", err
+	}
+	err = e.Encode(authenticate{
+		Mechanism:       client.Mechanism().Name,
+		InitialResponse: encodeOptional(resp),
+		UserAgent:       ua,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	current := client
+	var abortErr error
+	abort := func(err error) error {
+		abortErr = err
+		return sendPayload(e, "abort", nil)
+	}
+	for {
+		start, err := nextStart(d)
+		if err != nil {
+			return "", err
+		}
+
+		switch start.Name.Local {
+		case "challenge", "task-data":
+			var el payload
+			if err = d.DecodeElement(&el, &start); err != nil {
+				return "", err
+			}
+			if abortErr != nil {
+				continue
+			}
+			data, err := decode(el.Data)
+			if err == nil {
+				more, resp, err = current.Step(data)
+			}
+			if err != nil {
+				if err = abort(err); err != nil {
+					return "", err
+				}
+				continue
+			}
+			reply := "response"
+			if start.Name.Local == "task-data" {
+				reply = "task-data"
+			}
+			if err = sendPayload(e, reply, resp); err != nil {
+				return "", err
+			}
+		case "continue":
+			var c continueElement
+			if err = d.DecodeElement(&c, &start); err != nil {
+				return "", err
+			}
+			if abortErr != nil {
+				continue
+			}
+			more, err = finish(current, more, c.AdditionalData)
+			if err != nil {
+				if err = abort(err); err != nil {
+					return "", err
+				}
+				continue
+			}
+
+			var task *sasl.Negotiator
+			var name string
+			for _, name = range c.Tasks {
+				if tasks != nil {
+					if task = tasks(name); task != nil {
+						break
+					}
+				}
+			}
+			if task == nil {
+				if err = abort(ErrNoTask); err != nil {
+					return "", err
+				}
+				continue
+			}
+			current = task
+			more, resp, err = current.Step(nil)
+			if err != nil {
+				if err = abort(err); err != nil {
+					return "", err
+				}
+				continue
+			}
+			if err = e.Encode(next{Task: name}); err != nil {
+				return "", err
+			}
+			if err = sendPayload(e, "task-data", resp); err != nil {
+				return "", err
+			}
+		case "success":
+			var s success
+			if err = d.DecodeElement(&s, &start); err != nil {
+				return "", err
+			}
+			if abortErr != nil {
+				return "", abortErr
+			}
+			if _, err = finish(current, more, s.AdditionalData); err != nil {
+				return "", err
+			}
+			return s.AuthorizationIdentifier, nil
+		case "failure":
+			var f failure
+			if err = d.DecodeElement(&f, &start); err != nil {
+				return "", err
+			}
+			if abortErr != nil {
+				return "", abortErr
+			}
+			var cond string
+			if len(f.Conditions) > 0 {
+				cond = f.Conditions[0].Local
+			}
+			return "", xmpp.Err(cond, f.Text.Value, f.lang())
+		default:
+			return "", ErrUnexpectedElement
+		}
+	}
+}
+
+// finish passes any additional data sent by the server when a mechanism or
+// task completes to the negotiator and makes sure that it is finished.
+func finish(n *sasl.Negotiator, more bool, additional *string) (bool, error) {
+	data, err := decodeOptional(additional)
+	if err != nil {
+		return more, err
+	}
+	if data != nil {
+		if !more {
+			return more, sasl.ErrInvalidChallenge
+		}
+		more, _, err = n.Step(data)
+		if err != nil {
+			return more, err
+		}
+	}
+	if more {
+		return more, sasl.ErrUnexpectedSuccess
+	}
+	return false, nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package sasl2 implements the Extensible SASL Profile (SASL2) defined in
+// XEP-0388.
+//
+// SASL2 wraps the normal SASL exchange in an authenticate element that can
+// carry a user agent, and allows the server to require additional tasks after
+// the mechanism has succeeded before it sends success along with the
+// authorization identifier.
+//
+// Tasks are driven by Negotiators just like the main mechanism: after the
+// client selects a task with the next element both sides exchange base64
+// encoded task-data elements, starting with the client, until the task
+// negotiator on the server finishes.
+// Failure conditions are shared with RFC 6120 and are mapped to errors using
+// the xmpp package.
+package sasl2
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+
+	"github.com/whenspeakteam/sasl/xmpp"
+)
+
+// NS is the XML namespace of the SASL2 elements.
+const NS = "urn:xmpp:sasl:2"
+
+const xmlNS = "http://www.w3.org/XML/1998/namespace"
+
+// Errors returned by the client and server.
+var (
+	ErrUnexpectedElement = xmpp.ErrUnexpectedElement
+	ErrNoTask            = errors.New("None of the offered tasks are supported")
+	ErrUnknownTask       = errors.New("Task was not offered by the server")
+)
+
+// Authentication is the SASL2 stream feature.
+type Authentication struct {
+	XMLName   xml.Name `xml:"urn:xmpp:sasl:2 authentication"`
+	Mechanism []string `xml:"mechanism"`
+}
+
+// UserAgent identifies the software and device that is authenticating.
+// ID should be a UUID that is stable for the installation.
+type UserAgent struct {
+	ID       string `xml:"id,attr,omitempty"`
+	Software string `xml:"software,omitempty"`
+	Device   string `xml:"device,omitempty"`
+}
+
+type authenticate struct {
+	XMLName         xml.Name   `xml:"urn:xmpp:sasl:2 authenticate"`
+	Mechanism       string     `xml:"mechanism,attr"`
+	InitialResponse *string    `xml:"initial-response"`
+	UserAgent       *UserAgent `xml:"user-agent"`
+}
+
+type success struct {
+	XMLName                 xml.Name `xml:"urn:xmpp:sasl:2 success"`
+	AdditionalData          *string  `xml:"additional-data"`
+	AuthorizationIdentifier string   `xml:"authorization-identifier"`
+}
+
+type continueElement struct {
+	XMLName        xml.Name `xml:"urn:xmpp:sasl:2 continue"`
+	AdditionalData *string  `xml:"additional-data"`
+	Tasks          []string `xml:"tasks>task"`
+	Text           string   `xml:"text,omitempty"`
+}
+
+type next struct {
+	XMLName xml.Name `xml:"urn:xmpp:sasl:2 next"`
+	Task    string   `xml:"task,attr"`
+}
+
+// payload is any element that only carries base64 data (challenge, response,
+// task-data and abort).
+type payload struct {
+	XMLName xml.Name
+	Data    string `xml:",chardata"`
+}
+
+type failure struct {
+	XMLName    xml.Name   `xml:"urn:xmpp:sasl:2 failure"`
+	Conditions []xml.Name `xml:",any"`
+	Text       struct {
+		Attrs []xml.Attr `xml:",any,attr"`
+		Value string     `xml:",chardata"`
+	} `xml:"text"`
+}
+
+// lang returns the xml:lang attribute of the text element.
+func (f failure) lang() string {
+	for _, attr := range f.Text.Attrs {
+		if attr.Name.Space == xmlNS && attr.Name.Local == "lang" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// encode base64 encodes data, using "=" for empty data.
+func encode(data []byte) string {
+	if len(data) == 0 {
+		return "="
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// encodeOptional returns nil for nil data so that the element is omitted.
+func encodeOptional(data []byte) *string {
+	if data == nil {
+		return nil
+	}
+	s := encode(data)
+	return &s
+}
+
+// decode decodes base64 character data, an empty element or "=" decodes to an
+// empty slice.
+func decode(data string) ([]byte, error) {
+	if data == "" || data == "=" {
+		return []byte{}, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, xmpp.ErrIncorrectEncoding
+	}
+	return b, nil
+}
+
+func decodeOptional(data *string) ([]byte, error) {
+	if data == nil {
+		return nil, nil
+	}
+	return decode(*data)
+}
+
+func sendPayload(e *xml.Encoder, local string, data []byte) error {
+	el := payload{XMLName: xml.Name{Space: NS, Local: local}}
+	if data != nil {
+		el.Data = encode(data)
+	}
+	return e.Encode(el)
+}
+
+// sendFailure sends a failure element for err and returns err, or the encoding
+// error if there was one.
+func sendFailure(e *xml.Encoder, err error) error {
+	start := xml.StartElement{Name: xml.Name{Space: NS, Local: "failure"}}
+	cond := xml.StartElement{Name: xml.Name{Space: xmpp.NS, Local: xmpp.Condition(err)}}
+	toks := []xml.Token{start, cond, cond.End()}
+	if f, ok := err.(xmpp.Failure); ok && f.Text != "" {
+		text := xml.StartElement{Name: xml.Name{Local: "text"}}
+		if f.Lang != "" {
+			text.Attr = []xml.Attr{{Name: xml.Name{Space: xmlNS, Local: "lang"}, Value: f.Lang}}
+		}
+		toks = append(toks, text, xml.CharData(f.Text), text.End())
+	}
+	toks = append(toks, start.End())
+	for _, t := range toks {
+		if werr := e.EncodeToken(t); werr != nil {
+			return werr
+		}
+	}
+	if werr := e.Flush(); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// nextStart returns the next start element in the SASL2 namespace, skipping
+// whitespace between elements.
+func nextStart(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space != NS {
+				return t, ErrUnexpectedElement
+			}
+			return t, nil
+		case xml.EndElement:
+			return xml.StartElement{}, ErrUnexpectedElement
+		}
+	}
+}
@@ -0,0 +1,373 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl2_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/sasl2"
+	"github.com/whenspeakteam/sasl/xmpp"
+)
+
+// finalData is a mechanism with no initial response where the server sends
+// additional data with its success.
+var finalData = sasl.Mechanism{
+	Name: "X-FINAL",
+	Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+		return true, nil, nil, nil
+	},
+	Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		if n.State()&sasl.Receiving == sasl.Receiving {
+			if len(challenge) != 0 {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, []byte("goodbye"), nil, nil
+		}
+		if n.State()&sasl.StepMask == sasl.AuthTextSent {
+			return true, []byte{}, nil, nil
+		}
+		if string(challenge) != "goodbye" {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, nil, nil, nil
+	},
+}
+
+// codeTask is a task where the client sends the password as a code and the
+// server answers with "ok" as its additional data.
+var codeTask = sasl.Mechanism{
+	Name: "X-CODE",
+	Start: func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+		_, pass, _ := n.Credentials()
+		return true, pass, nil, nil
+	},
+	Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		if n.State()&sasl.Receiving == sasl.Receiving {
+			if string(challenge) != "1234" {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, []byte("ok"), nil, nil
+		}
+		if string(challenge) != "ok" {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, nil, nil, nil
+	},
+}
+
+func TestAuthentication(t *testing.T) {
+	const features = `<authentication xmlns="urn:xmpp:sasl:2"><mechanism>SCRAM-SHA-1</mechanism><mechanism>PLAIN</mechanism></authentication>`
+	var a sasl2.Authentication
+	if err := xml.Unmarshal([]byte(features), &a); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"SCRAM-SHA-1", "PLAIN"}; !reflect.DeepEqual(a.Mechanism, want) {
+		t.Errorf("Wrong mechanisms: want=%v, got=%v", want, a.Mechanism)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ua := &sasl2.UserAgent{
+		ID:       "d4565fa7-4d72-4749-b3d3-740edbf87770",
+		Software: "AwesomeXMPP",
+		Device:   "Kiva's Phone",
+	}
+	for i, tc := range [...]struct {
+		mech        sasl.Mechanism
+		clientOpts  []sasl.Option
+		serverOpts  []sasl.Option
+		serverMech  string
+		perm        func(*sasl.Negotiator) bool
+		tamper      bool
+		ua          *sasl2.UserAgent
+		serverTasks []string
+		clientTask  string
+		taskCode    string
+		authzid     string
+		clientErr   error
+		serverErr   error
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			ua:         ua,
+			authzid:    "user@example.net/phone",
+		},
+		1: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			clientErr:  sasl.ErrAuthn,
+			serverErr:  sasl.ErrAuthn,
+		},
+		2: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+			authzid: "user",
+		},
+		3: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  xmpp.ErrAborted,
+		},
+		4: {
+			mech: finalData,
+		},
+		5: {
+			mech:       sasl.Plain,
+			serverMech: "SCRAM-SHA-1",
+			clientErr:  xmpp.ErrUnknownMechanism,
+			serverErr:  xmpp.ErrUnknownMechanism,
+		},
+		6: {
+			mech:        sasl.Plain,
+			clientOpts:  []sasl.Option{sasltest.Creds("user", "pencil")},
+			ua:          ua,
+			serverTasks: []string{"X-CODE"},
+			clientTask:  "X-CODE",
+			taskCode:    "1234",
+			authzid:     "user@example.net",
+		},
+		7: {
+			mech:        finalData,
+			serverTasks: []string{"X-CODE", "X-CODE"},
+			clientTask:  "X-CODE",
+			taskCode:    "1234",
+		},
+		8: {
+			mech:        sasl.Plain,
+			clientOpts:  []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverTasks: []string{"X-CODE"},
+			clientTask:  "X-OTHER",
+			clientErr:   sasl2.ErrNoTask,
+			serverErr:   xmpp.ErrAborted,
+		},
+		9: {
+			mech:        sasl.Plain,
+			clientOpts:  []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverTasks: []string{"X-CODE"},
+			clientTask:  "X-CODE",
+			taskCode:    "4321",
+			clientErr:   sasl.ErrAuthn,
+			serverErr:   sasl.ErrAuthn,
+		},
+		10: {
+			// The signature is sent with the success so only the client notices that
+			// it is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			serverMech := tc.serverMech
+			if serverMech == "" {
+				serverMech = tc.mech.Name
+			}
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			var server *sasl.Negotiator
+			s := sasl2.Server{
+				Negotiator: func(name string) *sasl.Negotiator {
+					if name != serverMech {
+						return nil
+					}
+					server = sasl.NewServer(mech, perm, tc.serverOpts...)
+					return server
+				},
+				Tasks: func(*sasl2.UserAgent) []string {
+					return tc.serverTasks
+				},
+				Task: func(name string) *sasl.Negotiator {
+					if name != codeTask.Name {
+						return nil
+					}
+					return sasl.NewServer(codeTask, nil)
+				},
+				AuthorizationIdentifier: func(n *sasl.Negotiator) string {
+					if n != server {
+						t.Errorf("Wrong negotiator passed to AuthorizationIdentifier")
+					}
+					return tc.authzid
+				},
+			}
+			type result struct {
+				ua  *sasl2.UserAgent
+				err error
+			}
+			results := make(chan result, 1)
+			go func() {
+				defer serverConn.Close()
+				d := xml.NewDecoder(serverConn)
+				tok, err := d.Token()
+				if err != nil {
+					results <- result{err: err}
+					return
+				}
+				ua, err := s.HandleAuthenticate(d, xml.NewEncoder(serverConn), tok.(xml.StartElement))
+				results <- result{ua: ua, err: err}
+			}()
+
+			authzid, err := sasl2.Authenticate(
+				xml.NewDecoder(clientConn), xml.NewEncoder(clientConn),
+				sasl.NewClient(tc.mech, tc.clientOpts...), tc.ua,
+				func(name string) *sasl.Negotiator {
+					if name != tc.clientTask {
+						return nil
+					}
+					return sasl.NewClient(codeTask, sasltest.Creds("", tc.taskCode))
+				},
+			)
+			if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if authzid != tc.authzid && err == nil {
+				t.Errorf("Wrong authorization identifier: want=%q, got=%q", tc.authzid, authzid)
+			}
+			r := <-results
+			if r.err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, r.err)
+			}
+			if !reflect.DeepEqual(r.ua, tc.ua) {
+				t.Errorf("Wrong user agent: want=%+v, got=%+v", tc.ua, r.ua)
+			}
+		})
+	}
+}
+
+func TestServerOutput(t *testing.T) {
+	for i, tc := range [...]struct {
+		in      string
+		out     string
+		tasks   []string
+		authzid string
+		err     error
+	}{
+		0: {
+			in:      `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHVzZXIAcGVuY2ls</initial-response></authenticate>`,
+			out:     `<success xmlns="urn:xmpp:sasl:2"><authorization-identifier>user@example.net</authorization-identifier></success>`,
+			authzid: "user@example.net",
+		},
+		1: {
+			in: `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"/>` +
+				`<response xmlns="urn:xmpp:sasl:2">AHVzZXIAcGVuY2ls</response>`,
+			out: `<challenge xmlns="urn:xmpp:sasl:2"></challenge>` +
+				`<success xmlns="urn:xmpp:sasl:2"><authorization-identifier>user@example.net</authorization-identifier></success>`,
+			authzid: "user@example.net",
+		},
+		2: {
+			in:  `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>!!</initial-response></authenticate>`,
+			out: `<failure xmlns="urn:xmpp:sasl:2"><incorrect-encoding xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></incorrect-encoding></failure>`,
+			err: xmpp.ErrIncorrectEncoding,
+		},
+		3: {
+			in: `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHVzZXIAcGVuY2ls</initial-response></authenticate>` +
+				`<next xmlns="urn:xmpp:sasl:2" task="X-CODE"/>` +
+				`<task-data xmlns="urn:xmpp:sasl:2">MTIzNA==</task-data>`,
+			out: `<continue xmlns="urn:xmpp:sasl:2"><tasks><task>X-CODE</task></tasks></continue>` +
+				`<success xmlns="urn:xmpp:sasl:2"><additional-data>b2s=</additional-data><authorization-identifier>user@example.net</authorization-identifier></success>`,
+			tasks:   []string{"X-CODE"},
+			authzid: "user@example.net",
+		},
+		4: {
+			in: `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHVzZXIAcGVuY2ls</initial-response></authenticate>` +
+				`<next xmlns="urn:xmpp:sasl:2" task="X-OTHER"/>`,
+			out: `<continue xmlns="urn:xmpp:sasl:2"><tasks><task>X-CODE</task></tasks></continue>` +
+				`<failure xmlns="urn:xmpp:sasl:2"><not-authorized xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></not-authorized></failure>`,
+			tasks: []string{"X-CODE"},
+			err:   sasl2.ErrUnknownTask,
+		},
+		5: {
+			in: `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"/>` +
+				`<abort xmlns="urn:xmpp:sasl:2"/>`,
+			out: `<challenge xmlns="urn:xmpp:sasl:2"></challenge>` +
+				`<failure xmlns="urn:xmpp:sasl:2"><aborted xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></aborted></failure>`,
+			err: xmpp.ErrAborted,
+		},
+		6: {
+			in: `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"/>` +
+				`<message xmlns="jabber:client"/>`,
+			out: `<challenge xmlns="urn:xmpp:sasl:2"></challenge>` +
+				`<failure xmlns="urn:xmpp:sasl:2"><malformed-request xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></malformed-request></failure>`,
+			err: sasl2.ErrUnexpectedElement,
+		},
+		7: {
+			// Without an authorization identifier the username is sent.
+			in:  `<authenticate xmlns="urn:xmpp:sasl:2" mechanism="PLAIN"><initial-response>AHVzZXIAcGVuY2ls</initial-response></authenticate>`,
+			out: `<success xmlns="urn:xmpp:sasl:2"><authorization-identifier>user</authorization-identifier></success>`,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			var out bytes.Buffer
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := sasl2.Server{
+				Negotiator: func(string) *sasl.Negotiator {
+					return sasl.NewServer(sasl.Plain, sasltest.CheckPass)
+				},
+				Tasks: func(*sasl2.UserAgent) []string {
+					return tc.tasks
+				},
+				Task: func(string) *sasl.Negotiator {
+					return sasl.NewServer(codeTask, nil)
+				},
+				AuthorizationIdentifier: func(*sasl.Negotiator) string {
+					return tc.authzid
+				},
+			}
+			_, err = s.HandleAuthenticate(d, xml.NewEncoder(&out), tok.(xml.StartElement))
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if out.String() != tc.out {
+				t.Errorf("Unexpected output:\nwant=%s\n got=%s", tc.out, out.String())
+			}
+		})
+	}
+}
+
+func TestClientFailure(t *testing.T) {
+	const in = `<failure xmlns="urn:xmpp:sasl:2"><account-disabled xmlns="urn:ietf:params:xml:ns:xmpp-sasl"/><text xml:lang="en">Account disabled</text></failure>`
+	_, err := sasl2.Authenticate(
+		xml.NewDecoder(strings.NewReader(in)), xml.NewEncoder(ioutil.Discard),
+		sasl.NewClient(sasl.Plain, sasltest.Creds("user", "pencil")), nil, nil,
+	)
+	want := xmpp.Failure{Condition: xmpp.AccountDisabled, Text: "Account disabled", Lang: "en"}
+	if err != want {
+		t.Errorf("Unexpected error: want=%v, got=%v", want, err)
+	}
+}
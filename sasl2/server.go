@@ -0,0 +1,205 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl2
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/xmpp"
+)
+
+// Server handles authenticate elements on behalf of an XMPP server.
+type Server struct {
+	// Negotiator returns a server Negotiator (normally created with
+	// sasl.NewServer) for the named mechanism or nil if the mechanism is not
+	// supported.
+	Negotiator func(mechanism string) *sasl.Negotiator
+
+	// Tasks returns the names of the tasks that the client must complete, in
+	// order, after the mechanism has succeeded.
+	// The user agent is nil if the client did not send one.
+	// If Tasks is nil no tasks are required.
+	Tasks func(ua *UserAgent) []string
+
+	// Task returns a server Negotiator for the named task.
+	Task func(name string) *sasl.Negotiator
+
+	// AuthorizationIdentifier returns the authorization identifier that is sent
+	// to the client on success, normally the full JID that the client was
+	// authenticated as.
+	// It is passed the negotiator returned by Negotiator for the exchange so that
+	// it can be matched with the identity recorded by its permissions function.
+	// If it is nil or returns an empty string the authorization identity, or
+	// the username if there was none, accepted by the permissions function is
+	// sent.
+	AuthorizationIdentifier func(*sasl.Negotiator) string
+}
+
+// HandleAuthenticate handles an authenticate element.
+//
+// The start argument is the start element of the authenticate element which
+// the caller has already read from d.
+// The user agent sent by the client (if any) is returned even if
+// authentication fails.
+// Errors are sent to the client as a failure using xmpp.Condition.
+// If the client aborts the exchange xmpp.ErrAborted is returned.
+func (s Server) HandleAuthenticate(d *xml.Decoder, e *xml.Encoder, start xml.StartElement) (*UserAgent, error) {
+	if start.Name.Space != NS || start.Name.Local != "authenticate" {
+		return nil, sendFailure(e, ErrUnexpectedElement)
+	}
+	var auth authenticate
+	if err := d.DecodeElement(&auth, &start); err != nil {
+		return nil, err
+	}
+	ua := auth.UserAgent
+
+	var server *sasl.Negotiator
+	if s.Negotiator != nil {
+		server = s.Negotiator(strings.ToUpper(auth.Mechanism))
+	}
+	if server == nil {
+		return ua, sendFailure(e, xmpp.ErrUnknownMechanism)
+	}
+
+	resp, err := decodeOptional(auth.InitialResponse)
+	if err != nil {
+		return ua, sendFailure(e, err)
+	}
+	if resp == nil {
+		// No initial response, send an empty challenge to ask for one.
+		resp, err = read(d, e, "challenge", "response", nil)
+		if err != nil {
+			return ua, err
+		}
+	}
+	data, err := run(d, e, server, "challenge", "response", resp)
+	if err != nil {
+		return ua, err
+	}
+
+	var tasks []string
+	if s.Tasks != nil {
+		tasks = s.Tasks(ua)
+	}
+	for _, name := range tasks {
+		err = e.Encode(continueElement{
+			AdditionalData: encodeOptional(data),
+			Tasks:          []string{name},
+		})
+		if err != nil {
+			return ua, err
+		}
+
+		nextStart, err := nextStart(d)
+		switch {
+		case err == ErrUnexpectedElement:
+			return ua, sendFailure(e, err)
+		case err != nil:
+			return ua, err
+		}
+		if nextStart.Name.Local == "abort" {
+			if err = d.Skip(); err != nil {
+				return ua, err
+			}
+			return ua, sendFailure(e, xmpp.ErrAborted)
+		}
+		var n next
+		if nextStart.Name.Local != "next" {
+			return ua, sendFailure(e, ErrUnexpectedElement)
+		}
+		if err = d.DecodeElement(&n, &nextStart); err != nil {
+			return ua, err
+		}
+		if n.Task != name {
+			return ua, sendFailure(e, ErrUnknownTask)
+		}
+		var task *sasl.Negotiator
+		if s.Task != nil {
+			task = s.Task(name)
+		}
+		if task == nil {
+			return ua, sendFailure(e, ErrUnknownTask)
+		}
+
+		// Tasks are client first, read the initial task data.
+		resp, err = read(d, e, "", "task-data", nil)
+		if err != nil {
+			return ua, err
+		}
+		data, err = run(d, e, task, "task-data", "task-data", resp)
+		if err != nil {
+			return ua, err
+		}
+	}
+
+	var authzid string
+	if s.AuthorizationIdentifier != nil {
+		authzid = s.AuthorizationIdentifier(server)
+	}
+	if authzid == "" {
+		username, identity := server.Authenticated()
+		authzid = string(username)
+		if len(identity) > 0 {
+			authzid = string(identity)
+		}
+	}
+	return ua, e.Encode(success{
+		AdditionalData:          encodeOptional(data),
+		AuthorizationIdentifier: authzid,
+	})
+}
+
+// run steps the negotiator until it finishes and returns any additional data.
+func run(d *xml.Decoder, e *xml.Encoder, n *sasl.Negotiator, send, recv string, resp []byte) ([]byte, error) {
+	for {
+		more, data, err := n.Step(resp)
+		if err != nil {
+			return nil, sendFailure(e, err)
+		}
+		if !more {
+			return data, nil
+		}
+		resp, err = read(d, e, send, recv, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// read sends a payload element named send (unless send is empty) and then
+// reads a payload element named recv or an abort.
+func read(d *xml.Decoder, e *xml.Encoder, send, recv string, data []byte) ([]byte, error) {
+	if send != "" {
+		if err := sendPayload(e, send, data); err != nil {
+			return nil, err
+		}
+	}
+	start, err := nextStart(d)
+	switch {
+	case err == ErrUnexpectedElement:
+		return nil, sendFailure(e, err)
+	case err != nil:
+		return nil, err
+	}
+
+	var el payload
+	if err = d.DecodeElement(&el, &start); err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case recv:
+	case "abort":
+		return nil, sendFailure(e, xmpp.ErrAborted)
+	default:
+		return nil, sendFailure(e, ErrUnexpectedElement)
+	}
+	resp, err := decode(el.Data)
+	if err != nil {
+		return nil, sendFailure(e, err)
+	}
+	return resp, nil
+}
@@ -5,11 +5,19 @@
 package sasl
 
 import (
+	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // saslStep is from the perspective of a client, challenge is issued by the
@@ -31,6 +39,10 @@ type saslTest struct {
 	steps      []saslStep
 	skipClient bool
 	skipServer bool
+
+	// serverNonce replaces testNonce on the server so that the published SCRAM
+	// test vectors can be used.
+	serverNonce []byte
 }
 
 func getStepName(n *Negotiator) string {
@@ -56,6 +68,30 @@ var (
 	})}
 )
 
+var totpServerOpts = []Option{
+	TOTPSecrets(func(username []byte) []byte {
+		if string(username) == "Kurt" {
+			return []byte("12345678901234567890")
+		}
+		return nil
+	}),
+	TOTPCounter(func([]byte, uint64) bool { return true }),
+	Clock(func() time.Time { return time.Unix(59, 0) }),
+}
+
+func scramServerOpts(fn func() hash.Hash, salt string, opts ...Option) []Option {
+	return append(opts, ScramSecrets(func(username []byte) (ScramCredentials, bool) {
+		if string(username) != "user" {
+			return ScramCredentials{}, false
+		}
+		s, err := base64.StdEncoding.DecodeString(salt)
+		if err != nil {
+			panic(err)
+		}
+		return NewScramCredentials(fn, []byte("pencil"), s, 4096), true
+	}))
+}
+
 func acceptAll(_ *Negotiator) bool {
 	return true
 }
@@ -316,6 +352,273 @@ var saslTestCases = [...]saslTest{
 			{resp: []byte("Ursel\x00Kurt\x00xipj3plmq\x00"), serverErr: true, more: false},
 		},
 	},
+	16: {
+		mechanism:  WithTOTP(plain),
+		perm:       acceptAll,
+		clientOpts: append(plainClientOpts, TOTPCode(func() []byte { return []byte("287082") })),
+		serverOpts: totpServerOpts,
+		steps: []saslStep{
+			{resp: plainResp, more: true},
+			{resp: []byte("287082"), more: false},
+		},
+	},
+	17: {
+		skipClient: true,
+		mechanism:  WithTOTP(plain),
+		perm:       acceptAll,
+		serverOpts: totpServerOpts,
+		steps: []saslStep{
+			{resp: plainResp, more: true},
+			{resp: []byte("287083"), serverErr: true, more: false},
+		},
+	},
+	18: {
+		skipClient: true,
+		mechanism:  WithTOTP(plain),
+		serverOpts: totpServerOpts,
+		steps: []saslStep{
+			{resp: plainResp, serverErr: true, more: false},
+		},
+	},
+	19: {
+		skipServer: true,
+		mechanism:  WithTOTP(scram("SCRAM-SHA-256", sha256.New)),
+		clientOpts: []Option{
+			Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte("pencil"), []byte{}
+			}),
+			TOTPCode(func() []byte { return []byte("287082") }),
+		},
+		steps: []saslStep{
+			{
+				resp: []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL"),
+				more: true,
+			},
+			{
+				challenge: []byte(`r=fyko+d2lbbFgONRv9qkxdawL%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096`),
+				resp:      []byte(`c=biws,r=fyko+d2lbbFgONRv9qkxdawL%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=2FUSN0pPcS7P8hBhsxBJOiUDbRoW4KVNGZT0LxVnSek=`),
+				more:      true,
+			},
+			{
+				challenge: []byte(`v=zJZjsVp2g+W9jd01vgbsshippfH1sM0tLdBvs+e3DF4=`),
+				resp:      []byte("287082"),
+				more:      false,
+			},
+		},
+	},
+	20: {
+		skipClient:  true,
+		mechanism:   scram("SCRAM-SHA-1", sha1.New),
+		perm:        acceptAll,
+		serverOpts:  scramServerOpts(sha1.New, "QSXCR+Q6sek8bf92"),
+		serverNonce: []byte("3rfcNHYJY1ZVvWVs7j"),
+		steps: []saslStep{
+			{
+				resp:      []byte(`n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL`),
+				challenge: []byte(`r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096`),
+				more:      true,
+			},
+			{
+				resp:      []byte(`c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=`),
+				challenge: []byte(`v=rmF9pqV8S7suAoZWja4dJRkFsKQ=`),
+				more:      false,
+			},
+		},
+	},
+	21: {
+		skipClient:  true,
+		mechanism:   scram("SCRAM-SHA-256", sha256.New),
+		perm:        acceptAll,
+		serverOpts:  scramServerOpts(sha256.New, "W22ZaJ0SNY7soEsUEjb6gQ=="),
+		serverNonce: []byte("%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"),
+		steps: []saslStep{
+			{
+				resp:      []byte(`n,,n=user,r=rOprNGfwEbeRWgbNEkqO`),
+				challenge: []byte(`r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096`),
+				more:      true,
+			},
+			{
+				resp:      []byte(`c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=`),
+				challenge: []byte(`v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=`),
+				more:      false,
+			},
+		},
+	},
+	22: {
+		skipClient:  true,
+		mechanism:   scram("SCRAM-SHA-256", sha256.New),
+		perm:        acceptAll,
+		serverOpts:  scramServerOpts(sha256.New, "W22ZaJ0SNY7soEsUEjb6gQ=="),
+		serverNonce: []byte("%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"),
+		steps: []saslStep{
+			{
+				resp:      []byte(`n,,n=user,r=rOprNGfwEbeRWgbNEkqO`),
+				challenge: []byte(`r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096`),
+				more:      true,
+			},
+			{
+				// A valid proof, but for an empty username.
+				resp:      []byte(`c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=qvT2SWdEH5Q06albL+hjSYuUhCG7VndFyzIb7CK4n9k=`),
+				serverErr: true,
+			},
+		},
+	},
+	23: {
+		// The username is set on the server and the client sends an empty one.
+		skipClient: true,
+		mechanism:  scram("SCRAM-SHA-256", sha256.New),
+		perm:       acceptAll,
+		serverOpts: scramServerOpts(sha256.New, "W22ZaJ0SNY7soEsUEjb6gQ==", Credentials(func() ([]byte, []byte, []byte) {
+			return []byte("user"), nil, nil
+		})),
+		serverNonce: []byte("%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"),
+		steps: []saslStep{
+			{
+				resp:      []byte(`n,,n=,r=rOprNGfwEbeRWgbNEkqO`),
+				challenge: []byte(`r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096`),
+				more:      true,
+			},
+			{
+				resp:      []byte(`c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=qvT2SWdEH5Q06albL+hjSYuUhCG7VndFyzIb7CK4n9k=`),
+				challenge: []byte(`v=3HO6Qt1M4MKJrmlKaoOqLAI0/0TV0HZe7J9H3MBtSOg=`),
+				more:      false,
+			},
+		},
+	},
+	24: {
+		skipClient:  true,
+		mechanism:   scram("SCRAM-SHA-256", sha256.New),
+		perm:        acceptAll,
+		serverOpts:  scramServerOpts(sha256.New, "W22ZaJ0SNY7soEsUEjb6gQ=="),
+		serverNonce: []byte("%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"),
+		steps: []saslStep{
+			{
+				resp:      []byte(`n,a=admin,n=user,r=rOprNGfwEbeRWgbNEkqO`),
+				challenge: []byte(`r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096`),
+				more:      true,
+			},
+			{
+				// The channel binding data does not match the gs2-header.
+				resp:      []byte(`c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=`),
+				serverErr: true,
+			},
+		},
+	},
+	25: {
+		skipClient: true,
+		mechanism:  scram("SCRAM-SHA-256", sha256.New),
+		perm:       acceptAll,
+		serverOpts: scramServerOpts(sha256.New, "W22ZaJ0SNY7soEsUEjb6gQ=="),
+		steps: []saslStep{
+			{resp: []byte(`p=tls-unique,,n=user,r=rOprNGfwEbeRWgbNEkqO`), serverErr: true},
+		},
+	},
+	26: {
+		skipClient: true,
+		mechanism:  scram("SCRAM-SHA-256", sha256.New),
+		perm:       acceptAll,
+		serverOpts: scramServerOpts(sha256.New, "W22ZaJ0SNY7soEsUEjb6gQ=="),
+		steps: []saslStep{
+			{resp: []byte(`n,,n=us=er,r=rOprNGfwEbeRWgbNEkqO`), serverErr: true},
+		},
+	},
+	27: {
+		// An attacker removed the -PLUS mechanisms from the list so the client
+		// chose SCRAM-SHA-256 and told us that it supports channel binding.
+		skipClient: true,
+		mechanism:  scram("SCRAM-SHA-256", sha256.New),
+		perm:       acceptAll,
+		serverOpts: scramServerOpts(sha256.New, "W22ZaJ0SNY7soEsUEjb6gQ==",
+			TLSState(tls.ConnectionState{TLSUnique: []byte{0, 1, 2, 3, 4}}),
+			LocalMechanisms("SCRAM-SHA-256-PLUS", "SCRAM-SHA-256"),
+		),
+		steps: []saslStep{
+			{resp: []byte(`y,,n=user,r=rOprNGfwEbeRWgbNEkqO`), serverErr: true},
+		},
+	},
+	28: {
+		// We do not support channel binding so "y" is fine.
+		skipClient:  true,
+		mechanism:   scram("SCRAM-SHA-256", sha256.New),
+		perm:        acceptAll,
+		serverOpts:  scramServerOpts(sha256.New, "W22ZaJ0SNY7soEsUEjb6gQ=="),
+		serverNonce: []byte("%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"),
+		steps: []saslStep{
+			{
+				resp:      []byte(`y,,n=user,r=rOprNGfwEbeRWgbNEkqO`),
+				challenge: []byte(`r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096`),
+				more:      true,
+			},
+		},
+	},
+	29: {
+		skipServer: true,
+		mechanism:  scram("SCRAM-SHA-256", sha256.New),
+		clientOpts: []Option{
+			Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte("pencil"), []byte{}
+			}),
+			ScramExtensions(map[string]string{"tokenauth": "true", "x": "y=z"}),
+		},
+		steps: []saslStep{
+			{
+				resp: []byte("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL,tokenauth=true,x=y=z"),
+				more: true,
+			},
+		},
+	},
+	30: {
+		skipServer: true,
+		mechanism:  scram("SCRAM-SHA-256", sha256.New),
+		clientOpts: []Option{
+			ScramExtensions(map[string]string{"m": "true"}),
+		},
+		steps: []saslStep{
+			{clientErr: true},
+		},
+	},
+	31: {
+		skipClient: true,
+		mechanism:  scram("SCRAM-SHA-256", sha256.New),
+		perm:       acceptAll,
+		serverOpts: scramServerOpts(sha256.New, "W22ZaJ0SNY7soEsUEjb6gQ=="),
+		steps: []saslStep{
+			{resp: []byte(`n,,n=user,r=rOprNGfwEbeRWgbNEkqO,m=foo`), serverErr: true},
+		},
+	},
+	32: {
+		// Test vector from the MongoDB authentication specification.
+		skipServer: true,
+		mechanism:  scram("SCRAM-SHA-1", sha1.New),
+		clientOpts: []Option{
+			Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte("pencil"), []byte{}
+			}),
+			ScramPassword(mongoPassword),
+		},
+		steps: []saslStep{
+			{
+				resp: []byte(`n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL`),
+				more: true,
+			},
+			{
+				challenge: []byte(`r=fyko+d2lbbFgONRv9qkxdawLHo+Vgk7qvUOKUwuWLIWg4l/9SraGMHEE,s=rQ9ZY3MntBeuP3E1TDVC4w==,i=10000`),
+				resp:      []byte(`c=biws,r=fyko+d2lbbFgONRv9qkxdawLHo+Vgk7qvUOKUwuWLIWg4l/9SraGMHEE,p=MC2T8BvbmWRckDw8oWl5IVghwCY=`),
+				more:      true,
+			},
+			{
+				challenge: []byte(`v=UMWeI25JD1yNYZRMpZ4VHvhZ9e0=`),
+				resp:      nil,
+				more:      false,
+			},
+		},
+	},
+}
+
+func mongoPassword(username, password []byte) []byte {
+	h := md5.New()
+	fmt.Fprintf(h, "%s:mongo:%s", username, password)
+	return []byte(hex.EncodeToString(h.Sum(nil)))
 }
 
 func testClient(t *testing.T, client *Negotiator, tc saslTest, run int) {
@@ -392,6 +695,9 @@ func TestSASL(t *testing.T) {
 				// an option to set the RNG and pass in a dummy one.
 				client.nonce = testNonce
 				server.nonce = testNonce
+				if tc.serverNonce != nil {
+					server.nonce = tc.serverNonce
+				}
 
 				if !tc.skipClient {
 					testClient(t, client, tc, run)
@@ -406,3 +712,252 @@ func TestSASL(t *testing.T) {
 		})
 	}
 }
+
+func TestScramClientServer(t *testing.T) {
+	for i, mech := range [...]Mechanism{ScramSha1, ScramSha256, ScramSha256Plus, WithTOTP(ScramSha256)} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			fn := sha256.New
+			if mech.Name == "SCRAM-SHA-1" {
+				fn = sha1.New
+			}
+			tlsState := TLSState(tls.ConnectionState{TLSUnique: []byte{0, 1, 2, 3, 4}})
+			client := NewClient(mech,
+				Credentials(func() ([]byte, []byte, []byte) {
+					return []byte("us,er"), []byte("pencil"), []byte("a=dmin")
+				}),
+				RemoteMechanisms(mech.Name),
+				TOTPCode(func() []byte { return []byte("287082") }),
+				ScramExtensions(map[string]string{"tokenauth": "true"}),
+				tlsState,
+			)
+			server := NewServer(mech, func(n *Negotiator) bool {
+				user, pass, ident := n.Credentials()
+				return string(user) == "us,er" && pass == nil && string(ident) == "a=dmin" && n.ScramExtensions()["tokenauth"] == "true"
+			},
+				ScramExtensionSecrets(func(username []byte, ext map[string]string) (ScramCredentials, bool) {
+					return NewScramCredentials(fn, []byte("pencil"), []byte("salt"), 4096), string(username) == "us,er" && len(ext) == 1 && ext["tokenauth"] == "true"
+				}),
+				TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				TOTPCounter(func([]byte, uint64) bool { return true }),
+				Clock(func() time.Time { return time.Unix(59, 0) }),
+				RemoteMechanisms(mech.Name),
+				tlsState,
+			)
+
+			var challenge []byte
+			for {
+				more, resp, err := client.Step(challenge)
+				if err != nil {
+					t.Fatalf("Client error: %v", err)
+				}
+				if !more {
+					break
+				}
+				_, challenge, err = server.Step(resp)
+				if err != nil {
+					t.Fatalf("Server error: %v", err)
+				}
+			}
+			if server.State()&StepMask != ValidServerResponse {
+				t.Errorf("Server did not finish the exchange")
+			}
+			if user, ident := server.Authenticated(); string(user) != "us,er" || string(ident) != "a=dmin" {
+				t.Errorf("Wrong user authenticated: user=%q, identity=%q", user, ident)
+			}
+			server.Reset()
+			if user, ident := server.Authenticated(); user != nil || ident != nil {
+				t.Errorf("Authenticated user not cleared by Reset: user=%q, identity=%q", user, ident)
+			}
+		})
+	}
+}
+
+func TestScramServerEndPoint(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("cert"), SignatureAlgorithm: x509.ECDSAWithSHA384}
+	other := &x509.Certificate{Raw: []byte("other"), SignatureAlgorithm: x509.ECDSAWithSHA384}
+	for i, tc := range [...]struct {
+		serverCert *x509.Certificate
+		serverErr  error
+	}{
+		0: {serverCert: cert},
+		1: {serverCert: other, serverErr: ErrAuthn},
+		2: {serverErr: ErrAuthn},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			// There is no tls-unique channel binding with TLS 1.3.
+			client := NewClient(ScramSha256Plus,
+				Credentials(func() ([]byte, []byte, []byte) {
+					return []byte("user"), []byte("pencil"), nil
+				}),
+				RemoteMechanisms("SCRAM-SHA-256-PLUS"),
+				TLSState(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}),
+			)
+			opts := []Option{TLSState(tls.ConnectionState{})}
+			if tc.serverCert != nil {
+				opts = append(opts, ServerCertificate(tc.serverCert))
+			}
+			server := NewServer(ScramSha256Plus, acceptAll, scramServerOpts(sha256.New, "c2FsdA==", opts...)...)
+
+			var challenge []byte
+			var err error
+			for {
+				var more bool
+				var resp []byte
+				more, resp, err = client.Step(challenge)
+				if err != nil {
+					t.Fatalf("Client error: %v", err)
+				}
+				if challenge == nil && !strings.HasPrefix(string(resp), "p=tls-server-end-point,,") {
+					t.Errorf("Unexpected channel binding: %q", resp)
+				}
+				if !more {
+					break
+				}
+				_, challenge, err = server.Step(resp)
+				if err != nil {
+					break
+				}
+			}
+			if err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+func TestScramSecretsExtensions(t *testing.T) {
+	for i, tc := range [...]struct {
+		ext map[string]string
+		err error
+	}{
+		0: {},
+		1: {ext: map[string]string{"tokenauth": "true"}, err: ErrAuthn},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			client := NewClient(ScramSha256,
+				Credentials(func() ([]byte, []byte, []byte) {
+					return []byte("user"), []byte("pencil"), nil
+				}),
+				ScramExtensions(tc.ext),
+			)
+			server := NewServer(ScramSha256, func(*Negotiator) bool { return true },
+				ScramSecrets(func(username []byte) (ScramCredentials, bool) {
+					return NewScramCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096), true
+				}),
+			)
+			var err error
+			var challenge []byte
+			for {
+				var more bool
+				var resp []byte
+				more, resp, err = client.Step(challenge)
+				if err != nil || !more {
+					break
+				}
+				_, challenge, err = server.Step(resp)
+				if err != nil {
+					break
+				}
+			}
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestScramFakeCredentials(t *testing.T) {
+	serverFirst := func(user string, opts ...Option) string {
+		server := NewServer(ScramSha256, acceptAll, scramServerOpts(sha256.New, "W22ZaJ0SNY7soEsUEjb6gQ==", opts...)...)
+		server.nonce = testNonce
+		_, challenge, err := server.Step([]byte("n,,n=" + user + ",r=rOprNGfwEbeRWgbNEkqO"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return string(challenge)
+	}
+
+	nobody := serverFirst("nobody")
+	if nobody != serverFirst("nobody") {
+		t.Errorf("Fake credentials changed between attempts")
+	}
+	if nobody == serverFirst("somebody") {
+		t.Errorf("Different users got the same fake credentials")
+	}
+	h := sha256.New()
+	h.Write([]byte("nobody"))
+	if strings.Contains(nobody, base64.StdEncoding.EncodeToString(h.Sum(nil)[:16])) {
+		t.Errorf("Fake salt can be derived from the username")
+	}
+
+	secret := ScramFakeSecret([]byte("secret"))
+	shared := serverFirst("nobody", secret)
+	if shared == nobody {
+		t.Errorf("Fake secret was not used")
+	}
+	if shared != serverFirst("nobody", secret) {
+		t.Errorf("Servers sharing a secret sent different fake credentials")
+	}
+}
+
+type testKeyCache struct {
+	keys map[string][]byte
+	gets int
+	puts int
+}
+
+func (c *testKeyCache) Get(mechanism string, salt []byte, iter int) ([]byte, bool) {
+	c.gets++
+	k, ok := c.keys[mechanism+string(salt)+strconv.Itoa(iter)]
+	return k, ok
+}
+
+func (c *testKeyCache) Put(mechanism string, salt []byte, iter int, saltedPassword []byte) {
+	c.puts++
+	c.keys[mechanism+string(salt)+strconv.Itoa(iter)] = saltedPassword
+}
+
+func TestScramCache(t *testing.T) {
+	cache := &testKeyCache{keys: make(map[string][]byte)}
+	exchange := func(password string) error {
+		client := NewClient(ScramSha256,
+			Credentials(func() ([]byte, []byte, []byte) {
+				return []byte("user"), []byte(password), nil
+			}),
+			ScramCache(cache),
+		)
+		server := NewServer(ScramSha256, func(*Negotiator) bool { return true },
+			ScramSecrets(func([]byte) (ScramCredentials, bool) {
+				return NewScramCredentials(sha256.New, []byte("pencil"), []byte("salt"), 4096), true
+			}),
+		)
+		var challenge []byte
+		for {
+			more, resp, err := client.Step(challenge)
+			if err != nil || !more {
+				return err
+			}
+			if _, challenge, err = server.Step(resp); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := exchange("pencil"); err != nil {
+			t.Fatalf("Unexpected error on exchange %d: %v", i, err)
+		}
+	}
+	if cache.gets != 2 || cache.puts != 1 {
+		t.Errorf("Unexpected cache use: want 2 gets and 1 put, got=%d gets and %d puts", cache.gets, cache.puts)
+	}
+
+	// The cached key is used in place of the password.
+	if err := exchange("pen"); err != nil {
+		t.Errorf("Expected the cached key to be used, got error: %v", err)
+	}
+	cache.keys = make(map[string][]byte)
+	if err := exchange("pen"); err != ErrAuthn {
+		t.Errorf("Unexpected error with empty cache: want=%v, got=%v", ErrAuthn, err)
+	}
+}
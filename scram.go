@@ -7,9 +7,13 @@ package sasl
 import (
 	"bytes"
 	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"hash"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -17,67 +21,140 @@ import (
 )
 
 const (
-	gs2HeaderCBSupport         = "p=tls-unique,"
+	gs2HeaderCBSupport         = "p="
 	gs2HeaderNoServerCBSupport = "y,"
 	gs2HeaderNoCBSupport       = "n,"
 )
 
+// Channel binding types (RFC 5929) in the order that clients prefer them.
+const (
+	cbTLSUnique         = "tls-unique"
+	cbTLSServerEndPoint = "tls-server-end-point"
+)
+
 var (
 	clientKeyInput = []byte("Client Key")
 	serverKeyInput = []byte("Server Key")
 )
 
+// A ScramKeyCache stores the salted passwords calculated by SCRAM clients.
+// Entries are identified by the mechanism name, salt, and iteration count
+// sent by the server.
+// Implementations must be safe for concurrent use if they are shared between
+// negotiators used from multiple goroutines.
+type ScramKeyCache interface {
+	Get(mechanism string, salt []byte, iter int) (saltedPassword []byte, ok bool)
+	Put(mechanism string, salt []byte, iter int, saltedPassword []byte)
+}
+
 // The number of random bytes to generate for a nonce.
 const noncerandlen = 16
 
+// channelBinding returns the channel binding data of type typ for the TLS
+// connection that n is negotiating over, or nil if it is not available.
+func channelBinding(n *Negotiator, typ string) []byte {
+	tlsState := n.TLSState()
+	switch typ {
+	case cbTLSUnique:
+		if tlsState != nil {
+			return tlsState.TLSUnique
+		}
+	case cbTLSServerEndPoint:
+		cert := n.serverCert
+		if n.State()&Receiving != Receiving && tlsState != nil && len(tlsState.PeerCertificates) > 0 {
+			cert = tlsState.PeerCertificates[0]
+		}
+		if cert != nil {
+			return tlsServerEndPoint(cert)
+		}
+	}
+	return nil
+}
+
+// channelBindingType returns the first channel binding type that is available
+// on the connection or an empty string if there are none.
+func channelBindingType(n *Negotiator) string {
+	for _, typ := range []string{cbTLSUnique, cbTLSServerEndPoint} {
+		if len(channelBinding(n, typ)) > 0 {
+			return typ
+		}
+	}
+	return ""
+}
+
+// tlsServerEndPoint returns the hash of cert that is used as the
+// tls-server-end-point channel binding.
+// It uses the hash function of the certificates signature algorithm, or
+// SHA-256 if that is MD5 or SHA-1 (RFC 5929 section 4.1).
+func tlsServerEndPoint(cert *x509.Certificate) []byte {
+	var h hash.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		h = sha512.New384()
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		h = sha512.New()
+	default:
+		h = sha256.New()
+	}
+	/* #nosec */
+	h.Write(cert.Raw)
+	return h.Sum(nil)
+}
+
 func getGS2Header(name string, n *Negotiator) (gs2Header []byte) {
 	_, _, identity := n.Credentials()
+	cbType := channelBindingType(n)
 	switch {
-	case n.TLSState() == nil || !strings.HasSuffix(name, "-PLUS"):
+	case cbType == "" || !strings.HasSuffix(name, "-PLUS"):
 		// We do not support channel binding
 		gs2Header = []byte(gs2HeaderNoCBSupport)
 	case n.State()&RemoteCB == RemoteCB:
 		// We support channel binding and the server does too
-		gs2Header = []byte(gs2HeaderCBSupport)
+		gs2Header = append([]byte(gs2HeaderCBSupport), cbType...)
+		gs2Header = append(gs2Header, ',')
 	case n.State()&RemoteCB != RemoteCB:
 		// We support channel binding but the server does not
 		gs2Header = []byte(gs2HeaderNoServerCBSupport)
 	}
 	if len(identity) > 0 {
 		gs2Header = append(gs2Header, []byte(`a=`)...)
-		gs2Header = append(gs2Header, identity...)
+		gs2Header = append(gs2Header, scramEscape(identity)...)
 	}
 	gs2Header = append(gs2Header, ',')
 	return
 }
 
+// scramEscape escapes "=" and "," in usernames and authorization identities.
+// This is mostly the same as bytes.Replace but faster because we can do both
+// replacements in a single pass.
+func scramEscape(user []byte) []byte {
+	n := bytes.Count(user, []byte{'='}) + bytes.Count(user, []byte{','})
+	username := make([]byte, len(user)+(n*2))
+	w := 0
+	start := 0
+	for i := 0; i < n; i++ {
+		j := start
+		j += bytes.IndexAny(user[start:], "=,")
+		w += copy(username[w:], user[start:j])
+		switch user[j] {
+		case '=':
+			w += copy(username[w:], "=3D")
+		case ',':
+			w += copy(username[w:], "=2C")
+		}
+		start = j + 1
+	}
+	copy(username[w:], user[start:])
+	return username
+}
+
 func scram(name string, fn func() hash.Hash) Mechanism {
-	// BUG(ssw): We need a way to cache the SCRAM client and server key
-	// calculations.
 	return Mechanism{
 		Name: name,
 		Start: func(m *Negotiator) (bool, []byte, interface{}, error) {
 			user, _, _ := m.Credentials()
 
-			// Escape "=" and ",". This is mostly the same as bytes.Replace but
-			// faster because we can do both replacements in a single pass.
-			n := bytes.Count(user, []byte{'='}) + bytes.Count(user, []byte{','})
-			username := make([]byte, len(user)+(n*2))
-			w := 0
-			start := 0
-			for i := 0; i < n; i++ {
-				j := start
-				j += bytes.IndexAny(user[start:], "=,")
-				w += copy(username[w:], user[start:j])
-				switch user[j] {
-				case '=':
-					w += copy(username[w:], "=3D")
-				case ',':
-					w += copy(username[w:], "=2C")
-				}
-				start = j + 1
-			}
-			copy(username[w:], user[start:])
+			username := scramEscape(user)
 
 			clientFirstMessage := make([]byte, 5+len(m.Nonce())+len(username))
 			copy(clientFirstMessage, "n=")
@@ -85,6 +162,22 @@ func scram(name string, fn func() hash.Hash) Mechanism {
 			copy(clientFirstMessage[2+len(username):], ",r=")
 			copy(clientFirstMessage[5+len(username):], m.Nonce())
 
+			keys := make([]string, 0, len(m.scramExtensions))
+			for k := range m.scramExtensions {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				v := m.scramExtensions[k]
+				if k == "" || k == "m" || strings.ContainsAny(k, "=,") || strings.Contains(v, ",") {
+					return false, nil, nil, errors.New("Invalid SCRAM extension")
+				}
+				clientFirstMessage = append(clientFirstMessage, ',')
+				clientFirstMessage = append(clientFirstMessage, k...)
+				clientFirstMessage = append(clientFirstMessage, '=')
+				clientFirstMessage = append(clientFirstMessage, v...)
+			}
+
 			return true, append(getGS2Header(name, m), clientFirstMessage...), clientFirstMessage, nil
 		},
 		Next: func(m *Negotiator, challenge []byte, data interface{}) (more bool, resp []byte, cache interface{}, err error) {
@@ -93,7 +186,7 @@ func scram(name string, fn func() hash.Hash) Mechanism {
 			}
 
 			if m.State()&Receiving == Receiving {
-				panic("not yet implemented")
+				return scramServerNext(name, fn, m, challenge, data)
 			}
 			return scramClientNext(name, fn, m, challenge, data)
 		},
@@ -101,7 +194,6 @@ func scram(name string, fn func() hash.Hash) Mechanism {
 }
 
 func scramClientNext(name string, fn func() hash.Hash, m *Negotiator, challenge []byte, data interface{}) (more bool, resp []byte, cache interface{}, err error) {
-	_, password, _ := m.Credentials()
 	state := m.State()
 
 	switch state & StepMask {
@@ -153,25 +245,17 @@ func scramClientNext(name string, fn func() hash.Hash, m *Negotiator, challenge
 		}
 
 		gs2Header := getGS2Header(name, m)
-		tlsState := m.TLSState()
-		var channelBinding []byte
-		if tlsState != nil && strings.HasSuffix(name, "-PLUS") {
-			channelBinding = make(
-				[]byte,
-				2+base64.StdEncoding.EncodedLen(len(gs2Header)+len(tlsState.TLSUnique)),
-			)
-			base64.StdEncoding.Encode(channelBinding[2:], append(gs2Header, tlsState.TLSUnique...))
-			channelBinding[0] = 'c'
-			channelBinding[1] = '='
-		} else {
-			channelBinding = make(
-				[]byte,
-				2+base64.StdEncoding.EncodedLen(len(gs2Header)),
-			)
-			base64.StdEncoding.Encode(channelBinding[2:], gs2Header)
-			channelBinding[0] = 'c'
-			channelBinding[1] = '='
+		cbind := gs2Header
+		if bytes.HasPrefix(gs2Header, []byte(gs2HeaderCBSupport)) {
+			cbind = append(cbind, channelBinding(m, channelBindingType(m))...)
 		}
+		channelBinding := make(
+			[]byte,
+			2+base64.StdEncoding.EncodedLen(len(cbind)),
+		)
+		base64.StdEncoding.Encode(channelBinding[2:], cbind)
+		channelBinding[0] = 'c'
+		channelBinding[1] = '='
 		clientFinalMessageWithoutProof := append(channelBinding, []byte(",r=")...)
 		clientFinalMessageWithoutProof = append(clientFinalMessageWithoutProof, nonce...)
 
@@ -181,7 +265,21 @@ func scramClientNext(name string, fn func() hash.Hash, m *Negotiator, challenge
 		authMessage = append(authMessage, ',')
 		authMessage = append(authMessage, clientFinalMessageWithoutProof...)
 
-		saltedPassword := pbkdf2.Key(password, salt, iter, fn().Size(), fn)
+		var saltedPassword []byte
+		var ok bool
+		if m.scramCache != nil {
+			saltedPassword, ok = m.scramCache.Get(name, salt, iter)
+		}
+		if !ok {
+			user, password, _ := m.Credentials()
+			if m.scramPassword != nil {
+				password = m.scramPassword(user, password)
+			}
+			saltedPassword = pbkdf2.Key(password, salt, iter, fn().Size(), fn)
+			if m.scramCache != nil {
+				m.scramCache.Put(name, salt, iter, saltedPassword)
+			}
+		}
 
 		h := hmac.New(fn, saltedPassword)
 		_, err = h.Write(serverKeyInput)
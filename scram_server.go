@@ -0,0 +1,328 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The smallest iteration count advertised for users that do not exist.
+const scramFakeIter = 4096
+
+// The secret used to derive fake credentials if the ScramFakeSecret option is
+// not set.
+var (
+	scramFakeSecretOnce sync.Once
+	scramFakeSecret     []byte
+)
+
+// scramFakeCredentials returns the salt and iteration count that are sent for
+// a user that does not exist.
+// They are derived from a secret so that they are the same each time the user
+// tries to authenticate but cannot be predicted by an attacker, who would
+// otherwise be able to tell that the credentials were made up.
+func scramFakeCredentials(fn func() hash.Hash, m *Negotiator, user []byte) ScramCredentials {
+	secret := m.scramFakeSecret
+	if secret == nil {
+		scramFakeSecretOnce.Do(func() {
+			scramFakeSecret = make([]byte, 32)
+			if _, err := rand.Read(scramFakeSecret); err != nil {
+				panic("sasl: failed to generate SCRAM secret: " + err.Error())
+			}
+		})
+		secret = scramFakeSecret
+	}
+	h := hmac.New(fn, secret)
+	/* #nosec */
+	h.Write(user)
+	mac := h.Sum(nil)
+	return ScramCredentials{Salt: mac[:16], Iter: scramFakeIter + 1024*int(mac[16]%4)}
+}
+
+// ScramCredentials is the information that a server stores in place of a
+// users password to authenticate them with SCRAM (RFC 5802 section 3).
+// The keys must have been derived using the same hash as the mechanism.
+type ScramCredentials struct {
+	Salt      []byte
+	Iter      int
+	StoredKey []byte
+	ServerKey []byte
+}
+
+// NewScramCredentials derives the credentials that a server must store to
+// authenticate a user with the given password using a SCRAM mechanism based
+// on the hash function fn (eg. sha256.New for SCRAM-SHA-256).
+func NewScramCredentials(fn func() hash.Hash, password, salt []byte, iter int) ScramCredentials {
+	saltedPassword := pbkdf2.Key(password, salt, iter, fn().Size(), fn)
+
+	h := hmac.New(fn, saltedPassword)
+	/* #nosec */
+	h.Write(clientKeyInput)
+	clientKey := h.Sum(nil)
+	h.Reset()
+	/* #nosec */
+	h.Write(serverKeyInput)
+	serverKey := h.Sum(nil)
+
+	h = fn()
+	/* #nosec */
+	h.Write(clientKey)
+
+	return ScramCredentials{
+		Salt:      salt,
+		Iter:      iter,
+		StoredKey: h.Sum(nil),
+		ServerKey: serverKey,
+	}
+}
+
+type scramServerCache struct {
+	gs2Header   []byte
+	nonce       []byte
+	authMessage []byte
+	user        []byte
+	identity    []byte
+	ext         map[string]string
+	creds       ScramCredentials
+	known       bool
+}
+
+func scramServerNext(name string, fn func() hash.Hash, m *Negotiator, challenge []byte, data interface{}) (more bool, resp []byte, cache interface{}, err error) {
+	switch m.State() & StepMask {
+	case AuthTextSent:
+		return scramServerFirst(name, fn, m, challenge)
+	case ResponseSent:
+		c, ok := data.(scramServerCache)
+		if !ok {
+			return false, nil, nil, ErrInvalidState
+		}
+		return scramServerFinal(name, fn, m, challenge, c)
+	}
+	return false, nil, nil, ErrTooManySteps
+}
+
+func scramServerFirst(name string, fn func() hash.Hash, m *Negotiator, challenge []byte) (bool, []byte, interface{}, error) {
+	// Parts of the message are kept until the next step, so make sure that we
+	// do not hold on to a buffer that the caller may reuse.
+	challenge = append([]byte(nil), challenge...)
+
+	// gs2-header = gs2-cbind-flag "," [ authzid ] ","
+	fields := bytes.SplitN(challenge, []byte{','}, 3)
+	if len(fields) != 3 {
+		return false, nil, nil, ErrInvalidChallenge
+	}
+	plus := strings.HasSuffix(name, "-PLUS")
+	switch cb := string(fields[0]); {
+	case cb == "n":
+	case cb == "y":
+		// The client supports channel binding but thinks that we do not, so an
+		// attacker may have removed the -PLUS mechanisms from the list that it
+		// chose this mechanism from (RFC 5802 section 6).
+		for _, local := range m.localMechanisms {
+			if strings.HasSuffix(local, "-PLUS") {
+				return false, nil, nil, ErrAuthn
+			}
+		}
+	case strings.HasPrefix(cb, gs2HeaderCBSupport):
+		if !plus || len(channelBinding(m, cb[2:])) == 0 {
+			return false, nil, nil, ErrAuthn
+		}
+	default:
+		return false, nil, nil, ErrInvalidChallenge
+	}
+	var identity []byte
+	if len(fields[1]) > 0 {
+		if !bytes.HasPrefix(fields[1], []byte("a=")) {
+			return false, nil, nil, ErrInvalidChallenge
+		}
+		var err error
+		identity, err = scramUnescape(fields[1][2:])
+		if err != nil {
+			return false, nil, nil, err
+		}
+	}
+	gs2Header := challenge[:len(fields[0])+len(fields[1])+2]
+	clientFirstBare := fields[2]
+
+	var user, clientNonce []byte
+	var ext map[string]string
+	for i, field := range bytes.Split(clientFirstBare, []byte{','}) {
+		eq := bytes.IndexByte(field, '=')
+		// Extensions are supposed to have single letter names, but Kafka uses
+		// longer ones (eg. tokenauth=true).
+		if eq < 1 || (i < 2 && eq != 1) {
+			return false, nil, nil, ErrInvalidChallenge
+		}
+		switch {
+		case i == 0 && field[0] == 'n':
+			var err error
+			user, err = scramUnescape(field[2:])
+			if err != nil {
+				return false, nil, nil, err
+			}
+		case i == 1 && field[0] == 'r':
+			clientNonce = field[2:]
+		case i < 2 || (eq == 1 && field[0] == 'm'):
+			// See the comment on the reserved attribute in scramClientNext.
+			return false, nil, nil, ErrInvalidChallenge
+		default:
+			if ext == nil {
+				ext = make(map[string]string)
+			}
+			ext[string(field[:eq])] = string(field[eq+1:])
+		}
+	}
+	if len(clientNonce) == 0 {
+		return false, nil, nil, ErrInvalidChallenge
+	}
+
+	// Protocols that identify the user out of band (eg. PostgreSQL) set the
+	// username on the server and the client may leave it empty.
+	if u, _, _ := m.Credentials(); len(u) > 0 {
+		user = u
+	}
+
+	var creds ScramCredentials
+	known := false
+	if m.scramSecrets != nil {
+		creds, known = m.scramSecrets(user, ext)
+	}
+	if !known {
+		// Pretend that the user exists so that an attacker cannot use the
+		// exchange to find out which users do.
+		creds = scramFakeCredentials(fn, m, user)
+	}
+
+	nonce := make([]byte, 0, len(clientNonce)+len(m.Nonce()))
+	nonce = append(nonce, clientNonce...)
+	nonce = append(nonce, m.Nonce()...)
+
+	serverFirstMessage := make([]byte, 0, 2+len(nonce)+3+base64.StdEncoding.EncodedLen(len(creds.Salt))+3+10)
+	serverFirstMessage = append(serverFirstMessage, "r="...)
+	serverFirstMessage = append(serverFirstMessage, nonce...)
+	serverFirstMessage = append(serverFirstMessage, ",s="...)
+	serverFirstMessage = append(serverFirstMessage, base64.StdEncoding.EncodeToString(creds.Salt)...)
+	serverFirstMessage = append(serverFirstMessage, ",i="...)
+	serverFirstMessage = strconv.AppendInt(serverFirstMessage, int64(creds.Iter), 10)
+
+	authMessage := make([]byte, 0, len(clientFirstBare)+1+len(serverFirstMessage))
+	authMessage = append(authMessage, clientFirstBare...)
+	authMessage = append(authMessage, ',')
+	authMessage = append(authMessage, serverFirstMessage...)
+
+	return true, serverFirstMessage, scramServerCache{
+		gs2Header:   gs2Header,
+		nonce:       nonce,
+		authMessage: authMessage,
+		user:        user,
+		identity:    identity,
+		ext:         ext,
+		creds:       creds,
+		known:       known,
+	}, nil
+}
+
+func scramServerFinal(name string, fn func() hash.Hash, m *Negotiator, challenge []byte, c scramServerCache) (bool, []byte, interface{}, error) {
+	idx := bytes.LastIndex(challenge, []byte(",p="))
+	if idx < 0 {
+		return false, nil, nil, ErrInvalidChallenge
+	}
+	clientFinalMessageWithoutProof := challenge[:idx]
+	proof, err := base64.StdEncoding.DecodeString(string(challenge[idx+3:]))
+	if err != nil {
+		return false, nil, nil, ErrInvalidChallenge
+	}
+
+	fields := bytes.Split(clientFinalMessageWithoutProof, []byte{','})
+	if len(fields) < 2 || !bytes.HasPrefix(fields[0], []byte("c=")) || !bytes.HasPrefix(fields[1], []byte("r=")) {
+		return false, nil, nil, ErrInvalidChallenge
+	}
+	for _, field := range fields[2:] {
+		if bytes.HasPrefix(field, []byte("m=")) {
+			return false, nil, nil, ErrInvalidChallenge
+		}
+	}
+
+	cbind := c.gs2Header
+	if bytes.HasPrefix(cbind, []byte(gs2HeaderCBSupport)) {
+		cbType := string(cbind[2:bytes.IndexByte(cbind, ',')])
+		cbind = append(append([]byte(nil), cbind...), channelBinding(m, cbType)...)
+	}
+	if string(fields[0][2:]) != base64.StdEncoding.EncodeToString(cbind) {
+		return false, nil, nil, ErrAuthn
+	}
+	if !bytes.Equal(fields[1][2:], c.nonce) {
+		return false, nil, nil, ErrAuthn
+	}
+
+	authMessage := append(c.authMessage, ',')
+	authMessage = append(authMessage, clientFinalMessageWithoutProof...)
+
+	if !c.known || len(proof) != len(c.creds.StoredKey) {
+		return false, nil, nil, ErrAuthn
+	}
+	h := hmac.New(fn, c.creds.StoredKey)
+	/* #nosec */
+	h.Write(authMessage)
+	clientSignature := h.Sum(nil)
+	clientKey := make([]byte, len(proof))
+	xorBytes(clientKey, proof, clientSignature)
+	h = fn()
+	/* #nosec */
+	h.Write(clientKey)
+	if !hmac.Equal(h.Sum(nil), c.creds.StoredKey) {
+		return false, nil, nil, ErrAuthn
+	}
+
+	// The password has been verified, give the server a chance to check that the
+	// user may act as the authorization identity.
+	if !m.Permissions(Credentials(func() (Username, Password, Identity []byte) {
+		return c.user, nil, c.identity
+	}), ScramExtensions(c.ext)) {
+		return false, nil, nil, ErrAuthn
+	}
+
+	h = hmac.New(fn, c.creds.ServerKey)
+	/* #nosec */
+	h.Write(authMessage)
+	return false, []byte("v=" + base64.StdEncoding.EncodeToString(h.Sum(nil))), nil, nil
+}
+
+var errScramUsername = errors.New("Invalid escape sequence in SCRAM username")
+
+// scramUnescape reverses the escaping of "," and "=" in usernames and
+// authorization identities.
+func scramUnescape(b []byte) ([]byte, error) {
+	if bytes.IndexByte(b, '=') < 0 {
+		return b, nil
+	}
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != '=' {
+			out = append(out, b[i])
+			continue
+		}
+		switch {
+		case bytes.HasPrefix(b[i:], []byte("=2C")):
+			out = append(out, ',')
+		case bytes.HasPrefix(b[i:], []byte("=3D")):
+			out = append(out, '=')
+		default:
+			return nil, errScramUsername
+		}
+		i += 2
+	}
+	return out, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package smtp implements SASL authentication for SMTP as defined in RFC 4954.
+//
+// Clients that use the standard library net/smtp package can wrap any SASL
+// mechanism with Auth and pass the result to smtp.Client.Auth.
+// Servers can handle the AUTH command with Authenticate.
+package smtp
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/smtp"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Errors returned by the SMTP client.
+var (
+	ErrUnsupportedMechanism = errors.New("Mechanism not advertised by server")
+)
+
+type auth struct {
+	mechanism sasl.Mechanism
+	tlsState  *tls.ConnectionState
+	opts      []sasl.Option
+	client    *sasl.Negotiator
+	more      bool
+}
+
+// Auth returns an smtp.Auth that authenticates using the mechanism m.
+//
+// A new client Negotiator is created with opts every time authentication is
+// started and the mechanisms advertised by the server are used as its remote
+// mechanisms.
+// If the connection is encrypted and tlsState is not nil, it is used for
+// channel binding.
+// The returned value may be reused but must not be used to authenticate
+// multiple connections concurrently.
+func Auth(m sasl.Mechanism, tlsState *tls.ConnectionState, opts ...sasl.Option) smtp.Auth {
+	return &auth{
+		mechanism: m,
+		tlsState:  tlsState,
+		opts:      opts,
+	}
+}
+
+func (a *auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if len(server.Auth) > 0 {
+		var found bool
+		for _, name := range server.Auth {
+			if name == a.mechanism.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", nil, ErrUnsupportedMechanism
+		}
+	}
+
+	opts := make([]sasl.Option, 0, len(a.opts)+2)
+	opts = append(opts, a.opts...)
+	opts = append(opts, sasl.RemoteMechanisms(server.Auth...))
+	if server.TLS && a.tlsState != nil {
+		opts = append(opts, sasl.TLSState(*a.tlsState))
+	}
+	a.client = sasl.NewClient(a.mechanism, opts...)
+
+	more, resp, err := a.client.Step(nil)
+	if err != nil {
+		return "", nil, err
+	}
+	a.more = more
+	return a.mechanism.Name, resp, nil
+}
+
+func (a *auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if a.client == nil {
+		return nil, sasl.ErrInvalidState
+	}
+	if !more {
+		if a.more {
+			return nil, sasl.ErrUnexpectedSuccess
+		}
+		return nil, nil
+	}
+
+	var (
+		resp []byte
+		err  error
+	)
+	a.more, resp, err = a.client.Step(fromServer)
+	if err != nil {
+		return nil, err
+	}
+	// A nil response would end the exchange in net/smtp, but the server is still
+	// waiting for a (possibly empty) line.
+	if resp == nil {
+		resp = []byte{}
+	}
+	return resp, nil
+}
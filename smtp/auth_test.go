@@ -0,0 +1,190 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package smtp_test
+
+import (
+	"encoding/base64"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	saslsmtp "github.com/whenspeakteam/sasl/smtp"
+)
+
+var secret = []byte("12345678901234567890")
+
+func clock() time.Time {
+	return time.Unix(59, 0)
+}
+
+// fakeServer is a minimal SMTP server that only knows enough to authenticate
+// a single client.
+func fakeServer(conn net.Conn, server *sasl.Negotiator, mechs string) {
+	tp := textproto.NewConn(conn)
+	defer tp.Close()
+
+	tp.PrintfLine("220 localhost ESMTP")
+	if _, err := tp.ReadLine(); err != nil {
+		return
+	}
+	tp.PrintfLine("250-localhost")
+	tp.PrintfLine("250 AUTH %s", mechs)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(line)
+	var resp []byte
+	if len(fields) > 2 {
+		resp, err = base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			tp.PrintfLine("501 5.5.2 Bad base64")
+			return
+		}
+	}
+	for {
+		more, challenge, err := server.Step(resp)
+		if err != nil {
+			tp.PrintfLine("535 5.7.8 Authentication credentials invalid")
+			break
+		}
+		if !more && len(challenge) == 0 {
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+			break
+		}
+		// Additional data with success is sent as a final challenge.
+		tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString(challenge))
+		line, err = tp.ReadLine()
+		if err != nil || line == "*" {
+			return
+		}
+		if !more {
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+			break
+		}
+		resp, err = base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return
+		}
+	}
+
+	if line, err = tp.ReadLine(); err == nil && line == "QUIT" {
+		tp.PrintfLine("221 2.0.0 Bye")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		advertised string
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		code       int
+		err        error
+	}{
+		0: {
+			mech:       sasl.Plain,
+			advertised: "SCRAM-SHA-1 PLAIN",
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		1: {
+			mech:       sasl.Plain,
+			advertised: "PLAIN",
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			code:       535,
+		},
+		2: {
+			mech:       sasl.Plain,
+			advertised: "SCRAM-SHA-1",
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			err:        saslsmtp.ErrUnsupportedMechanism,
+		},
+		3: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			advertised: "PLAIN",
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return secret }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(clock),
+			},
+		},
+		4: {
+			// The client cancels the exchange when the server signature is wrong.
+			mech:       sasl.ScramSha256,
+			advertised: "SCRAM-SHA-256",
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			err:        sasl.ErrAuthn,
+		},
+		5: {
+			mech:       sasl.ScramSha256,
+			advertised: "SCRAM-SHA-256",
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			server := sasl.NewServer(mech, perm, tc.serverOpts...)
+			go fakeServer(serverConn, server, tc.advertised)
+
+			c, err := smtp.NewClient(clientConn, "localhost")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			err = c.Auth(saslsmtp.Auth(tc.mech, nil, tc.clientOpts...))
+			switch {
+			case tc.code != 0:
+				if e, ok := err.(*textproto.Error); !ok || e.Code != tc.code {
+					t.Fatalf("Expected SMTP error %d, got %v", tc.code, err)
+				}
+				return
+			case err != tc.err:
+				t.Fatalf("Unexpected error: want=%v, got=%v", tc.err, err)
+			case err != nil:
+				return
+			}
+			if err = c.Quit(); err != nil {
+				t.Errorf("Error quitting: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnexpectedSuccess(t *testing.T) {
+	a := saslsmtp.Auth(sasl.WithTOTP(sasl.Plain), nil, sasltest.Creds("user", "pencil"))
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "localhost", Auth: []string{"PLAIN"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Next([]byte("2.7.0 Authentication successful"), false); err != sasl.ErrUnexpectedSuccess {
+		t.Errorf("Unexpected error: want=%v, got=%v", sasl.ErrUnexpectedSuccess, err)
+	}
+}
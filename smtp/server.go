@@ -0,0 +1,119 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package smtp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/wire"
+)
+
+// RFC 4954 requires that servers accept lines of at least 12288 octets
+// (excluding the CRLF) during an AUTH exchange.
+const maxLineLen = 12288
+
+// Errors returned by the SMTP server.
+var (
+	ErrAborted          = errors.New("Authentication aborted by client")
+	ErrUnknownMechanism = errors.New("Unrecognized authentication type")
+	ErrSyntax           = errors.New("Syntax error in AUTH command")
+	ErrLineTooLong      = wire.ErrLineTooLong
+)
+
+// Authenticate handles an SMTP AUTH command on behalf of a server.
+//
+// The line argument is the AUTH command line that was already read by the
+// caller, without the trailing CRLF.
+// The mechanism named in the command is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported.
+// Authenticate then runs the 334 continuation loop over rw and writes the
+// final 235 or error reply.
+//
+// If the client sends "*" the exchange is aborted and ErrAborted is returned.
+// Any other error means that authentication failed and a reply has already
+// been written unless the error came from rw.
+// Authenticate never reads past the end of the last line of the exchange.
+func Authenticate(rw io.ReadWriter, line string, negotiator func(mechanism string) *sasl.Negotiator) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 || !strings.EqualFold(fields[0], "AUTH") {
+		return wire.Reply(rw, ErrSyntax, "501 5.5.2 Syntax error")
+	}
+
+	server := negotiator(strings.ToUpper(fields[1]))
+	if server == nil {
+		return wire.Reply(rw, ErrUnknownMechanism, "504 5.5.4 Unrecognized authentication type")
+	}
+
+	var resp []byte
+	var err error
+	switch {
+	case len(fields) == 2:
+		// No initial response, send an empty challenge to ask for one.
+		resp, err = challenge(rw, nil)
+	case fields[2] == "=":
+		resp = []byte{}
+	default:
+		resp, err = wire.Decode([]byte(fields[2]))
+		if err != nil {
+			return wire.Reply(rw, err, "501 5.5.2 Cannot decode response")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		more, data, err := server.Step(resp)
+		if err != nil {
+			return wire.Reply(rw, err, "535 5.7.8 Authentication credentials invalid")
+		}
+		if !more {
+			// SMTP has no way to send additional data with the success reply so it
+			// is sent as a challenge and the client must respond with an empty line.
+			if len(data) > 0 {
+				resp, err = challenge(rw, data)
+				if err != nil {
+					return err
+				}
+				if len(resp) != 0 {
+					return wire.Reply(rw, sasl.ErrInvalidChallenge, "535 5.7.8 Authentication credentials invalid")
+				}
+			}
+			return wire.Reply(rw, nil, "235 2.7.0 Authentication successful")
+		}
+		resp, err = challenge(rw, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// challenge writes a 334 reply and reads and decodes the clients response.
+func challenge(rw io.ReadWriter, data []byte) ([]byte, error) {
+	if err := wire.WriteLine(rw, "334 %s", wire.Encode(data)); err != nil {
+		return nil, err
+	}
+	line, err := wire.ReadLine(rw, maxLineLen)
+	switch err {
+	case nil:
+	case wire.ErrLineTooLong:
+		return nil, wire.Reply(rw, ErrLineTooLong, "500 5.5.6 Authentication Exchange line is too long")
+	default:
+		return nil, err
+	}
+	if bytes.Equal(line, []byte("*")) {
+		return nil, wire.Reply(rw, ErrAborted, "501 5.0.0 Authentication aborted")
+	}
+	resp, err := wire.Decode(line)
+	if err != nil {
+		return nil, wire.Reply(rw, err, "501 5.5.2 Cannot decode response")
+	}
+	return resp, nil
+}
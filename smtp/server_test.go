@@ -0,0 +1,164 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package smtp_test
+
+import (
+	"encoding/base64"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	saslsmtp "github.com/whenspeakteam/sasl/smtp"
+)
+
+var plainResp = base64.StdEncoding.EncodeToString([]byte("\x00user\x00pencil"))
+
+func plainServer(mech string) *sasl.Negotiator {
+	if mech != "PLAIN" {
+		return nil
+	}
+	return sasl.NewServer(sasl.Plain, sasltest.CheckPass)
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		line   string
+		script []string
+		err    error
+	}{
+		0: {
+			line:   "AUTH PLAIN " + plainResp,
+			script: []string{"S: 235 2.7.0 Authentication successful"},
+		},
+		1: {
+			line:   "auth plain " + plainResp,
+			script: []string{"S: 235 2.7.0 Authentication successful"},
+		},
+		2: {
+			line:   "AUTH PLAIN =",
+			script: []string{"S: 535 5.7.8 Authentication credentials invalid"},
+			err:    sasl.ErrInvalidChallenge,
+		},
+		3: {
+			line: "AUTH PLAIN",
+			script: []string{
+				"S: 334 ",
+				"C: " + plainResp,
+				"S: 235 2.7.0 Authentication successful",
+			},
+		},
+		4: {
+			line: "AUTH PLAIN",
+			script: []string{
+				"S: 334 ",
+				"C: *",
+				"S: 501 5.0.0 Authentication aborted",
+			},
+			err: saslsmtp.ErrAborted,
+		},
+		5: {
+			line:   "AUTH FOO",
+			script: []string{"S: 504 5.5.4 Unrecognized authentication type"},
+			err:    saslsmtp.ErrUnknownMechanism,
+		},
+		6: {
+			line:   "AUTH",
+			script: []string{"S: 501 5.5.2 Syntax error"},
+			err:    saslsmtp.ErrSyntax,
+		},
+		7: {
+			line:   "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00user\x00pen")),
+			script: []string{"S: 535 5.7.8 Authentication credentials invalid"},
+			err:    sasl.ErrAuthn,
+		},
+		8: {
+			line: "AUTH PLAIN",
+			script: []string{
+				"S: 334 ",
+				"C: " + strings.Repeat("A", 20000),
+				"S: 500 5.5.6 Authentication Exchange line is too long",
+			},
+			err: saslsmtp.ErrLineTooLong,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			errs := make(chan error, 1)
+			go func() {
+				errs <- saslsmtp.Authenticate(serverConn, tc.line, plainServer)
+				serverConn.Close()
+			}()
+
+			tp := textproto.NewConn(clientConn)
+			for _, l := range tc.script {
+				switch l[:3] {
+				case "C: ":
+					if err := tp.PrintfLine("%s", l[3:]); err != nil {
+						t.Fatal(err)
+					}
+				case "S: ":
+					got, err := tp.ReadLine()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != l[3:] {
+						t.Fatalf("Unexpected reply: want=%q, got=%q", l[3:], got)
+					}
+				}
+			}
+			if err := <-errs; err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	mech := sasl.WithTOTP(sasl.Plain)
+	clientConn, serverConn := net.Pipe()
+	errs := make(chan error, 1)
+	go func() {
+		tp := textproto.NewConn(serverConn)
+		defer tp.Close()
+		tp.PrintfLine("220 localhost ESMTP")
+		tp.ReadLine()
+		tp.PrintfLine("250-localhost")
+		tp.PrintfLine("250 AUTH PLAIN")
+		line, err := tp.ReadLine()
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- saslsmtp.Authenticate(serverConn, line, func(string) *sasl.Negotiator {
+			return sasl.NewServer(mech, sasltest.CheckPass,
+				sasl.TOTPSecrets(func([]byte) []byte { return secret }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(clock),
+			)
+		})
+	}()
+
+	c, err := smtp.NewClient(clientConn, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	err = c.Auth(saslsmtp.Auth(mech, nil,
+		sasltest.Creds("user", "pencil"),
+		sasl.TOTPCode(func() []byte { return []byte("287082") }),
+	))
+	if err != nil {
+		t.Errorf("Unexpected client error: %v", err)
+	}
+	if err = <-errs; err != nil {
+		t.Errorf("Unexpected server error: %v", err)
+	}
+}
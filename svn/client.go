@@ -0,0 +1,150 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package svn
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// ReadAuthRequest reads the auth-request that the server sends after the
+// greeting and returns the mechanisms, which can be passed to the
+// sasl.RemoteMechanisms option, and the realm.
+//
+// If the mechanism list is empty no authentication is required.
+// If the server responds with failure it is returned as an *Error.
+func ReadAuthRequest(r io.Reader) (mechanisms []string, realm string, err error) {
+	it, err := readItem(r)
+	if err != nil {
+		return nil, "", err
+	}
+	status, params, err := response(it)
+	if err != nil {
+		return nil, "", err
+	}
+	switch {
+	case status == "failure":
+		return nil, "", parseFailure(params)
+	case status != "success" || len(params.list) < 2:
+		return nil, "", ErrUnexpectedResponse
+	case params.list[0].kind != itemList || params.list[1].kind != itemString:
+		return nil, "", ErrMalformed
+	}
+	for _, m := range params.list[0].list {
+		if m.kind != itemWord {
+			return nil, "", ErrMalformed
+		}
+		mechanisms = append(mechanisms, m.word)
+	}
+	return mechanisms, string(params.list[1].str), nil
+}
+
+// Authenticate runs the exchange using client after ReadAuthRequest until the
+// server responds with success or failure.
+//
+// If the server responds with failure it is returned as an *Error.
+// The protocol has no way to abort authentication, so if the negotiator
+// returns an error it is returned immediately and the caller should close the
+// connection.
+func Authenticate(rw io.ReadWriter, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+	name := client.Mechanism().Name
+	token := list()
+	if resp != nil {
+		token = list(str(encodeToken(name, resp)))
+	}
+	if err = writeItem(rw, list(word(name), token)); err != nil {
+		return err
+	}
+
+	for {
+		it, err := readItem(rw)
+		if err != nil {
+			return err
+		}
+		status, params, err := response(it)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case "step":
+		case "success":
+			data, err := optionalToken(params)
+			if err != nil {
+				return err
+			}
+			if more && data != nil {
+				data, err = decodeToken(name, data)
+				if err != nil {
+					return err
+				}
+				more, _, err = client.Step(data)
+				if err != nil {
+					return err
+				}
+			}
+			if more {
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		case "failure":
+			return parseFailure(params)
+		default:
+			return ErrUnexpectedResponse
+		}
+
+		challenge, err := optionalToken(params)
+		if err != nil {
+			return err
+		}
+		if challenge, err = decodeToken(name, challenge); err != nil {
+			return err
+		}
+		more, resp, err = client.Step(challenge)
+		if err != nil {
+			return err
+		}
+		if err = writeItem(rw, str(encodeToken(name, resp))); err != nil {
+			return err
+		}
+	}
+}
+
+// response splits a response such as ( success ( ... ) ) into its status word
+// and parameter list.
+// A missing parameter list is treated as empty.
+func response(it item) (string, item, error) {
+	if it.kind != itemList || len(it.list) == 0 || len(it.list) > 2 || it.list[0].kind != itemWord {
+		return "", item{}, ErrMalformed
+	}
+	if len(it.list) == 1 {
+		return it.list[0].word, list(), nil
+	}
+	if it.list[1].kind != itemList {
+		return "", item{}, ErrMalformed
+	}
+	return it.list[0].word, it.list[1], nil
+}
+
+// parseFailure returns the error from the parameters of a failure, which may
+// either be an authentication failure ( message ) or a list of command errors
+// ( ( apr-err message file line ) ... ).
+func parseFailure(params item) error {
+	if len(params.list) == 0 {
+		return &Error{}
+	}
+	it := params.list[0]
+	if it.kind == itemList && len(it.list) > 1 {
+		it = it.list[1]
+	}
+	if it.kind != itemString {
+		return ErrMalformed
+	}
+	return &Error{Message: string(it.str)}
+}
@@ -0,0 +1,113 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package svn
+
+import (
+	"io"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// HandleAuth sends an auth-request on behalf of a server and authenticates the
+// client.
+//
+// The caller should already have exchanged the greeting.
+// The mechanism chosen by the client is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported.
+// If the client does not send an initial response an empty challenge is sent
+// to ask for one.
+// Any additional data returned by the negotiator on success is sent with the
+// success response.
+// The caller should follow a successful exchange with the repository
+// information.
+func HandleAuth(rw io.ReadWriter, mechanisms []string, realm string, negotiator func(mechanism string) *sasl.Negotiator) error {
+	mechs := make([]item, 0, len(mechanisms))
+	for _, m := range mechanisms {
+		mechs = append(mechs, word(m))
+	}
+	if err := writeItem(rw, list(word("success"), list(list(mechs...), str([]byte(realm))))); err != nil {
+		return err
+	}
+
+	it, err := readItem(rw)
+	if err != nil {
+		return err
+	}
+	if it.kind != itemList || len(it.list) != 2 || it.list[0].kind != itemWord {
+		return writeFailure(rw, "Malformed auth response", ErrMalformed)
+	}
+	name := it.list[0].word
+	resp, err := optionalToken(it.list[1])
+	if err != nil {
+		return writeFailure(rw, "Malformed auth response", err)
+	}
+
+	var server *sasl.Negotiator
+	for _, m := range mechanisms {
+		if m == name {
+			server = negotiator(m)
+			break
+		}
+	}
+	if server == nil {
+		return writeFailure(rw, ErrUnknownMechanism.Error(), ErrUnknownMechanism)
+	}
+
+	if resp == nil {
+		// No initial response, send an empty challenge to ask for one.
+		resp, err = challenge(rw, name, nil)
+		if err != nil {
+			return err
+		}
+	} else if resp, err = decodeToken(name, resp); err != nil {
+		return writeFailure(rw, "Malformed auth response", err)
+	}
+	for {
+		more, data, err := server.Step(resp)
+		switch {
+		case err != nil:
+			return writeFailure(rw, "Authentication failed", err)
+		case !more:
+			token := list()
+			if data != nil {
+				token = list(str(encodeToken(name, data)))
+			}
+			return writeItem(rw, list(word("success"), token))
+		}
+		resp, err = challenge(rw, name, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// challenge sends a step and reads the clients token.
+func challenge(rw io.ReadWriter, name string, data []byte) ([]byte, error) {
+	if err := writeItem(rw, list(word("step"), list(str(encodeToken(name, data))))); err != nil {
+		return nil, err
+	}
+	it, err := readItem(rw)
+	if err != nil {
+		return nil, err
+	}
+	if it.kind != itemString {
+		return nil, writeFailure(rw, "Malformed auth response", ErrMalformed)
+	}
+	resp, err := decodeToken(name, it.str)
+	if err != nil {
+		return nil, writeFailure(rw, "Malformed auth response", err)
+	}
+	return resp, nil
+}
+
+// writeFailure sends a failure with msg and returns err, or the write error if
+// there was one.
+func writeFailure(w io.Writer, msg string, err error) error {
+	if werr := writeItem(w, list(word("failure"), list(str([]byte(msg))))); werr != nil {
+		return werr
+	}
+	return err
+}
@@ -0,0 +1,272 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package svn implements SASL authentication for the Subversion svn://
+// (ra_svn) protocol.
+//
+// After the greeting the server sends an auth-request listing its mechanisms
+// and realm.
+// The client responds with ( mech ( token ) ) and the server answers with
+// ( step ( token ) ), to which the client responds with a bare token, until
+// it sends ( success ( token ) ) or ( failure ( message ) ).
+// As in the reference client and Cyrus SASL based servers, tokens are base64
+// encoded for every mechanism except CRAM-MD5.
+//
+// ReadAuthRequest and Authenticate drive a client Negotiator and HandleAuth
+// drives a server Negotiator.
+// Neither reads past the end of the exchange.
+package svn
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Errors returned by the client and server.
+var (
+	ErrUnknownMechanism   = errors.New("Must authenticate with listed mechanism")
+	ErrUnexpectedResponse = errors.New("Unexpected response")
+	ErrMalformed          = errors.New("Malformed item")
+	ErrItemTooLong        = errors.New("Item too long")
+)
+
+// Error is returned by the client when the server responds with failure.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "svn: " + e.Message
+}
+
+const (
+	// maxStringLen is the longest string we will read.
+	// Tokens and realms are much shorter than this.
+	maxStringLen = 64 * 1024
+
+	// maxListLen is the largest number of items in a list and maxDepth is the
+	// maximum nesting of lists.
+	maxListLen = 256
+	maxDepth   = 8
+)
+
+type itemKind uint8
+
+const (
+	itemWord itemKind = iota
+	itemNumber
+	itemString
+	itemList
+)
+
+// item is a word, number, string or list of items.
+type item struct {
+	kind itemKind
+	word string
+	num  uint64
+	str  []byte
+	list []item
+}
+
+func word(w string) item {
+	return item{kind: itemWord, word: w}
+}
+
+func str(s []byte) item {
+	return item{kind: itemString, str: s}
+}
+
+func list(items ...item) item {
+	return item{kind: itemList, list: items}
+}
+
+// appendItem appends the encoding of it followed by a space.
+func appendItem(b []byte, it item) []byte {
+	switch it.kind {
+	case itemWord:
+		b = append(b, it.word...)
+	case itemNumber:
+		b = strconv.AppendUint(b, it.num, 10)
+	case itemString:
+		b = strconv.AppendInt(b, int64(len(it.str)), 10)
+		b = append(b, ':')
+		b = append(b, it.str...)
+	case itemList:
+		b = append(b, "( "...)
+		for _, i := range it.list {
+			b = appendItem(b, i)
+		}
+		b = append(b, ')')
+	}
+	return append(b, ' ')
+}
+
+func writeItem(w io.Writer, it item) error {
+	_, err := w.Write(appendItem(nil, it))
+	return err
+}
+
+// readItem reads a single item and the whitespace that follows it.
+// The item is read a byte at a time so that nothing after it is consumed.
+func readItem(r io.Reader) (item, error) {
+	c, err := readByte(r)
+	if err != nil {
+		return item{}, err
+	}
+	for isSpace(c) {
+		if c, err = readByte(r); err != nil {
+			return item{}, err
+		}
+	}
+	return readItemStart(r, c, 0)
+}
+
+func readItemStart(r io.Reader, c byte, depth int) (item, error) {
+	var err error
+	switch {
+	case c == '(':
+		if depth == maxDepth {
+			return item{}, ErrMalformed
+		}
+		if c, err = readByte(r); err != nil {
+			return item{}, err
+		}
+		if !isSpace(c) {
+			return item{}, ErrMalformed
+		}
+		it := item{kind: itemList}
+		for {
+			if c, err = readByte(r); err != nil {
+				return item{}, err
+			}
+			if isSpace(c) {
+				continue
+			}
+			if c == ')' {
+				break
+			}
+			if len(it.list) == maxListLen {
+				return item{}, ErrItemTooLong
+			}
+			child, err := readItemStart(r, c, depth+1)
+			if err != nil {
+				return item{}, err
+			}
+			it.list = append(it.list, child)
+		}
+		return it, readSpace(r)
+	case c >= '0' && c <= '9':
+		n := uint64(c - '0')
+		for {
+			if c, err = readByte(r); err != nil {
+				return item{}, err
+			}
+			if c < '0' || c > '9' {
+				break
+			}
+			if n > (1<<63)/10 {
+				return item{}, ErrMalformed
+			}
+			n = n*10 + uint64(c-'0')
+		}
+		switch {
+		case isSpace(c):
+			return item{kind: itemNumber, num: n}, nil
+		case c != ':':
+			return item{}, ErrMalformed
+		case n > maxStringLen:
+			return item{}, ErrItemTooLong
+		}
+		s := make([]byte, n)
+		if _, err = io.ReadFull(r, s); err != nil {
+			return item{}, err
+		}
+		return str(s), readSpace(r)
+	case isAlpha(c):
+		w := []byte{c}
+		for {
+			if c, err = readByte(r); err != nil {
+				return item{}, err
+			}
+			if isSpace(c) {
+				return word(string(w)), nil
+			}
+			if !isAlpha(c) && (c < '0' || c > '9') && c != '-' {
+				return item{}, ErrMalformed
+			}
+			if len(w) == maxStringLen {
+				return item{}, ErrItemTooLong
+			}
+			w = append(w, c)
+		}
+	}
+	return item{}, ErrMalformed
+}
+
+func readByte(r io.Reader) (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readSpace reads the whitespace that terminates an item.
+func readSpace(r io.Reader) error {
+	c, err := readByte(r)
+	if err != nil {
+		return err
+	}
+	if !isSpace(c) {
+		return ErrMalformed
+	}
+	return nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n'
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// encodeToken encodes data for the mechanism.
+func encodeToken(mechanism string, data []byte) []byte {
+	if mechanism == "CRAM-MD5" {
+		return data
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(out, data)
+	return out
+}
+
+// decodeToken decodes data for the mechanism.
+func decodeToken(mechanism string, data []byte) ([]byte, error) {
+	if mechanism == "CRAM-MD5" {
+		return data, nil
+	}
+	out := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(out, data)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return out[:n], nil
+}
+
+// optionalToken returns the string in an optional tuple, eg. ( ) or
+// ( 4:abcd ), or nil if it is empty.
+func optionalToken(it item) ([]byte, error) {
+	switch {
+	case it.kind != itemList || len(it.list) > 1:
+		return nil, ErrMalformed
+	case len(it.list) == 0:
+		return nil, nil
+	case it.list[0].kind != itemString:
+		return nil, ErrMalformed
+	}
+	return it.list[0].str, nil
+}
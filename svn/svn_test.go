@@ -0,0 +1,284 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package svn_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/svn"
+)
+
+// finalData is a mechanism with no initial response where the server sends
+// additional data with its success.
+var finalData = sasl.Mechanism{
+	Name: "X-FINAL",
+	Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+		return true, nil, nil, nil
+	},
+	Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		if n.State()&sasl.Receiving == sasl.Receiving {
+			if len(challenge) != 0 {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, []byte("goodbye"), nil, nil
+		}
+		if n.State()&sasl.StepMask == sasl.AuthTextSent {
+			return true, []byte{}, nil, nil
+		}
+		if string(challenge) != "goodbye" {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, nil, nil, nil
+	},
+}
+
+func TestAuthenticate(t *testing.T) {
+	const realm = "<svn://example.net:3690> 0123-4567"
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		clientErr  error
+		serverErr  error
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		1: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			clientErr:  &svn.Error{Message: "Authentication failed"},
+			serverErr:  sasl.ErrAuthn,
+		},
+		2: {
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+		},
+		3: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+		},
+		4: {
+			// There is no way to abort, so the client just hangs up.
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  io.EOF,
+		},
+		5: {
+			mech: finalData,
+		},
+		6: {
+			mech:      sasl.ScramSha1,
+			clientErr: &svn.Error{Message: "Must authenticate with listed mechanism"},
+			serverErr: svn.ErrUnknownMechanism,
+		},
+		7: {
+			// The signature is sent with the success so only the client notices that
+			// it is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			offered := []string{"SCRAM-SHA-256", "PLAIN", "X-FINAL"}
+			errs := make(chan error, 1)
+			go func() {
+				defer serverConn.Close()
+				errs <- svn.HandleAuth(serverConn, offered, realm, func(name string) *sasl.Negotiator {
+					if name != tc.mech.Name {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+			}()
+
+			mechs, gotRealm, err := svn.ReadAuthRequest(clientConn)
+			if err != nil {
+				t.Fatalf("Unexpected error reading auth request: %v", err)
+			}
+			if !reflect.DeepEqual(mechs, offered) {
+				t.Errorf("Wrong mechanisms: want=%v, got=%v", offered, mechs)
+			}
+			if gotRealm != realm {
+				t.Errorf("Wrong realm: want=%q, got=%q", realm, gotRealm)
+			}
+			opts := append([]sasl.Option{sasl.RemoteMechanisms(mechs...)}, tc.clientOpts...)
+			err = svn.Authenticate(clientConn, sasl.NewClient(tc.mech, opts...))
+			clientConn.Close()
+			if !reflect.DeepEqual(err, tc.clientErr) {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+func TestClientInput(t *testing.T) {
+	for i, tc := range [...]struct {
+		in  string
+		out string
+		err error
+	}{
+		0: {
+			in:  "( success ( ) ) ",
+			out: "( PLAIN ( 16:AHVzZXIAcGVuY2ls ) ) ",
+		},
+		1: {
+			// Whitespace may be a newline and the parameters may be left off.
+			in:  "(\nsuccess\n)\n",
+			out: "( PLAIN ( 16:AHVzZXIAcGVuY2ls ) ) ",
+		},
+		2: {
+			in:  "( failure ( ( 170001 20:Authorization failed 9:auth.c:42 42 ) ) ) ",
+			out: "( PLAIN ( 16:AHVzZXIAcGVuY2ls ) ) ",
+			err: &svn.Error{Message: "Authorization failed"},
+		},
+		3: {
+			in:  "( success ( 99999999:toolong ) ) ",
+			out: "( PLAIN ( 16:AHVzZXIAcGVuY2ls ) ) ",
+			err: svn.ErrItemTooLong,
+		},
+		4: {
+			in:  "( success (x) ) ",
+			out: "( PLAIN ( 16:AHVzZXIAcGVuY2ls ) ) ",
+			err: svn.ErrMalformed,
+		},
+		5: {
+			in:  "( done ( ) ) ",
+			out: "( PLAIN ( 16:AHVzZXIAcGVuY2ls ) ) ",
+			err: svn.ErrUnexpectedResponse,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: bytes.NewReader([]byte(tc.in)),
+				Writer: &out,
+			}
+			err := svn.Authenticate(rw, sasl.NewClient(sasl.Plain, sasltest.Creds("user", "pencil")))
+			if !reflect.DeepEqual(err, tc.err) {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if out.String() != tc.out {
+				t.Errorf("Unexpected output:\nwant=%q\n got=%q", tc.out, out.String())
+			}
+		})
+	}
+}
+
+func TestServerOutput(t *testing.T) {
+	const authRequest = "( success ( ( CRAM-MD5 PLAIN ) 5:realm ) ) "
+	for i, tc := range [...]struct {
+		in  string
+		out string
+		err error
+	}{
+		0: {
+			in:  "( PLAIN ( 16:AHVzZXIAcGVuY2ls ) ) ",
+			out: authRequest + "( success ( ) ) ",
+		},
+		1: {
+			in:  "( PLAIN ( ) ) 16:AHVzZXIAcGVuY2ls ",
+			out: authRequest + "( step ( 0: ) ) ( success ( ) ) ",
+		},
+		2: {
+			in:  "( PLAIN ( 3:!!! ) ) ",
+			out: authRequest + "( failure ( 23:Malformed auth response ) ) ",
+			err: svn.ErrMalformed,
+		},
+		3: {
+			in:  "( ANONYMOUS ( ) ) ",
+			out: authRequest + "( failure ( 39:Must authenticate with listed mechanism ) ) ",
+			err: svn.ErrUnknownMechanism,
+		},
+		4: {
+			// CRAM-MD5 tokens are not base64 encoded.
+			in:  "( CRAM-MD5 ( 11:user pencil ) ) ",
+			out: authRequest + "( success ( ) ) ",
+		},
+		5: {
+			in:  "( PLAIN ( 99999999:toolong ) ) ",
+			out: authRequest,
+			err: svn.ErrItemTooLong,
+		},
+		6: {
+			in:  "( PLAIN ( ) ) ( PLAIN ( ) ) ",
+			out: authRequest + "( step ( 0: ) ) ( failure ( 23:Malformed auth response ) ) ",
+			err: svn.ErrMalformed,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			rw := struct {
+				io.Reader
+				io.Writer
+			}{
+				Reader: bytes.NewReader([]byte(tc.in)),
+				Writer: &out,
+			}
+			err := svn.HandleAuth(rw, []string{"CRAM-MD5", "PLAIN"}, "realm", func(name string) *sasl.Negotiator {
+				if name == "CRAM-MD5" {
+					// A stand in that only checks the encoding of the token.
+					return sasl.NewServer(sasl.Mechanism{
+						Name: name,
+						Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+							if string(challenge) != "user pencil" {
+								return false, nil, nil, sasl.ErrAuthn
+							}
+							return false, nil, nil, nil
+						},
+					}, sasltest.CheckPass)
+				}
+				return sasl.NewServer(sasl.Plain, sasltest.CheckPass)
+			})
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if out.String() != tc.out {
+				t.Errorf("Unexpected output:\nwant=%q\n got=%q", tc.out, out.String())
+			}
+		})
+	}
+}
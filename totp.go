@@ -0,0 +1,176 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"crypto/hmac"
+	/* #nosec */
+	"crypto/sha1"
+	"encoding/binary"
+	"strconv"
+	"time"
+)
+
+// TOTP parameters. These are the defaults recommended by RFC 6238 and are the
+// only values supported by most authenticator apps.
+const (
+	totpStep   = 30
+	totpDigits = 6
+
+	// The number of time steps before and after the current one in which a code
+	// will still be accepted to allow for clock drift.
+	totpSkew = 1
+)
+
+type totpCache struct {
+	inner interface{}
+	done  bool
+	sent  bool
+	user  []byte
+}
+
+// WithTOTP returns a Mechanism that negotiates m and, once it has succeeded,
+// requires a time-based one-time password (RFC 6238) as a second factor in the
+// same exchange.
+// The returned mechanism has the same name as m.
+//
+// When m finishes the server sends its final message (if any) as a challenge
+// and the client responds to it with the code returned by the function set
+// using the TOTPCode option.
+// The server looks up the secret for the user that m authenticated using the
+// function set with the TOTPSecrets option, so m must call the negotiators
+// Permissions method with the authenticated user.
+// Codes that were already used, or that are older than the last code that was
+// used, are rejected using the function set with the TOTPCounter option.
+//
+// Codes are 6 digits long, use HMAC-SHA1 and a 30 second time step, and a
+// single step of clock drift is allowed in either direction.
+func WithTOTP(m Mechanism) Mechanism {
+	return Mechanism{
+		Name: m.Name,
+		Start: func(n *Negotiator) (bool, []byte, interface{}, error) {
+			more, resp, cache, err := m.Start(n)
+			if err != nil {
+				return false, nil, nil, err
+			}
+			// The client always has more to say, even if m does not.
+			return true, resp, totpCache{inner: cache, done: !more}, nil
+		},
+		Next: func(n *Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+			cache, _ := data.(totpCache)
+			if n.State()&Receiving == Receiving {
+				return totpServerNext(m, n, challenge, cache)
+			}
+			return totpClientNext(m, n, challenge, cache)
+		},
+	}
+}
+
+func totpClientNext(m Mechanism, n *Negotiator, challenge []byte, cache totpCache) (bool, []byte, interface{}, error) {
+	if cache.sent {
+		return false, nil, nil, ErrTooManySteps
+	}
+	if !cache.done {
+		more, resp, inner, err := m.Next(n, challenge, cache.inner)
+		switch {
+		case err != nil:
+			return false, nil, nil, err
+		case more:
+			return true, resp, totpCache{inner: inner}, nil
+		case len(resp) > 0:
+			// The server still has to see the final response from m before it can
+			// ask for the code.
+			return true, resp, totpCache{done: true}, nil
+		}
+	}
+
+	if n.totpCode == nil {
+		return false, nil, nil, ErrInvalidState
+	}
+	return false, n.totpCode(), totpCache{done: true, sent: true}, nil
+}
+
+func totpServerNext(m Mechanism, n *Negotiator, challenge []byte, cache totpCache) (bool, []byte, interface{}, error) {
+	if cache.done {
+		if n.totpSecrets == nil || n.totpCounter == nil {
+			return false, nil, nil, ErrAuthn
+		}
+		secret := n.totpSecrets(cache.user)
+		if len(secret) == 0 {
+			return false, nil, nil, ErrAuthn
+		}
+		counter, ok := validTOTP(secret, challenge, n.now())
+		if !ok || !n.totpCounter(cache.user, counter) {
+			return false, nil, nil, ErrAuthn
+		}
+		return false, nil, nil, nil
+	}
+
+	// Record the user that the primary mechanism authenticated so that we know
+	// whose secret to check the code against.
+	var user []byte
+	permissions := n.permissions
+	n.permissions = func(nn *Negotiator) bool {
+		ok := permissions(nn)
+		if ok {
+			user, _, _ = nn.Credentials()
+		}
+		return ok
+	}
+	more, resp, inner, err := m.Next(n, challenge, cache.inner)
+	n.permissions = permissions
+
+	switch {
+	case err != nil:
+		return false, nil, nil, err
+	case more:
+		return true, resp, totpCache{inner: inner}, nil
+	case user == nil:
+		return false, nil, nil, ErrAuthn
+	}
+	return true, resp, totpCache{done: true, user: user}, nil
+}
+
+// validTOTP reports whether code is valid for secret at time t and returns the
+// time step that it was generated for.
+// If code is valid for more than one step the latest one is returned.
+func validTOTP(secret, code []byte, t time.Time) (uint64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	now := t.Unix() / totpStep
+	var counter uint64
+	valid := false
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		// Check every step so that the time taken does not reveal which one
+		// matched.
+		if hmac.Equal(hotp(secret, uint64(now+i)), code) {
+			counter = uint64(now + i)
+			valid = true
+		}
+	}
+	return counter, valid
+}
+
+// hotp computes an RFC 4226 one-time password.
+func hotp(secret []byte, counter uint64) []byte {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	h := hmac.New(sha1.New, secret)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	code %= 1000000
+
+	s := strconv.FormatUint(uint64(code), 10)
+	out := make([]byte, totpDigits)
+	for i := range out {
+		out[i] = '0'
+	}
+	copy(out[totpDigits-len(s):], s)
+	return out
+}
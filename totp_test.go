@@ -0,0 +1,82 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package sasl
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 appendix B truncated to 6 digits.
+var totpTestCases = [...]struct {
+	time int64
+	code string
+}{
+	0: {time: 59, code: "287082"},
+	1: {time: 1111111109, code: "081804"},
+	2: {time: 1111111111, code: "050471"},
+	3: {time: 1234567890, code: "005924"},
+	4: {time: 2000000000, code: "279037"},
+	5: {time: 20000000000, code: "353130"},
+}
+
+func TestTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	for i, tc := range totpTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			now := time.Unix(tc.time, 0)
+			if code := hotp(secret, uint64(tc.time/totpStep)); string(code) != tc.code {
+				t.Errorf("Wrong code: want=%s, got=%s", tc.code, code)
+			}
+			counter, ok := validTOTP(secret, []byte(tc.code), now.Add(totpStep*time.Second))
+			if !ok {
+				t.Errorf("Expected code from the previous step to be accepted")
+			}
+			if want := uint64(tc.time / totpStep); counter != want {
+				t.Errorf("Wrong counter: want=%d, got=%d", want, counter)
+			}
+			if _, ok = validTOTP(secret, []byte(tc.code), now.Add(2*totpStep*time.Second)); ok {
+				t.Errorf("Expected code from two steps ago to be rejected")
+			}
+		})
+	}
+}
+
+func TestTOTPReplay(t *testing.T) {
+	var last uint64
+	now := time.Unix(59, 0)
+	server := NewServer(WithTOTP(plain), acceptAll,
+		TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+		TOTPCounter(func(_ []byte, counter uint64) bool {
+			if counter <= last {
+				return false
+			}
+			last = counter
+			return true
+		}),
+		Clock(func() time.Time { return now }),
+	)
+	for i, tc := range [...]struct {
+		code string
+		err  error
+	}{
+		0: {code: "287082"},
+		// The same code again.
+		1: {code: "287082", err: ErrAuthn},
+		// A code for the previous step, which is still within the allowed skew.
+		2: {code: "755224", err: ErrAuthn},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			server.Reset()
+			if _, _, err := server.Step(plainResp); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, _, err := server.Step([]byte(tc.code)); err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+		})
+	}
+}
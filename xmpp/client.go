@@ -0,0 +1,98 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package xmpp
+
+import (
+	"encoding/xml"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// Authenticate sends an auth element and runs the exchange using client until
+// the server sends success or failure.
+//
+// If the initial response is nil the auth element is sent empty, and the
+// response is sent after the first challenge.
+// Additional data sent with success is passed to the negotiator, which must
+// accept it and finish.
+// If the negotiator returns an error the exchange is aborted and the
+// negotiators error is returned once the server has sent its failure.
+// A failure from the server is converted to an error using Err.
+func Authenticate(d *xml.Decoder, e *xml.Encoder, client *sasl.Negotiator) error {
+	more, resp, err := client.Step(nil)
+	if err != nil {
+		return err
+	}
+	if err = send(e, "auth", client.Mechanism().Name, resp); err != nil {
+		return err
+	}
+
+	var abortErr error
+	for {
+		start, err := nextStart(d)
+		if err != nil {
+			return err
+		}
+
+		if start.Name.Local == "failure" {
+			var f failure
+			if err = d.DecodeElement(&f, &start); err != nil {
+				return err
+			}
+			if abortErr != nil {
+				return abortErr
+			}
+			var cond string
+			if len(f.Conditions) > 0 {
+				cond = f.Conditions[0].Local
+			}
+			return Err(cond, f.Text.Value, f.Text.Lang)
+		}
+
+		var el element
+		if err = d.DecodeElement(&el, &start); err != nil {
+			return err
+		}
+		if abortErr != nil {
+			// Ignore anything else the server sends after we abort.
+			continue
+		}
+		data, err := decode(el.Data)
+		if err != nil {
+			return err
+		}
+
+		switch start.Name.Local {
+		case "challenge":
+			more, resp, err = client.Step(data)
+			if err != nil {
+				abortErr = err
+				if err = e.Encode(element{XMLName: xml.Name{Space: NS, Local: "abort"}}); err != nil {
+					return err
+				}
+				continue
+			}
+			if err = send(e, "response", "", resp); err != nil {
+				return err
+			}
+		case "success":
+			if data != nil {
+				if !more {
+					return sasl.ErrInvalidChallenge
+				}
+				more, _, err = client.Step(data)
+				if err != nil {
+					return err
+				}
+			}
+			if more {
+				return sasl.ErrUnexpectedSuccess
+			}
+			return nil
+		default:
+			return ErrUnexpectedElement
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package xmpp
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// HandleAuth handles an auth element on behalf of a server.
+//
+// The start argument is the start element of the auth element which the caller
+// has already read from d.
+// The mechanism named in the element is passed to negotiator which should
+// return a server Negotiator (normally created with sasl.NewServer) or nil if
+// the mechanism is not supported.
+//
+// Errors are sent to the client as a failure using Condition.
+// Additional data returned by the negotiator on success is sent in the success
+// element.
+// If the client aborts the exchange ErrAborted is returned.
+func HandleAuth(d *xml.Decoder, e *xml.Encoder, start xml.StartElement, negotiator func(mechanism string) *sasl.Negotiator) error {
+	var auth element
+	if start.Name.Space != NS || start.Name.Local != "auth" {
+		return sendFailure(e, ErrUnexpectedElement)
+	}
+	if err := d.DecodeElement(&auth, &start); err != nil {
+		return err
+	}
+
+	server := negotiator(strings.ToUpper(auth.Mechanism))
+	if server == nil {
+		return sendFailure(e, ErrUnknownMechanism)
+	}
+
+	resp, err := decode(auth.Data)
+	if err != nil {
+		return sendFailure(e, err)
+	}
+	if resp == nil {
+		// No initial response, send an empty challenge to ask for one.
+		resp, err = challenge(d, e, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		more, data, err := server.Step(resp)
+		if err != nil {
+			return sendFailure(e, err)
+		}
+		if !more {
+			return send(e, "success", "", data)
+		}
+		resp, err = challenge(d, e, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// challenge sends a challenge and reads the clients response.
+// The response is never nil so that an empty response element is not
+// mistaken for a missing initial response.
+func challenge(d *xml.Decoder, e *xml.Encoder, data []byte) ([]byte, error) {
+	if err := send(e, "challenge", "", data); err != nil {
+		return nil, err
+	}
+	start, err := nextStart(d)
+	switch {
+	case err == ErrUnexpectedElement:
+		return nil, sendFailure(e, err)
+	case err != nil:
+		return nil, err
+	}
+
+	var el element
+	if err = d.DecodeElement(&el, &start); err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "response":
+	case "abort":
+		return nil, sendFailure(e, ErrAborted)
+	default:
+		return nil, sendFailure(e, ErrUnexpectedElement)
+	}
+	resp, err := decode(el.Data)
+	if err != nil {
+		return nil, sendFailure(e, err)
+	}
+	if resp == nil {
+		resp = []byte{}
+	}
+	return resp, nil
+}
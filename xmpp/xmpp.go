@@ -0,0 +1,212 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+// Package xmpp implements the XMPP SASL negotiation defined in RFC 6120 on top
+// of encoding/xml.
+//
+// Authenticate drives a client Negotiator and HandleAuth drives a server
+// Negotiator.
+// Both read from an xml.Decoder and write to an xml.Encoder that are
+// positioned inside an already open XML stream; opening the stream,
+// advertising stream features, and restarting the stream after success are
+// left to the caller.
+package xmpp
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+
+	"github.com/whenspeakteam/sasl"
+)
+
+// NS is the XML namespace of the SASL elements.
+const NS = "urn:ietf:params:xml:ns:xmpp-sasl"
+
+const xmlNS = "http://www.w3.org/XML/1998/namespace"
+
+// Failure conditions defined in RFC 6120 §6.5.
+const (
+	Aborted              = "aborted"
+	AccountDisabled      = "account-disabled"
+	CredentialsExpired   = "credentials-expired"
+	EncryptionRequired   = "encryption-required"
+	IncorrectEncoding    = "incorrect-encoding"
+	InvalidAuthzid       = "invalid-authzid"
+	InvalidMechanism     = "invalid-mechanism"
+	MalformedRequest     = "malformed-request"
+	MechanismTooWeak     = "mechanism-too-weak"
+	NotAuthorized        = "not-authorized"
+	TemporaryAuthFailure = "temporary-auth-failure"
+)
+
+// Errors returned by the client and server.
+var (
+	ErrAborted           = errors.New("Authentication aborted")
+	ErrUnknownMechanism  = errors.New("Unsupported authentication mechanism")
+	ErrIncorrectEncoding = errors.New("Incorrect base64 encoding")
+	ErrUnexpectedElement = errors.New("Unexpected element during SASL negotiation")
+)
+
+// Failure is a SASL failure that does not map to any of the Err values.
+type Failure struct {
+	Condition string
+	Text      string
+	Lang      string
+}
+
+func (f Failure) Error() string {
+	if f.Text != "" {
+		return "xmpp: " + f.Condition + ": " + f.Text
+	}
+	return "xmpp: " + f.Condition
+}
+
+// Mechanisms is the SASL stream feature.
+// The mechanisms received from a server can be passed to the
+// sasl.RemoteMechanisms option.
+type Mechanisms struct {
+	XMLName   xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms"`
+	Mechanism []string `xml:"mechanism"`
+}
+
+// element is any of the SASL elements that carry data.
+type element struct {
+	XMLName   xml.Name
+	Mechanism string `xml:"mechanism,attr,omitempty"`
+	Data      string `xml:",chardata"`
+}
+
+type failure struct {
+	XMLName    xml.Name   `xml:"urn:ietf:params:xml:ns:xmpp-sasl failure"`
+	Conditions []xml.Name `xml:",any"`
+	Text       struct {
+		Lang  string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"text"`
+}
+
+// Condition returns the failure condition that should be sent for err.
+func Condition(err error) string {
+	switch err {
+	case sasl.ErrAuthn:
+		return NotAuthorized
+	case sasl.ErrInvalidChallenge, sasl.ErrTooManySteps, sasl.ErrInvalidState, ErrUnexpectedElement:
+		return MalformedRequest
+	case ErrAborted:
+		return Aborted
+	case ErrUnknownMechanism:
+		return InvalidMechanism
+	case ErrIncorrectEncoding:
+		return IncorrectEncoding
+	}
+	switch e := err.(type) {
+	case Failure:
+		return e.Condition
+	case base64.CorruptInputError:
+		return IncorrectEncoding
+	}
+	return NotAuthorized
+}
+
+// Err returns the error for a failure condition.
+// Conditions that do not map to one of the Err values in this package or the
+// sasl package are returned as a Failure.
+func Err(condition, text, lang string) error {
+	switch condition {
+	case NotAuthorized:
+		return sasl.ErrAuthn
+	case Aborted:
+		return ErrAborted
+	case InvalidMechanism:
+		return ErrUnknownMechanism
+	case IncorrectEncoding:
+		return ErrIncorrectEncoding
+	case MalformedRequest:
+		return sasl.ErrInvalidChallenge
+	}
+	return Failure{Condition: condition, Text: text, Lang: lang}
+}
+
+// encode base64 encodes data.
+// If data is empty but not nil the RFC 6120 "=" convention is used, nil data
+// results in an empty element.
+func encode(data []byte) string {
+	switch {
+	case data == nil:
+		return ""
+	case len(data) == 0:
+		return "="
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// decode decodes the character data of an element.
+// An empty element is decoded to nil, and "=" to an empty slice.
+func decode(data string) ([]byte, error) {
+	switch data {
+	case "":
+		return nil, nil
+	case "=":
+		return []byte{}, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, ErrIncorrectEncoding
+	}
+	return b, nil
+}
+
+func send(e *xml.Encoder, local, mechanism string, data []byte) error {
+	return e.Encode(element{
+		XMLName:   xml.Name{Space: NS, Local: local},
+		Mechanism: mechanism,
+		Data:      encode(data),
+	})
+}
+
+// sendFailure sends a failure element for err and returns err, or the encoding
+// error if there was one.
+func sendFailure(e *xml.Encoder, err error) error {
+	start := xml.StartElement{Name: xml.Name{Space: NS, Local: "failure"}}
+	cond := xml.StartElement{Name: xml.Name{Local: Condition(err)}}
+	toks := []xml.Token{start, cond, cond.End()}
+	if f, ok := err.(Failure); ok && f.Text != "" {
+		text := xml.StartElement{Name: xml.Name{Local: "text"}}
+		if f.Lang != "" {
+			text.Attr = []xml.Attr{{Name: xml.Name{Space: xmlNS, Local: "lang"}, Value: f.Lang}}
+		}
+		toks = append(toks, text, xml.CharData(f.Text), text.End())
+	}
+	toks = append(toks, start.End())
+	for _, t := range toks {
+		if werr := e.EncodeToken(t); werr != nil {
+			return werr
+		}
+	}
+	if werr := e.Flush(); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// nextStart returns the next start element in the SASL namespace, skipping
+// whitespace between elements.
+func nextStart(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space != NS {
+				return t, ErrUnexpectedElement
+			}
+			return t, nil
+		case xml.EndElement:
+			return xml.StartElement{}, ErrUnexpectedElement
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2016 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause license that can be
+// found in the LICENSE file.
+
+package xmpp_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whenspeakteam/sasl"
+	"github.com/whenspeakteam/sasl/internal/sasltest"
+	"github.com/whenspeakteam/sasl/xmpp"
+)
+
+// finalData is a mechanism with no initial response where the server sends
+// additional data with its success.
+var finalData = sasl.Mechanism{
+	Name: "X-FINAL",
+	Start: func(*sasl.Negotiator) (bool, []byte, interface{}, error) {
+		return true, nil, nil, nil
+	},
+	Next: func(n *sasl.Negotiator, challenge []byte, _ interface{}) (bool, []byte, interface{}, error) {
+		if n.State()&sasl.Receiving == sasl.Receiving {
+			if len(challenge) != 0 {
+				return false, nil, nil, sasl.ErrAuthn
+			}
+			return false, []byte("goodbye"), nil, nil
+		}
+		if n.State()&sasl.StepMask == sasl.AuthTextSent {
+			return true, []byte{}, nil, nil
+		}
+		if string(challenge) != "goodbye" {
+			return false, nil, nil, sasl.ErrAuthn
+		}
+		return false, nil, nil, nil
+	},
+}
+
+func TestMechanisms(t *testing.T) {
+	const features = `<mechanisms xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><mechanism>SCRAM-SHA-1-PLUS</mechanism><mechanism>PLAIN</mechanism></mechanisms>`
+	var m xmpp.Mechanisms
+	if err := xml.Unmarshal([]byte(features), &m); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"SCRAM-SHA-1-PLUS", "PLAIN"}; !reflect.DeepEqual(m.Mechanism, want) {
+		t.Errorf("Wrong mechanisms: want=%v, got=%v", want, m.Mechanism)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	for i, tc := range [...]struct {
+		err       error
+		condition string
+		back      error
+	}{
+		0: {err: sasl.ErrAuthn, condition: xmpp.NotAuthorized, back: sasl.ErrAuthn},
+		1: {err: sasl.ErrInvalidChallenge, condition: xmpp.MalformedRequest, back: sasl.ErrInvalidChallenge},
+		2: {err: sasl.ErrTooManySteps, condition: xmpp.MalformedRequest, back: sasl.ErrInvalidChallenge},
+		3: {err: xmpp.ErrAborted, condition: xmpp.Aborted, back: xmpp.ErrAborted},
+		4: {err: xmpp.ErrUnknownMechanism, condition: xmpp.InvalidMechanism, back: xmpp.ErrUnknownMechanism},
+		5: {err: xmpp.ErrIncorrectEncoding, condition: xmpp.IncorrectEncoding, back: xmpp.ErrIncorrectEncoding},
+		6: {
+			err:       xmpp.Failure{Condition: xmpp.AccountDisabled},
+			condition: xmpp.AccountDisabled,
+			back:      xmpp.Failure{Condition: xmpp.AccountDisabled},
+		},
+		7: {err: errors.New("some error"), condition: xmpp.NotAuthorized, back: sasl.ErrAuthn},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			cond := xmpp.Condition(tc.err)
+			if cond != tc.condition {
+				t.Errorf("Wrong condition: want=%s, got=%s", tc.condition, cond)
+			}
+			if err := xmpp.Err(cond, "", ""); err != tc.back {
+				t.Errorf("Wrong error: want=%v, got=%v", tc.back, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	for i, tc := range [...]struct {
+		mech       sasl.Mechanism
+		clientOpts []sasl.Option
+		serverOpts []sasl.Option
+		serverMech string
+		perm       func(*sasl.Negotiator) bool
+		tamper     bool
+		clientErr  error
+		serverErr  error
+	}{
+		0: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+		},
+		1: {
+			mech:       sasl.Plain,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pen")},
+			clientErr:  sasl.ErrAuthn,
+			serverErr:  sasl.ErrAuthn,
+		},
+		2: {
+			mech: sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{
+				sasltest.Creds("user", "pencil"),
+				sasl.TOTPCode(func() []byte { return []byte("287082") }),
+			},
+			serverOpts: []sasl.Option{
+				sasl.TOTPSecrets(func([]byte) []byte { return []byte("12345678901234567890") }),
+				sasl.TOTPCounter(func([]byte, uint64) bool { return true }),
+				sasl.Clock(func() time.Time { return time.Unix(59, 0) }),
+			},
+		},
+		3: {
+			mech:       sasl.WithTOTP(sasl.Plain),
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			clientErr:  sasl.ErrInvalidState,
+			serverErr:  xmpp.ErrAborted,
+		},
+		4: {
+			mech: finalData,
+		},
+		5: {
+			mech:       sasl.Plain,
+			serverMech: "SCRAM-SHA-1",
+			clientErr:  xmpp.ErrUnknownMechanism,
+			serverErr:  xmpp.ErrUnknownMechanism,
+		},
+		6: {
+			// The signature is sent with the success so only the client notices that
+			// it is wrong.
+			mech:       sasl.ScramSha256,
+			clientOpts: []sasl.Option{sasltest.Creds("user", "pencil")},
+			serverOpts: []sasl.Option{sasltest.ScramSecrets},
+			perm:       sasltest.CheckUser,
+			tamper:     true,
+			clientErr:  sasl.ErrAuthn,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			serverMech := tc.serverMech
+			if serverMech == "" {
+				serverMech = tc.mech.Name
+			}
+			mech := tc.mech
+			if tc.tamper {
+				mech = sasltest.BadSignature(mech)
+			}
+			perm := tc.perm
+			if perm == nil {
+				perm = sasltest.CheckPass
+			}
+			errs := make(chan error, 1)
+			go func() {
+				defer serverConn.Close()
+				d := xml.NewDecoder(serverConn)
+				tok, err := d.Token()
+				if err != nil {
+					errs <- err
+					return
+				}
+				errs <- xmpp.HandleAuth(d, xml.NewEncoder(serverConn), tok.(xml.StartElement), func(name string) *sasl.Negotiator {
+					if name != serverMech {
+						return nil
+					}
+					return sasl.NewServer(mech, perm, tc.serverOpts...)
+				})
+			}()
+
+			err := xmpp.Authenticate(xml.NewDecoder(clientConn), xml.NewEncoder(clientConn), sasl.NewClient(tc.mech, tc.clientOpts...))
+			if err != tc.clientErr {
+				t.Errorf("Unexpected client error: want=%v, got=%v", tc.clientErr, err)
+			}
+			if err = <-errs; err != tc.serverErr {
+				t.Errorf("Unexpected server error: want=%v, got=%v", tc.serverErr, err)
+			}
+		})
+	}
+}
+
+func TestServerOutput(t *testing.T) {
+	for i, tc := range [...]struct {
+		in  string
+		out string
+		err error
+	}{
+		0: {
+			in:  `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">AHVzZXIAcGVuY2ls</auth>`,
+			out: `<success xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></success>`,
+		},
+		1: {
+			in:  `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">=</auth>`,
+			out: `<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><malformed-request></malformed-request></failure>`,
+			err: sasl.ErrInvalidChallenge,
+		},
+		2: {
+			in:  `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">!!</auth>`,
+			out: `<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><incorrect-encoding></incorrect-encoding></failure>`,
+			err: xmpp.ErrIncorrectEncoding,
+		},
+		3: {
+			in: `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN"/>` +
+				`<response xmlns="urn:ietf:params:xml:ns:xmpp-sasl">AHVzZXIAcGVuY2ls</response>`,
+			out: `<challenge xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></challenge>` +
+				`<success xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></success>`,
+		},
+		4: {
+			in: `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN"/>` +
+				`<abort xmlns="urn:ietf:params:xml:ns:xmpp-sasl"/>`,
+			out: `<challenge xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></challenge>` +
+				`<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><aborted></aborted></failure>`,
+			err: xmpp.ErrAborted,
+		},
+		5: {
+			in:  `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN">AHVzZXIAZGlzYWJsZWQ=</auth>`,
+			out: `<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><account-disabled></account-disabled><text xml:lang="en">Account disabled</text></failure>`,
+			err: xmpp.Failure{Condition: xmpp.AccountDisabled, Text: "Account disabled", Lang: "en"},
+		},
+		6: {
+			in: `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="PLAIN"/>` +
+				`<message xmlns="jabber:client"/>`,
+			out: `<challenge xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></challenge>` +
+				`<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><malformed-request></malformed-request></failure>`,
+			err: xmpp.ErrUnexpectedElement,
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			var out bytes.Buffer
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			disabled := sasl.Mechanism{
+				Name:  "PLAIN",
+				Start: sasl.Plain.Start,
+				Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+					if bytes.HasSuffix(challenge, []byte("disabled")) {
+						return false, nil, nil, xmpp.Failure{Condition: xmpp.AccountDisabled, Text: "Account disabled", Lang: "en"}
+					}
+					return sasl.Plain.Next(n, challenge, data)
+				},
+			}
+			err = xmpp.HandleAuth(d, xml.NewEncoder(&out), tok.(xml.StartElement), func(string) *sasl.Negotiator {
+				return sasl.NewServer(disabled, sasltest.CheckPass)
+			})
+			if err != tc.err {
+				t.Errorf("Unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if out.String() != tc.out {
+				t.Errorf("Unexpected output:\nwant=%s\n got=%s", tc.out, out.String())
+			}
+		})
+	}
+}